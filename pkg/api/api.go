@@ -3,8 +3,10 @@ package api
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"strconv"
 	"strings"
 
 	eeDStore "github.com/direktiv/direktiv/direktiv-ee/pkg/datastore"
@@ -23,6 +25,78 @@ func writeJSON(w http.ResponseWriter, v any) {
 	_ = json.NewEncoder(w).Encode(payLoad)
 }
 
+// etag formats the version of an entry as a strong entity tag.
+func etag(version int64) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+// matchesIfMatch reports whether the If-Match header of r allows the given version, requests
+// without the header always match.
+func matchesIfMatch(r *http.Request, version int64) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return true
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == etag(version) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// pagination is the metadata of a paged list response.
+type pagination struct {
+	Limit int `json:"limit"`
+	// NextCursor is passed as cursor query parameter to fetch the next page, it is empty on the last page.
+	NextCursor string `json:"nextCursor"`
+}
+
+func writeJSONPage(w http.ResponseWriter, v any, page *pagination) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	payLoad := struct {
+		Data       any         `json:"data"`
+		Pagination *pagination `json:"pagination"`
+	}{
+		Data:       v,
+		Pagination: page,
+	}
+	_ = json.NewEncoder(w).Encode(payLoad)
+}
+
+// parseListOptions parses the limit, cursor, sort and order query parameters of list endpoints.
+func parseListOptions(r *http.Request) (eeDStore.ListOptions, map[string]string) {
+	query := r.URL.Query()
+	vErrs := map[string]string{}
+
+	opts := eeDStore.ListOptions{
+		Cursor: query.Get("cursor"),
+		SortBy: query.Get("sort"),
+	}
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxListLimit {
+			vErrs["limit"] = fmt.Sprintf("must be a number between 1 and %d", maxListLimit)
+		}
+		opts.Limit = limit
+	}
+	switch query.Get("order") {
+	case "", "asc":
+	case "desc":
+		opts.Desc = true
+	default:
+		vErrs["order"] = "must be one of 'asc' or 'desc'"
+	}
+
+	return opts, vErrs
+}
+
+const maxListLimit = 1000
+
 func extractContextNamespace(r *http.Request) *datastore.Namespace {
 	//nolint:forcetypeassert
 	ns := r.Context().Value(ctxKeyNamespace).(*datastore.Namespace)
@@ -32,59 +106,51 @@ func extractContextNamespace(r *http.Request) *datastore.Namespace {
 
 const ctxKeyNamespace = "namespace"
 
-func writeInternalError(w http.ResponseWriter, err error) {
-	writeError(w, &Error{
-		Code:    "internal",
+func writeInternalError(w http.ResponseWriter, r *http.Request, err error) {
+	writeError(w, r, &Error{
+		Code:    CodeInternal,
 		Message: "internal server error",
 	})
 
-	slog.Error("internal", "err", err)
+	slog.Error("internal", "err", err, "requestId", requestID(r))
 }
 
-func writeNotJSONError(w http.ResponseWriter, err error) {
-	if strings.Contains(err.Error(), "cannot unmarshal") {
-		writeError(w, &Error{
-			Code:    "request_body_bad_json_schema",
-			Message: "request payload has bad json schema",
-		})
-
-		return
-	}
-
-	writeError(w, &Error{
-		Code:    "request_body_not_json",
-		Message: "couldn't parse request payload in json format",
-	})
-}
-
-func writeDataStoreError(w http.ResponseWriter, err error) {
-	if errors.Is(err, datastore.ErrNotFound) {
-		writeError(w, &Error{
-			Code:    "resource_not_found",
+func writeDataStoreError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, datastore.ErrNotFound) || errors.Is(err, eeDStore.ErrNotFound) {
+		writeError(w, r, &Error{
+			Code:    CodeResourceNotFound,
 			Message: "requested resource is not found",
 		})
 
 		return
 	}
 	if errors.Is(err, datastore.ErrInvalidRuntimeVariableName) {
-		writeError(w, &Error{
-			Code:    "request_data_invalid",
+		writeError(w, r, &Error{
+			Code:    CodeRequestDataInvalid,
 			Message: "field name has invalid string",
 		})
 
 		return
 	}
 	if errors.Is(err, datastore.ErrInvalidNamespaceName) {
-		writeError(w, &Error{
-			Code:    "request_data_invalid",
+		writeError(w, r, &Error{
+			Code:    CodeRequestDataInvalid,
 			Message: "invalid namespace name",
 		})
 
 		return
 	}
-	if errors.Is(err, datastore.ErrDuplication) {
-		writeError(w, &Error{
-			Code:    "resource_already_exists",
+	if errors.Is(err, eeDStore.ErrConflict) {
+		writeError(w, r, &Error{
+			Code:    CodeResourceConflict,
+			Message: "resource was changed concurrently",
+		})
+
+		return
+	}
+	if errors.Is(err, datastore.ErrDuplication) || errors.Is(err, eeDStore.ErrDuplication) {
+		writeError(w, r, &Error{
+			Code:    CodeResourceAlreadyExists,
 			Message: "resource already exists",
 		})
 
@@ -92,8 +158,8 @@ func writeDataStoreError(w http.ResponseWriter, err error) {
 	}
 
 	if errors.Is(err, datastore.ErrDuplicatedNamespaceName) {
-		writeError(w, &Error{
-			Code:    "request_data_invalid",
+		writeError(w, r, &Error{
+			Code:    CodeRequestDataInvalid,
 			Message: "namespace name already used",
 		})
 
@@ -102,8 +168,8 @@ func writeDataStoreError(w http.ResponseWriter, err error) {
 
 	var vErrs eeDStore.InvalidArgumentError
 	if errors.As(err, &vErrs) {
-		writeError(w, &Error{
-			Code:       "request_data_invalid",
+		writeError(w, r, &Error{
+			Code:       CodeRequestDataInvalid,
 			Message:    "request data has invalid fields",
 			Validation: vErrs,
 		})
@@ -111,7 +177,7 @@ func writeDataStoreError(w http.ResponseWriter, err error) {
 		return
 	}
 
-	writeInternalError(w, err)
+	writeInternalError(w, r, err)
 }
 
 func writeOk(w http.ResponseWriter) {
@@ -119,53 +185,3 @@ func writeOk(w http.ResponseWriter) {
 
 	w.WriteHeader(http.StatusOK)
 }
-
-func writeError(w http.ResponseWriter, err *Error) {
-	// access_token_denied
-	// access_token_missing
-	// access_token_invalid
-
-	// request_path_not_found
-	// request_method_not_allowed
-	// request_body_not_json
-	// resource_not_found
-	// resource_already_exists
-	// resource_id_invalid
-
-	// request_data_invalid
-
-	httpStatus := http.StatusInternalServerError
-
-	if strings.HasPrefix(err.Code, "access") {
-		httpStatus = http.StatusForbidden
-	}
-	if strings.HasPrefix(err.Code, "request") {
-		httpStatus = http.StatusBadRequest
-	}
-	if strings.HasPrefix(err.Code, "resource") {
-		httpStatus = http.StatusBadRequest
-	}
-	if strings.Contains(err.Code, "not_found") {
-		httpStatus = http.StatusNotFound
-	}
-	if strings.Contains(err.Code, "method_not_allowed") {
-		httpStatus = http.StatusMethodNotAllowed
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(httpStatus)
-
-	payLoad := struct {
-		Error *Error `json:"error"`
-	}{
-		Error: err,
-	}
-
-	_ = json.NewEncoder(w).Encode(payLoad)
-}
-
-type Error struct {
-	Code       string            `json:"code"`
-	Message    string            `json:"message"`
-	Validation map[string]string `json:"validation"`
-}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_parseListOptions(t *testing.T) {
+	r := httptest.NewRequest("GET", "/?limit=10&cursor=abc&sort=name&order=desc", nil)
+	opts, vErrs := parseListOptions(r)
+	if len(vErrs) != 0 {
+		t.Fatalf("parseListOptions() validation = %v", vErrs)
+	}
+	if opts.Limit != 10 || opts.Cursor != "abc" || opts.SortBy != "name" || !opts.Desc {
+		t.Errorf("parseListOptions() = %v", opts)
+	}
+
+	r = httptest.NewRequest("GET", "/?limit=0&order=up", nil)
+	_, vErrs = parseListOptions(r)
+	if vErrs["limit"] == "" || vErrs["order"] == "" {
+		t.Errorf("parseListOptions() validation = %v, want limit and order errors", vErrs)
+	}
+}
+
+func Test_matchesIfMatch(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", true},
+		{"*", true},
+		{`"3"`, true},
+		{`"1", "3"`, true},
+		{`"2"`, false},
+		{`W/"3"`, false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("PUT", "/", nil)
+		if tt.header != "" {
+			r.Header.Set("If-Match", tt.header)
+		}
+		if got := matchesIfMatch(r, 3); got != tt.want {
+			t.Errorf("matchesIfMatch(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
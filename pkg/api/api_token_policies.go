@@ -0,0 +1,244 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	isoDuration "github.com/ChannelMeter/iso8601duration"
+	eeDStore "github.com/direktiv/direktiv/direktiv-ee/pkg/datastore"
+	"github.com/direktiv/direktiv/pkg/database"
+	"github.com/go-chi/chi/v5"
+)
+
+//nolint:revive
+type APITokenPoliciesController struct {
+	db     *database.DB
+	eStore eeDStore.Store
+}
+
+func NewAPITokenPoliciesController(db *database.DB, eStore eeDStore.Store) *APITokenPoliciesController {
+	return &APITokenPoliciesController{
+		db:     db,
+		eStore: eStore,
+	}
+}
+
+type apiTokenPolicyRequest struct {
+	MaxLifetime        string               `json:"maxLifetime"`
+	DefaultLifetime    string               `json:"defaultLifetime"`
+	PermissionsCeiling eeDStore.Permissions `json:"permissionsCeiling"`
+	AllowNonExpiring   bool                 `json:"allowNonExpiring"`
+}
+
+func (c *APITokenPoliciesController) MountRouter(r chi.Router) {
+	r.Get("/", c.get)
+	r.Put("/", c.set)
+	r.Delete("/", c.delete)
+}
+
+func (c *APITokenPoliciesController) get(w http.ResponseWriter, r *http.Request) {
+	ns := extractContextNamespace(r)
+
+	db, err := c.db.BeginTx(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer db.Rollback()
+
+	policy, err := tokenPolicy(r.Context(), c.eStore.With(db.Conn()), ns.Name)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeJSON(w, convertAPITokenPolicy(policy))
+}
+
+func (c *APITokenPoliciesController) set(w http.ResponseWriter, r *http.Request) {
+	ns := extractContextNamespace(r)
+
+	db, err := c.db.BeginTx(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer db.Rollback()
+
+	if !checkPolicyWritable(w, r, c.eStore.With(db.Conn()), ns.Name) {
+		return
+	}
+
+	// Parse request.
+	req := apiTokenPolicyRequest{}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	vErrs := fieldErrors{}
+	var maxLifetime, defaultLifetime time.Duration
+	if req.MaxLifetime != "" {
+		d, err := isoDuration.FromString(req.MaxLifetime)
+		if err != nil {
+			vErrs["maxLifetime"] = "invalid iso8601 duration format"
+		} else {
+			maxLifetime = d.ToDuration()
+		}
+	}
+	if req.DefaultLifetime != "" {
+		d, err := isoDuration.FromString(req.DefaultLifetime)
+		if err != nil {
+			vErrs["defaultLifetime"] = "invalid iso8601 duration format"
+		} else {
+			defaultLifetime = d.ToDuration()
+		}
+	}
+	vErrs.check("permissionsCeiling", req.PermissionsCeiling.Validate())
+	if !checkFields(w, r, vErrs) {
+		return
+	}
+
+	policy, err := c.eStore.With(db.Conn()).APITokenPolicies().Set(r.Context(), &eeDStore.APITokenPolicy{
+		Namespace:          ns.Name,
+		MaxLifetime:        maxLifetime,
+		DefaultLifetime:    defaultLifetime,
+		PermissionsCeiling: req.PermissionsCeiling,
+		AllowNonExpiring:   req.AllowNonExpiring,
+	})
+	if err != nil {
+		writeDataStoreError(w, r, err)
+		return
+	}
+
+	err = db.Commit(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeJSON(w, convertAPITokenPolicy(policy))
+}
+
+// delete resets the namespace to the default policy.
+func (c *APITokenPoliciesController) delete(w http.ResponseWriter, r *http.Request) {
+	ns := extractContextNamespace(r)
+
+	db, err := c.db.BeginTx(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer db.Rollback()
+
+	if !checkPolicyWritable(w, r, c.eStore.With(db.Conn()), ns.Name) {
+		return
+	}
+
+	err = c.eStore.With(db.Conn()).APITokenPolicies().Delete(r.Context(), ns.Name)
+	if err != nil && !errors.Is(err, eeDStore.ErrNotFound) {
+		writeDataStoreError(w, r, err)
+		return
+	}
+
+	err = db.Commit(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeOk(w)
+}
+
+// checkPolicyWritable writes an error and returns false when the policy of the namespace is declared
+// in the namespace tree, such policies can only be changed through their file.
+func checkPolicyWritable(w http.ResponseWriter, r *http.Request, store eeDStore.StoreInner, namespace string) bool {
+	policy, err := store.APITokenPolicies().Get(r.Context(), namespace)
+	if errors.Is(err, eeDStore.ErrNotFound) {
+		return true
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return false
+	}
+	if policy.ManagedBy != "" {
+		writeError(w, r, &Error{
+			Code:    CodeResourceReadOnly,
+			Message: fmt.Sprintf("api token policy is managed by '%s' in the namespace tree", policy.ManagedBy),
+		})
+
+		return false
+	}
+
+	return true
+}
+
+func convertAPITokenPolicy(v *eeDStore.APITokenPolicy) any {
+	type apiTokenPolicyForAPI struct {
+		MaxLifetime        string `json:"maxLifetime"`
+		DefaultLifetime    string `json:"defaultLifetime"`
+		PermissionsCeiling any    `json:"permissionsCeiling"`
+		AllowNonExpiring   bool   `json:"allowNonExpiring"`
+		ManagedBy          string `json:"managedBy"`
+		ReadOnly           bool   `json:"readOnly"`
+	}
+
+	type permission struct {
+		Topic  string `json:"topic"`
+		Method string `json:"method"`
+	}
+
+	permissions := make([]permission, len(v.PermissionsCeiling))
+	for i := range permissions {
+		permissions[i] = permission{
+			Topic:  v.PermissionsCeiling[i].Topic,
+			Method: v.PermissionsCeiling[i].Method,
+		}
+	}
+	if v.PermissionsCeiling == nil {
+		permissions = nil
+	}
+
+	return &apiTokenPolicyForAPI{
+		MaxLifetime:        formatISODuration(v.MaxLifetime),
+		DefaultLifetime:    formatISODuration(v.DefaultLifetime),
+		PermissionsCeiling: permissions,
+		AllowNonExpiring:   v.AllowNonExpiring,
+		ManagedBy:          v.ManagedBy,
+		ReadOnly:           v.ManagedBy != "",
+	}
+}
+
+// formatISODuration formats d as an ISO 8601 duration with days as the largest unit, zero is
+// formatted as an empty string.
+func formatISODuration(d time.Duration) string {
+	if d <= 0 {
+		return ""
+	}
+	seconds := int64(d.Seconds())
+	days, seconds := seconds/86400, seconds%86400
+	hours, seconds := seconds/3600, seconds%3600
+	minutes, seconds := seconds/60, seconds%60
+
+	var b strings.Builder
+	b.WriteString("P")
+	if days > 0 {
+		fmt.Fprintf(&b, "%dD", days)
+	}
+	if hours > 0 || minutes > 0 || seconds > 0 {
+		b.WriteString("T")
+	}
+	if hours > 0 {
+		fmt.Fprintf(&b, "%dH", hours)
+	}
+	if minutes > 0 {
+		fmt.Fprintf(&b, "%dM", minutes)
+	}
+	if seconds > 0 {
+		fmt.Fprintf(&b, "%dS", seconds)
+	}
+
+	return b.String()
+}
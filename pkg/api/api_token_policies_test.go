@@ -0,0 +1,23 @@
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_formatISODuration(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{0, ""},
+		{time.Second * 30, "PT30S"},
+		{time.Hour*26 + time.Minute*30, "P1DT2H30M"},
+		{time.Hour * 24 * 90, "P90D"},
+	}
+	for _, tt := range tests {
+		if got := formatISODuration(tt.d); got != tt.want {
+			t.Errorf("formatISODuration(%v) = %v, want %v", tt.d, got, tt.want)
+		}
+	}
+}
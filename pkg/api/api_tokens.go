@@ -1,14 +1,20 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
 	"time"
 
 	isoDuration "github.com/ChannelMeter/iso8601duration"
+	"github.com/direktiv/direktiv/direktiv-ee/pkg/apitoken"
 	eeDStore "github.com/direktiv/direktiv/direktiv-ee/pkg/datastore"
 	"github.com/direktiv/direktiv/pkg/database"
 	"github.com/go-chi/chi/v5"
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
 	"github.com/google/uuid"
 )
 
@@ -16,30 +22,100 @@ import (
 type APITokensController struct {
 	db     *database.DB
 	eStore eeDStore.Store
+	// signer is nil when signed jwt tokens are not enabled.
+	signer *apitoken.Signer
 }
 
-func NewAPITokensController(db *database.DB, eStore eeDStore.Store) *APITokensController {
+func NewAPITokensController(db *database.DB, eStore eeDStore.Store, signer *apitoken.Signer) *APITokensController {
 	return &APITokensController{
 		db:     db,
 		eStore: eStore,
+		signer: signer,
 	}
 }
 
+// apiTokenCreateRequest and the other request types are the bodies handlers decode, they are
+// described in openapi.json.
+type apiTokenCreateRequest struct {
+	Name            string               `json:"name"`
+	Description     string               `json:"description"`
+	Format          string               `json:"format"`
+	Permissions     eeDStore.Permissions `json:"permissions"`
+	Roles           eeDStore.RoleRefs    `json:"roles"`
+	ServiceAccount  string               `json:"serviceAccount"`
+	DurationISO8601 string               `json:"duration"`
+	NeverExpires    bool                 `json:"neverExpires"`
+}
+
+// validate checks every field of the request, grants and the token policy are checked afterwards.
+func (req *apiTokenCreateRequest) validate() fieldErrors {
+	vErrs := fieldErrors{}
+	vErrs.name("name", req.Name, "")
+	vErrs.length("description", req.Description, maxDescriptionLength)
+	switch req.Format {
+	case "", eeDStore.APITokenFormatSecret, eeDStore.APITokenFormatJWT:
+	default:
+		vErrs["format"] = "format should be one of 'secret' or 'jwt'"
+	}
+	vErrs.check("permissions", req.Permissions.Validate())
+	vErrs.check("roles", req.Roles.Validate())
+	if _, err := parseTokenDuration(req.DurationISO8601); err != nil {
+		vErrs["duration"] = "invalid iso8601 duration format"
+	}
+
+	return vErrs
+}
+
+type apiTokenUpdateRequest struct {
+	Description     string               `json:"description"`
+	Permissions     eeDStore.Permissions `json:"permissions"`
+	Roles           eeDStore.RoleRefs    `json:"roles"`
+	DurationISO8601 string               `json:"duration"`
+	NeverExpires    bool                 `json:"neverExpires"`
+}
+
+func (req *apiTokenUpdateRequest) validate() fieldErrors {
+	vErrs := fieldErrors{}
+	vErrs.length("description", req.Description, maxDescriptionLength)
+	vErrs.check("permissions", req.Permissions.Validate())
+	vErrs.check("roles", req.Roles.Validate())
+	if _, err := parseTokenDuration(req.DurationISO8601); err != nil {
+		vErrs["duration"] = "invalid iso8601 duration format"
+	}
+
+	return vErrs
+}
+
+// createdAPIToken is the only response that contains the secret of a token.
+type createdAPIToken struct {
+	APIToken any    `json:"apiToken"`
+	Secret   string `json:"secret"`
+}
+
 func (c *APITokensController) MountRouter(r chi.Router) {
 	r.Get("/{apiTokenName}", c.get)
 	r.Delete("/{apiTokenName}", c.delete)
+	r.Put("/{apiTokenName}", c.update)
 
 	r.Get("/", c.list)
 	r.Post("/", c.create)
 }
 
+// jwksPath is the public key set of signed jwt tokens, it is served without authentication.
+const jwksPath = "/jwks"
+
+// MountJWKSRouter mounts the public key set of signed jwt tokens.
+func (c *APITokensController) MountJWKSRouter(r chi.Router) {
+	r.Get("/", c.jwks)
+}
+
 func (c *APITokensController) get(w http.ResponseWriter, r *http.Request) {
 	ns := extractContextNamespace(r)
 	apiTokenName := chi.URLParam(r, "apiTokenName")
 
 	db, err := c.db.BeginTx(r.Context())
 	if err != nil {
-		writeInternalError(w, err)
+		writeInternalError(w, r, err)
 		return
 	}
 	defer db.Rollback()
@@ -47,7 +123,7 @@ func (c *APITokensController) get(w http.ResponseWriter, r *http.Request) {
 	// Fetch one
 	apiToken, err := c.eStore.With(db.Conn()).APITokens().Get(r.Context(), ns.Name, apiTokenName)
 	if err != nil {
-		writeDataStoreError(w, err)
+		writeDataStoreError(w, r, err)
 		return
 	}
 
@@ -60,20 +136,20 @@ func (c *APITokensController) delete(w http.ResponseWriter, r *http.Request) {
 
 	db, err := c.db.BeginTx(r.Context())
 	if err != nil {
-		writeInternalError(w, err)
+		writeInternalError(w, r, err)
 		return
 	}
 	defer db.Rollback()
 
 	err = c.eStore.With(db.Conn()).APITokens().Delete(r.Context(), ns.Name, apiTokenName)
 	if err != nil {
-		writeDataStoreError(w, err)
+		writeDataStoreError(w, r, err)
 		return
 	}
 
 	err = db.Commit(r.Context())
 	if err != nil {
-		writeInternalError(w, err)
+		writeInternalError(w, r, err)
 		return
 	}
 
@@ -85,69 +161,274 @@ func (c *APITokensController) create(w http.ResponseWriter, r *http.Request) {
 
 	db, err := c.db.BeginTx(r.Context())
 	if err != nil {
-		writeInternalError(w, err)
+		writeInternalError(w, r, err)
 		return
 	}
 	defer db.Rollback()
 
 	// Parse request.
-	req := struct {
-		Name            string               `json:"name"`
-		Description     string               `json:"description"`
-		Permissions     eeDStore.Permissions `json:"permissions"`
-		DurationISO8601 string               `json:"duration"`
-	}{}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeNotJSONError(w, err)
-		return
-	}
-
-	// Parse the ISO 8601 in duration field.
-	duration, err := isoDuration.FromString(req.DurationISO8601)
-	if err != nil {
-		writeError(w, &Error{
-			Code:    "request_data_invalid",
-			Message: "request data has invalid fields",
-			Validation: map[string]string{
-				"duration": "invalid iso8601 duration format",
-			},
-		})
+	req := apiTokenCreateRequest{}
+	if !decodeJSON(w, r, &req) || !checkFields(w, r, req.validate()) {
+		return
+	}
 
+	// The duration was validated with the request.
+	lifetime, _ := parseTokenDuration(req.DurationISO8601)
+	store := c.eStore.With(db.Conn())
+	policy, err := tokenPolicy(r.Context(), store, ns.Name)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	expiredAt, err := policy.Expiry(time.Now(), lifetime, req.NeverExpires)
+	if err != nil {
+		writeDataStoreError(w, r, err)
+		return
+	}
+	vErrs, err := tokenDisallowedGrants(r.Context(), CallerFromContext(r.Context()), store, ns.Name,
+		req.Permissions, req.Roles, req.ServiceAccount)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if !checkGrants(w, r, vErrs) {
 		return
 	}
 
-	secret := uuid.New()
-	hash := eeDStore.HashTokenID(secret)
+	var (
+		secret                 string
+		hash                   uuid.UUID
+		lookupID, salt, digest string
+	)
+	switch req.Format {
+	case "", eeDStore.APITokenFormatSecret:
+		req.Format = eeDStore.APITokenFormatSecret
+		var key string
+		secret, lookupID, key, err = apitoken.NewSecret()
+		if err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+		salt, digest, err = apitoken.HashKey(key)
+		if err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+	case eeDStore.APITokenFormatJWT:
+		if c.signer == nil {
+			writeError(w, r, &Error{
+				Code:    CodeRequestDataInvalid,
+				Message: "request data has invalid fields",
+				Validation: map[string]string{
+					"format": "signed jwt tokens are not enabled",
+				},
+			})
+
+			return
+		}
+		// For signed tokens the hash column holds the jti claim, the secret is the token itself.
+		hash = uuid.New()
+	}
 
 	// Create apiToken.
-	apiToken, err := c.eStore.With(db.Conn()).APITokens().Create(r.Context(), &eeDStore.APIToken{
-		Name:        req.Name,
-		Namespace:   ns.Name,
-		Description: req.Description,
-		Hash:        hash,
-		Permissions: req.Permissions,
-	}, int(duration.ToDuration().Seconds()))
+	apiToken, err := store.APITokens().Create(r.Context(), &eeDStore.APIToken{
+		Name:           req.Name,
+		Namespace:      ns.Name,
+		Description:    req.Description,
+		Format:         req.Format,
+		Hash:           hash,
+		LookupID:       lookupID,
+		Salt:           salt,
+		Digest:         digest,
+		Permissions:    req.Permissions,
+		Roles:          req.Roles,
+		ServiceAccount: req.ServiceAccount,
+		ExpiredAt:      expiredAt,
+	})
 	if err != nil {
-		writeDataStoreError(w, err)
+		writeDataStoreError(w, r, err)
 
 		return
 	}
+	// Permissions are checked after creating so that roles and the service account are known to exist,
+	// the transaction is rolled back on violations.
+	err = checkTokenPermissions(r.Context(), store, policy, apiToken)
+	if err != nil {
+		writeDataStoreError(w, r, err)
+		return
+	}
+
+	if apiToken.Format == eeDStore.APITokenFormatJWT {
+		secret, err = c.signer.Sign(newAPITokenClaims(apiToken))
+		if err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+	}
 
 	err = db.Commit(r.Context())
 	if err != nil {
-		writeInternalError(w, err)
+		writeInternalError(w, r, err)
 		return
 	}
 
-	type res struct {
-		APIToken any    `json:"apiToken"`
-		Secret   string `json:"secret"`
+	writeJSON(w, &createdAPIToken{
+		APIToken: convertAPIToken(apiToken),
+		Secret:   secret,
+	})
+}
+
+func (c *APITokensController) update(w http.ResponseWriter, r *http.Request) {
+	ns := extractContextNamespace(r)
+	apiTokenName := chi.URLParam(r, "apiTokenName")
+
+	db, err := c.db.BeginTx(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
 	}
+	defer db.Rollback()
 
-	writeJSON(w, &res{
-		APIToken: convertAPIToken(apiToken),
-		Secret:   secret.String(),
+	// Parse request.
+	req := apiTokenUpdateRequest{}
+	if !decodeJSON(w, r, &req) || !checkFields(w, r, req.validate()) {
+		return
+	}
+
+	// The duration was validated with the request.
+	lifetime, _ := parseTokenDuration(req.DurationISO8601)
+	store := c.eStore.With(db.Conn())
+	current, err := store.APITokens().Get(r.Context(), ns.Name, apiTokenName)
+	if err != nil {
+		writeDataStoreError(w, r, err)
+		return
+	}
+	policy, err := tokenPolicy(r.Context(), store, ns.Name)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	// Without a duration the token keeps its expiry.
+	expiredAt := current.ExpiredAt
+	if lifetime > 0 || req.NeverExpires {
+		expiredAt, err = policy.Expiry(time.Now(), lifetime, req.NeverExpires)
+		if err != nil {
+			writeDataStoreError(w, r, err)
+			return
+		}
+	}
+
+	vErrs, err := tokenDisallowedGrants(r.Context(), CallerFromContext(r.Context()), store, ns.Name,
+		req.Permissions, req.Roles, current.ServiceAccount)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if !checkGrants(w, r, vErrs) {
+		return
+	}
+
+	apiToken, err := store.APITokens().Update(r.Context(), ns.Name, apiTokenName, &eeDStore.APIToken{
+		Description: req.Description,
+		Permissions: req.Permissions,
+		Roles:       req.Roles,
+		ExpiredAt:   expiredAt,
 	})
+	if err != nil {
+		writeDataStoreError(w, r, err)
+		return
+	}
+	err = checkTokenPermissions(r.Context(), store, policy, apiToken)
+	if err != nil {
+		writeDataStoreError(w, r, err)
+		return
+	}
+
+	err = db.Commit(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeJSON(w, convertAPIToken(apiToken))
+}
+
+// parseTokenDuration parses the optional ISO 8601 duration of a token, an empty duration is zero.
+func parseTokenDuration(str string) (time.Duration, error) {
+	if str == "" {
+		return 0, nil
+	}
+	duration, err := isoDuration.FromString(str)
+	if err != nil {
+		return 0, err
+	}
+
+	return duration.ToDuration(), nil
+}
+
+// tokenPolicy returns the token policy of the namespace, or the default one when none is set.
+func tokenPolicy(ctx context.Context, store eeDStore.StoreInner, namespace string) (*eeDStore.APITokenPolicy, error) {
+	policy, err := store.APITokenPolicies().Get(ctx, namespace)
+	if errors.Is(err, eeDStore.ErrNotFound) {
+		return eeDStore.DefaultAPITokenPolicy(namespace), nil
+	}
+
+	return policy, err
+}
+
+// checkTokenPermissions checks every permission a token grants, including the ones of its roles
+// and service account, against the permissions ceiling of the policy.
+func checkTokenPermissions(ctx context.Context, store eeDStore.StoreInner, policy *eeDStore.APITokenPolicy,
+	apiToken *eeDStore.APIToken,
+) error {
+	if policy.PermissionsCeiling == nil {
+		return nil
+	}
+	permissions, err := resolvePermissions(ctx, store, apiToken.Namespace, apiToken.Permissions, apiToken.Roles,
+		apiToken.ServiceAccount)
+	if err != nil {
+		return err
+	}
+
+	return policy.CheckPermissions(permissions)
+}
+
+func (c *APITokensController) jwks(w http.ResponseWriter, r *http.Request) {
+	keySet := jose.JSONWebKeySet{Keys: []jose.JSONWebKey{}}
+	if c.signer != nil {
+		keySet = c.signer.JWKS()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(keySet)
+}
+
+func newAPITokenClaims(v *eeDStore.APIToken) *apitoken.Claims {
+	permissions := make([]apitoken.Permission, len(v.Permissions))
+	for i := range permissions {
+		permissions[i] = apitoken.Permission{
+			Topic:  v.Permissions[i].Topic,
+			Method: v.Permissions[i].Method,
+		}
+	}
+
+	claims := &apitoken.Claims{
+		Claims: jwt.Claims{
+			ID:       v.Hash.String(),
+			Subject:  v.Name,
+			IssuedAt: jwt.NewNumericDate(v.CreatedAt),
+		},
+		Namespace:      v.Namespace,
+		Permissions:    permissions,
+		Roles:          v.Roles,
+		ServiceAccount: v.ServiceAccount,
+	}
+	if v.ExpiredAt != nil {
+		claims.Expiry = jwt.NewNumericDate(*v.ExpiredAt)
+	}
+
+	return claims
 }
 
 func (c *APITokensController) list(w http.ResponseWriter, r *http.Request) {
@@ -155,14 +436,43 @@ func (c *APITokensController) list(w http.ResponseWriter, r *http.Request) {
 
 	db, err := c.db.BeginTx(r.Context())
 	if err != nil {
-		writeInternalError(w, err)
+		writeInternalError(w, r, err)
 		return
 	}
 	defer db.Rollback()
 
-	list, err := c.eStore.With(db.Conn()).APITokens().List(r.Context(), ns.Name)
+	opts, vErrs := parseListOptions(r)
+	filter := eeDStore.APITokensFilter{
+		Topic:      r.URL.Query().Get("topic"),
+		NamePrefix: r.URL.Query().Get("namePrefix"),
+	}
+	// Tokens that were not used since the given time are candidates for cleanup.
+	if v := r.URL.Query().Get("unusedSince"); v != "" {
+		filter.UnusedSince, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			vErrs["unusedSince"] = "invalid rfc3339 time format"
+		}
+	}
+	if v := r.URL.Query().Get("expired"); v != "" {
+		expired, err := strconv.ParseBool(v)
+		if err != nil {
+			vErrs["expired"] = "must be one of 'true' or 'false'"
+		}
+		filter.Expired = &expired
+	}
+	if len(vErrs) > 0 {
+		writeError(w, r, &Error{
+			Code:       CodeRequestDataInvalid,
+			Message:    "request data has invalid fields",
+			Validation: vErrs,
+		})
+
+		return
+	}
+
+	list, next, err := c.eStore.With(db.Conn()).APITokens().List(r.Context(), ns.Name, filter, opts)
 	if err != nil {
-		writeDataStoreError(w, err)
+		writeDataStoreError(w, r, err)
 		return
 	}
 
@@ -171,17 +481,26 @@ func (c *APITokensController) list(w http.ResponseWriter, r *http.Request) {
 		res[i] = convertAPIToken(list[i])
 	}
 
-	writeJSON(w, res)
+	writeJSONPage(w, res, &pagination{Limit: opts.Limit, NextCursor: next})
 }
 
 func convertAPIToken(v *eeDStore.APIToken) any {
 	type apiTokenForAPI struct {
-		Name        string    `json:"name"`
-		Description string    `json:"description"`
-		Prefix      string    `json:"prefix"`
-		Permissions any       `json:"permissions"`
-		ExpiredAt   time.Time `json:"expiredAt"`
-		IsExpired   bool      `json:"isExpired"`
+		Name           string     `json:"name"`
+		Description    string     `json:"description"`
+		Format         string     `json:"format"`
+		Prefix         string     `json:"prefix"`
+		Permissions    any        `json:"permissions"`
+		Roles          []string   `json:"roles"`
+		ServiceAccount string     `json:"serviceAccount"`
+		ExpiredAt      *time.Time `json:"expiredAt"`
+		IsExpired      bool       `json:"isExpired"`
+
+		LastUsedAt         *time.Time `json:"lastUsedAt"`
+		LastUsedIP         string     `json:"lastUsedIp"`
+		LastUsedUserAgent  string     `json:"lastUsedUserAgent"`
+		RequestCount       int64      `json:"requestCount"`
+		RecentRequestCount int64      `json:"recentRequestCount"`
 
 		CreatedAt time.Time `json:"createdAt"`
 		UpdatedAt time.Time `json:"updatedAt"`
@@ -203,13 +522,27 @@ func convertAPIToken(v *eeDStore.APIToken) any {
 		permissions = nil
 	}
 
+	prefix := v.Hash.String()[0:8]
+	if v.Format == eeDStore.APITokenFormatSecret {
+		prefix = apitoken.SecretPrefix + v.LookupID
+	}
+
 	res := &apiTokenForAPI{
-		Name:        v.Name,
-		Description: v.Description,
-		Prefix:      v.Hash.String()[0:8],
-		Permissions: permissions,
-		ExpiredAt:   v.ExpiredAt,
-		IsExpired:   v.IsExpired,
+		Name:           v.Name,
+		Description:    v.Description,
+		Format:         v.Format,
+		Prefix:         prefix,
+		Permissions:    permissions,
+		Roles:          v.Roles,
+		ServiceAccount: v.ServiceAccount,
+		ExpiredAt:      v.ExpiredAt,
+		IsExpired:      v.IsExpired,
+
+		LastUsedAt:         v.LastUsedAt,
+		LastUsedIP:         v.LastUsedIP,
+		LastUsedUserAgent:  v.LastUsedUserAgent,
+		RequestCount:       v.RequestCount,
+		RecentRequestCount: v.RecentRequestCount,
 
 		CreatedAt: v.CreatedAt,
 		UpdatedAt: v.UpdatedAt,
@@ -0,0 +1,302 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	eeDStore "github.com/direktiv/direktiv/direktiv-ee/pkg/datastore"
+	"github.com/direktiv/direktiv/pkg/database"
+)
+
+// AuthLimitPolicy configures when clients are locked out after failed authentications.
+type AuthLimitPolicy struct {
+	// Threshold is the number of failures within Window that locks a key out.
+	Threshold int
+	// Window is how long failures are remembered after the last one.
+	Window time.Duration
+	// Lockout is the first lockout, it doubles with every further failure up to MaxLockout.
+	Lockout    time.Duration
+	MaxLockout time.Duration
+}
+
+var DefaultAuthLimitPolicy = AuthLimitPolicy{
+	Threshold:  5,
+	Window:     time.Minute * 15,
+	Lockout:    time.Second,
+	MaxLockout: time.Minute * 15,
+}
+
+// lockout returns how long a key is locked out after the given number of failures, zero when it is
+// below the threshold.
+func (p AuthLimitPolicy) lockout(failures int) time.Duration {
+	if p.Threshold <= 0 || failures < p.Threshold {
+		return 0
+	}
+	exp := failures - p.Threshold
+	if exp > 32 {
+		return p.MaxLockout
+	}
+	d := p.Lockout * time.Duration(math.Pow(2, float64(exp)))
+	if d <= 0 || d > p.MaxLockout {
+		return p.MaxLockout
+	}
+
+	return d
+}
+
+// AuthLimiter counts failed authentications by key, usually a client ip, and locks out keys with too
+// many failures.
+type AuthLimiter interface {
+	// LockedUntil returns when the lockout of key ends, the zero time when it isn't locked out.
+	LockedUntil(ctx context.Context, key string) (time.Time, error)
+	Fail(ctx context.Context, key string) error
+	// Purge forgets the keys that didn't fail within the window of the policy.
+	Purge(ctx context.Context) error
+}
+
+// memoryAuthLimiterKeys caps the keys of the memory limiter, so that failures from many addresses
+// can't exhaust the memory of a replica.
+const memoryAuthLimiterKeys = 100_000
+
+type memoryAuthLimiter struct {
+	policy  AuthLimitPolicy
+	now     func() time.Time
+	maxKeys int
+
+	mu       sync.Mutex
+	failures map[string]*eeDStore.AuthFailure
+}
+
+// NewMemoryAuthLimiter returns a limiter that counts failures of a single replica only.
+func NewMemoryAuthLimiter(policy AuthLimitPolicy) AuthLimiter {
+	return &memoryAuthLimiter{
+		policy:   policy,
+		now:      time.Now,
+		maxKeys:  memoryAuthLimiterKeys,
+		failures: map[string]*eeDStore.AuthFailure{},
+	}
+}
+
+func (l *memoryAuthLimiter) LockedUntil(_ context.Context, key string) (time.Time, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, ok := l.failures[key]
+	if !ok || f.LockedUntil == nil || !f.LockedUntil.After(l.now()) {
+		return time.Time{}, nil
+	}
+
+	return *f.LockedUntil, nil
+}
+
+func (l *memoryAuthLimiter) Fail(_ context.Context, key string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	f, ok := l.failures[key]
+	if !ok && len(l.failures) >= l.maxKeys {
+		l.evict(now)
+	}
+	if !ok || f.LastFailureAt.Before(now.Add(-l.policy.Window)) {
+		f = &eeDStore.AuthFailure{Key: key}
+		l.failures[key] = f
+	}
+	f.Failures++
+	f.LastFailureAt = now
+	if d := l.policy.lockout(f.Failures); d > 0 {
+		until := now.Add(d)
+		f.LockedUntil = &until
+	}
+
+	return nil
+}
+
+// evict makes room for a new key: it forgets the expired keys or else the key that failed longest
+// ago, keys that are locked out are kept as long as there are others.
+func (l *memoryAuthLimiter) evict(now time.Time) {
+	isLocked := func(f *eeDStore.AuthFailure) bool {
+		return f.LockedUntil != nil && f.LockedUntil.After(now)
+	}
+	var oldest *eeDStore.AuthFailure
+	for key, f := range l.failures {
+		if !isLocked(f) && f.LastFailureAt.Before(now.Add(-l.policy.Window)) {
+			delete(l.failures, key)
+			continue
+		}
+		switch {
+		case oldest == nil, isLocked(oldest) && !isLocked(f):
+			oldest = f
+		case isLocked(oldest) == isLocked(f) && f.LastFailureAt.Before(oldest.LastFailureAt):
+			oldest = f
+		}
+	}
+	if len(l.failures) >= l.maxKeys && oldest != nil {
+		delete(l.failures, oldest.Key)
+	}
+}
+
+func (l *memoryAuthLimiter) Purge(_ context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	for key, f := range l.failures {
+		locked := f.LockedUntil != nil && f.LockedUntil.After(now)
+		if !locked && f.LastFailureAt.Before(now.Add(-l.policy.Window)) {
+			delete(l.failures, key)
+		}
+	}
+
+	return nil
+}
+
+type datastoreAuthLimiter struct {
+	db     *database.DB
+	eStore eeDStore.Store
+	policy AuthLimitPolicy
+}
+
+// NewDatastoreAuthLimiter returns a limiter that counts failures in the database, so that all
+// replicas share the lockouts.
+func NewDatastoreAuthLimiter(db *database.DB, eStore eeDStore.Store, policy AuthLimitPolicy) AuthLimiter {
+	return &datastoreAuthLimiter{
+		db:     db,
+		eStore: eStore,
+		policy: policy,
+	}
+}
+
+func (l *datastoreAuthLimiter) store() eeDStore.AuthFailuresStore {
+	return l.eStore.With(l.db.Conn()).AuthFailures()
+}
+
+func (l *datastoreAuthLimiter) LockedUntil(ctx context.Context, key string) (time.Time, error) {
+	f, err := l.store().Get(ctx, key)
+	if errors.Is(err, eeDStore.ErrNotFound) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	if f.LockedUntil == nil || !f.LockedUntil.After(time.Now()) {
+		return time.Time{}, nil
+	}
+
+	return *f.LockedUntil, nil
+}
+
+func (l *datastoreAuthLimiter) Fail(ctx context.Context, key string) error {
+	now := time.Now()
+	f, err := l.store().Record(ctx, key, now.Add(-l.policy.Window))
+	if err != nil {
+		return err
+	}
+	if d := l.policy.lockout(f.Failures); d > 0 {
+		return l.store().Lock(ctx, key, now.Add(d))
+	}
+
+	return nil
+}
+
+func (l *datastoreAuthLimiter) Purge(ctx context.Context) error {
+	_, err := l.store().Purge(ctx, time.Now().Add(-l.policy.Window))
+
+	return err
+}
+
+// SetAuthLimiter enables the limit of failed authentications, there is none by default.
+func (c *Middlewares) SetAuthLimiter(limiter AuthLimiter) {
+	c.limiter = limiter
+}
+
+// RunAuthLimiter periodically purges the failures that the limiter doesn't need anymore until ctx
+// is done.
+func (c *Middlewares) RunAuthLimiter(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if c.limiter == nil {
+				continue
+			}
+			if err := c.limiter.Purge(ctx); err != nil {
+				slog.Error("purging failed authentications", "err", err)
+			}
+		}
+	}
+}
+
+// authLimitKeys returns the keys that a failed authentication of r counts against. Failures are only
+// counted per client ip: token prefixes are public, counting them would let anyone lock out a token.
+func (c *Middlewares) authLimitKeys(r *http.Request) []string {
+	return []string{"ip:" + c.clientIP(r)}
+}
+
+// checkAuthLimit writes a 429 error and returns false when one of keys is locked out.
+func (c *Middlewares) checkAuthLimit(w http.ResponseWriter, r *http.Request, keys []string) bool {
+	if c.limiter == nil {
+		return true
+	}
+	var until time.Time
+	for _, key := range keys {
+		t, err := c.limiter.LockedUntil(r.Context(), key)
+		if err != nil {
+			writeInternalError(w, r, err)
+			return false
+		}
+		if t.After(until) {
+			until = t
+		}
+	}
+	if until.IsZero() {
+		return true
+	}
+
+	retry := int(math.Ceil(time.Until(until).Seconds()))
+	countAuthLockout(r.Context())
+	w.Header().Set("Retry-After", strconv.Itoa(max(retry, 1)))
+	writeError(w, r, &Error{
+		Code:    CodeAccessRateLimited,
+		Message: fmt.Sprintf("too many failed authentications, retry in %d seconds", max(retry, 1)),
+	})
+
+	return false
+}
+
+// failAuth counts a failed authentication against keys.
+func (c *Middlewares) failAuth(r *http.Request, keys []string) {
+	if c.limiter == nil {
+		return
+	}
+	for _, key := range keys {
+		if err := c.limiter.Fail(r.Context(), key); err != nil {
+			slog.Error("recording failed authentication", "err", err, "key", key)
+		}
+	}
+}
+
+type authenticatedContextKey struct{}
+
+// withAuthenticated marks r as authenticated by CheckOidc, CheckAPIToken or CheckClientCert. CheckAPIKey
+// only limits and grants direct access to requests that bring the api key themselves.
+func withAuthenticated(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), authenticatedContextKey{}, true))
+}
+
+func isAuthenticated(r *http.Request) bool {
+	v, _ := r.Context().Value(authenticatedContextKey{}).(bool)
+
+	return v
+}
@@ -0,0 +1,186 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_AuthLimitPolicy_lockout(t *testing.T) {
+	p := AuthLimitPolicy{Threshold: 3, Window: time.Minute, Lockout: time.Second, MaxLockout: time.Second * 10}
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, 0},
+		{2, 0},
+		{3, time.Second},
+		{4, time.Second * 2},
+		{6, time.Second * 8},
+		{7, time.Second * 10},
+		{100, time.Second * 10},
+	}
+	for _, tt := range tests {
+		if got := p.lockout(tt.failures); got != tt.want {
+			t.Errorf("lockout(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func Test_memoryAuthLimiter(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	l := NewMemoryAuthLimiter(AuthLimitPolicy{Threshold: 2, Window: time.Minute, Lockout: time.Second, MaxLockout: time.Minute}).(*memoryAuthLimiter)
+	l.now = func() time.Time { return now }
+
+	lockedUntil := func(key string) time.Time {
+		t.Helper()
+		until, err := l.LockedUntil(ctx, key)
+		if err != nil {
+			t.Fatalf("LockedUntil() error = %v", err)
+		}
+
+		return until
+	}
+
+	_ = l.Fail(ctx, "ip:a")
+	if until := lockedUntil("ip:a"); !until.IsZero() {
+		t.Errorf("LockedUntil() = %v after one failure, want unlocked", until)
+	}
+	_ = l.Fail(ctx, "ip:a")
+	if until := lockedUntil("ip:a"); !until.Equal(now.Add(time.Second)) {
+		t.Errorf("LockedUntil() = %v, want %v", until, now.Add(time.Second))
+	}
+	if until := lockedUntil("ip:b"); !until.IsZero() {
+		t.Errorf("LockedUntil() = %v for another key, want unlocked", until)
+	}
+
+	// The lockout doubles with every failure after it ended.
+	now = now.Add(time.Second * 2)
+	if until := lockedUntil("ip:a"); !until.IsZero() {
+		t.Errorf("LockedUntil() = %v after the lockout, want unlocked", until)
+	}
+	_ = l.Fail(ctx, "ip:a")
+	if until := lockedUntil("ip:a"); !until.Equal(now.Add(time.Second * 2)) {
+		t.Errorf("LockedUntil() = %v, want %v", until, now.Add(time.Second*2))
+	}
+
+	// Failures are forgotten after the window.
+	_ = l.Fail(ctx, "ip:a")
+	now = now.Add(time.Minute * 2)
+	_ = l.Fail(ctx, "ip:a")
+	if until := lockedUntil("ip:a"); !until.IsZero() {
+		t.Errorf("LockedUntil() = %v after the window, want unlocked", until)
+	}
+
+	now = now.Add(time.Minute * 2)
+	_ = l.Purge(ctx)
+	if len(l.failures) != 0 {
+		t.Errorf("Purge() kept %v keys, want none", len(l.failures))
+	}
+}
+
+func Test_memoryAuthLimiter_maxKeys(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	l := NewMemoryAuthLimiter(AuthLimitPolicy{Threshold: 1, Window: time.Minute, Lockout: time.Minute, MaxLockout: time.Minute}).(*memoryAuthLimiter)
+	l.now = func() time.Time { return now }
+	l.maxKeys = 2
+
+	_ = l.Fail(ctx, "ip:locked")
+	l.policy.Threshold = 5
+	now = now.Add(time.Second)
+	_ = l.Fail(ctx, "ip:a")
+	now = now.Add(time.Second)
+	_ = l.Fail(ctx, "ip:b")
+
+	// The oldest key that isn't locked out makes room.
+	if len(l.failures) != 2 || l.failures["ip:a"] != nil {
+		t.Errorf("Fail() kept %v, want the locked out and the newest key", l.failures)
+	}
+	if until, _ := l.LockedUntil(ctx, "ip:locked"); until.IsZero() {
+		t.Errorf("LockedUntil() = %v after eviction, want locked", until)
+	}
+}
+
+func Test_clientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8, 192.168.1.1")
+	if err != nil {
+		t.Fatalf("ParseTrustedProxies() error = %v", err)
+	}
+	if _, err := ParseTrustedProxies("10.0.0.0/33"); err == nil {
+		t.Errorf("ParseTrustedProxies() error = nil for an invalid cidr")
+	}
+
+	tests := []struct {
+		name       string
+		proxies    bool
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{"no proxies", false, "10.0.0.5:1234", []string{"1.2.3.4"}, "10.0.0.5"},
+		{"untrusted remote", true, "8.8.8.8:1234", []string{"1.2.3.4"}, "8.8.8.8"},
+		{"trusted remote", true, "10.0.0.5:1234", []string{"1.2.3.4"}, "1.2.3.4"},
+		{"spoofed hops", true, "10.0.0.5:1234", []string{"6.6.6.6, 1.2.3.4, 192.168.1.1"}, "1.2.3.4"},
+		{"several headers", true, "10.0.0.5:1234", []string{"6.6.6.6", "1.2.3.4"}, "1.2.3.4"},
+		{"only proxies", true, "10.0.0.5:1234", []string{"10.0.0.6"}, "10.0.0.6"},
+		{"no header", true, "10.0.0.5:1234", nil, "10.0.0.5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Middlewares{}
+			if tt.proxies {
+				c.SetTrustedProxies(proxies)
+			}
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, v := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if got := c.clientIP(r); got != tt.want {
+				t.Errorf("clientIP() = %q, want %q", got, tt.want)
+			}
+			if keys := c.authLimitKeys(r); len(keys) != 1 || keys[0] != "ip:"+tt.want {
+				t.Errorf("authLimitKeys() = %v, want the client ip only", keys)
+			}
+		})
+	}
+}
+
+func Test_checkAuthLimit(t *testing.T) {
+	c := &Middlewares{limiter: NewMemoryAuthLimiter(AuthLimitPolicy{Threshold: 1, Window: time.Minute, Lockout: time.Second * 30, MaxLockout: time.Minute})}
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	keys := c.authLimitKeys(r)
+
+	if !c.checkAuthLimit(httptest.NewRecorder(), r, keys) {
+		t.Fatalf("checkAuthLimit() = false before failures")
+	}
+	c.failAuth(r, keys)
+
+	w := httptest.NewRecorder()
+	if c.checkAuthLimit(w, r, keys) {
+		t.Fatalf("checkAuthLimit() = true after lockout")
+	}
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("checkAuthLimit() status = %v, want %v", w.Code, http.StatusTooManyRequests)
+	}
+	if got := w.Header().Get("Retry-After"); got != "30" {
+		t.Errorf("checkAuthLimit() Retry-After = %q, want %q", got, "30")
+	}
+}
+
+func Test_checkAuthLimit_disabled(t *testing.T) {
+	c := &Middlewares{}
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	keys := c.authLimitKeys(r)
+
+	for range 10 {
+		c.failAuth(r, keys)
+	}
+	if !c.checkAuthLimit(httptest.NewRecorder(), r, keys) {
+		t.Errorf("checkAuthLimit() = false without a limiter")
+	}
+}
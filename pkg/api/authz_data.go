@@ -0,0 +1,61 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	eeDStore "github.com/direktiv/direktiv/direktiv-ee/pkg/datastore"
+)
+
+// authzRefresh is how long CheckAPIKey works on a copy of the roles, role assignments and certificate
+// bindings, changes to them take effect after at most this long.
+const authzRefresh = time.Second * 10
+
+// authzData is what CheckAPIKey needs of the datastore to authorize groups, users and client
+// certificates. It holds the assignments that were active at the fetch, CheckAPIKey checks them
+// against the time of the request again so that none outlives its end.
+type authzData struct {
+	roles       []*eeDStore.Role
+	assignments []*eeDStore.RoleAssignment
+	bindings    []*eeDStore.CertificateBinding
+	// namespaces are the names of all namespaces, only these are used as metric labels.
+	namespaces map[string]bool
+}
+
+func (c *Middlewares) fetchAuthzData(ctx context.Context) (*authzData, error) {
+	store := c.eStore.With(c.db.Conn())
+	roles, err := store.Roles().ListAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	assignments, err := store.RoleAssignments().ListActive(ctx, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	bindings, err := store.CertificateBindings().ListAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	list, err := c.db.DataStore().Namespaces().GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	namespaces := make(map[string]bool, len(list))
+	for _, ns := range list {
+		namespaces[ns.Name] = true
+	}
+
+	return &authzData{roles: roles, assignments: assignments, bindings: bindings, namespaces: namespaces}, nil
+}
+
+// activeAssignments returns the assignments that grant their role at the given time.
+func (d *authzData) activeAssignments(at time.Time) []*eeDStore.RoleAssignment {
+	var res []*eeDStore.RoleAssignment
+	for _, a := range d.assignments {
+		if a.IsActive(at) {
+			res = append(res, a)
+		}
+	}
+
+	return res
+}
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	eeDStore "github.com/direktiv/direktiv/direktiv-ee/pkg/datastore"
+)
+
+type callerContextKey struct{}
+
+// Caller is the identity of an authenticated request as established by CheckAPIKey.
+type Caller struct {
+	// Name identifies the caller in audit records like role revisions, it is "api_key" for direct
+	// api key access, "api_token:<namespace>/<name>" for api tokens and "oidc:<subject>" for oidc
+	// tokens, or just "oidc" when the token has no subject. Client certificates are named
+	// "client_cert:<uri>", or by their subject when they have no uri. Requests of impersonating admins
+	// are named "<admin> impersonating <identity>".
+	Name string
+	// Admin is set for direct api key access and members of the oidc admin group.
+	Admin bool
+	// Permissions are the effective permissions of the caller, including the ones of its roles.
+	Permissions eeDStore.Permissions
+}
+
+func withCaller(r *http.Request, caller *Caller) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), callerContextKey{}, caller))
+}
+
+// CallerFromContext returns the caller of a request, requests without a caller are only possible
+// when authentication is disabled and are treated as admins.
+func CallerFromContext(ctx context.Context) *Caller {
+	caller, ok := ctx.Value(callerContextKey{}).(*Caller)
+	if !ok {
+		return &Caller{Admin: true}
+	}
+
+	return caller
+}
+
+// holds reports whether the caller holds perm in the given namespace.
+func (c *Caller) holds(namespace string, perm *eeDStore.Permission) bool {
+	if c.Admin {
+		return true
+	}
+	var held eeDStore.Permissions
+	for _, p := range c.Permissions {
+		if p.Namespace == namespace {
+			held = append(held, p)
+		}
+	}
+
+	return held.Covers(perm)
+}
+
+// ownsNamespace reports whether the caller manages the roles of the namespace, namespace owners approve
+// role assignments.
+func (c *Caller) ownsNamespace(namespace string) bool {
+	return c.holds(namespace, &eeDStore.Permission{Topic: "roles", Method: "manage"})
+}
+
+// disallowedGrants returns a validation entry for every permission the caller doesn't hold itself,
+// granting them would escalate its privileges. Entries are keyed by field, like "permissions[1]".
+func (c *Caller) disallowedGrants(field, namespace string, permissions eeDStore.Permissions) map[string]string {
+	vErrs := map[string]string{}
+	for i, p := range permissions {
+		if !c.holds(namespace, p) {
+			vErrs[fmt.Sprintf("%s[%d]", field, i)] = fmt.Sprintf("caller doesn't hold permission '%s:%s'", p.Topic, p.Method)
+		}
+	}
+
+	return vErrs
+}
+
+// checkGrants writes a request_data_invalid error listing the disallowed grants and returns false
+// when there are any.
+func checkGrants(w http.ResponseWriter, r *http.Request, vErrs map[string]string) bool {
+	if len(vErrs) == 0 {
+		return true
+	}
+	writeError(w, r, &Error{
+		Code:       CodeRequestDataInvalid,
+		Message:    "request grants permissions the caller doesn't hold",
+		Validation: vErrs,
+	})
+
+	return false
+}
+
+// tokenDisallowedGrants checks the inline permissions of a token and the permissions of the roles it
+// references, roles are reported by their index in the roles field.
+func tokenDisallowedGrants(ctx context.Context, caller *Caller, store eeDStore.StoreInner, namespace string,
+	permissions eeDStore.Permissions, roles eeDStore.RoleRefs, serviceAccount string,
+) (map[string]string, error) {
+	if caller.Admin {
+		return nil, nil
+	}
+	vErrs := caller.disallowedGrants("permissions", namespace, permissions)
+	for i, roleName := range roles {
+		role, err := store.Roles().Get(ctx, namespace, roleName)
+		// Unknown roles are reported by the datastore.
+		if errors.Is(err, eeDStore.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range role.Permissions {
+			if !caller.holds(namespace, p) {
+				vErrs[fmt.Sprintf("roles[%d]", i)] = fmt.Sprintf("role '%s' grants permission '%s:%s' the caller doesn't hold",
+					roleName, p.Topic, p.Method)
+
+				break
+			}
+		}
+	}
+	if serviceAccount != "" {
+		sa, err := store.ServiceAccounts().Get(ctx, namespace, serviceAccount)
+		if err != nil && !errors.Is(err, eeDStore.ErrNotFound) {
+			return nil, err
+		}
+		if err == nil {
+			saPermissions, err := resolvePermissions(ctx, store, namespace, nil, sa.Roles, "")
+			if err != nil {
+				return nil, err
+			}
+			for _, p := range saPermissions {
+				if !caller.holds(namespace, p) {
+					vErrs["serviceAccount"] = fmt.Sprintf("service account '%s' grants permission '%s:%s' the caller doesn't hold",
+						serviceAccount, p.Topic, p.Method)
+
+					break
+				}
+			}
+		}
+	}
+
+	return vErrs, nil
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"context"
+	"testing"
+
+	eeDStore "github.com/direktiv/direktiv/direktiv-ee/pkg/datastore"
+)
+
+func Test_Caller_disallowedGrants(t *testing.T) {
+	caller := &Caller{
+		Permissions: eeDStore.Permissions{
+			{"ns1", "secrets", "read"},
+			{"ns1", "variables", "manage"},
+			{"ns2", "secrets", "manage"},
+		},
+	}
+
+	got := caller.disallowedGrants("permissions", "ns1", eeDStore.Permissions{
+		{"", "secrets", "GET"},
+		{"", "secrets", "manage"},
+		{"", "variables", "POST"},
+		{"", "files", "read"},
+	})
+	want := map[string]string{
+		"permissions[1]": "caller doesn't hold permission 'secrets:manage'",
+		"permissions[3]": "caller doesn't hold permission 'files:read'",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("disallowedGrants() = %v, want %v", got, want)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("disallowedGrants()[%s] = %v, want %v", k, got[k], v)
+		}
+	}
+
+	admin := &Caller{Admin: true}
+	if got := admin.disallowedGrants("permissions", "ns1", eeDStore.Permissions{{"", "secrets", "manage"}}); len(got) != 0 {
+		t.Errorf("disallowedGrants() = %v, want none for admins", got)
+	}
+}
+
+func Test_CallerFromContext(t *testing.T) {
+	// Without authentication every request is trusted.
+	if caller := CallerFromContext(context.Background()); !caller.Admin {
+		t.Errorf("CallerFromContext() = %v, want admin", caller)
+	}
+}
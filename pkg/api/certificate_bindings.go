@@ -0,0 +1,208 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	eeDStore "github.com/direktiv/direktiv/direktiv-ee/pkg/datastore"
+	"github.com/direktiv/direktiv/pkg/database"
+	"github.com/go-chi/chi/v5"
+)
+
+type CertificateBindingsController struct {
+	db     *database.DB
+	eStore eeDStore.Store
+}
+
+func NewCertificateBindingsController(db *database.DB, eStore eeDStore.Store) *CertificateBindingsController {
+	return &CertificateBindingsController{
+		db:     db,
+		eStore: eStore,
+	}
+}
+
+type certificateBindingRequest struct {
+	Name           string            `json:"name"`
+	Description    string            `json:"description"`
+	Subject        string            `json:"subject"`
+	URI            string            `json:"uri"`
+	Fingerprint    string            `json:"fingerprint"`
+	Roles          eeDStore.RoleRefs `json:"roles"`
+	ServiceAccount string            `json:"serviceAccount"`
+}
+
+func (req *certificateBindingRequest) binding(namespace string) *eeDStore.CertificateBinding {
+	return &eeDStore.CertificateBinding{
+		Name:           req.Name,
+		Namespace:      namespace,
+		Description:    req.Description,
+		Subject:        req.Subject,
+		URI:            req.URI,
+		Fingerprint:    eeDStore.NormalizeFingerprint(req.Fingerprint),
+		Roles:          req.Roles,
+		ServiceAccount: req.ServiceAccount,
+	}
+}
+
+func (req *certificateBindingRequest) validate() fieldErrors {
+	vErrs := fieldErrors{}
+	vErrs.name("name", req.Name, "")
+	vErrs.length("description", req.Description, maxDescriptionLength)
+	var invalid eeDStore.InvalidArgumentError
+	if errors.As(req.binding("").Validate(), &invalid) {
+		for field, msg := range invalid {
+			vErrs[field] = msg
+		}
+	}
+
+	return vErrs
+}
+
+func (c *CertificateBindingsController) MountRouter(r chi.Router) {
+	r.Get("/{bindingName}", c.get)
+	r.Delete("/{bindingName}", c.delete)
+
+	r.Get("/", c.list)
+	r.Post("/", c.create)
+}
+
+func (c *CertificateBindingsController) get(w http.ResponseWriter, r *http.Request) {
+	ns := extractContextNamespace(r)
+	name := chi.URLParam(r, "bindingName")
+
+	db, err := c.db.BeginTx(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer db.Rollback()
+
+	binding, err := c.eStore.With(db.Conn()).CertificateBindings().Get(r.Context(), ns.Name, name)
+	if err != nil {
+		writeDataStoreError(w, r, err)
+		return
+	}
+
+	writeJSON(w, convertCertificateBinding(binding))
+}
+
+func (c *CertificateBindingsController) delete(w http.ResponseWriter, r *http.Request) {
+	ns := extractContextNamespace(r)
+	name := chi.URLParam(r, "bindingName")
+
+	db, err := c.db.BeginTx(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer db.Rollback()
+
+	err = c.eStore.With(db.Conn()).CertificateBindings().Delete(r.Context(), ns.Name, name)
+	if err != nil {
+		writeDataStoreError(w, r, err)
+		return
+	}
+
+	err = db.Commit(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeOk(w)
+}
+
+func (c *CertificateBindingsController) create(w http.ResponseWriter, r *http.Request) {
+	ns := extractContextNamespace(r)
+
+	db, err := c.db.BeginTx(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer db.Rollback()
+
+	// Parse request.
+	req := certificateBindingRequest{}
+	if !decodeJSON(w, r, &req) || !checkFields(w, r, req.validate()) {
+		return
+	}
+
+	store := c.eStore.With(db.Conn())
+	vErrs, err := tokenDisallowedGrants(r.Context(), CallerFromContext(r.Context()), store, ns.Name,
+		nil, req.Roles, req.ServiceAccount)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if !checkGrants(w, r, vErrs) {
+		return
+	}
+
+	binding, err := store.CertificateBindings().Create(r.Context(), req.binding(ns.Name))
+	if err != nil {
+		writeDataStoreError(w, r, err)
+		return
+	}
+
+	err = db.Commit(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeJSON(w, convertCertificateBinding(binding))
+}
+
+func (c *CertificateBindingsController) list(w http.ResponseWriter, r *http.Request) {
+	ns := extractContextNamespace(r)
+
+	db, err := c.db.BeginTx(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer db.Rollback()
+
+	list, err := c.eStore.With(db.Conn()).CertificateBindings().List(r.Context(), ns.Name)
+	if err != nil {
+		writeDataStoreError(w, r, err)
+		return
+	}
+
+	res := make([]any, len(list))
+	for i := range list {
+		res[i] = convertCertificateBinding(list[i])
+	}
+
+	writeJSON(w, res)
+}
+
+func convertCertificateBinding(v *eeDStore.CertificateBinding) any {
+	type certificateBindingForAPI struct {
+		Name           string   `json:"name"`
+		Description    string   `json:"description"`
+		Subject        string   `json:"subject"`
+		URI            string   `json:"uri"`
+		Fingerprint    string   `json:"fingerprint"`
+		Roles          []string `json:"roles"`
+		ServiceAccount string   `json:"serviceAccount"`
+
+		CreatedAt time.Time `json:"createdAt"`
+		UpdatedAt time.Time `json:"updatedAt"`
+	}
+
+	return &certificateBindingForAPI{
+		Name:           v.Name,
+		Description:    v.Description,
+		Subject:        v.Subject,
+		URI:            v.URI,
+		Fingerprint:    v.Fingerprint,
+		Roles:          v.Roles,
+		ServiceAccount: v.ServiceAccount,
+
+		CreatedAt: v.CreatedAt,
+		UpdatedAt: v.UpdatedAt,
+	}
+}
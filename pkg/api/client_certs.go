@@ -0,0 +1,260 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	eeDStore "github.com/direktiv/direktiv/direktiv-ee/pkg/datastore"
+)
+
+// Headers that pass the identity of a verified client certificate on to CheckAPIKey, uris holds one
+// value per uri subject alternative name.
+const (
+	clientCertSubjectHeader     = "X-Client-Cert-Subject"
+	clientCertURIsHeader        = "X-Client-Cert-Uris"
+	clientCertFingerprintHeader = "X-Client-Cert-Fingerprint"
+)
+
+var (
+	errClientCertMalformed = errors.New("malformed client certificate")
+	errClientCertExpired   = errors.New("client certificate is expired")
+)
+
+// LoadClientCAs reads the CA bundles that client certificates are verified against, every file holds
+// one or more PEM encoded certificates.
+func LoadClientCAs(paths ...string) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	for _, p := range paths {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return nil, fmt.Errorf("reading ca bundle: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("ca bundle '%s' has no certificates", p)
+		}
+	}
+
+	return pool, nil
+}
+
+// SetClientCertificates enables CheckClientCert, certificates are verified against roots. Unless
+// header is empty, certificates are also read from that header, which a proxy that terminates tls
+// has to set to the URL encoded PEM of the client certificate. The header is only honored on
+// requests from the trusted proxies, see SetTrustedProxies.
+func (c *Middlewares) SetClientCertificates(roots *x509.CertPool, header string) {
+	c.clientCAs = roots
+	c.clientCertHeader = header
+}
+
+// CheckClientCert authenticates requests with a client certificate that is signed by one of the
+// trusted CAs. The certificate identity is checked against the certificate bindings by CheckAPIKey,
+// requests that carry a token are left to the other middlewares.
+func (c *Middlewares) CheckClientCert(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The certificate header is only ever set by a trusted proxy.
+		r = stripIdentityHeaders(r)
+		if c.clientCertHeader != "" && !c.isTrustedProxy(remoteIP(r)) {
+			r.Header.Del(c.clientCertHeader)
+		}
+		if c.clientCAs == nil || isAuthenticated(r) || hasBearerToken(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		chain, err := c.clientCertificates(r)
+		if err == nil && len(chain) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		r, span, step := startStep(r, "CheckClientCert", next)
+		defer span.End()
+
+		limitKeys := c.authLimitKeys(r)
+		if !c.checkAuthLimit(w, r, limitKeys) {
+			return
+		}
+		var identity certIdentity
+		if err == nil {
+			identity, err = verifyClientCert(chain, c.clientCAs, time.Now())
+		}
+		countClientCertVerification(r.Context(), clientCertResult(err))
+		if errors.Is(err, errClientCertExpired) {
+			writeError(w, r, &Error{
+				Code:    CodeAccessTokenInvalid,
+				Message: "client certificate is expired",
+			})
+
+			return
+		}
+		if err != nil {
+			c.failAuth(r, limitKeys)
+			writeError(w, r, &Error{
+				Code:    CodeAccessTokenInvalid,
+				Message: "client certificate is not trusted",
+			})
+
+			return
+		}
+
+		identity.setHeaders(r)
+		r.Header.Set("Direktiv-Api-Key", os.Getenv("DIREKTIV_API_KEY"))
+		step.ServeHTTP(w, withAuthenticated(r))
+	})
+}
+
+// hasBearerToken reports whether r carries an oidc token, CheckOidc may run after CheckClientCert.
+func hasBearerToken(r *http.Request) bool {
+	return strings.HasPrefix(strings.ToLower(r.Header.Get("Authorization")), "bearer ")
+}
+
+// clientCertificates returns the certificate chain the client presented, leaf first. Certificates of
+// the tls connection take precedence over the header.
+func (c *Middlewares) clientCertificates(r *http.Request) ([]*x509.Certificate, error) {
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		return r.TLS.PeerCertificates, nil
+	}
+	if c.clientCertHeader == "" || r.Header.Get(c.clientCertHeader) == "" {
+		return nil, nil
+	}
+	// PathUnescape keeps the '+' of base64 intact.
+	rest, err := url.PathUnescape(r.Header.Get(c.clientCertHeader))
+	if err != nil {
+		return nil, errClientCertMalformed
+	}
+	var chain []*x509.Certificate
+	data := []byte(rest)
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, errClientCertMalformed
+		}
+		chain = append(chain, cert)
+	}
+	if len(chain) == 0 {
+		return nil, errClientCertMalformed
+	}
+
+	return chain, nil
+}
+
+// verifyClientCert verifies that the leaf of chain is a client certificate signed by one of roots,
+// the rest of the chain may hold intermediates.
+func verifyClientCert(chain []*x509.Certificate, roots *x509.CertPool, now time.Time) (certIdentity, error) {
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := chain[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	var invalid x509.CertificateInvalidError
+	if errors.As(err, &invalid) && invalid.Reason == x509.Expired {
+		return certIdentity{}, fmt.Errorf("%w: %w", errClientCertExpired, err)
+	}
+	if err != nil {
+		return certIdentity{}, err
+	}
+
+	return newCertIdentity(chain[0]), nil
+}
+
+// certIdentity identifies the client of a verified certificate, certificate bindings match it by any
+// of its fields.
+type certIdentity struct {
+	Subject     string
+	URIs        []string
+	Fingerprint string
+}
+
+func newCertIdentity(cert *x509.Certificate) certIdentity {
+	digest := sha256.Sum256(cert.Raw)
+	identity := certIdentity{
+		Subject:     cert.Subject.String(),
+		Fingerprint: hex.EncodeToString(digest[:]),
+	}
+	for _, u := range cert.URIs {
+		identity.URIs = append(identity.URIs, u.String())
+	}
+
+	return identity
+}
+
+func certIdentityFromRequest(r *http.Request) certIdentity {
+	return certIdentity{
+		Subject:     r.Header.Get(clientCertSubjectHeader),
+		URIs:        r.Header.Values(clientCertURIsHeader),
+		Fingerprint: r.Header.Get(clientCertFingerprintHeader),
+	}
+}
+
+// setHeaders passes the identity on to CheckAPIKey, the empty identity removes the headers.
+func (i certIdentity) setHeaders(r *http.Request) {
+	r.Header.Del(clientCertSubjectHeader)
+	r.Header.Del(clientCertURIsHeader)
+	r.Header.Del(clientCertFingerprintHeader)
+	if i.Fingerprint == "" {
+		return
+	}
+	r.Header.Set(clientCertSubjectHeader, i.Subject)
+	for _, u := range i.URIs {
+		r.Header.Add(clientCertURIsHeader, u)
+	}
+	r.Header.Set(clientCertFingerprintHeader, i.Fingerprint)
+}
+
+// name identifies the client in audit records by its first uri, usually a SPIFFE ID, or else by its
+// subject.
+func (i certIdentity) name() string {
+	if len(i.URIs) > 0 {
+		return i.URIs[0]
+	}
+	if i.Subject != "" {
+		return i.Subject
+	}
+
+	return i.Fingerprint
+}
+
+// certPermissions returns the permissions that the certificate bindings of all namespaces grant to
+// the identity, they are cached by the fingerprint of the certificate.
+func (c *Middlewares) certPermissions(ctx context.Context, bindings []*eeDStore.CertificateBinding,
+	identity certIdentity,
+) (eeDStore.Permissions, error) {
+	var permissions eeDStore.Permissions
+	if cached, ok := c.certs.Get(identity.Fingerprint); ok {
+		_ = permissions.Scan(cached)
+		return permissions, nil
+	}
+
+	store := c.eStore.With(c.db.Conn())
+	for _, b := range bindings {
+		if !b.Matches(identity.Subject, identity.URIs, identity.Fingerprint) {
+			continue
+		}
+		granted, err := resolvePermissions(ctx, store, b.Namespace, nil, b.Roles, b.ServiceAccount)
+		if err != nil {
+			return nil, fmt.Errorf("resolving certificate binding '%s/%s': %w", b.Namespace, b.Name, err)
+		}
+		permissions = append(permissions, granted...)
+	}
+	c.certs.Add(identity.Fingerprint, permissions.String())
+
+	return permissions, nil
+}
@@ -0,0 +1,209 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// testCert issues a certificate for template, self-signed when parent is nil.
+func testCert(t *testing.T, template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey,
+) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey() error = %v", err)
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificate() error = %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("x509.ParseCertificate() error = %v", err)
+	}
+
+	return cert, key
+}
+
+func testClientCerts(t *testing.T) (*x509.CertPool, map[string]*x509.Certificate) {
+	t.Helper()
+	now := time.Now()
+	ca, caKey := testCert(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+	other, otherKey := testCert(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(2),
+		Subject:               pkix.Name{CommonName: "other"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+	spiffe, _ := url.Parse("spiffe://example.org/deploy")
+	leaf := func(serial int64, notAfter time.Time, usage x509.ExtKeyUsage) *x509.Certificate {
+		return &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: "deploy", Organization: []string{"Acme"}},
+			URIs:         []*url.URL{spiffe},
+			NotBefore:    now.Add(-time.Hour),
+			NotAfter:     notAfter,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		}
+	}
+
+	certs := map[string]*x509.Certificate{}
+	certs["client"], _ = testCert(t, leaf(3, now.Add(time.Hour), x509.ExtKeyUsageClientAuth), ca, caKey)
+	certs["expired"], _ = testCert(t, leaf(4, now.Add(-time.Minute), x509.ExtKeyUsageClientAuth), ca, caKey)
+	certs["server"], _ = testCert(t, leaf(5, now.Add(time.Hour), x509.ExtKeyUsageServerAuth), ca, caKey)
+	certs["untrusted"], _ = testCert(t, leaf(6, now.Add(time.Hour), x509.ExtKeyUsageClientAuth), other, otherKey)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+
+	return roots, certs
+}
+
+func Test_verifyClientCert(t *testing.T) {
+	roots, certs := testClientCerts(t)
+
+	identity, err := verifyClientCert([]*x509.Certificate{certs["client"]}, roots, time.Now())
+	if err != nil {
+		t.Fatalf("verifyClientCert() error = %v", err)
+	}
+	if identity.Subject != "CN=deploy,O=Acme" {
+		t.Errorf("verifyClientCert() subject = %q, want %q", identity.Subject, "CN=deploy,O=Acme")
+	}
+	if len(identity.URIs) != 1 || identity.URIs[0] != "spiffe://example.org/deploy" {
+		t.Errorf("verifyClientCert() uris = %v", identity.URIs)
+	}
+	if len(identity.Fingerprint) != 64 {
+		t.Errorf("verifyClientCert() fingerprint = %q, want a sha-256 digest", identity.Fingerprint)
+	}
+	if identity.name() != "spiffe://example.org/deploy" {
+		t.Errorf("name() = %q, want the spiffe id", identity.name())
+	}
+
+	_, err = verifyClientCert([]*x509.Certificate{certs["expired"]}, roots, time.Now())
+	if !errors.Is(err, errClientCertExpired) {
+		t.Errorf("verifyClientCert() error = %v for an expired certificate, want %v", err, errClientCertExpired)
+	}
+	for _, name := range []string{"server", "untrusted"} {
+		_, err = verifyClientCert([]*x509.Certificate{certs[name]}, roots, time.Now())
+		if err == nil || errors.Is(err, errClientCertExpired) {
+			t.Errorf("verifyClientCert() error = %v for the %s certificate, want untrusted", err, name)
+		}
+	}
+}
+
+func Test_CheckClientCert(t *testing.T) {
+	t.Setenv("DIREKTIV_API_KEY", "password")
+	roots, certs := testClientCerts(t)
+	c := &Middlewares{limiter: NewMemoryAuthLimiter(DefaultAuthLimitPolicy)}
+	c.SetClientCertificates(roots, "X-Ssl-Client-Cert")
+	proxies, _ := ParseTrustedProxies("192.0.2.1")
+	c.SetTrustedProxies(proxies)
+
+	var got *http.Request
+	handler := c.CheckClientCert(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+	}))
+	send := func(cert *x509.Certificate, headers map[string]string) *httptest.ResponseRecorder {
+		got = nil
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/api/v2/namespaces/ns/secrets", nil)
+		if cert != nil {
+			pemCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+			r.Header.Set("X-Ssl-Client-Cert", url.PathEscape(string(pemCert)))
+		}
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+		handler.ServeHTTP(w, r)
+
+		return w
+	}
+
+	if w := send(certs["client"], nil); w.Code != http.StatusOK || got == nil {
+		t.Fatalf("CheckClientCert() status = %d, want %d", w.Code, http.StatusOK)
+	}
+	identity := certIdentityFromRequest(got)
+	if identity.name() != "spiffe://example.org/deploy" || identity.Fingerprint == "" {
+		t.Errorf("CheckClientCert() passed on identity %+v", identity)
+	}
+	if got.Header.Get("Direktiv-Api-Key") != "password" || !isAuthenticated(got) {
+		t.Errorf("CheckClientCert() didn't authenticate the request")
+	}
+
+	// Identities are never taken from the client.
+	send(nil, map[string]string{clientCertFingerprintHeader: "forged", clientCertSubjectHeader: "CN=admin"})
+	if got == nil || got.Header.Get(clientCertFingerprintHeader) != "" || got.Header.Get(clientCertSubjectHeader) != "" {
+		t.Errorf("CheckClientCert() kept the identity headers of the client")
+	}
+	send(certs["client"], map[string]string{
+		"X-Oidc-Groups":    "admin",
+		oidcSubjectHeader:  "admin",
+		oidcEmailHeader:    "admin@example.org",
+		"X-Permissions":    "secrets:POST",
+		apiTokenNameHeader: "ns/admin",
+	})
+	if got == nil || certIdentityFromRequest(got).Fingerprint == "" {
+		t.Fatalf("CheckClientCert() rejected a certificate with forged identity headers")
+	}
+	for _, h := range []string{"X-Oidc-Groups", oidcSubjectHeader, oidcEmailHeader, "X-Permissions", apiTokenNameHeader} {
+		if got.Header.Get(h) != "" {
+			t.Errorf("CheckClientCert() kept the forged %s header", h)
+		}
+	}
+	// The certificate header is only honored from trusted proxies.
+	c.SetTrustedProxies(nil)
+	send(certs["client"], nil)
+	if got == nil || got.Header.Get("X-Ssl-Client-Cert") != "" || isAuthenticated(got) {
+		t.Errorf("CheckClientCert() honored the certificate header of a client that isn't a trusted proxy")
+	}
+	c.SetTrustedProxies(proxies)
+	// Tokens take precedence over certificates.
+	send(certs["client"], map[string]string{"Authorization": "Bearer token"})
+	if got == nil || got.Header.Get(clientCertFingerprintHeader) != "" {
+		t.Errorf("CheckClientCert() checked the certificate of a request with a bearer token")
+	}
+
+	tests := []struct {
+		name   string
+		cert   *x509.Certificate
+		header string
+	}{
+		{"expired", certs["expired"], ""},
+		{"untrusted", certs["untrusted"], ""},
+		{"malformed", nil, "not a certificate"},
+	}
+	for _, tt := range tests {
+		var headers map[string]string
+		if tt.header != "" {
+			headers = map[string]string{"X-Ssl-Client-Cert": tt.header}
+		}
+		if w := send(tt.cert, headers); w.Code != http.StatusUnauthorized || got != nil {
+			t.Errorf("CheckClientCert() status = %d for the %s certificate, want %d", w.Code, tt.name, http.StatusUnauthorized)
+		}
+	}
+}
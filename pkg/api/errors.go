@@ -0,0 +1,200 @@
+package api
+
+import (
+	"encoding/json"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// ErrorCode identifies the kind of an api error, every code has a fixed http status in errorCatalogue.
+type ErrorCode string
+
+const (
+	// CodeAccessTokenMissing and CodeAccessTokenInvalid fail authentication, the request carries no
+	// credentials or credentials that are unknown, malformed, expired or revoked.
+	CodeAccessTokenMissing ErrorCode = "access_token_missing"
+	CodeAccessTokenInvalid ErrorCode = "access_token_invalid"
+	// CodeAccessTokenDenied fails authorization, the caller is known but not allowed to do what it
+	// asked for.
+	CodeAccessTokenDenied ErrorCode = "access_token_denied"
+	// CodeAccessRateLimited rejects clients that failed to authenticate too often, the response has a
+	// Retry-After header.
+	CodeAccessRateLimited ErrorCode = "access_rate_limited"
+	// CodeQuotaExceeded rejects requests to a namespace or of an api token that used up the quota of
+	// the current minute, the response has a Retry-After header.
+	CodeQuotaExceeded ErrorCode = "quota_exceeded"
+
+	CodeInternal ErrorCode = "internal"
+
+	CodeRequestPathNotFound        ErrorCode = "request_path_not_found"
+	CodeRequestMethodNotAllowed    ErrorCode = "request_method_not_allowed"
+	CodeRequestBodyNotJSON         ErrorCode = "request_body_not_json"
+	CodeRequestBodyBadJSONSchema   ErrorCode = "request_body_bad_json_schema"
+	CodeRequestBodyTooLarge        ErrorCode = "request_body_too_large"
+	CodeRequestContentTypeInvalid  ErrorCode = "request_content_type_invalid"
+	CodeRequestDataInvalid         ErrorCode = "request_data_invalid"
+	CodeResourceNotFound           ErrorCode = "resource_not_found"
+	CodeResourceAlreadyExists      ErrorCode = "resource_already_exists"
+	CodeResourceReadOnly           ErrorCode = "resource_read_only"
+	CodeResourceDeclarationInvalid ErrorCode = "resource_declaration_invalid"
+	CodeResourceSyncInProgress     ErrorCode = "resource_sync_in_progress"
+	CodeResourceConflict           ErrorCode = "resource_conflict"
+	CodeResourcePreconditionFailed ErrorCode = "resource_precondition_failed"
+)
+
+type errorKind struct {
+	status int
+	// title is the short summary of problem responses, it is the same for every error of a code.
+	title string
+}
+
+// errorCatalogue lists every code writeError accepts, unknown codes are written as internal errors.
+var errorCatalogue = map[ErrorCode]errorKind{
+	CodeAccessTokenMissing: {http.StatusUnauthorized, "Missing credentials"},
+	CodeAccessTokenInvalid: {http.StatusUnauthorized, "Invalid credentials"},
+	CodeAccessTokenDenied:  {http.StatusForbidden, "Access denied"},
+	CodeAccessRateLimited:  {http.StatusTooManyRequests, "Too many failed authentications"},
+	CodeQuotaExceeded:      {http.StatusTooManyRequests, "Quota exceeded"},
+
+	CodeInternal: {http.StatusInternalServerError, "Internal server error"},
+
+	CodeRequestPathNotFound:        {http.StatusNotFound, "Path not found"},
+	CodeRequestMethodNotAllowed:    {http.StatusMethodNotAllowed, "Method not allowed"},
+	CodeRequestBodyNotJSON:         {http.StatusBadRequest, "Request body is not json"},
+	CodeRequestBodyBadJSONSchema:   {http.StatusBadRequest, "Request body has bad json schema"},
+	CodeRequestBodyTooLarge:        {http.StatusRequestEntityTooLarge, "Request body too large"},
+	CodeRequestContentTypeInvalid:  {http.StatusBadRequest, "Invalid content type"},
+	CodeRequestDataInvalid:         {http.StatusBadRequest, "Invalid request data"},
+	CodeResourceNotFound:           {http.StatusNotFound, "Resource not found"},
+	CodeResourceAlreadyExists:      {http.StatusBadRequest, "Resource already exists"},
+	CodeResourceReadOnly:           {http.StatusBadRequest, "Resource is read only"},
+	CodeResourceDeclarationInvalid: {http.StatusBadRequest, "Invalid resource declaration"},
+	CodeResourceSyncInProgress:     {http.StatusBadRequest, "Resource sync in progress"},
+	CodeResourceConflict:           {http.StatusConflict, "Resource conflict"},
+	CodeResourcePreconditionFailed: {http.StatusPreconditionFailed, "Precondition failed"},
+}
+
+type Error struct {
+	Code       ErrorCode         `json:"code"`
+	Message    string            `json:"message"`
+	Validation map[string]string `json:"validation"`
+	// RequestID is set by writeError, it matches the X-Request-Id response header.
+	RequestID string `json:"requestId"`
+}
+
+// problem is an RFC 7807 problem details document, it is written instead of the error envelope
+// when the client accepts application/problem+json.
+type problem struct {
+	Type       string            `json:"type"`
+	Title      string            `json:"title"`
+	Status     int               `json:"status"`
+	Detail     string            `json:"detail"`
+	Instance   string            `json:"instance"`
+	Code       ErrorCode         `json:"code"`
+	RequestID  string            `json:"requestId"`
+	Validation map[string]string `json:"validation,omitempty"`
+}
+
+const (
+	requestIDHeader    = "X-Request-Id"
+	problemContentType = "application/problem+json"
+	// authChallenge is sent with every 401, credentials are passed as bearer oidc tokens or in the
+	// Direktiv-Api-Key and Direktiv-Api-Token headers.
+	authChallenge = `Bearer realm="direktiv"`
+)
+
+func writeError(w http.ResponseWriter, r *http.Request, err *Error) {
+	kind, ok := errorCatalogue[err.Code]
+	if !ok {
+		slog.Error("unknown api error code", "code", err.Code)
+		kind = errorCatalogue[CodeInternal]
+	}
+
+	err.RequestID = requestID(r)
+	w.Header().Set(requestIDHeader, err.RequestID)
+	switch err.Code {
+	case CodeAccessTokenMissing:
+		w.Header().Set("WWW-Authenticate", authChallenge)
+	case CodeAccessTokenInvalid:
+		w.Header().Set("WWW-Authenticate", authChallenge+`, error="invalid_token"`)
+	default:
+	}
+
+	if acceptsProblem(r) {
+		w.Header().Set("Content-Type", problemContentType)
+		w.WriteHeader(kind.status)
+		_ = json.NewEncoder(w).Encode(&problem{
+			Type:       "urn:direktiv:error:" + string(err.Code),
+			Title:      kind.title,
+			Status:     kind.status,
+			Detail:     err.Message,
+			Instance:   r.URL.Path,
+			Code:       err.Code,
+			RequestID:  err.RequestID,
+			Validation: err.Validation,
+		})
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(kind.status)
+
+	payLoad := struct {
+		Error *Error `json:"error"`
+	}{
+		Error: err,
+	}
+
+	_ = json.NewEncoder(w).Encode(payLoad)
+}
+
+// maxRequestIDLength bounds the X-Request-Id that clients can send.
+const maxRequestIDLength = 128
+
+// requestID returns the X-Request-Id of r, requests without a valid one get a random id that is
+// kept on r so that later calls return the same.
+func requestID(r *http.Request) string {
+	id := r.Header.Get(requestIDHeader)
+	if isValidRequestID(id) {
+		return id
+	}
+	id = uuid.NewString()
+	r.Header.Set(requestIDHeader, id)
+
+	return id
+}
+
+// isValidRequestID reports whether id is safe to echo in headers and logs: letters, digits and
+// "-", "_", ".", ":" up to maxRequestIDLength.
+func isValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// acceptsProblem reports whether the Accept header of r lists application/problem+json.
+func acceptsProblem(r *http.Request) bool {
+	for _, v := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(v))
+		if err == nil && mediaType == problemContentType {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_writeError(t *testing.T) {
+	tests := []struct {
+		code       ErrorCode
+		wantStatus int
+		wantAuth   string
+	}{
+		{CodeAccessTokenMissing, http.StatusUnauthorized, `Bearer realm="direktiv"`},
+		{CodeAccessTokenInvalid, http.StatusUnauthorized, `Bearer realm="direktiv", error="invalid_token"`},
+		{CodeAccessTokenDenied, http.StatusForbidden, ""},
+		{CodeResourceNotFound, http.StatusNotFound, ""},
+		{CodeResourceConflict, http.StatusConflict, ""},
+		{"unknown", http.StatusInternalServerError, ""},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.code), func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set(requestIDHeader, "abc")
+			writeError(w, r, &Error{Code: tt.code, Message: "message"})
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("writeError() status = %v, want %v", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("WWW-Authenticate"); got != tt.wantAuth {
+				t.Errorf("writeError() WWW-Authenticate = %q, want %q", got, tt.wantAuth)
+			}
+			var res struct {
+				Error *Error `json:"error"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+				t.Fatalf("decoding response error = %v", err)
+			}
+			if res.Error.Code != tt.code || res.Error.RequestID != "abc" {
+				t.Errorf("writeError() body = %+v", res.Error)
+			}
+		})
+	}
+}
+
+func Test_writeError_problem(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/api/v2/namespaces/ns/roles", nil)
+	r.Header.Set("Accept", "application/json;q=0.5, application/problem+json")
+	writeError(w, r, &Error{
+		Code:       CodeRequestDataInvalid,
+		Message:    "request data has invalid fields",
+		Validation: map[string]string{"name": "is required"},
+	})
+
+	if got := w.Header().Get("Content-Type"); got != problemContentType {
+		t.Errorf("writeError() Content-Type = %v, want %v", got, problemContentType)
+	}
+	var res problem
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+		t.Fatalf("decoding response error = %v", err)
+	}
+	if res.Type != "urn:direktiv:error:request_data_invalid" || res.Status != http.StatusBadRequest ||
+		res.Instance != "/api/v2/namespaces/ns/roles" || res.Validation["name"] == "" {
+		t.Errorf("writeError() problem = %+v", res)
+	}
+	// A generated request id is returned in both the body and the header.
+	if res.RequestID == "" || res.RequestID != w.Header().Get(requestIDHeader) {
+		t.Errorf("writeError() request id = %q, header %q", res.RequestID, w.Header().Get(requestIDHeader))
+	}
+}
+
+func Test_errorCatalogue(t *testing.T) {
+	for code, kind := range errorCatalogue {
+		if kind.status < 400 || kind.title == "" {
+			t.Errorf("errorCatalogue[%s] = %+v", code, kind)
+		}
+	}
+}
+
+func Test_requestID(t *testing.T) {
+	tests := []struct {
+		id   string
+		keep bool
+	}{
+		{"0b8f7c6e-1d3a-4c55-9a8e-2f1c3b4d5e6f", true},
+		{"trace:abc_1.2", true},
+		{"", false},
+		{"has space", false},
+		{"<script>", false},
+		{"line\nbreak", false},
+		{strings.Repeat("a", maxRequestIDLength), true},
+		{strings.Repeat("a", maxRequestIDLength+1), false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header[requestIDHeader] = []string{tt.id}
+		got := requestID(r)
+		if (got == tt.id) != tt.keep {
+			t.Errorf("requestID() = %q for %q, want kept %v", got, tt.id, tt.keep)
+		}
+		if !isValidRequestID(got) || requestID(r) != got {
+			t.Errorf("requestID() = %q for %q, want a stable valid id", got, tt.id)
+		}
+	}
+}
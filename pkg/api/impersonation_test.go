@@ -0,0 +1,51 @@
+package api
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func Test_impersonationFromRequest(t *testing.T) {
+	tests := []struct {
+		name       string
+		headers    map[string]string
+		want       *impersonation
+		wantString string
+	}{
+		{
+			name: "none",
+		},
+		{
+			name:    "empty groups",
+			headers: map[string]string{impersonateGroupsHeader: " , "},
+		},
+		{
+			name:       "user and groups",
+			headers:    map[string]string{impersonateGroupsHeader: "g1, g2", impersonateUserHeader: "alice"},
+			want:       &impersonation{Groups: []string{"g1", "g2"}, User: "alice"},
+			wantString: "user:alice groups:g1,g2",
+		},
+		{
+			name:       "token",
+			headers:    map[string]string{impersonateTokenHeader: "ns/ci"},
+			want:       &impersonation{Token: "ns/ci"},
+			wantString: "api_token:ns/ci",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			got := impersonationFromRequest(r)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("impersonationFromRequest() = %+v, want %+v", got, tt.want)
+			}
+			if got != nil && got.String() != tt.wantString {
+				t.Errorf("String() = %v, want %v", got.String(), tt.wantString)
+			}
+		})
+	}
+}
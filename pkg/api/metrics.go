@@ -0,0 +1,213 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	eeDStore "github.com/direktiv/direktiv/direktiv-ee/pkg/datastore"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var (
+	oidcVerifications = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "direktiv_oidc_verifications_total",
+		Help: "Number of oidc bearer tokens checked, by result. Failures are labeled with their reason.",
+	}, []string{"result"})
+	oidcVerificationDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "direktiv_oidc_verification_duration_seconds",
+		Help:    "Time to verify an oidc bearer token that isn't cached, including provider discovery.",
+		Buckets: prometheus.DefBuckets,
+	})
+	apiTokenLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "direktiv_api_token_lookups_total",
+		Help: "Number of api tokens checked, by format (opaque or jwt) and result.",
+	}, []string{"format", "result"})
+	clientCertVerifications = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "direktiv_client_cert_verifications_total",
+		Help: "Number of client certificates verified against the trusted CAs, by result.",
+	}, []string{"result"})
+	authzDecisions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "direktiv_authz_decisions_total",
+		Help: "Number of authorization decisions, by namespace, topic and outcome.",
+	}, []string{"namespace", "topic", "outcome"})
+	authLockouts = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "direktiv_auth_lockouts_total",
+		Help: "Number of requests rejected because their client ip or api token is locked out after failed authentications.",
+	})
+	quotaRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "direktiv_quota_rejections_total",
+		Help: "Number of requests rejected because their namespace or api token exceeded its quota, by kind.",
+	}, []string{"kind"})
+)
+
+// Results of oidc verifications and api token lookups, failures that can point at brute force
+// attempts have their own result.
+const (
+	resultCached  = "cached"
+	resultOK      = "ok"
+	resultUnknown = "unknown"
+	resultExpired = "expired"
+	resultRevoked = "revoked"
+	resultInvalid = "invalid"
+	resultDenied  = "denied"
+	resultError   = "error"
+)
+
+const (
+	apiTokenFormatOpaque = "opaque"
+	apiTokenFormatSigned = "jwt"
+)
+
+// Outcomes of authorization decisions.
+const (
+	outcomeAllowed = "allowed"
+	outcomeDenied  = "denied"
+	// outcomeAdmin is recorded for the api key and the admin group, they are never checked.
+	outcomeAdmin = "admin"
+)
+
+var (
+	errOidcDiscovery = errors.New("error creating oidc provider")
+	errOidcVerify    = errors.New("error verifying token")
+	errOidcClaims    = errors.New("error parsing token claims")
+	// errOidcNoIdentity is returned for valid tokens without groups, subject and email.
+	errOidcNoIdentity = errors.New("empty oidc groups and subject in claims")
+)
+
+// observeOidcVerification records an uncached oidc verification that started at start.
+func observeOidcVerification(ctx context.Context, start time.Time, err error) {
+	oidcVerificationDuration.Observe(time.Since(start).Seconds())
+	countOidcVerification(ctx, oidcVerificationResult(err))
+}
+
+// countOidcVerification counts an oidc verification and records its result on the span of ctx.
+func countOidcVerification(ctx context.Context, result string) {
+	oidcVerifications.WithLabelValues(result).Inc()
+	setSpanResult(ctx, attribute.String(attrAuthResult, result))
+}
+
+// countAPITokenLookup counts an api token lookup and records its result on the span of ctx.
+func countAPITokenLookup(ctx context.Context, format, result string) {
+	apiTokenLookups.WithLabelValues(format, result).Inc()
+	setSpanResult(ctx, attribute.String(attrAPITokenFormat, format), attribute.String(attrAuthResult, result))
+}
+
+// countClientCertVerification counts a client certificate verification and records its result on
+// the span of ctx.
+func countClientCertVerification(ctx context.Context, result string) {
+	clientCertVerifications.WithLabelValues(result).Inc()
+	setSpanResult(ctx, attribute.String(attrAuthResult, result))
+}
+
+// countAuthLockout counts a request rejected by the limiter of failed authentications.
+func countAuthLockout(ctx context.Context) {
+	authLockouts.Inc()
+	setSpanResult(ctx, attribute.String(attrAuthResult, "locked_out"))
+}
+
+// countQuotaRejection counts a request rejected by CheckQuota.
+func countQuotaRejection(ctx context.Context, kind string) {
+	quotaRejections.WithLabelValues(kind).Inc()
+	setSpanResult(ctx, attribute.String(attrQuotaKind, kind))
+}
+
+// oidcVerificationResult maps the error of verifying an oidc token to its result.
+func oidcVerificationResult(err error) string {
+	switch {
+	case err == nil:
+		return resultOK
+	case errors.Is(err, errOidcDiscovery):
+		return "discovery_failed"
+	case errors.Is(err, errOidcVerify):
+		return resultInvalid
+	case errors.Is(err, errOidcClaims):
+		return "bad_claims"
+	case errors.Is(err, errOidcNoIdentity):
+		return "no_identity"
+	default:
+		return resultError
+	}
+}
+
+// apiTokenLookupResult maps the error of looking up an opaque api token to its result.
+func apiTokenLookupResult(err error) string {
+	switch {
+	case err == nil:
+		return resultOK
+	case errors.Is(err, errAPITokenFormat):
+		return resultInvalid
+	case errors.Is(err, eeDStore.ErrNotFound):
+		return resultUnknown
+	default:
+		return resultError
+	}
+}
+
+// clientCertResult maps the error of verifying a client certificate to its result.
+func clientCertResult(err error) string {
+	switch {
+	case err == nil:
+		return resultOK
+	case errors.Is(err, errClientCertMalformed):
+		return "malformed"
+	case errors.Is(err, errClientCertExpired):
+		return resultExpired
+	default:
+		return resultInvalid
+	}
+}
+
+// recordAuthzDecision counts a decision of CheckAPIKey and records it on the span of ctx. Namespace
+// and topic come from the request path, only namespaces that exist and topics permissions can grant
+// are used as label, anything else is counted as "other" to keep the number of series bounded.
+func (c *Middlewares) recordAuthzDecision(ctx context.Context, namespace, topic, outcome string) {
+	if !eeDStore.IsTopic(topic) {
+		topic = "other"
+	}
+	authzDecisions.WithLabelValues(c.namespaceLabel(namespace), topic, outcome).Inc()
+	setSpanResult(ctx, attribute.String(attrNamespace, namespace), attribute.String(attrTopic, topic),
+		attribute.String(attrDecision, outcome))
+}
+
+// namespaceLabel returns namespace when it is in the authz snapshot, requests outside of namespaces
+// have no namespace label either.
+func (c *Middlewares) namespaceLabel(namespace string) string {
+	if namespace == "" {
+		return ""
+	}
+	if c.authz != nil {
+		if data, _ := c.authz.peek(); data != nil && data.namespaces[namespace] {
+			return namespace
+		}
+	}
+
+	return "other"
+}
+
+// registerCacheMetrics exposes the number of entries of the caches of c. Only the first
+// Middlewares registers them, which is the only one outside of tests.
+func (c *Middlewares) registerCacheMetrics() {
+	caches := map[string]func() int{
+		"credentials": c.lru.Len,
+		"api_tokens":  c.tokens.Len,
+		"oidc_users":  c.oidcUsers.Len,
+		"revocations": c.revocations.len,
+		"quotas":      c.quotaCache.Len,
+	}
+	for name, size := range caches {
+		err := prometheus.Register(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "direktiv_auth_cache_entries",
+			Help:        "Number of entries in the caches of the authentication middlewares.",
+			ConstLabels: prometheus.Labels{"cache": name},
+		}, func() float64 {
+			return float64(size())
+		}))
+		var already prometheus.AlreadyRegisteredError
+		if err != nil && !errors.As(err, &already) {
+			panic(err)
+		}
+	}
+}
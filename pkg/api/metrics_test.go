@@ -0,0 +1,74 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	eeDStore "github.com/direktiv/direktiv/direktiv-ee/pkg/datastore"
+)
+
+func Test_oidcVerificationResult(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{nil, resultOK},
+		{fmt.Errorf("%w: %w", errOidcDiscovery, errors.New("dial tcp")), "discovery_failed"},
+		{fmt.Errorf("%w: %w", errOidcVerify, errors.New("token is expired")), resultInvalid},
+		{fmt.Errorf("%w: %w", errOidcClaims, errors.New("bad json")), "bad_claims"},
+		{errOidcNoIdentity, "no_identity"},
+		{errors.New("other"), resultError},
+	}
+	for _, tt := range tests {
+		if got := oidcVerificationResult(tt.err); got != tt.want {
+			t.Errorf("oidcVerificationResult(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func Test_apiTokenLookupResult(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{nil, resultOK},
+		{errAPITokenFormat, resultInvalid},
+		{fmt.Errorf("lookup: %w", eeDStore.ErrNotFound), resultUnknown},
+		{errors.New("connection refused"), resultError},
+	}
+	for _, tt := range tests {
+		if got := apiTokenLookupResult(tt.err); got != tt.want {
+			t.Errorf("apiTokenLookupResult(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func Test_namespaceLabel(t *testing.T) {
+	c := &Middlewares{}
+	if got := c.namespaceLabel("ns"); got != "other" {
+		t.Errorf("namespaceLabel() = %v without authz data, want other", got)
+	}
+
+	c.authz = newSnapshot(time.Minute, func(context.Context) (*authzData, error) {
+		return &authzData{namespaces: map[string]bool{"ns": true}}, nil
+	})
+	if _, err := c.authz.get(context.Background()); err != nil {
+		t.Fatalf("unexpected get() error = %v", err)
+	}
+	tests := []struct {
+		namespace string
+		want      string
+	}{
+		{"ns", "ns"},
+		{"made-up", "other"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := c.namespaceLabel(tt.namespace); got != tt.want {
+			t.Errorf("namespaceLabel(%q) = %v, want %v", tt.namespace, got, tt.want)
+		}
+	}
+}
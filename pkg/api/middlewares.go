@@ -3,18 +3,25 @@ package api
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/netip"
 	"os"
 	"path"
 	"slices"
 	"strings"
+	"time"
 
 	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/direktiv/direktiv/direktiv-ee/pkg/apitoken"
 	eeDStore "github.com/direktiv/direktiv/direktiv-ee/pkg/datastore"
 	"github.com/direktiv/direktiv/pkg/core"
 	"github.com/direktiv/direktiv/pkg/database"
+	"github.com/go-jose/go-jose/v4/jwt"
 	"github.com/google/uuid"
 	"github.com/hashicorp/golang-lru/v2/expirable"
 )
@@ -24,34 +31,187 @@ type Middlewares struct {
 	config *core.Config
 	eStore eeDStore.Store
 	lru    *expirable.LRU[string, string]
+
+	// signer is nil when signed jwt tokens are not enabled.
+	signer      *apitoken.Signer
+	revocations *revocationList
+	// authz is the copy of the roles, role assignments, certificate bindings and namespaces that CheckAPIKey uses.
+	authz *snapshot[*authzData]
+
+	// tokens is keyed by the same secrets as lru, it maps a cached secret back to its token.
+	tokens *expirable.LRU[string, [2]string]
+	usage  *usageRecorder
+	// oidcUsers is keyed by oidc token, groups and identity are cached together so that neither is
+	// ever used without the other.
+	oidcUsers *expirable.LRU[string, oidcUser]
+	// limiter locks out clients after failed authentications, it is nil when the limit is disabled.
+	limiter AuthLimiter
+	quotas  QuotaCounter
+	// quotaCache is keyed by namespace.
+	quotaCache *expirable.LRU[string, *eeDStore.Quota]
+	// clientCAs is nil when client certificates are not enabled.
+	clientCAs        *x509.CertPool
+	clientCertHeader string
+	// certs maps the fingerprint of a client certificate to the permissions its bindings grant.
+	certs *expirable.LRU[string, string]
+	// trustedProxies are the proxies whose forwarded headers are honored.
+	trustedProxies []netip.Prefix
 }
 
-func NewMiddlewares(db *database.DB, config *core.Config, eStore eeDStore.Store, lru *expirable.LRU[string, string]) *Middlewares {
-	return &Middlewares{
+func NewMiddlewares(db *database.DB, config *core.Config, eStore eeDStore.Store, lru *expirable.LRU[string, string], signer *apitoken.Signer) *Middlewares {
+	c := &Middlewares{
 		db:     db,
 		config: config,
 		eStore: eStore,
 		lru:    lru,
+		signer: signer,
+	}
+	c.authz = newSnapshot(authzRefresh, c.fetchAuthzData)
+	c.certs = expirable.NewLRU[string, string](1000, nil, time.Second*30)
+	c.revocations = newRevocationList(time.Second*30, func(ctx context.Context) ([]uuid.UUID, error) {
+		return c.eStore.With(c.db.Conn()).APITokens().ListRevoked(ctx)
+	})
+	c.tokens = expirable.NewLRU[string, [2]string](1000, nil, time.Second*30)
+	c.oidcUsers = expirable.NewLRU[string, oidcUser](1000, nil, time.Second*30)
+	c.usage = newUsageRecorder(c.writeUsage)
+	c.quotas = NewMemoryQuotaCounter()
+	c.quotaCache = expirable.NewLRU[string, *eeDStore.Quota](1000, nil, time.Second*30)
+	c.registerCacheMetrics()
+
+	return c
+}
+
+// RunUsageRecorder periodically writes the usage of api tokens until ctx is done.
+func (c *Middlewares) RunUsageRecorder(ctx context.Context, interval time.Duration) {
+	c.usage.run(ctx, interval)
+}
+
+func (c *Middlewares) writeUsage(ctx context.Context, usage []*eeDStore.APITokenUsage) error {
+	db, err := c.db.BeginTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Rollback()
+
+	if err := c.eStore.With(db.Conn()).APITokens().RecordUsage(ctx, usage); err != nil {
+		return err
+	}
+
+	return db.Commit(ctx)
+}
+
+func (c *Middlewares) recordUsage(r *http.Request, namespace, name string) {
+	c.usage.record(namespace, name, c.clientIP(r), r.UserAgent())
+}
+
+// ParseTrustedProxies parses a comma separated list of the addresses or CIDRs of the proxies in
+// front of direktiv.
+func ParseTrustedProxies(s string) ([]netip.Prefix, error) {
+	var proxies []netip.Prefix
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if !strings.Contains(v, "/") {
+			addr, err := netip.ParseAddr(v)
+			if err != nil {
+				return nil, err
+			}
+			proxies = append(proxies, netip.PrefixFrom(addr, addr.BitLen()))
+
+			continue
+		}
+		prefix, err := netip.ParsePrefix(v)
+		if err != nil {
+			return nil, err
+		}
+		proxies = append(proxies, prefix.Masked())
+	}
+
+	return proxies, nil
+}
+
+// SetTrustedProxies sets the proxies whose X-Forwarded-For and client certificate headers are
+// honored, without any the client ip is always the remote address of the connection.
+func (c *Middlewares) SetTrustedProxies(proxies []netip.Prefix) {
+	c.trustedProxies = proxies
+}
+
+func (c *Middlewares) isTrustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
 	}
+	addr = addr.Unmap()
+	for _, p := range c.trustedProxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// remoteIP returns the address of the connection that r came in on.
+func remoteIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return ip
+}
+
+// clientIP returns the remote address of r. When that is a trusted proxy, the client is the last
+// address of X-Forwarded-For that isn't a trusted proxy itself, the addresses before it are set by
+// the client and can't be relied on.
+func (c *Middlewares) clientIP(r *http.Request) string {
+	ip := remoteIP(r)
+	if !c.isTrustedProxy(ip) {
+		return ip
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		ip = hop
+		if !c.isTrustedProxy(hop) {
+			break
+		}
+	}
+
+	return ip
 }
 
 func (c *Middlewares) CheckOidc(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = stripIdentityHeaders(r)
 		authHeader := r.Header.Get("Authorization")
 		if !strings.HasPrefix(strings.ToLower(authHeader), "bearer ") {
 			next.ServeHTTP(w, r)
 			return
 		}
+		r, span, step := startStep(r, "CheckOidc", next)
+		defer span.End()
+
 		authHeader = strings.TrimPrefix(authHeader, "Bearer ")
 		authHeader = strings.TrimPrefix(authHeader, "bearer ")
-		oidcGroups, ok := c.lru.Get(authHeader)
-		if ok {
+		if user, ok := c.oidcUsers.Get(authHeader); ok {
+			countOidcVerification(r.Context(), resultCached)
 			r.Header.Set("Direktiv-Api-Key", os.Getenv("DIREKTIV_API_KEY"))
-			r.Header.Set("X-Oidc-Groups", oidcGroups)
-			next.ServeHTTP(w, r)
+			r.Header.Set("X-Oidc-Groups", user.Groups)
+			user.Identity.setHeaders(r)
+			step.ServeHTTP(w, withAuthenticated(r))
 
 			return
 		}
+		limitKeys := c.authLimitKeys(r)
+		if !c.checkAuthLimit(w, r, limitKeys) {
+			return
+		}
 
 		if os.Getenv("DIREKTIV_OIDC_DEV") == "true" {
 			c.config.OidcIssuerUrl = "http://127.0.0.1:9090/dex"
@@ -59,87 +219,338 @@ func (c *Middlewares) CheckOidc(next http.Handler) http.Handler {
 		}
 
 		// Use the original authHeader for claims extraction
-		oidcGroups, err := extractOidcGroupsFromToken(r.Context(), c.config.OidcIssuerUrl, c.config.OidcClientID, authHeader)
-		if err != nil {
-			writeError(w, &Error{
-				Code:    "access_token_denied",
-				Message: "couldn't parse claims from jwt token",
+		start := time.Now()
+		oidcGroups, identity, err := extractOidcClaimsFromToken(r.Context(), c.config.OidcIssuerUrl, c.config.OidcClientID, authHeader)
+		// Users without groups can still be bound to roles individually.
+		if err == nil && oidcGroups == "" && identity == (oidcIdentity{}) {
+			err = errOidcNoIdentity
+		}
+		observeOidcVerification(r.Context(), start, err)
+		if errors.Is(err, errOidcNoIdentity) {
+			writeError(w, r, &Error{
+				Code:    CodeAccessTokenDenied,
+				Message: "empty oidc groups and subject in claims",
 			})
 
 			return
 		}
-		if oidcGroups == "" {
-			writeError(w, &Error{
-				Code:    "access_token_denied",
-				Message: "empty oidc groups in claims",
+		if err != nil {
+			// Expired tokens are usually stale sessions rather than guesses.
+			var expired *oidc.TokenExpiredError
+			if errors.Is(err, errOidcVerify) && !errors.As(err, &expired) {
+				c.failAuth(r, limitKeys)
+			}
+			writeError(w, r, &Error{
+				Code:    CodeAccessTokenInvalid,
+				Message: "couldn't parse claims from jwt token",
 			})
 
 			return
 		}
 
-		c.lru.Add(authHeader, oidcGroups)
+		c.oidcUsers.Add(authHeader, oidcUser{Groups: oidcGroups, Identity: identity})
 		r.Header.Set("Direktiv-Api-Key", os.Getenv("DIREKTIV_API_KEY"))
 		r.Header.Set("X-Oidc-Groups", oidcGroups)
-		next.ServeHTTP(w, r)
+		identity.setHeaders(r)
+		step.ServeHTTP(w, withAuthenticated(r))
 	})
 }
 
+// oidcSubjectHeader and oidcEmailHeader pass the user identity of the oidc token on to CheckAPIKey.
+const (
+	oidcSubjectHeader = "X-Oidc-Subject"
+	oidcEmailHeader   = "X-Oidc-Email"
+)
+
+// oidcIdentity identifies the user of an oidc token, roles and role assignments can bind users by
+// either claim.
+type oidcIdentity struct {
+	Subject string
+	Email   string
+}
+
+// oidcUser is what a verified oidc token is cached as.
+type oidcUser struct {
+	Groups   string
+	Identity oidcIdentity
+}
+
+func (i oidcIdentity) setHeaders(r *http.Request) {
+	r.Header.Set(oidcSubjectHeader, i.Subject)
+	r.Header.Set(oidcEmailHeader, i.Email)
+}
+
+type identityHeadersStrippedContextKey struct{}
+
+// stripIdentityHeaders removes the headers the auth middlewares pass the caller on with from what the
+// client sent. Whichever middleware runs first strips them, the ones after it keep what it set.
+func stripIdentityHeaders(r *http.Request) *http.Request {
+	if stripped, _ := r.Context().Value(identityHeadersStrippedContextKey{}).(bool); stripped {
+		return r
+	}
+	for _, h := range []string{"X-Oidc-Groups", oidcSubjectHeader, oidcEmailHeader, "X-Permissions", apiTokenNameHeader} {
+		r.Header.Del(h)
+	}
+	certIdentity{}.setHeaders(r)
+
+	return r.WithContext(context.WithValue(r.Context(), identityHeadersStrippedContextKey{}, true))
+}
+
 func (c *Middlewares) CheckAPIToken(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = stripIdentityHeaders(r)
 		apiTokenStr := r.Header.Get("Direktiv-Api-Token")
 		if apiTokenStr == "" {
 			next.ServeHTTP(w, r)
 			return
 		}
-		apiToken, err := uuid.Parse(apiTokenStr)
-		if err != nil {
-			writeError(w, &Error{
-				Code:    "access_token_invalid",
-				Message: "api token invalid format",
-			})
+		r, span, step := startStep(r, "CheckAPIToken", next)
+		defer span.End()
 
+		if apitoken.IsJWT(apiTokenStr) {
+			c.checkSignedAPIToken(w, r, step, apiTokenStr)
 			return
 		}
 		permissions, ok := c.lru.Get(apiTokenStr)
-		if ok {
+		if token, found := c.tokens.Get(apiTokenStr); ok && found {
+			countAPITokenLookup(r.Context(), apiTokenFormatOpaque, resultCached)
+			c.recordUsage(r, token[0], token[1])
+			r.Header.Set(apiTokenNameHeader, token[0]+"/"+token[1])
 			r.Header.Set("Direktiv-Api-Key", os.Getenv("DIREKTIV_API_KEY"))
 			r.Header.Set("X-Permissions", permissions)
-			next.ServeHTTP(w, r)
+			step.ServeHTTP(w, withAuthenticated(r))
 
 			return
 		}
+		limitKeys := c.authLimitKeys(r)
+		if !c.checkAuthLimit(w, r, limitKeys) {
+			return
+		}
+
+		t, err := c.lookupAPIToken(r.Context(), apiTokenStr)
+		if err != nil {
+			countAPITokenLookup(r.Context(), apiTokenFormatOpaque, apiTokenLookupResult(err))
+		}
+		if errors.Is(err, errAPITokenFormat) || errors.Is(err, eeDStore.ErrNotFound) {
+			c.failAuth(r, limitKeys)
+		}
+		if errors.Is(err, errAPITokenFormat) {
+			writeError(w, r, &Error{
+				Code:    CodeAccessTokenInvalid,
+				Message: "api token invalid format",
+			})
 
-		t, err := c.eStore.With(c.db.Conn()).APITokens().GetByHash(r.Context(), eeDStore.HashTokenID(apiToken))
+			return
+		}
 		if errors.Is(err, eeDStore.ErrNotFound) {
-			writeError(w, &Error{
-				Code:    "access_token_denied",
-				Message: "api token is denied",
+			writeError(w, r, &Error{
+				Code:    CodeAccessTokenInvalid,
+				Message: "api token is invalid",
 			})
 
 			return
 		}
 		if err != nil {
-			writeInternalError(w, err)
+			writeInternalError(w, r, err)
 			return
 		}
 		if t.IsExpired {
-			writeError(w, &Error{
-				Code:    "access_token_denied",
+			countAPITokenLookup(r.Context(), apiTokenFormatOpaque, resultExpired)
+			writeError(w, r, &Error{
+				Code:    CodeAccessTokenInvalid,
 				Message: "api token is expired",
 			})
 
 			return
 		}
-		c.lru.Add(apiTokenStr, t.Permissions.String())
+		resolved, err := c.resolvePermissions(r.Context(), t.Namespace, t.Permissions, t.Roles, t.ServiceAccount)
+		if err != nil {
+			countAPITokenLookup(r.Context(), apiTokenFormatOpaque, resultError)
+			writeInternalError(w, r, err)
+			return
+		}
+		countAPITokenLookup(r.Context(), apiTokenFormatOpaque, resultOK)
+		c.lru.Add(apiTokenStr, resolved.String())
+		c.tokens.Add(apiTokenStr, [2]string{t.Namespace, t.Name})
+		c.recordUsage(r, t.Namespace, t.Name)
+		r.Header.Set(apiTokenNameHeader, t.Namespace+"/"+t.Name)
 		r.Header.Set("Direktiv-Api-Key", os.Getenv("DIREKTIV_API_KEY"))
-		r.Header.Set("X-Permissions", t.Permissions.String())
-		next.ServeHTTP(w, r)
+		r.Header.Set("X-Permissions", resolved.String())
+		step.ServeHTTP(w, withAuthenticated(r))
 	})
 }
 
+var errAPITokenFormat = errors.New("invalid api token format")
+
+// apiTokenNameHeader passes the namespace and name of the authenticated api token on to CheckAPIKey.
+const apiTokenNameHeader = "X-Api-Token-Name"
+
+// lookupAPIToken finds the stored token of an opaque secret. Secrets in the dkv_ format are
+// verified against their salted digest, legacy uuid tokens are looked up by their hash.
+func (c *Middlewares) lookupAPIToken(ctx context.Context, secret string) (*eeDStore.APIToken, error) {
+	store := c.eStore.With(c.db.Conn()).APITokens()
+
+	if lookupID, key, ok := apitoken.ParseSecret(secret); ok {
+		t, err := store.GetByLookupID(ctx, lookupID)
+		if err != nil {
+			return nil, err
+		}
+		if !apitoken.VerifyKey(key, t.Salt, t.Digest) {
+			return nil, eeDStore.ErrNotFound
+		}
+
+		return t, nil
+	}
+
+	legacy, err := uuid.Parse(secret)
+	if err != nil {
+		return nil, errAPITokenFormat
+	}
+
+	return store.GetByHash(ctx, eeDStore.HashTokenID(legacy))
+}
+
+// checkSignedAPIToken verifies a jwt api token offline, only the revocation list and the
+// permissions of referenced roles come from the datastore and both are cached.
+func (c *Middlewares) checkSignedAPIToken(w http.ResponseWriter, r *http.Request, next http.Handler, token string) {
+	if c.signer == nil {
+		countAPITokenLookup(r.Context(), apiTokenFormatSigned, resultInvalid)
+		writeError(w, r, &Error{
+			Code:    CodeAccessTokenInvalid,
+			Message: "signed api tokens are not enabled",
+		})
+
+		return
+	}
+	// Tokens that passed before are cached and not limited at all.
+	limitKeys := c.authLimitKeys(r)
+	if _, cached := c.lru.Get(token); !cached && !c.checkAuthLimit(w, r, limitKeys) {
+		return
+	}
+	claims, err := c.signer.Verify(token)
+	if err != nil {
+		if !errors.Is(err, jwt.ErrExpired) {
+			c.failAuth(r, limitKeys)
+		}
+		countAPITokenLookup(r.Context(), apiTokenFormatSigned, resultInvalid)
+		writeError(w, r, &Error{
+			Code:    CodeAccessTokenInvalid,
+			Message: "api token is invalid",
+		})
+
+		return
+	}
+	jti, err := uuid.Parse(claims.ID)
+	if err != nil {
+		c.failAuth(r, limitKeys)
+		countAPITokenLookup(r.Context(), apiTokenFormatSigned, resultInvalid)
+		writeError(w, r, &Error{
+			Code:    CodeAccessTokenInvalid,
+			Message: "api token is invalid",
+		})
+
+		return
+	}
+	revoked, err := c.revocations.contains(r.Context(), jti)
+	if err != nil {
+		countAPITokenLookup(r.Context(), apiTokenFormatSigned, resultError)
+		writeInternalError(w, r, err)
+		return
+	}
+	if revoked {
+		countAPITokenLookup(r.Context(), apiTokenFormatSigned, resultRevoked)
+		writeError(w, r, &Error{
+			Code:    CodeAccessTokenInvalid,
+			Message: "api token is revoked",
+		})
+
+		return
+	}
+
+	permissions, ok := c.lru.Get(token)
+	result := resultCached
+	if !ok {
+		result = resultOK
+		inline := make(eeDStore.Permissions, len(claims.Permissions))
+		for i, p := range claims.Permissions {
+			inline[i] = &eeDStore.Permission{
+				Namespace: claims.Namespace,
+				Topic:     p.Topic,
+				Method:    p.Method,
+			}
+		}
+		resolved, err := c.resolvePermissions(r.Context(), claims.Namespace, inline, claims.Roles, claims.ServiceAccount)
+		if errors.Is(err, eeDStore.ErrNotFound) {
+			countAPITokenLookup(r.Context(), apiTokenFormatSigned, resultDenied)
+			writeError(w, r, &Error{
+				Code:    CodeAccessTokenDenied,
+				Message: "api token is denied",
+			})
+
+			return
+		}
+		if err != nil {
+			countAPITokenLookup(r.Context(), apiTokenFormatSigned, resultError)
+			writeInternalError(w, r, err)
+			return
+		}
+		permissions = resolved.String()
+		c.lru.Add(token, permissions)
+	}
+	countAPITokenLookup(r.Context(), apiTokenFormatSigned, result)
+	c.recordUsage(r, claims.Namespace, claims.Subject)
+
+	r.Header.Set(apiTokenNameHeader, claims.Namespace+"/"+claims.Subject)
+	r.Header.Set("Direktiv-Api-Key", os.Getenv("DIREKTIV_API_KEY"))
+	r.Header.Set("X-Permissions", permissions)
+	next.ServeHTTP(w, withAuthenticated(r))
+}
+
+func (c *Middlewares) resolvePermissions(ctx context.Context, namespace string, permissions eeDStore.Permissions,
+	roles eeDStore.RoleRefs, serviceAccount string,
+) (eeDStore.Permissions, error) {
+	return resolvePermissions(ctx, c.eStore.With(c.db.Conn()), namespace, permissions, roles, serviceAccount)
+}
+
+// resolvePermissions merges the inline permissions of a token with the permissions of the roles
+// it references and the roles of its service account.
+func resolvePermissions(ctx context.Context, store eeDStore.StoreInner, namespace string, permissions eeDStore.Permissions,
+	roles eeDStore.RoleRefs, serviceAccount string,
+) (eeDStore.Permissions, error) {
+	if serviceAccount != "" {
+		sa, err := store.ServiceAccounts().Get(ctx, namespace, serviceAccount)
+		if err != nil {
+			return nil, fmt.Errorf("fetching service account '%s': %w", serviceAccount, err)
+		}
+		roles = append(slices.Clone(roles), sa.Roles...)
+	}
+
+	// Roles that were deleted after being referenced are skipped.
+	for _, roleName := range roles {
+		role, err := store.Roles().Get(ctx, namespace, roleName)
+		if errors.Is(err, eeDStore.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("fetching role '%s': %w", roleName, err)
+		}
+		permissions = append(permissions, role.Permissions...)
+	}
+
+	return permissions, nil
+}
+
 //nolint:gocognit,goconst
 func (c *Middlewares) CheckAPIKey(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The key set of signed api tokens is public so that other services can verify them, the
+		// openapi document is public as well.
+		if isPublicPath(r) {
+			next.ServeHTTP(w, r)
+
+			return
+		}
+
 		//nolint:gosec
 		apiKeyHeader := "Direktiv-Api-Key"
 		apiKey := os.Getenv("DIREKTIV_API_KEY")
@@ -148,64 +559,148 @@ func (c *Middlewares) CheckAPIKey(next http.Handler) http.Handler {
 
 			return
 		}
+		r = stripIdentityHeaders(r)
+		r, span, step := startStep(r, "CheckAPIKey", next)
+		defer span.End()
+
+		// Requests that authenticated with a token carry the api key already, only clients that bring
+		// it themselves are limited.
+		limitKeys := c.authLimitKeys(r)
+		if !isAuthenticated(r) && !c.checkAuthLimit(w, r, limitKeys) {
+			return
+		}
 		if r.Header.Get(apiKeyHeader) == "" {
-			writeError(w, &Error{
-				Code:    "access_token_missing",
+			writeError(w, r, &Error{
+				Code:    CodeAccessTokenMissing,
 				Message: "missing api key",
 			})
 
 			return
 		}
 		if apiKey != r.Header.Get(apiKeyHeader) {
-			writeError(w, &Error{
-				Code:    "access_token_denied",
+			c.failAuth(r, limitKeys)
+			writeError(w, r, &Error{
+				Code:    CodeAccessTokenInvalid,
 				Message: "invalid api key",
 			})
 
 			return
 		}
 
-		// this is direct access with api key
-		if r.Header.Get("X-Oidc-Groups") == "" && r.Header.Get("X-Permissions") == "" {
-			next.ServeHTTP(w, r)
+		subject, email := r.Header.Get(oidcSubjectHeader), r.Header.Get(oidcEmailHeader)
+		tokenPermissions := r.Header.Get("X-Permissions")
+		cert := certIdentityFromRequest(r)
 
-			return
+		// this is direct access with api key, every other caller is marked by the middleware that
+		// authenticated it.
+		directAccess := !isAuthenticated(r)
+
+		callerName := "api_key"
+		if !directAccess {
+			callerName = "oidc"
+		}
+		if subject != "" {
+			callerName = "oidc:" + subject
+		}
+		if cert.Fingerprint != "" {
+			callerName = "client_cert:" + cert.name()
+		}
+		if r.Header.Get(apiTokenNameHeader) != "" {
+			callerName = "api_token:" + r.Header.Get(apiTokenNameHeader)
+			// Users are only bound through oidc tokens.
+			subject, email = "", ""
 		}
 
+		reqNamespace, reqTopic := extractNamespaceAndTopic(r.URL.Path)
 		reqGroupsStr := r.Header.Get("X-Oidc-Groups")
 
 		reqGroups := strings.Split(reqGroupsStr, ",")
 
+		// Admins can evaluate a request as someone else, everything below applies to the impersonated
+		// identity while audit records name both.
+		if imp := impersonationFromRequest(r); imp != nil {
+			if !directAccess && !slices.Contains(reqGroups, os.Getenv("DIREKTIV_OIDC_ADMIN_GROUP")) {
+				c.recordAuthzDecision(r.Context(), reqNamespace, reqTopic, outcomeDenied)
+				writeError(w, r, &Error{
+					Code:    CodeAccessTokenDenied,
+					Message: "only admins can impersonate",
+				})
+
+				return
+			}
+			if imp.Token != "" && (len(imp.Groups) > 0 || imp.User != "") {
+				writeError(w, r, &Error{
+					Code:    CodeRequestDataInvalid,
+					Message: fmt.Sprintf("%s can't be combined with other impersonation headers", impersonateTokenHeader),
+				})
+
+				return
+			}
+			if imp.Token != "" {
+				permissions, err := c.impersonatedTokenPermissions(r.Context(), imp.Token)
+				if err != nil {
+					writeImpersonationError(w, r, imp.Token, err)
+					return
+				}
+				tokenPermissions = permissions.String()
+			}
+			slog.Info("impersonated request", "caller", callerName, "impersonating", imp.String(),
+				"method", r.Method, "path", r.URL.Path)
+
+			callerName += " impersonating " + imp.String()
+			directAccess = false
+			reqGroups, subject, email = imp.Groups, imp.User, imp.User
+			cert = certIdentity{}
+		}
+
+		if directAccess {
+			c.recordAuthzDecision(r.Context(), reqNamespace, reqTopic, outcomeAdmin)
+			step.ServeHTTP(w, withCaller(r, &Caller{Name: callerName, Admin: true}))
+
+			return
+		}
+
 		// Admin group has like a root access.
 		if slices.Contains(reqGroups, os.Getenv("DIREKTIV_OIDC_ADMIN_GROUP")) {
-			next.ServeHTTP(w, r)
+			c.recordAuthzDecision(r.Context(), reqNamespace, reqTopic, outcomeAdmin)
+			step.ServeHTTP(w, withCaller(r, &Caller{Name: callerName, Admin: true}))
 
 			return
 		}
 
-		reqNamespace, reqTopic := extractNamespaceAndTopic(r.URL.Path)
-
 		// None admins cannot create namespaces.
 		if !slices.Contains(reqGroups, os.Getenv("DIREKTIV_OIDC_ADMIN_GROUP")) &&
 			r.Method == http.MethodPost &&
 			reqTopic == "namespaces" &&
 			reqNamespace == "" {
-			writeError(w, &Error{
-				Code:    "access_token_denied",
+			c.recordAuthzDecision(r.Context(), reqNamespace, reqTopic, outcomeDenied)
+			writeError(w, r, &Error{
+				Code:    CodeAccessTokenDenied,
 				Message: "only admins can create namespaces",
 			})
 
 			return
 		}
 
-		roles, err := c.eStore.With(c.db.Conn()).Roles().ListAll(r.Context())
+		authz, err := c.authz.get(r.Context())
 		if err != nil {
-			writeInternalError(w, err)
+			writeInternalError(w, r, err)
+			return
 		}
+		roles, assignments := authz.roles, authz.activeAssignments(time.Now())
 
 		var permissions eeDStore.Permissions
-		if r.Header.Get("X-Permissions") != "" {
-			_ = permissions.Scan(r.Header.Get("X-Permissions"))
+		if tokenPermissions != "" {
+			_ = permissions.Scan(tokenPermissions)
+		}
+		// Client certificates are bound like oidc groups, by whatever bindings match them.
+		if cert.Fingerprint != "" {
+			certPermissions, err := c.certPermissions(r.Context(), authz.bindings, cert)
+			if err != nil {
+				writeInternalError(w, r, err)
+				return
+			}
+			permissions = append(permissions, certPermissions...)
 		}
 
 		for _, group := range reqGroups {
@@ -215,6 +710,14 @@ func (c *Middlewares) CheckAPIKey(next http.Handler) http.Handler {
 				}
 			}
 		}
+		for _, role := range roles {
+			if role.Users.Matches(subject, email) {
+				permissions = append(permissions, role.Permissions...)
+			}
+		}
+		for _, role := range assignedRoles(roles, assignments, reqGroups, subject, email) {
+			permissions = append(permissions, role.Permissions...)
+		}
 
 		allowedNamespaces := ","
 		for _, permission := range permissions {
@@ -232,24 +735,49 @@ func (c *Middlewares) CheckAPIKey(next http.Handler) http.Handler {
 				permission.Method = "GET"
 			}
 			if permission.Method == "manage" || permission.Method == r.Method {
+				c.recordAuthzDecision(r.Context(), reqNamespace, reqTopic, outcomeAllowed)
 				req := r.WithContext(context.WithValue(r.Context(), "allowedNamespaces", allowedNamespaces))
-				next.ServeHTTP(w, req)
+				step.ServeHTTP(w, withCaller(req, &Caller{Name: callerName, Permissions: permissions}))
 
 				return
 			}
 		}
 
-		writeError(w, &Error{
-			Code:    "access_token_denied",
+		c.recordAuthzDecision(r.Context(), reqNamespace, reqTopic, outcomeDenied)
+		writeError(w, r, &Error{
+			Code:    CodeAccessTokenDenied,
 			Message: "not enough permissions",
 		})
 	})
 }
 
+// assignedRoles returns the roles that active assignments bind to one of the groups or to the user
+// with the given subject or email.
+func assignedRoles(roles []*eeDStore.Role, assignments []*eeDStore.RoleAssignment, groups []string, subject, email string,
+) []*eeDStore.Role {
+	var res []*eeDStore.Role
+	for _, a := range assignments {
+		switch {
+		case a.AssigneeType == eeDStore.AssigneeGroup && slices.Contains(groups, a.Assignee):
+		case a.AssigneeType == eeDStore.AssigneeUser && eeDStore.RoleUsers{a.Assignee}.Matches(subject, email):
+		default:
+			continue
+		}
+		i := slices.IndexFunc(roles, func(role *eeDStore.Role) bool {
+			return role.Namespace == a.Namespace && role.Name == a.RoleName
+		})
+		if i >= 0 && !slices.Contains(res, roles[i]) {
+			res = append(res, roles[i])
+		}
+	}
+
+	return res
+}
+
 // nolint
-func extractOidcGroupsFromToken(ctx context.Context, oidcIssuerURL, oidcClientID, oidcToken string) (string, error) {
+func extractOidcClaimsFromToken(ctx context.Context, oidcIssuerURL, oidcClientID, oidcToken string) (string, oidcIdentity, error) {
 	if os.Getenv("DIREKTIV_OIDC_DEV") == "true" {
-		return "admin,g1,g2", nil
+		return "admin,g1,g2", oidcIdentity{Subject: "dev"}, nil
 	}
 
 	if os.Getenv("DIREKTIV_OIDC_SKIP_TLS_VERIFY") == "true" {
@@ -266,21 +794,27 @@ func extractOidcGroupsFromToken(ctx context.Context, oidcIssuerURL, oidcClientID
 
 	provider, err := oidc.NewProvider(ctx, oidcIssuerURL)
 	if err != nil {
-		return "", fmt.Errorf("error creating oidc provider: %w", err)
+		return "", oidcIdentity{}, fmt.Errorf("%w: %w", errOidcDiscovery, err)
 	}
 	verifier := provider.Verifier(&oidc.Config{ClientID: oidcClientID})
 	oidcTokenObject, err := verifier.Verify(ctx, oidcToken)
 	if err != nil {
-		return "", fmt.Errorf("error verifying token: %w", err)
+		return "", oidcIdentity{}, fmt.Errorf("%w: %w", errOidcVerify, err)
 	}
 
 	claims := make(map[string]interface{})
 	if err := oidcTokenObject.Claims(&claims); err != nil {
-		return "", fmt.Errorf("error parsing token claims: %w", err)
+		return "", oidcIdentity{}, fmt.Errorf("%w: %w", errOidcClaims, err)
 	}
 	groups := parseOIDCGroups(claims)
 
-	return strings.Join(groups, ","), nil
+	// Emails are only trusted when the provider doesn't say they are unverified.
+	identity := oidcIdentity{Subject: getClaim(claims, "sub", "")}
+	if verified, ok := claims["email_verified"].(bool); !ok || verified {
+		identity.Email = getClaim(claims, "email", "")
+	}
+
+	return strings.Join(groups, ","), identity, nil
 }
 
 // nolint
@@ -307,6 +841,15 @@ func getClaim(claims map[string]interface{}, key string, fallback string) string
 }
 
 //nolint:goconst
+func isPublicPath(r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		return false
+	}
+	p := strings.TrimSuffix(path.Clean("/"+r.URL.Path), "/")
+
+	return p == "/api/v2"+jwksPath || p == "/api/v2"+openAPIPath
+}
+
 func extractNamespaceAndTopic(pathString string) (string, string) {
 	pathString = "/" + pathString + "/"
 	pathString = path.Clean(pathString)
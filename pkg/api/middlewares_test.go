@@ -1,6 +1,16 @@
 package api
 
-import "testing"
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	eeDStore "github.com/direktiv/direktiv/direktiv-ee/pkg/datastore"
+	"github.com/direktiv/direktiv/pkg/core"
+	"github.com/hashicorp/golang-lru/v2/expirable"
+)
 
 func Test_extractNamespaceAndTopic(t *testing.T) {
 	tests := []struct {
@@ -163,3 +173,214 @@ func Test_extractNamespaceAndTopic(t *testing.T) {
 		})
 	}
 }
+
+func Test_isPublicPath(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		want   bool
+	}{
+		{http.MethodGet, "/api/v2/jwks", true},
+		{http.MethodGet, "/api/v2/jwks/", true},
+		{http.MethodGet, "/api/v2/enterprise/openapi.json", true},
+		{http.MethodPost, "/api/v2/jwks", false},
+		{http.MethodGet, "/api/v2/namespaces/ns/jwks", false},
+		{http.MethodGet, "/api/v2/jwks/../namespaces/ns/secrets", false},
+		{http.MethodGet, "/api/v2/namespaces/ns/secrets", false},
+	}
+	for _, tt := range tests {
+		if got := isPublicPath(httptest.NewRequest(tt.method, tt.path, nil)); got != tt.want {
+			t.Errorf("isPublicPath(%s %s) = %v, want %v", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
+
+func Test_assignedRoles(t *testing.T) {
+	roles := []*eeDStore.Role{
+		{Namespace: "ns1", Name: "r1"},
+		{Namespace: "ns1", Name: "r2"},
+		{Namespace: "ns2", Name: "r1"},
+	}
+	assignments := []*eeDStore.RoleAssignment{
+		{Namespace: "ns1", RoleName: "r1", AssigneeType: eeDStore.AssigneeGroup, Assignee: "g1"},
+		{Namespace: "ns1", RoleName: "r1", AssigneeType: eeDStore.AssigneeUser, Assignee: "alice"},
+		{Namespace: "ns1", RoleName: "r2", AssigneeType: eeDStore.AssigneeUser, Assignee: "bob@example.com"},
+		{Namespace: "ns2", RoleName: "r1", AssigneeType: eeDStore.AssigneeGroup, Assignee: "g2"},
+		{Namespace: "ns2", RoleName: "deleted", AssigneeType: eeDStore.AssigneeGroup, Assignee: "g1"},
+	}
+
+	tests := []struct {
+		name    string
+		groups  []string
+		subject string
+		email   string
+		want    []*eeDStore.Role
+	}{
+		{
+			name:    "group and user bind the same role once",
+			groups:  []string{"g1"},
+			subject: "alice",
+			want:    roles[:1],
+		},
+		{
+			name:    "user by email",
+			groups:  []string{""},
+			subject: "bob",
+			email:   "bob@example.com",
+			want:    roles[1:2],
+		},
+		{
+			name:   "no subject",
+			groups: []string{"g2"},
+			want:   roles[2:],
+		},
+		{
+			name:   "no match",
+			groups: []string{"g3"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := assignedRoles(roles, assignments, tt.groups, tt.subject, tt.email)
+			if len(got) != len(tt.want) {
+				t.Fatalf("assignedRoles() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("assignedRoles()[%d] = %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+// forgedIdentityHeaders are what a client sends to pass as someone else.
+var forgedIdentityHeaders = map[string]string{
+	"X-Oidc-Groups":             "admin",
+	oidcSubjectHeader:           "admin",
+	oidcEmailHeader:             "admin@example.org",
+	"X-Permissions":             "secrets:POST",
+	apiTokenNameHeader:          "ns/admin",
+	clientCertFingerprintHeader: "forged",
+}
+
+func testAuthMiddlewares() *Middlewares {
+	return &Middlewares{
+		config:    &core.Config{},
+		lru:       expirable.NewLRU[string, string](10, nil, time.Minute),
+		tokens:    expirable.NewLRU[string, [2]string](10, nil, time.Minute),
+		oidcUsers: expirable.NewLRU[string, oidcUser](10, nil, time.Minute),
+		usage: newUsageRecorder(func(context.Context, []*eeDStore.APITokenUsage) error {
+			return nil
+		}),
+		limiter: NewMemoryAuthLimiter(DefaultAuthLimitPolicy),
+	}
+}
+
+func Test_authMiddlewares_forgedHeaders(t *testing.T) {
+	t.Setenv("DIREKTIV_API_KEY", "password")
+	c := testAuthMiddlewares()
+	c.lru.Add("opaque", "secrets:GET")
+	c.tokens.Add("opaque", [2]string{"ns", "ci"})
+	c.oidcUsers.Add("jwt", oidcUser{Identity: oidcIdentity{Subject: "alice"}})
+
+	var got *http.Request
+	// The order core wires the middlewares in doesn't matter.
+	chains := map[string]http.Handler{
+		"oidc first": c.CheckOidc(c.CheckAPIToken(c.CheckClientCert(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = r
+		})))),
+		"token first": c.CheckAPIToken(c.CheckClientCert(c.CheckOidc(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = r
+		})))),
+	}
+	tests := []struct {
+		name   string
+		header [2]string
+		want   map[string]string
+	}{
+		{
+			name:   "api token",
+			header: [2]string{"Direktiv-Api-Token", "opaque"},
+			want:   map[string]string{"X-Permissions": "secrets:GET", apiTokenNameHeader: "ns/ci"},
+		},
+		{
+			name:   "oidc",
+			header: [2]string{"Authorization", "Bearer jwt"},
+			want:   map[string]string{oidcSubjectHeader: "alice"},
+		},
+		{
+			name: "no credentials",
+			want: map[string]string{},
+		},
+	}
+	for chain, handler := range chains {
+		for _, tt := range tests {
+			t.Run(chain+"/"+tt.name, func(t *testing.T) {
+				got = nil
+				r := httptest.NewRequest(http.MethodGet, "/api/v2/namespaces/ns/secrets", nil)
+				for k, v := range forgedIdentityHeaders {
+					r.Header.Set(k, v)
+				}
+				if tt.header[0] != "" {
+					r.Header.Set(tt.header[0], tt.header[1])
+				}
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, r)
+				if got == nil {
+					t.Fatalf("status = %d, want the request passed on", w.Code)
+				}
+				for h := range forgedIdentityHeaders {
+					if got.Header.Get(h) != tt.want[h] {
+						t.Errorf("header %s = %q, want %q", h, got.Header.Get(h), tt.want[h])
+					}
+				}
+			})
+		}
+	}
+}
+
+func Test_CheckAPIKey_directAccess(t *testing.T) {
+	t.Setenv("DIREKTIV_API_KEY", "password")
+	t.Setenv("DIREKTIV_OIDC_ADMIN_GROUP", "admin")
+	c := testAuthMiddlewares()
+	c.authz = newSnapshot(time.Minute, func(context.Context) (*authzData, error) {
+		return &authzData{}, nil
+	})
+	// Neither a user without groups nor a token without permissions is the api key.
+	c.oidcUsers.Add("user", oidcUser{Identity: oidcIdentity{Subject: "alice"}})
+	c.lru.Add("opaque", "")
+	c.tokens.Add("opaque", [2]string{"ns", "ci"})
+	// Entries of api tokens are never taken for oidc users.
+	c.lru.Add("evicted", "")
+
+	var got *Caller
+	handler := c.CheckOidc(c.CheckAPIToken(c.CheckAPIKey(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = CallerFromContext(r.Context())
+	}))))
+	tests := []struct {
+		name   string
+		header [2]string
+		admin  bool
+	}{
+		{"api key", [2]string{"Direktiv-Api-Key", "password"}, true},
+		{"oidc user without groups", [2]string{"Authorization", "Bearer user"}, false},
+		{"api token without permissions", [2]string{"Direktiv-Api-Token", "opaque"}, false},
+		{"oidc token that isn't cached", [2]string{"Authorization", "Bearer evicted"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got = nil
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/api/v2/namespaces/ns/secrets", nil)
+			r.Header.Set(tt.header[0], tt.header[1])
+			handler.ServeHTTP(w, r)
+			if tt.admin && (got == nil || !got.Admin) {
+				t.Errorf("status = %d, caller = %+v, want the admin api key", w.Code, got)
+			}
+			if !tt.admin && (got != nil || w.Code == http.StatusOK) {
+				t.Errorf("status = %d, caller = %+v, want the request rejected", w.Code, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,25 @@
+package api
+
+import (
+	_ "embed"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// openAPISpec describes the enterprise endpoints, Test_openAPISpec fails when it disagrees with the
+// routes and the request and response types of the handlers.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// openAPIPath is public like the key set of signed api tokens.
+const openAPIPath = "/enterprise/openapi.json"
+
+func mountOpenAPIRouter(r chi.Router) {
+	r.Get("/", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(openAPISpec)
+	})
+}
@@ -0,0 +1,283 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	eeDStore "github.com/direktiv/direktiv/direktiv-ee/pkg/datastore"
+	"github.com/direktiv/direktiv/direktiv-ee/pkg/gitops"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+type openAPISchema struct {
+	Ref        string                    `json:"$ref"`
+	Type       string                    `json:"type"`
+	Properties map[string]*openAPISchema `json:"properties"`
+	Items      *openAPISchema            `json:"items"`
+	AllOf      []*openAPISchema          `json:"allOf"`
+}
+
+type openAPIDocument struct {
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Schemas map[string]*openAPISchema `json:"schemas"`
+	} `json:"components"`
+}
+
+func loadOpenAPISpec(t *testing.T) *openAPIDocument {
+	t.Helper()
+
+	doc := &openAPIDocument{}
+	if err := json.Unmarshal(openAPISpec, doc); err != nil {
+		t.Fatalf("parsing openapi.json error = %v", err)
+	}
+
+	return doc
+}
+
+// routeRecorder records the routes a controller mounts, other chi.Router methods are not used by
+// MountRouter functions.
+type routeRecorder struct {
+	chi.Router
+	prefix string
+	routes map[string]bool
+}
+
+func (rr *routeRecorder) add(method, pattern string) {
+	rr.routes[method+" "+strings.TrimSuffix(rr.prefix+pattern, "/")] = true
+}
+
+func (rr *routeRecorder) Get(pattern string, _ http.HandlerFunc)   { rr.add(http.MethodGet, pattern) }
+func (rr *routeRecorder) Post(pattern string, _ http.HandlerFunc)  { rr.add(http.MethodPost, pattern) }
+func (rr *routeRecorder) Put(pattern string, _ http.HandlerFunc)   { rr.add(http.MethodPut, pattern) }
+func (rr *routeRecorder) Patch(pattern string, _ http.HandlerFunc) { rr.add(http.MethodPatch, pattern) }
+func (rr *routeRecorder) Delete(pattern string, _ http.HandlerFunc) {
+	rr.add(http.MethodDelete, pattern)
+}
+
+func Test_openAPISpec_routes(t *testing.T) {
+	doc := loadOpenAPISpec(t)
+
+	mounted := map[string]bool{}
+	routes := (&Controllers{
+		APITokens:           &APITokensController{},
+		APITokenPolicies:    &APITokenPoliciesController{},
+		Roles:               &RolesController{},
+		RoleAssignments:     &RoleAssignmentsController{},
+		RBACSync:            &RBACSyncController{},
+		ServiceAccounts:     &ServiceAccountsController{},
+		Quotas:              &QuotasController{},
+		CertificateBindings: &CertificateBindingsController{},
+	}).Routes()
+	for prefix, mount := range routes {
+		mount(&routeRecorder{prefix: prefix, routes: mounted})
+	}
+
+	described := map[string]bool{}
+	for path, item := range doc.Paths {
+		for method := range item {
+			if method == "parameters" {
+				continue
+			}
+			described[strings.ToUpper(method)+" "+path] = true
+		}
+	}
+
+	for route := range mounted {
+		if !described[route] {
+			t.Errorf("route %s is not described in openapi.json", route)
+		}
+	}
+	for route := range described {
+		if !mounted[route] {
+			t.Errorf("openapi.json describes %s which is not mounted", route)
+		}
+	}
+}
+
+func Test_openAPISpec_shapes(t *testing.T) {
+	doc := loadOpenAPISpec(t)
+
+	now := time.Now()
+	permissions := eeDStore.Permissions{{Topic: "secrets", Method: "read"}}
+	token := &eeDStore.APIToken{
+		Name:        "ci",
+		Format:      eeDStore.APITokenFormatSecret,
+		Hash:        uuid.New(),
+		Permissions: permissions,
+		Roles:       eeDStore.RoleRefs{"readers"},
+		ExpiredAt:   &now,
+		LastUsedAt:  &now,
+	}
+	role := &eeDStore.Role{
+		Name:        "readers",
+		OidcGroups:  eeDStore.OidcGroups{"g1"},
+		Users:       eeDStore.RoleUsers{"alice"},
+		Permissions: permissions,
+	}
+	revision := func(n int64, description string) *eeDStore.RoleRevision {
+		return &eeDStore.RoleRevision{
+			Revision:    n,
+			Description: description,
+			OidcGroups:  eeDStore.OidcGroups{"g1"},
+			Users:       eeDStore.RoleUsers{"alice"},
+			Permissions: permissions,
+		}
+	}
+	assignment := func(assigneeType, assignee string) any {
+		return convertRoleAssignment(&eeDStore.RoleAssignment{
+			ID:           uuid.New(),
+			AssigneeType: assigneeType,
+			Assignee:     assignee,
+			DecidedAt:    &now,
+		})
+	}
+	documents := []permissionDocument{{Topic: "secrets", Method: "read"}}
+
+	tests := []struct {
+		schema string
+		// values are checked together, a property of the schema has to be set in one of them.
+		values []any
+	}{
+		// Responses.
+		{"APIToken", []any{convertAPIToken(token)}},
+		{"CreatedAPIToken", []any{&createdAPIToken{APIToken: convertAPIToken(token), Secret: "dkv_"}}},
+		{"APITokenPolicy", []any{convertAPITokenPolicy(&eeDStore.APITokenPolicy{PermissionsCeiling: permissions})}},
+		{"Role", []any{convertRole(role)}},
+		{"RoleRevision", []any{convertRoleRevision(revision(1, ""))}},
+		{"RoleRevisionsDiff", []any{diffRoleRevisions(revision(1, "a"), revision(2, "b"))}},
+		{"RolesDocument", []any{&rolesDocument{
+			Version: rolesDocumentVersion,
+			Roles: []*roleDocument{{
+				Name: "readers", Description: "d", OidcGroups: []string{"g1"}, Users: []string{"alice"}, Permissions: documents,
+			}},
+			APITokens: []*apiTokenDocument{{
+				Name: "ci", Description: "d", Format: "secret", Permissions: documents, Roles: []string{"readers"},
+				ServiceAccount: "sa", ExpiredAt: &now,
+			}},
+		}}},
+		{"RolesImportResult", []any{&rolesImportResult{Created: []string{"readers"}}}},
+		{"RoleAssignment", []any{assignment(eeDStore.AssigneeGroup, "g1"), assignment(eeDStore.AssigneeUser, "alice")}},
+		{"ServiceAccount", []any{convertServiceAccount(&eeDStore.ServiceAccount{Roles: eeDStore.RoleRefs{"readers"}})}},
+		{"RBACChange", []any{convertChanges([]gitops.Change{{Kind: "role", Name: "readers", Action: "create", Path: "/a.yaml"}})}},
+		{"Quota", []any{convertQuota(&eeDStore.Quota{})}},
+		{"QuotaUsage", []any{convertQuotaUsage(&eeDStore.QuotaUsage{APIToken: "ci", Kind: eeDStore.QuotaAPICalls})}},
+		{"CertificateBinding", []any{convertCertificateBinding(&eeDStore.CertificateBinding{Roles: eeDStore.RoleRefs{"readers"}})}},
+		{"Pagination", []any{&pagination{}}},
+		{"Error", []any{&Error{Validation: map[string]string{"name": "is required"}}}},
+		{"Problem", []any{&problem{Validation: map[string]string{"name": "is required"}}}},
+
+		// Requests.
+		{"APITokenCreateRequest", []any{&apiTokenCreateRequest{}}},
+		{"APITokenUpdateRequest", []any{&apiTokenUpdateRequest{}}},
+		{"APITokenPolicyRequest", []any{&apiTokenPolicyRequest{}}},
+		{"RoleRequest", []any{&roleRequest{}}},
+		{"RolePatch", []any{&rolePatchDocument{Permissions: documents}}},
+		{"RoleOidcGroupRequest", []any{&roleOidcGroupRequest{}}},
+		{"RoleUserRequest", []any{&roleUserRequest{}}},
+		{"Permission", []any{&permissionDocument{}}},
+		{"RoleAssignmentRequest", []any{&roleAssignmentRequest{StartsAt: &now}}},
+		{"ServiceAccountRequest", []any{&serviceAccountRequest{}}},
+		{"QuotaRequest", []any{&quotaRequest{}}},
+		{"CertificateBindingRequest", []any{&certificateBindingRequest{}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.schema, func(t *testing.T) {
+			schema, ok := doc.Components.Schemas[tt.schema]
+			if !ok {
+				t.Fatalf("openapi.json has no schema %s", tt.schema)
+			}
+			set := map[string]bool{}
+			for _, v := range tt.values {
+				data, err := json.Marshal(v)
+				if err != nil {
+					t.Fatalf("json.Marshal() error = %v", err)
+				}
+				var decoded any
+				if err := json.Unmarshal(data, &decoded); err != nil {
+					t.Fatalf("json.Unmarshal() error = %v", err)
+				}
+				compareShape(t, doc, schema, tt.schema, decoded, set)
+			}
+
+			var missing []string
+			for path, ok := range set {
+				if !ok {
+					missing = append(missing, path)
+				}
+			}
+			sort.Strings(missing)
+			for _, path := range missing {
+				t.Errorf("%s is described in openapi.json but never set", path)
+			}
+		})
+	}
+}
+
+// compareShape checks v against schema, set records for every property of a visited object whether
+// it was set.
+func compareShape(t *testing.T, doc *openAPIDocument, schema *openAPISchema, path string, v any, set map[string]bool) {
+	t.Helper()
+
+	for schema.Ref != "" || len(schema.AllOf) > 0 {
+		if len(schema.AllOf) > 0 {
+			schema = schema.AllOf[0]
+			continue
+		}
+		schema = doc.Components.Schemas[strings.TrimPrefix(schema.Ref, "#/components/schemas/")]
+	}
+
+	switch v := v.(type) {
+	case nil:
+	case map[string]any:
+		// Objects without properties are free form, like validation maps.
+		if schema.Properties == nil {
+			return
+		}
+		for name := range schema.Properties {
+			_, ok := v[name]
+			set[path+"."+name] = set[path+"."+name] || ok
+		}
+		for name, value := range v {
+			prop, ok := schema.Properties[name]
+			if !ok {
+				t.Errorf("%s.%s is not described in openapi.json", path, name)
+				continue
+			}
+			compareShape(t, doc, prop, path+"."+name, value, set)
+		}
+	case []any:
+		// Lists of objects, like the changes of an rbac sync, are checked against the object schema.
+		if schema.Type != "array" && schema.Properties != nil {
+			for _, item := range v {
+				compareShape(t, doc, schema, path, item, set)
+			}
+
+			return
+		}
+		if schema.Type != "array" {
+			t.Errorf("%s is an array, openapi.json says %s", path, schema.Type)
+			return
+		}
+		for _, item := range v {
+			compareShape(t, doc, schema.Items, path+"[]", item, set)
+		}
+	case string:
+		if schema.Type != "string" {
+			t.Errorf("%s is a string, openapi.json says %s", path, schema.Type)
+		}
+	case float64:
+		if schema.Type != "integer" && schema.Type != "number" {
+			t.Errorf("%s is a number, openapi.json says %s", path, schema.Type)
+		}
+	case bool:
+		if schema.Type != "boolean" {
+			t.Errorf("%s is a boolean, openapi.json says %s", path, schema.Type)
+		}
+	}
+}
@@ -0,0 +1,321 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	eeDStore "github.com/direktiv/direktiv/direktiv-ee/pkg/datastore"
+	"github.com/direktiv/direktiv/pkg/database"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// memoryQuotaRetention is how long the memory counter keeps usage.
+const memoryQuotaRetention = time.Hour * 24
+
+// QuotaCounter counts the requests of namespaces and api tokens per minute for CheckQuota and keeps
+// the usage for billing.
+type QuotaCounter interface {
+	// Count counts a request in the minute that begins at minute and returns the requests of the
+	// minute so far. apiToken is empty for the count of the whole namespace.
+	Count(ctx context.Context, namespace, apiToken, kind string, minute time.Time) (int64, error)
+	// Reject counts a request that was counted before as rejected.
+	Reject(ctx context.Context, namespace, apiToken, kind string, minute time.Time) error
+	// Usage returns the usage of a namespace summed up by hour, from is inclusive and to exclusive.
+	Usage(ctx context.Context, namespace string, from, to time.Time) ([]*eeDStore.QuotaUsage, error)
+	// Purge forgets the usage older than the retention of the counter.
+	Purge(ctx context.Context) error
+}
+
+type quotaUsageKey struct {
+	namespace string
+	apiToken  string
+	kind      string
+	minute    int64
+}
+
+type memoryQuotaCounter struct {
+	now func() time.Time
+
+	mu    sync.Mutex
+	usage map[quotaUsageKey]*eeDStore.QuotaUsage
+}
+
+// NewMemoryQuotaCounter returns a counter that counts the requests of a single replica only and keeps
+// their usage for a day.
+func NewMemoryQuotaCounter() QuotaCounter {
+	return &memoryQuotaCounter{
+		now:   time.Now,
+		usage: map[quotaUsageKey]*eeDStore.QuotaUsage{},
+	}
+}
+
+func (q *memoryQuotaCounter) Count(_ context.Context, namespace, apiToken, kind string, minute time.Time) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	key := quotaUsageKey{namespace, apiToken, kind, minute.Unix()}
+	usage, ok := q.usage[key]
+	if !ok {
+		usage = &eeDStore.QuotaUsage{
+			Namespace: namespace,
+			APIToken:  apiToken,
+			Kind:      kind,
+			Start:     minute,
+		}
+		q.usage[key] = usage
+	}
+	usage.Requests++
+
+	return usage.Requests, nil
+}
+
+func (q *memoryQuotaCounter) Reject(_ context.Context, namespace, apiToken, kind string, minute time.Time) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	usage, ok := q.usage[quotaUsageKey{namespace, apiToken, kind, minute.Unix()}]
+	if !ok {
+		return eeDStore.ErrNotFound
+	}
+	usage.Rejected++
+
+	return nil
+}
+
+func (q *memoryQuotaCounter) Usage(_ context.Context, namespace string, from, to time.Time) ([]*eeDStore.QuotaUsage, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	hours := map[quotaUsageKey]*eeDStore.QuotaUsage{}
+	for key, usage := range q.usage {
+		if key.namespace != namespace || usage.Start.Before(from) || !usage.Start.Before(to) {
+			continue
+		}
+		start := usage.Start.Truncate(time.Hour)
+		hourKey := quotaUsageKey{key.namespace, key.apiToken, key.kind, start.Unix()}
+		hour, ok := hours[hourKey]
+		if !ok {
+			hour = &eeDStore.QuotaUsage{
+				Namespace: namespace,
+				APIToken:  key.apiToken,
+				Kind:      key.kind,
+				Start:     start,
+			}
+			hours[hourKey] = hour
+		}
+		hour.Requests += usage.Requests
+		hour.Rejected += usage.Rejected
+	}
+
+	list := make([]*eeDStore.QuotaUsage, 0, len(hours))
+	for _, hour := range hours {
+		list = append(list, hour)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].Start.Equal(list[j].Start) {
+			return list[i].Start.Before(list[j].Start)
+		}
+		if list[i].APIToken != list[j].APIToken {
+			return list[i].APIToken < list[j].APIToken
+		}
+
+		return list[i].Kind < list[j].Kind
+	})
+
+	return list, nil
+}
+
+func (q *memoryQuotaCounter) Purge(_ context.Context) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	before := q.now().Add(-memoryQuotaRetention)
+	for key, usage := range q.usage {
+		if usage.Start.Before(before) {
+			delete(q.usage, key)
+		}
+	}
+
+	return nil
+}
+
+type datastoreQuotaCounter struct {
+	db        *database.DB
+	eStore    eeDStore.Store
+	retention time.Duration
+}
+
+// NewDatastoreQuotaCounter returns a counter that counts requests in the database, so that all
+// replicas share the quotas. Usage is kept for the given retention.
+func NewDatastoreQuotaCounter(db *database.DB, eStore eeDStore.Store, retention time.Duration) QuotaCounter {
+	return &datastoreQuotaCounter{
+		db:        db,
+		eStore:    eStore,
+		retention: retention,
+	}
+}
+
+func (q *datastoreQuotaCounter) store() eeDStore.QuotasStore {
+	return q.eStore.With(q.db.Conn()).Quotas()
+}
+
+func (q *datastoreQuotaCounter) Count(ctx context.Context, namespace, apiToken, kind string, minute time.Time) (int64, error) {
+	return q.store().CountUsage(ctx, namespace, apiToken, kind, minute)
+}
+
+func (q *datastoreQuotaCounter) Reject(ctx context.Context, namespace, apiToken, kind string, minute time.Time) error {
+	return q.store().RejectUsage(ctx, namespace, apiToken, kind, minute)
+}
+
+func (q *datastoreQuotaCounter) Usage(ctx context.Context, namespace string, from, to time.Time) ([]*eeDStore.QuotaUsage, error) {
+	return q.store().ListUsage(ctx, namespace, from, to)
+}
+
+func (q *datastoreQuotaCounter) Purge(ctx context.Context) error {
+	_, err := q.store().PurgeUsage(ctx, time.Now().Add(-q.retention))
+
+	return err
+}
+
+// SetQuotaCounter replaces the in-memory counter of CheckQuota.
+func (c *Middlewares) SetQuotaCounter(counter QuotaCounter) {
+	c.quotas = counter
+}
+
+// RunQuotaCounter periodically purges the usage that the quota counter doesn't keep anymore until
+// ctx is done.
+func (c *Middlewares) RunQuotaCounter(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.quotas.Purge(ctx); err != nil {
+				slog.Error("purging quota usage", "err", err)
+			}
+		}
+	}
+}
+
+// CheckQuota counts the requests to a namespace and rejects them when the namespace or the api token
+// that sent them exceeded its quota of the current minute. It runs after CheckAPIKey, so that only
+// authenticated requests are counted.
+func (c *Middlewares) CheckQuota(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		namespace, _ := extractNamespaceAndTopic(r.URL.Path)
+		if namespace == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		r, span, step := startStep(r, "CheckQuota", next)
+		defer span.End()
+		setSpanResult(r.Context(), attribute.String(attrNamespace, namespace))
+
+		quota, err := c.namespaceQuota(r.Context(), namespace)
+		if err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+		apiToken := quotaAPIToken(r, namespace)
+		minute := time.Now().Truncate(time.Minute)
+		for _, kind := range quotaKinds(r, namespace) {
+			if !c.countQuota(w, r, quota.Limits.Of(kind), namespace, "", kind, minute) {
+				return
+			}
+			if apiToken != "" && !c.countQuota(w, r, quota.APITokenLimits.Of(kind), namespace, apiToken, kind, minute) {
+				return
+			}
+		}
+		step.ServeHTTP(w, r)
+	})
+}
+
+// namespaceQuota returns the cached quota of a namespace, namespaces without a quota get an
+// unlimited one.
+func (c *Middlewares) namespaceQuota(ctx context.Context, namespace string) (*eeDStore.Quota, error) {
+	if quota, ok := c.quotaCache.Get(namespace); ok {
+		return quota, nil
+	}
+	quota, err := c.eStore.With(c.db.Conn()).Quotas().Get(ctx, namespace)
+	if errors.Is(err, eeDStore.ErrNotFound) {
+		quota = &eeDStore.Quota{Namespace: namespace}
+	} else if err != nil {
+		return nil, err
+	}
+	c.quotaCache.Add(namespace, quota)
+
+	return quota, nil
+}
+
+// countQuota counts a request against a limit of the namespace or, when apiToken is set, of one of its
+// api tokens. It writes a 429 error and returns false when the limit is exceeded.
+func (c *Middlewares) countQuota(w http.ResponseWriter, r *http.Request, limit int, namespace, apiToken, kind string,
+	minute time.Time,
+) bool {
+	requests, err := c.quotas.Count(r.Context(), namespace, apiToken, kind, minute)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return false
+	}
+	if limit == 0 || requests <= int64(limit) {
+		return true
+	}
+	if err := c.quotas.Reject(r.Context(), namespace, apiToken, kind, minute); err != nil {
+		slog.Error("counting rejected request", "err", err, "namespace", namespace, "kind", kind)
+	}
+	countQuotaRejection(r.Context(), kind)
+
+	subject := fmt.Sprintf("namespace '%s'", namespace)
+	if apiToken != "" {
+		subject = fmt.Sprintf("api token '%s'", apiToken)
+	}
+	retry := max(int(math.Ceil(time.Until(minute.Add(time.Minute)).Seconds())), 1)
+	w.Header().Set("Retry-After", strconv.Itoa(retry))
+	writeError(w, r, &Error{
+		Code:    CodeQuotaExceeded,
+		Message: fmt.Sprintf("%s exceeded its quota of %d %s per minute", subject, limit, strings.ReplaceAll(kind, "_", " ")),
+	})
+
+	return false
+}
+
+// quotaAPIToken returns the name of the api token that authenticated r when it belongs to the
+// namespace.
+func quotaAPIToken(r *http.Request, namespace string) string {
+	tokenNamespace, name, ok := strings.Cut(r.Header.Get(apiTokenNameHeader), "/")
+	if !ok || tokenNamespace != namespace {
+		return ""
+	}
+
+	return name
+}
+
+// quotaKinds returns the kinds of quotas a request to a namespace counts against.
+func quotaKinds(r *http.Request, namespace string) []string {
+	kinds := []string{eeDStore.QuotaAPICalls}
+	if r.Method != http.MethodPost {
+		return kinds
+	}
+	p := path.Clean("/" + r.URL.Path)
+	switch {
+	case strings.HasSuffix(p, "/namespaces/"+namespace+"/instances"):
+		kinds = append(kinds, eeDStore.QuotaInstanceStarts)
+	case strings.HasSuffix(p, "/namespaces/"+namespace+"/events/broadcast"):
+		kinds = append(kinds, eeDStore.QuotaEventBroadcasts)
+	}
+
+	return kinds
+}
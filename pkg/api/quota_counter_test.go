@@ -0,0 +1,115 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	eeDStore "github.com/direktiv/direktiv/direktiv-ee/pkg/datastore"
+	"github.com/hashicorp/golang-lru/v2/expirable"
+)
+
+func Test_memoryQuotaCounter(t *testing.T) {
+	ctx := context.Background()
+	counter := NewMemoryQuotaCounter().(*memoryQuotaCounter)
+
+	hour := time.Now().Truncate(time.Hour)
+	for i := int64(1); i <= 3; i++ {
+		if got, _ := counter.Count(ctx, "ns", "", eeDStore.QuotaAPICalls, hour); got != i {
+			t.Errorf("Count() = %d, want %d", got, i)
+		}
+	}
+	_, _ = counter.Count(ctx, "ns", "", eeDStore.QuotaAPICalls, hour.Add(time.Minute))
+	_, _ = counter.Count(ctx, "ns", "ci", eeDStore.QuotaAPICalls, hour)
+	_, _ = counter.Count(ctx, "other", "", eeDStore.QuotaAPICalls, hour)
+	if err := counter.Reject(ctx, "ns", "", eeDStore.QuotaAPICalls, hour); err != nil {
+		t.Fatalf("Reject() error = %v", err)
+	}
+
+	// Minutes are summed up by hour.
+	list, err := counter.Usage(ctx, "ns", hour, hour.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Usage() error = %v", err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("Usage() returned %d entries, want 2", len(list))
+	}
+	if list[0].APIToken != "" || list[0].Requests != 4 || list[0].Rejected != 1 || !list[0].Start.Equal(hour) {
+		t.Errorf("Usage()[0] = %+v", list[0])
+	}
+	if list[1].APIToken != "ci" || list[1].Requests != 1 {
+		t.Errorf("Usage()[1] = %+v", list[1])
+	}
+
+	counter.now = func() time.Time { return hour.Add(memoryQuotaRetention + time.Second) }
+	_ = counter.Purge(ctx)
+	if len(counter.usage) != 1 {
+		t.Errorf("Purge() kept %d entries, want 1", len(counter.usage))
+	}
+}
+
+func Test_quotaKinds(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		want   []string
+	}{
+		{http.MethodGet, "/api/v2/namespaces/ns/instances", []string{eeDStore.QuotaAPICalls}},
+		{http.MethodPost, "/api/v2/namespaces/ns/instances", []string{eeDStore.QuotaAPICalls, eeDStore.QuotaInstanceStarts}},
+		{http.MethodPost, "/api/v2/namespaces/ns/instances/", []string{eeDStore.QuotaAPICalls, eeDStore.QuotaInstanceStarts}},
+		{http.MethodPost, "/api/v2/namespaces/ns/instances/1234/cancel", []string{eeDStore.QuotaAPICalls}},
+		{http.MethodPost, "/api/v2/namespaces/ns/events/broadcast", []string{eeDStore.QuotaAPICalls, eeDStore.QuotaEventBroadcasts}},
+		{http.MethodPost, "/api/v2/namespaces/ns/events/history", []string{eeDStore.QuotaAPICalls}},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		if got := quotaKinds(r, "ns"); !slices.Equal(got, tt.want) {
+			t.Errorf("quotaKinds(%s %s) = %v, want %v", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
+
+func Test_CheckQuota(t *testing.T) {
+	c := &Middlewares{
+		quotas:     NewMemoryQuotaCounter(),
+		quotaCache: expirable.NewLRU[string, *eeDStore.Quota](10, nil, time.Minute),
+	}
+	c.quotaCache.Add("ns", &eeDStore.Quota{
+		Namespace:      "ns",
+		Limits:         eeDStore.QuotaLimits{APICalls: 3},
+		APITokenLimits: eeDStore.QuotaLimits{APICalls: 1},
+	})
+	handler := c.CheckQuota(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	send := func(token string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/api/v2/namespaces/ns/secrets", nil)
+		if token != "" {
+			r.Header.Set(apiTokenNameHeader, token)
+		}
+		handler.ServeHTTP(w, r)
+
+		return w
+	}
+
+	if w := send("ns/ci"); w.Code != http.StatusOK {
+		t.Fatalf("CheckQuota() status = %d, want %d", w.Code, http.StatusOK)
+	}
+	// Every token has its own limit, the namespace counts all requests.
+	if w := send("ns/ci"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("CheckQuota() status = %d for the token limit, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	if w := send("ns/deploy"); w.Code != http.StatusOK {
+		t.Errorf("CheckQuota() status = %d for another token, want %d", w.Code, http.StatusOK)
+	}
+	w := send("")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("CheckQuota() status = %d for the namespace limit, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Errorf("CheckQuota() has no Retry-After header")
+	}
+}
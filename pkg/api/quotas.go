@@ -0,0 +1,265 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	eeDStore "github.com/direktiv/direktiv/direktiv-ee/pkg/datastore"
+	"github.com/direktiv/direktiv/pkg/database"
+	"github.com/go-chi/chi/v5"
+)
+
+// maxQuotaUsageRange limits the period of a single usage query.
+const maxQuotaUsageRange = time.Hour * 24 * 31
+
+type QuotasController struct {
+	db      *database.DB
+	eStore  eeDStore.Store
+	counter QuotaCounter
+}
+
+// NewQuotasController returns the controller of namespace quotas, counter has to be the one of the
+// middlewares so that the usage matches what CheckQuota counted.
+func NewQuotasController(db *database.DB, eStore eeDStore.Store, counter QuotaCounter) *QuotasController {
+	return &QuotasController{
+		db:      db,
+		eStore:  eStore,
+		counter: counter,
+	}
+}
+
+type quotaLimitsDocument struct {
+	APICalls        int `json:"apiCallsPerMinute"`
+	InstanceStarts  int `json:"instanceStartsPerMinute"`
+	EventBroadcasts int `json:"eventBroadcastsPerMinute"`
+}
+
+func (d quotaLimitsDocument) limits() eeDStore.QuotaLimits {
+	return eeDStore.QuotaLimits{
+		APICalls:        d.APICalls,
+		InstanceStarts:  d.InstanceStarts,
+		EventBroadcasts: d.EventBroadcasts,
+	}
+}
+
+func convertQuotaLimits(l eeDStore.QuotaLimits) quotaLimitsDocument {
+	return quotaLimitsDocument{
+		APICalls:        l.APICalls,
+		InstanceStarts:  l.InstanceStarts,
+		EventBroadcasts: l.EventBroadcasts,
+	}
+}
+
+type quotaRequest struct {
+	Limits         quotaLimitsDocument `json:"limits"`
+	APITokenLimits quotaLimitsDocument `json:"apiTokenLimits"`
+}
+
+func (req *quotaRequest) validate(namespace string) fieldErrors {
+	quota := &eeDStore.Quota{
+		Namespace:      namespace,
+		Limits:         req.Limits.limits(),
+		APITokenLimits: req.APITokenLimits.limits(),
+	}
+	vErrs := fieldErrors{}
+	var invalid eeDStore.InvalidArgumentError
+	if errors.As(quota.Validate(), &invalid) {
+		for field, msg := range invalid {
+			vErrs[field] = msg
+		}
+	}
+
+	return vErrs
+}
+
+func (c *QuotasController) MountRouter(r chi.Router) {
+	r.Get("/", c.get)
+	r.Put("/", c.set)
+	r.Delete("/", c.delete)
+	r.Get("/usage", c.usage)
+}
+
+func (c *QuotasController) get(w http.ResponseWriter, r *http.Request) {
+	ns := extractContextNamespace(r)
+
+	db, err := c.db.BeginTx(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer db.Rollback()
+
+	quota, err := c.eStore.With(db.Conn()).Quotas().Get(r.Context(), ns.Name)
+	if errors.Is(err, eeDStore.ErrNotFound) {
+		quota = &eeDStore.Quota{Namespace: ns.Name}
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeJSON(w, convertQuota(quota))
+}
+
+func (c *QuotasController) set(w http.ResponseWriter, r *http.Request) {
+	ns := extractContextNamespace(r)
+
+	if !checkQuotaAdmin(w, r) {
+		return
+	}
+
+	db, err := c.db.BeginTx(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer db.Rollback()
+
+	// Parse request.
+	req := quotaRequest{}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if !checkFields(w, r, req.validate(ns.Name)) {
+		return
+	}
+
+	quota, err := c.eStore.With(db.Conn()).Quotas().Set(r.Context(), &eeDStore.Quota{
+		Namespace:      ns.Name,
+		Limits:         req.Limits.limits(),
+		APITokenLimits: req.APITokenLimits.limits(),
+	})
+	if err != nil {
+		writeDataStoreError(w, r, err)
+		return
+	}
+
+	err = db.Commit(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeJSON(w, convertQuota(quota))
+}
+
+// delete makes the namespace unlimited.
+func (c *QuotasController) delete(w http.ResponseWriter, r *http.Request) {
+	ns := extractContextNamespace(r)
+
+	if !checkQuotaAdmin(w, r) {
+		return
+	}
+
+	db, err := c.db.BeginTx(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer db.Rollback()
+
+	err = c.eStore.With(db.Conn()).Quotas().Delete(r.Context(), ns.Name)
+	if err != nil && !errors.Is(err, eeDStore.ErrNotFound) {
+		writeDataStoreError(w, r, err)
+		return
+	}
+
+	err = db.Commit(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeOk(w)
+}
+
+// usage returns the usage of the namespace by hour, the last day unless the from and to query
+// parameters are set.
+func (c *QuotasController) usage(w http.ResponseWriter, r *http.Request) {
+	ns := extractContextNamespace(r)
+
+	to := time.Now()
+	vErrs := fieldErrors{}
+	if v := r.URL.Query().Get("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			vErrs["to"] = "should be an RFC 3339 timestamp"
+		}
+		to = t
+	}
+	from := to.Add(-time.Hour * 24)
+	if v := r.URL.Query().Get("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			vErrs["from"] = "should be an RFC 3339 timestamp"
+		}
+		from = t
+	}
+	if len(vErrs) == 0 && !from.Before(to) {
+		vErrs["from"] = "should be before to"
+	}
+	if len(vErrs) == 0 && to.Sub(from) > maxQuotaUsageRange {
+		vErrs["from"] = fmt.Sprintf("should be at most %d days before to", maxQuotaUsageRange/(time.Hour*24))
+	}
+	if !checkFields(w, r, vErrs) {
+		return
+	}
+
+	list, err := c.counter.Usage(r.Context(), ns.Name, from, to)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	res := make([]any, len(list))
+	for i := range list {
+		res[i] = convertQuotaUsage(list[i])
+	}
+
+	writeJSON(w, res)
+}
+
+// checkQuotaAdmin writes an error and returns false unless the caller is an admin, quotas cap what
+// a namespace may use, so its own members can't change them.
+func checkQuotaAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if CallerFromContext(r.Context()).Admin {
+		return true
+	}
+	writeError(w, r, &Error{
+		Code:    CodeAccessTokenDenied,
+		Message: "only admins can change quotas",
+	})
+
+	return false
+}
+
+func convertQuota(v *eeDStore.Quota) any {
+	type quotaForAPI struct {
+		Limits         quotaLimitsDocument `json:"limits"`
+		APITokenLimits quotaLimitsDocument `json:"apiTokenLimits"`
+	}
+
+	return &quotaForAPI{
+		Limits:         convertQuotaLimits(v.Limits),
+		APITokenLimits: convertQuotaLimits(v.APITokenLimits),
+	}
+}
+
+func convertQuotaUsage(v *eeDStore.QuotaUsage) any {
+	type quotaUsageForAPI struct {
+		APIToken string    `json:"apiToken"`
+		Kind     string    `json:"kind"`
+		Start    time.Time `json:"start"`
+		Requests int64     `json:"requests"`
+		Rejected int64     `json:"rejected"`
+	}
+
+	return &quotaUsageForAPI{
+		APIToken: v.APIToken,
+		Kind:     v.Kind,
+		Start:    v.Start,
+		Requests: v.Requests,
+		Rejected: v.Rejected,
+	}
+}
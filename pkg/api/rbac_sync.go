@@ -0,0 +1,135 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	eeDStore "github.com/direktiv/direktiv/direktiv-ee/pkg/datastore"
+	"github.com/direktiv/direktiv/direktiv-ee/pkg/gitops"
+	"github.com/go-chi/chi/v5"
+)
+
+// rbacSyncer is what RBACSyncController needs of *gitops.RBACSyncer.
+type rbacSyncer interface {
+	Drift(ctx context.Context, namespace string) ([]gitops.Change, error)
+	Sync(ctx context.Context, namespace string, check gitops.SyncCheck) ([]gitops.Change, error)
+}
+
+// RBACSyncController exposes the sync of the roles and the api token policy declared in the
+// namespace tree.
+type RBACSyncController struct {
+	syncer rbacSyncer
+}
+
+func NewRBACSyncController(syncer *gitops.RBACSyncer) *RBACSyncController {
+	return &RBACSyncController{
+		syncer: syncer,
+	}
+}
+
+func (c *RBACSyncController) MountRouter(r chi.Router) {
+	r.Get("/", c.drift)
+	r.Post("/", c.sync)
+}
+
+// drift lists the changes the next sync would apply, an empty list means the datastore matches the
+// namespace tree.
+func (c *RBACSyncController) drift(w http.ResponseWriter, r *http.Request) {
+	ns := extractContextNamespace(r)
+
+	changes, err := c.syncer.Drift(r.Context(), ns.Name)
+	if err != nil {
+		writeSyncError(w, r, err)
+		return
+	}
+
+	writeJSON(w, convertChanges(changes))
+}
+
+// errSyncGrants aborts a sync whose declarations grant permissions the caller doesn't hold.
+var errSyncGrants = errors.New("declarations grant permissions the caller doesn't hold")
+
+// sync applies the declarations on behalf of the caller, who has to hold every permission of the
+// roles it creates, adopts or updates. Syncs on mirror runs trust the repository instead.
+func (c *RBACSyncController) sync(w http.ResponseWriter, r *http.Request) {
+	ns := extractContextNamespace(r)
+	caller := CallerFromContext(r.Context())
+
+	vErrs := map[string]string{}
+	changes, err := c.syncer.Sync(r.Context(), ns.Name, func(decl *gitops.Declarations, changes []gitops.Change) error {
+		declared := map[string]*eeDStore.Role{}
+		for _, role := range decl.Roles {
+			declared[role.Name] = role
+		}
+		for _, change := range changes {
+			if change.Kind != gitops.KindRole || change.Action == gitops.ActionDelete {
+				continue
+			}
+			role := declared[change.Name]
+			for k, v := range caller.disallowedGrants(change.Path+": permissions", ns.Name, role.Permissions) {
+				vErrs[k] = v
+			}
+		}
+		if len(vErrs) > 0 {
+			return errSyncGrants
+		}
+
+		return nil
+	})
+	if errors.Is(err, errSyncGrants) {
+		checkGrants(w, r, vErrs)
+		return
+	}
+	if err != nil {
+		writeSyncError(w, r, err)
+		return
+	}
+
+	writeJSON(w, convertChanges(changes))
+}
+
+// writeSyncError reports invalid declarations as invalid resources, they are the only errors that
+// name the declaring file.
+func writeSyncError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, gitops.ErrSyncInProgress) {
+		writeError(w, r, &Error{
+			Code:    CodeResourceSyncInProgress,
+			Message: err.Error(),
+		})
+
+		return
+	}
+	var pathErr *gitops.DeclarationError
+	if errors.As(err, &pathErr) {
+		writeError(w, r, &Error{
+			Code:    CodeResourceDeclarationInvalid,
+			Message: err.Error(),
+		})
+
+		return
+	}
+
+	writeInternalError(w, r, err)
+}
+
+func convertChanges(changes []gitops.Change) any {
+	type changeForAPI struct {
+		Kind   string `json:"kind"`
+		Name   string `json:"name,omitempty"`
+		Action string `json:"action"`
+		Path   string `json:"path"`
+	}
+
+	res := make([]changeForAPI, len(changes))
+	for i, c := range changes {
+		res[i] = changeForAPI{
+			Kind:   c.Kind,
+			Name:   c.Name,
+			Action: c.Action,
+			Path:   c.Path,
+		}
+	}
+
+	return res
+}
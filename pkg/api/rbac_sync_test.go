@@ -0,0 +1,135 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	eeDStore "github.com/direktiv/direktiv/direktiv-ee/pkg/datastore"
+	"github.com/direktiv/direktiv/direktiv-ee/pkg/gitops"
+	"github.com/direktiv/direktiv/pkg/datastore"
+)
+
+// fakeRBACSyncer plans the given changes and records whether they were applied.
+type fakeRBACSyncer struct {
+	decl    *gitops.Declarations
+	changes []gitops.Change
+	applied bool
+}
+
+func (s *fakeRBACSyncer) Drift(context.Context, string) ([]gitops.Change, error) {
+	return s.changes, nil
+}
+
+func (s *fakeRBACSyncer) Sync(_ context.Context, _ string, check gitops.SyncCheck) ([]gitops.Change, error) {
+	if check != nil {
+		if err := check(s.decl, s.changes); err != nil {
+			return nil, err
+		}
+	}
+	s.applied = true
+
+	return s.changes, nil
+}
+
+func Test_RBACSyncController_sync(t *testing.T) {
+	decl := &gitops.Declarations{Roles: []*eeDStore.Role{
+		{Namespace: "ns", Name: "readers", Permissions: eeDStore.Permissions{{"ns", "secrets", "read"}}},
+		{Namespace: "ns", Name: "admins", Permissions: eeDStore.Permissions{{"ns", "roles", "manage"}}},
+	}}
+	reader := &Caller{Name: "oidc:alice", Permissions: eeDStore.Permissions{
+		{"ns", "rbac_sync", "manage"},
+		{"ns", "secrets", "read"},
+	}}
+
+	tests := []struct {
+		name        string
+		caller      *Caller
+		changes     []gitops.Change
+		wantStatus  int
+		wantApplied bool
+	}{
+		{
+			name:   "caller holds the declared permissions",
+			caller: reader,
+			changes: []gitops.Change{
+				{Kind: gitops.KindRole, Name: "readers", Action: gitops.ActionCreate, Path: "/.direktiv/roles/readers.yaml"},
+			},
+			wantStatus:  http.StatusOK,
+			wantApplied: true,
+		},
+		{
+			name:   "created role escalates",
+			caller: reader,
+			changes: []gitops.Change{
+				{Kind: gitops.KindRole, Name: "readers", Action: gitops.ActionCreate, Path: "/.direktiv/roles/readers.yaml"},
+				{Kind: gitops.KindRole, Name: "admins", Action: gitops.ActionCreate, Path: "/.direktiv/roles/admins.yaml"},
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:   "adopted role escalates",
+			caller: reader,
+			changes: []gitops.Change{
+				{Kind: gitops.KindRole, Name: "admins", Action: gitops.ActionAdopt, Path: "/.direktiv/roles/admins.yaml"},
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:   "deleted roles grant nothing",
+			caller: reader,
+			changes: []gitops.Change{
+				{Kind: gitops.KindRole, Name: "old", Action: gitops.ActionDelete, Path: "/.direktiv/roles/old.yaml"},
+			},
+			wantStatus:  http.StatusOK,
+			wantApplied: true,
+		},
+		{
+			name:   "admins sync anything",
+			caller: &Caller{Name: "api_key", Admin: true},
+			changes: []gitops.Change{
+				{Kind: gitops.KindRole, Name: "admins", Action: gitops.ActionUpdate, Path: "/.direktiv/roles/admins.yaml"},
+			},
+			wantStatus:  http.StatusOK,
+			wantApplied: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			syncer := &fakeRBACSyncer{decl: decl, changes: tt.changes}
+			c := &RBACSyncController{syncer: syncer}
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/api/v2/namespaces/ns/rbac_sync", nil)
+			r = r.WithContext(context.WithValue(r.Context(), ctxKeyNamespace, &datastore.Namespace{Name: "ns"}))
+			c.sync(w, withCaller(r, tt.caller))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("sync() status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if syncer.applied != tt.wantApplied {
+				t.Errorf("sync() applied = %v, want %v", syncer.applied, tt.wantApplied)
+			}
+			if tt.wantApplied {
+				return
+			}
+			var body struct {
+				Error struct {
+					Code       ErrorCode         `json:"code"`
+					Validation map[string]string `json:"validation"`
+				} `json:"error"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("sync() returned invalid json: %v", err)
+			}
+			if body.Error.Code != CodeRequestDataInvalid {
+				t.Errorf("sync() error code = %s, want %s", body.Error.Code, CodeRequestDataInvalid)
+			}
+			if _, ok := body.Error.Validation["/.direktiv/roles/admins.yaml: permissions[0]"]; !ok || len(body.Error.Validation) != 1 {
+				t.Errorf("sync() validation = %v, want the permission of admins.yaml", body.Error.Validation)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// revocationList is an in memory copy of the revoked signed api tokens. It is refreshed
+// from the datastore at most once per refresh interval, so verifying a signed token
+// doesn't need a database query per request.
+type revocationList struct {
+	jtis *snapshot[map[uuid.UUID]struct{}]
+}
+
+func newRevocationList(refresh time.Duration, fetch func(ctx context.Context) ([]uuid.UUID, error)) *revocationList {
+	return &revocationList{
+		jtis: newSnapshot(refresh, func(ctx context.Context) (map[uuid.UUID]struct{}, error) {
+			list, err := fetch(ctx)
+			if err != nil {
+				return nil, err
+			}
+			jtis := make(map[uuid.UUID]struct{}, len(list))
+			for _, id := range list {
+				jtis[id] = struct{}{}
+			}
+
+			return jtis, nil
+		}),
+	}
+}
+
+func (l *revocationList) contains(ctx context.Context, jti uuid.UUID) (bool, error) {
+	jtis, err := l.jtis.get(ctx)
+	if err != nil {
+		return false, err
+	}
+	_, ok := jtis[jti]
+
+	return ok, nil
+}
+
+// len returns the number of revoked tokens as of the last refresh.
+func (l *revocationList) len() int {
+	jtis, _ := l.jtis.peek()
+
+	return len(jtis)
+}
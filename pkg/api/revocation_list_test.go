@@ -0,0 +1,39 @@
+package api
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func Test_revocationList(t *testing.T) {
+	revoked := uuid.New()
+	fetches := 0
+	list := newRevocationList(time.Hour, func(ctx context.Context) ([]uuid.UUID, error) {
+		fetches++
+
+		return []uuid.UUID{revoked}, nil
+	})
+
+	got, err := list.contains(context.Background(), revoked)
+	if err != nil {
+		t.Fatalf("contains() error = %v", err)
+	}
+	if !got {
+		t.Errorf("contains() = %v, want %v", got, true)
+	}
+
+	got, err = list.contains(context.Background(), uuid.New())
+	if err != nil {
+		t.Fatalf("contains() error = %v", err)
+	}
+	if got {
+		t.Errorf("contains() = %v, want %v", got, false)
+	}
+
+	if fetches != 1 {
+		t.Errorf("contains() fetched %v times, want %v", fetches, 1)
+	}
+}
@@ -0,0 +1,326 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	eeDStore "github.com/direktiv/direktiv/direktiv-ee/pkg/datastore"
+	"github.com/direktiv/direktiv/pkg/database"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+type RoleAssignmentsController struct {
+	db     *database.DB
+	eStore eeDStore.Store
+}
+
+func NewRoleAssignmentsController(db *database.DB, eStore eeDStore.Store) *RoleAssignmentsController {
+	return &RoleAssignmentsController{
+		db:     db,
+		eStore: eStore,
+	}
+}
+
+// roleAssignmentRequest binds either a group or a user, startsAt defaults to now.
+type roleAssignmentRequest struct {
+	Role          string     `json:"role"`
+	Group         string     `json:"group"`
+	User          string     `json:"user"`
+	Justification string     `json:"justification"`
+	StartsAt      *time.Time `json:"startsAt"`
+	EndsAt        time.Time  `json:"endsAt"`
+}
+
+// validate checks the fields of the request, the role and the time window are checked by the
+// datastore.
+func (req *roleAssignmentRequest) validate() fieldErrors {
+	vErrs := fieldErrors{}
+	if req.User != "" && req.Group != "" {
+		vErrs["user"] = "only one of group and user can be set"
+	}
+	vErrs.length("justification", req.Justification, maxJustificationLength)
+
+	return vErrs
+}
+
+func (c *RoleAssignmentsController) MountRouter(r chi.Router) {
+	r.Get("/{assignmentID}", c.get)
+	r.Delete("/{assignmentID}", c.delete)
+	r.Post("/{assignmentID}/approve", c.approve)
+	r.Post("/{assignmentID}/reject", c.reject)
+
+	r.Get("/", c.list)
+	r.Post("/", c.create)
+}
+
+func (c *RoleAssignmentsController) get(w http.ResponseWriter, r *http.Request) {
+	ns := extractContextNamespace(r)
+	id, ok := parseAssignmentID(w, r)
+	if !ok {
+		return
+	}
+
+	db, err := c.db.BeginTx(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer db.Rollback()
+
+	assignment, err := c.eStore.With(db.Conn()).RoleAssignments().Get(r.Context(), ns.Name, id)
+	if err != nil {
+		writeDataStoreError(w, r, err)
+		return
+	}
+
+	writeJSON(w, convertRoleAssignment(assignment))
+}
+
+// delete revokes an assignment, revoked assignments are removed rather than kept as history.
+func (c *RoleAssignmentsController) delete(w http.ResponseWriter, r *http.Request) {
+	ns := extractContextNamespace(r)
+	id, ok := parseAssignmentID(w, r)
+	if !ok {
+		return
+	}
+
+	db, err := c.db.BeginTx(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer db.Rollback()
+
+	err = c.eStore.With(db.Conn()).RoleAssignments().Delete(r.Context(), ns.Name, id)
+	if err != nil {
+		writeDataStoreError(w, r, err)
+		return
+	}
+
+	err = db.Commit(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeOk(w)
+}
+
+func (c *RoleAssignmentsController) list(w http.ResponseWriter, r *http.Request) {
+	ns := extractContextNamespace(r)
+
+	db, err := c.db.BeginTx(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer db.Rollback()
+
+	list, err := c.eStore.With(db.Conn()).RoleAssignments().List(r.Context(), ns.Name)
+	if err != nil {
+		writeDataStoreError(w, r, err)
+		return
+	}
+
+	res := make([]any, len(list))
+	for i := range list {
+		res[i] = convertRoleAssignment(list[i])
+	}
+
+	writeJSON(w, res)
+}
+
+// create requests an assignment, it is approved right away when the caller is a namespace owner that
+// holds every permission of the role and pending otherwise.
+func (c *RoleAssignmentsController) create(w http.ResponseWriter, r *http.Request) {
+	ns := extractContextNamespace(r)
+
+	db, err := c.db.BeginTx(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer db.Rollback()
+
+	// Parse request.
+	req := roleAssignmentRequest{}
+	if !decodeJSON(w, r, &req) || !checkFields(w, r, req.validate()) {
+		return
+	}
+
+	assignment := &eeDStore.RoleAssignment{
+		Namespace:     ns.Name,
+		RoleName:      req.Role,
+		AssigneeType:  eeDStore.AssigneeGroup,
+		Assignee:      req.Group,
+		Justification: req.Justification,
+		StartsAt:      time.Now(),
+		EndsAt:        req.EndsAt,
+		Status:        eeDStore.AssignmentPending,
+		RequestedBy:   CallerFromContext(r.Context()).Name,
+	}
+	if req.User != "" {
+		assignment.AssigneeType, assignment.Assignee = eeDStore.AssigneeUser, req.User
+	}
+	if req.StartsAt != nil {
+		assignment.StartsAt = *req.StartsAt
+	}
+
+	store := c.eStore.With(db.Conn())
+	// Unknown roles are reported by the datastore.
+	role, err := store.Roles().Get(r.Context(), ns.Name, req.Role)
+	if err != nil && !errors.Is(err, eeDStore.ErrNotFound) {
+		writeDataStoreError(w, r, err)
+		return
+	}
+	if role != nil && canApprove(CallerFromContext(r.Context()), role) {
+		assignment.Status = eeDStore.AssignmentApproved
+	}
+
+	assignment, err = store.RoleAssignments().Create(r.Context(), assignment)
+	if err != nil {
+		writeDataStoreError(w, r, err)
+		return
+	}
+
+	err = db.Commit(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeJSON(w, convertRoleAssignment(assignment))
+}
+
+func (c *RoleAssignmentsController) approve(w http.ResponseWriter, r *http.Request) {
+	c.decide(w, r, eeDStore.AssignmentApproved)
+}
+
+func (c *RoleAssignmentsController) reject(w http.ResponseWriter, r *http.Request) {
+	c.decide(w, r, eeDStore.AssignmentRejected)
+}
+
+// decide approves or rejects a pending assignment, only namespace owners that hold every permission of
+// the role can do so.
+func (c *RoleAssignmentsController) decide(w http.ResponseWriter, r *http.Request, status string) {
+	ns := extractContextNamespace(r)
+	id, ok := parseAssignmentID(w, r)
+	if !ok {
+		return
+	}
+
+	db, err := c.db.BeginTx(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer db.Rollback()
+
+	store := c.eStore.With(db.Conn())
+	assignment, err := store.RoleAssignments().Get(r.Context(), ns.Name, id)
+	if err != nil {
+		writeDataStoreError(w, r, err)
+		return
+	}
+	role, err := store.Roles().Get(r.Context(), ns.Name, assignment.RoleName)
+	if err != nil {
+		writeDataStoreError(w, r, err)
+		return
+	}
+	caller := CallerFromContext(r.Context())
+	if !canApprove(caller, role) {
+		writeError(w, r, &Error{
+			Code:    CodeAccessTokenDenied,
+			Message: fmt.Sprintf("only namespace owners holding the permissions of role '%s' can decide its assignments", role.Name),
+		})
+
+		return
+	}
+
+	assignment, err = store.RoleAssignments().Decide(r.Context(), ns.Name, id, status, caller.Name)
+	if errors.Is(err, eeDStore.ErrConflict) {
+		writeError(w, r, &Error{
+			Code:    CodeResourceConflict,
+			Message: "role assignment was already decided",
+		})
+
+		return
+	}
+	if err != nil {
+		writeDataStoreError(w, r, err)
+		return
+	}
+
+	err = db.Commit(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeJSON(w, convertRoleAssignment(assignment))
+}
+
+// canApprove reports whether the caller is a namespace owner that could grant the role itself.
+func canApprove(caller *Caller, role *eeDStore.Role) bool {
+	return caller.ownsNamespace(role.Namespace) &&
+		len(caller.disallowedGrants("permissions", role.Namespace, role.Permissions)) == 0
+}
+
+func parseAssignmentID(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	id, err := uuid.Parse(chi.URLParam(r, "assignmentID"))
+	if err != nil {
+		writeError(w, r, &Error{
+			Code:    CodeResourceNotFound,
+			Message: "requested resource is not found",
+		})
+
+		return uuid.Nil, false
+	}
+
+	return id, true
+}
+
+func convertRoleAssignment(v *eeDStore.RoleAssignment) any {
+	type roleAssignmentForAPI struct {
+		ID            uuid.UUID `json:"id"`
+		Role          string    `json:"role"`
+		Group         string    `json:"group,omitempty"`
+		User          string    `json:"user,omitempty"`
+		Justification string    `json:"justification"`
+		StartsAt      time.Time `json:"startsAt"`
+		EndsAt        time.Time `json:"endsAt"`
+		Status        string    `json:"status"`
+		// Active is set while the assignment grants its role.
+		Active      bool       `json:"active"`
+		RequestedBy string     `json:"requestedBy"`
+		DecidedBy   string     `json:"decidedBy"`
+		DecidedAt   *time.Time `json:"decidedAt"`
+
+		CreatedAt time.Time `json:"createdAt"`
+	}
+
+	res := &roleAssignmentForAPI{
+		ID:            v.ID,
+		Role:          v.RoleName,
+		Justification: v.Justification,
+		StartsAt:      v.StartsAt,
+		EndsAt:        v.EndsAt,
+		Status:        v.Status,
+		Active:        v.IsActive(time.Now()),
+		RequestedBy:   v.RequestedBy,
+		DecidedBy:     v.DecidedBy,
+		DecidedAt:     v.DecidedAt,
+
+		CreatedAt: v.CreatedAt,
+	}
+	if v.AssigneeType == eeDStore.AssigneeUser {
+		res.User = v.Assignee
+	} else {
+		res.Group = v.Assignee
+	}
+
+	return res
+}
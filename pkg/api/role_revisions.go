@@ -0,0 +1,250 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	eeDStore "github.com/direktiv/direktiv/direktiv-ee/pkg/datastore"
+	"github.com/go-chi/chi/v5"
+)
+
+func (c *RolesController) listRevisions(w http.ResponseWriter, r *http.Request) {
+	ns := extractContextNamespace(r)
+	roleName := chi.URLParam(r, "roleName")
+
+	db, err := c.db.BeginTx(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer db.Rollback()
+
+	store := c.eStore.With(db.Conn())
+	if _, err := store.Roles().Get(r.Context(), ns.Name, roleName); err != nil {
+		writeDataStoreError(w, r, err)
+		return
+	}
+	list, err := store.Roles().ListRevisions(r.Context(), ns.Name, roleName)
+	if err != nil {
+		writeDataStoreError(w, r, err)
+		return
+	}
+
+	res := make([]any, len(list))
+	for i := range list {
+		res[i] = convertRoleRevision(list[i])
+	}
+
+	writeJSON(w, res)
+}
+
+func (c *RolesController) getRevision(w http.ResponseWriter, r *http.Request) {
+	ns := extractContextNamespace(r)
+	roleName := chi.URLParam(r, "roleName")
+
+	revision, ok := parseRevision(w, r, "revision", chi.URLParam(r, "revision"))
+	if !ok {
+		return
+	}
+
+	db, err := c.db.BeginTx(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer db.Rollback()
+
+	rev, err := c.eStore.With(db.Conn()).Roles().GetRevision(r.Context(), ns.Name, roleName, revision)
+	if err != nil {
+		writeDataStoreError(w, r, err)
+		return
+	}
+
+	writeJSON(w, convertRoleRevision(rev))
+}
+
+// diffRevisions compares the revisions given by the from and to query parameters, to defaults to the
+// current revision.
+func (c *RolesController) diffRevisions(w http.ResponseWriter, r *http.Request) {
+	ns := extractContextNamespace(r)
+	roleName := chi.URLParam(r, "roleName")
+
+	from, ok := parseRevision(w, r, "from", r.URL.Query().Get("from"))
+	if !ok {
+		return
+	}
+
+	db, err := c.db.BeginTx(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer db.Rollback()
+
+	store := c.eStore.With(db.Conn())
+	role, err := store.Roles().Get(r.Context(), ns.Name, roleName)
+	if err != nil {
+		writeDataStoreError(w, r, err)
+		return
+	}
+	to := role.Version
+	if r.URL.Query().Get("to") != "" {
+		if to, ok = parseRevision(w, r, "to", r.URL.Query().Get("to")); !ok {
+			return
+		}
+	}
+
+	fromRev, err := store.Roles().GetRevision(r.Context(), ns.Name, roleName, from)
+	if err != nil {
+		writeDataStoreError(w, r, err)
+		return
+	}
+	toRev, err := store.Roles().GetRevision(r.Context(), ns.Name, roleName, to)
+	if err != nil {
+		writeDataStoreError(w, r, err)
+		return
+	}
+
+	writeJSON(w, diffRoleRevisions(fromRev, toRev))
+}
+
+// rollback restores the definition of a revision, the rollback itself is recorded as a new revision.
+func (c *RolesController) rollback(w http.ResponseWriter, r *http.Request) {
+	ns := extractContextNamespace(r)
+	roleName := chi.URLParam(r, "roleName")
+
+	revision, ok := parseRevision(w, r, "revision", r.URL.Query().Get("revision"))
+	if !ok {
+		return
+	}
+
+	db, err := c.db.BeginTx(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer db.Rollback()
+
+	current, ok := writableRole(w, r, c.eStore.With(db.Conn()), ns.Name, roleName)
+	if !ok {
+		return
+	}
+	if !matchesIfMatch(r, current.Version) {
+		writeError(w, r, &Error{
+			Code:    CodeResourcePreconditionFailed,
+			Message: fmt.Sprintf("role '%s' was changed, its current version is %s", roleName, etag(current.Version)),
+		})
+
+		return
+	}
+	rev, err := c.eStore.With(db.Conn()).Roles().GetRevision(r.Context(), ns.Name, roleName, revision)
+	if err != nil {
+		writeDataStoreError(w, r, err)
+		return
+	}
+
+	c.saveRole(w, r, db, roleName, &eeDStore.Role{
+		Name:        current.Name,
+		Namespace:   ns.Name,
+		Description: rev.Description,
+		OidcGroups:  rev.OidcGroups,
+		Users:       rev.Users,
+		Permissions: rev.Permissions,
+		Version:     current.Version,
+	})
+}
+
+func parseRevision(w http.ResponseWriter, r *http.Request, field, value string) (int64, bool) {
+	revision, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || revision < 1 {
+		writeError(w, r, &Error{
+			Code:    CodeRequestDataInvalid,
+			Message: "request data has invalid fields",
+			Validation: map[string]string{
+				field: "should be a revision number",
+			},
+		})
+
+		return 0, false
+	}
+
+	return revision, true
+}
+
+type stringChange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type listChange[T any] struct {
+	Added   []T `json:"added"`
+	Removed []T `json:"removed"`
+}
+
+type roleRevisionsDiff struct {
+	From int64 `json:"from"`
+	To   int64 `json:"to"`
+	// Description is nil when it didn't change.
+	Description *stringChange                  `json:"description"`
+	OidcGroups  listChange[string]             `json:"oidcGroups"`
+	Users       listChange[string]             `json:"users"`
+	Permissions listChange[permissionDocument] `json:"permissions"`
+}
+
+func diffRoleRevisions(from, to *eeDStore.RoleRevision) *roleRevisionsDiff {
+	diff := &roleRevisionsDiff{
+		From:        from.Revision,
+		To:          to.Revision,
+		OidcGroups:  diffLists([]string(from.OidcGroups), []string(to.OidcGroups)),
+		Users:       diffLists([]string(from.Users), []string(to.Users)),
+		Permissions: diffLists(toPermissionDocuments(from.Permissions), toPermissionDocuments(to.Permissions)),
+	}
+	if from.Description != to.Description {
+		diff.Description = &stringChange{From: from.Description, To: to.Description}
+	}
+
+	return diff
+}
+
+func diffLists[T comparable](from, to []T) listChange[T] {
+	res := listChange[T]{Added: []T{}, Removed: []T{}}
+	for _, v := range to {
+		if !slices.Contains(from, v) {
+			res.Added = append(res.Added, v)
+		}
+	}
+	for _, v := range from {
+		if !slices.Contains(to, v) {
+			res.Removed = append(res.Removed, v)
+		}
+	}
+
+	return res
+}
+
+func convertRoleRevision(v *eeDStore.RoleRevision) any {
+	type roleRevisionForAPI struct {
+		Revision    int64                `json:"revision"`
+		Description string               `json:"description"`
+		OidcGroups  any                  `json:"oidcGroups"`
+		Users       any                  `json:"users"`
+		Permissions []permissionDocument `json:"permissions"`
+		Author      string               `json:"author"`
+
+		CreatedAt time.Time `json:"createdAt"`
+	}
+
+	return &roleRevisionForAPI{
+		Revision:    v.Revision,
+		Description: v.Description,
+		OidcGroups:  v.OidcGroups,
+		Users:       v.Users,
+		Permissions: toPermissionDocuments(v.Permissions),
+		Author:      v.Author,
+
+		CreatedAt: v.CreatedAt,
+	}
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+
+	eeDStore "github.com/direktiv/direktiv/direktiv-ee/pkg/datastore"
+)
+
+func Test_diffRoleRevisions(t *testing.T) {
+	from := &eeDStore.RoleRevision{
+		Revision:    1,
+		Description: "old",
+		OidcGroups:  eeDStore.OidcGroups{"g1", "g2"},
+		Users:       eeDStore.RoleUsers{"alice"},
+		Permissions: eeDStore.Permissions{{Topic: "secrets", Method: "read"}},
+	}
+	to := &eeDStore.RoleRevision{
+		Revision:    3,
+		Description: "new",
+		OidcGroups:  eeDStore.OidcGroups{"g2", "g3"},
+		Users:       eeDStore.RoleUsers{"alice", "bob@example.com"},
+		Permissions: eeDStore.Permissions{{Topic: "secrets", Method: "read"}, {Topic: "files", Method: "manage"}},
+	}
+
+	got := diffRoleRevisions(from, to)
+	want := &roleRevisionsDiff{
+		From:        1,
+		To:          3,
+		Description: &stringChange{From: "old", To: "new"},
+		OidcGroups:  listChange[string]{Added: []string{"g3"}, Removed: []string{"g1"}},
+		Users:       listChange[string]{Added: []string{"bob@example.com"}, Removed: []string{}},
+		Permissions: listChange[permissionDocument]{
+			Added:   []permissionDocument{{Topic: "files", Method: "manage"}},
+			Removed: []permissionDocument{},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("diffRoleRevisions() = %+v, want %+v", got, want)
+	}
+
+	got = diffRoleRevisions(from, from)
+	if got.Description != nil || len(got.OidcGroups.Added) != 0 || len(got.Permissions.Removed) != 0 {
+		t.Errorf("diffRoleRevisions() of the same revision = %+v, want no changes", got)
+	}
+}
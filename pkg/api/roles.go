@@ -1,7 +1,7 @@
 package api
 
 import (
-	"encoding/json"
+	"fmt"
 	"net/http"
 	"time"
 
@@ -22,10 +22,48 @@ func NewRolesController(db *database.DB, eStore eeDStore.Store) *RolesController
 	}
 }
 
+// roleRequest is the body of both creates and updates, updates replace the whole role.
+type roleRequest struct {
+	Name        string               `json:"name"`
+	Description string               `json:"description"`
+	OidcGroups  eeDStore.OidcGroups  `json:"oidcGroups"`
+	Users       eeDStore.RoleUsers   `json:"users"`
+	Permissions eeDStore.Permissions `json:"permissions"`
+}
+
+// validate checks every field of the request, current is the name of the role that is updated.
+func (req *roleRequest) validate(current string) fieldErrors {
+	vErrs := fieldErrors{}
+	vErrs.name("name", req.Name, current)
+	vErrs.length("description", req.Description, maxDescriptionLength)
+	vErrs.check("oidcGroups", req.OidcGroups.Validate())
+	vErrs.check("users", req.Users.Validate())
+	vErrs.check("permissions", req.Permissions.Validate())
+
+	return vErrs
+}
+
 func (c *RolesController) MountRouter(r chi.Router) {
+	// Collection actions live under "/-", which can't collide with role names like "export".
+	r.Get("/-/export", c.export)
+	r.Post("/-/import", c.importRoles)
+
 	r.Get("/{roleName}", c.get)
 	r.Delete("/{roleName}", c.delete)
 	r.Put("/{roleName}", c.update)
+	r.Patch("/{roleName}", c.patch)
+
+	r.Post("/{roleName}/oidc_groups", c.addOidcGroup)
+	r.Delete("/{roleName}/oidc_groups/{group}", c.removeOidcGroup)
+	r.Post("/{roleName}/users", c.addUser)
+	r.Delete("/{roleName}/users/{user}", c.removeUser)
+	r.Post("/{roleName}/permissions", c.addPermission)
+	r.Delete("/{roleName}/permissions/{topic}/{method}", c.removePermission)
+
+	r.Get("/{roleName}/revisions", c.listRevisions)
+	r.Get("/{roleName}/revisions/diff", c.diffRevisions)
+	r.Get("/{roleName}/revisions/{revision}", c.getRevision)
+	r.Post("/{roleName}/rollback", c.rollback)
 
 	r.Get("/", c.list)
 	r.Post("/", c.create)
@@ -37,7 +75,7 @@ func (c *RolesController) get(w http.ResponseWriter, r *http.Request) {
 
 	db, err := c.db.BeginTx(r.Context())
 	if err != nil {
-		writeInternalError(w, err)
+		writeInternalError(w, r, err)
 		return
 	}
 	defer db.Rollback()
@@ -45,10 +83,11 @@ func (c *RolesController) get(w http.ResponseWriter, r *http.Request) {
 	// Fetch one
 	role, err := c.eStore.With(db.Conn()).Roles().Get(r.Context(), ns.Name, roleName)
 	if err != nil {
-		writeDataStoreError(w, err)
+		writeDataStoreError(w, r, err)
 		return
 	}
 
+	w.Header().Set("ETag", etag(role.Version))
 	writeJSON(w, convertRole(role))
 }
 
@@ -58,20 +97,24 @@ func (c *RolesController) delete(w http.ResponseWriter, r *http.Request) {
 
 	db, err := c.db.BeginTx(r.Context())
 	if err != nil {
-		writeInternalError(w, err)
+		writeInternalError(w, r, err)
 		return
 	}
 	defer db.Rollback()
 
+	if _, ok := writableRole(w, r, c.eStore.With(db.Conn()), ns.Name, roleName); !ok {
+		return
+	}
+
 	err = c.eStore.With(db.Conn()).Roles().Delete(r.Context(), ns.Name, roleName)
 	if err != nil {
-		writeDataStoreError(w, err)
+		writeDataStoreError(w, r, err)
 		return
 	}
 
 	err = db.Commit(r.Context())
 	if err != nil {
-		writeInternalError(w, err)
+		writeInternalError(w, r, err)
 		return
 	}
 
@@ -83,33 +126,19 @@ func (c *RolesController) create(w http.ResponseWriter, r *http.Request) {
 
 	db, err := c.db.BeginTx(r.Context())
 	if err != nil {
-		writeInternalError(w, err)
+		writeInternalError(w, r, err)
 		return
 	}
 	defer db.Rollback()
 
 	// Parse request.
-	req := struct {
-		Name        string               `json:"name"`
-		Description string               `json:"description"`
-		OidcGroups  eeDStore.OidcGroups  `json:"oidcGroups"`
-		Permissions eeDStore.Permissions `json:"permissions"`
-	}{}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeNotJSONError(w, err)
+	req := roleRequest{}
+	if !decodeJSON(w, r, &req) || !checkFields(w, r, req.validate("")) {
 		return
 	}
 
-	err = req.OidcGroups.Validate()
-	if err != nil {
-		writeError(w, &Error{
-			Code:    "request_data_invalid",
-			Message: "field oidcGroup has invalid format",
-			Validation: map[string]string{
-				"permissions": err.Error(),
-			},
-		})
-
+	// Roles can't grant more than the caller holds, as the caller could bind them to its own groups.
+	if !checkGrants(w, r, CallerFromContext(r.Context()).disallowedGrants("permissions", ns.Name, req.Permissions)) {
 		return
 	}
 
@@ -119,20 +148,23 @@ func (c *RolesController) create(w http.ResponseWriter, r *http.Request) {
 		Namespace:   ns.Name,
 		Description: req.Description,
 		OidcGroups:  req.OidcGroups,
+		Users:       req.Users,
 		Permissions: req.Permissions,
+		UpdatedBy:   CallerFromContext(r.Context()).Name,
 	})
 	if err != nil {
-		writeDataStoreError(w, err)
+		writeDataStoreError(w, r, err)
 
 		return
 	}
 
 	err = db.Commit(r.Context())
 	if err != nil {
-		writeInternalError(w, err)
+		writeInternalError(w, r, err)
 		return
 	}
 
+	w.Header().Set("ETag", etag(role.Version))
 	writeJSON(w, convertRole(role))
 }
 
@@ -142,55 +174,63 @@ func (c *RolesController) update(w http.ResponseWriter, r *http.Request) {
 
 	db, err := c.db.BeginTx(r.Context())
 	if err != nil {
-		writeInternalError(w, err)
+		writeInternalError(w, r, err)
 		return
 	}
 	defer db.Rollback()
 
 	// Parse request.
-	req := struct {
-		Name        string               `json:"name"`
-		Description string               `json:"description"`
-		OidcGroups  eeDStore.OidcGroups  `json:"oidcGroups"`
-		Permissions eeDStore.Permissions `json:"permissions"`
-	}{}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeNotJSONError(w, err)
+	req := roleRequest{}
+	if !decodeJSON(w, r, &req) || !checkFields(w, r, req.validate(roleName)) {
 		return
 	}
 
-	err = req.OidcGroups.Validate()
-	if err != nil {
-		writeError(w, &Error{
-			Code:    "request_data_invalid",
-			Message: "field permissions has invalid format",
-			Validation: map[string]string{
-				"permissions": err.Error(),
-			},
-		})
+	// Roles can't grant more than the caller holds, as the caller could bind them to its own groups.
+	if !checkGrants(w, r, CallerFromContext(r.Context()).disallowedGrants("permissions", ns.Name, req.Permissions)) {
+		return
+	}
 
+	current, ok := writableRole(w, r, c.eStore.With(db.Conn()), ns.Name, roleName)
+	if !ok {
 		return
 	}
+	// Updates with If-Match are conditional on the version, without they overwrite the role.
+	var version int64
+	if r.Header.Get("If-Match") != "" {
+		if !matchesIfMatch(r, current.Version) {
+			writeError(w, r, &Error{
+				Code:    CodeResourcePreconditionFailed,
+				Message: fmt.Sprintf("role '%s' was changed, its current version is %s", roleName, etag(current.Version)),
+			})
+
+			return
+		}
+		version = current.Version
+	}
 
 	// Update role.
 	role, err := c.eStore.With(db.Conn()).Roles().Update(r.Context(), ns.Name, roleName, &eeDStore.Role{
 		Name:        req.Name,
 		Description: req.Description,
 		OidcGroups:  req.OidcGroups,
+		Users:       req.Users,
 		Permissions: req.Permissions,
+		Version:     version,
+		UpdatedBy:   CallerFromContext(r.Context()).Name,
 	})
 	if err != nil {
-		writeDataStoreError(w, err)
+		writeDataStoreError(w, r, err)
 
 		return
 	}
 
 	err = db.Commit(r.Context())
 	if err != nil {
-		writeInternalError(w, err)
+		writeInternalError(w, r, err)
 		return
 	}
 
+	w.Header().Set("ETag", etag(role.Version))
 	writeJSON(w, convertRole(role))
 }
 
@@ -199,14 +239,31 @@ func (c *RolesController) list(w http.ResponseWriter, r *http.Request) {
 
 	db, err := c.db.BeginTx(r.Context())
 	if err != nil {
-		writeInternalError(w, err)
+		writeInternalError(w, r, err)
 		return
 	}
 	defer db.Rollback()
 
-	list, err := c.eStore.With(db.Conn()).Roles().List(r.Context(), ns.Name)
+	opts, vErrs := parseListOptions(r)
+	if len(vErrs) > 0 {
+		writeError(w, r, &Error{
+			Code:       CodeRequestDataInvalid,
+			Message:    "request data has invalid fields",
+			Validation: vErrs,
+		})
+
+		return
+	}
+	filter := eeDStore.RolesFilter{
+		OidcGroup:  r.URL.Query().Get("oidcGroup"),
+		User:       r.URL.Query().Get("user"),
+		Topic:      r.URL.Query().Get("topic"),
+		NamePrefix: r.URL.Query().Get("namePrefix"),
+	}
+
+	list, next, err := c.eStore.With(db.Conn()).Roles().List(r.Context(), ns.Name, filter, opts)
 	if err != nil {
-		writeDataStoreError(w, err)
+		writeDataStoreError(w, r, err)
 		return
 	}
 
@@ -215,7 +272,28 @@ func (c *RolesController) list(w http.ResponseWriter, r *http.Request) {
 		res[i] = convertRole(list[i])
 	}
 
-	writeJSON(w, res)
+	writeJSONPage(w, res, &pagination{Limit: opts.Limit, NextCursor: next})
+}
+
+// writableRole returns the current role, it writes an error and returns false when the role is
+// declared in the namespace tree, such roles can only be changed through their file.
+func writableRole(w http.ResponseWriter, r *http.Request, store eeDStore.StoreInner, namespace, name string,
+) (*eeDStore.Role, bool) {
+	role, err := store.Roles().Get(r.Context(), namespace, name)
+	if err != nil {
+		writeDataStoreError(w, r, err)
+		return nil, false
+	}
+	if role.ManagedBy != "" {
+		writeError(w, r, &Error{
+			Code:    CodeResourceReadOnly,
+			Message: fmt.Sprintf("role '%s' is managed by '%s' in the namespace tree", name, role.ManagedBy),
+		})
+
+		return nil, false
+	}
+
+	return role, true
 }
 
 func convertRole(v *eeDStore.Role) any {
@@ -223,7 +301,10 @@ func convertRole(v *eeDStore.Role) any {
 		Name        string `json:"name"`
 		Description string `json:"description"`
 		OidcGroups  any    `json:"oidcGroups"`
+		Users       any    `json:"users"`
 		Permissions any    `json:"permissions"`
+		ManagedBy   string `json:"managedBy"`
+		ReadOnly    bool   `json:"readOnly"`
 
 		CreatedAt time.Time `json:"createdAt"`
 		UpdatedAt time.Time `json:"updatedAt"`
@@ -249,7 +330,10 @@ func convertRole(v *eeDStore.Role) any {
 		Name:        v.Name,
 		Description: v.Description,
 		OidcGroups:  v.OidcGroups,
+		Users:       v.Users,
 		Permissions: permissions,
+		ManagedBy:   v.ManagedBy,
+		ReadOnly:    v.ManagedBy != "",
 
 		CreatedAt: v.CreatedAt,
 		UpdatedAt: v.UpdatedAt,
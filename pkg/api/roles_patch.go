@@ -0,0 +1,319 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"slices"
+
+	eeDStore "github.com/direktiv/direktiv/direktiv-ee/pkg/datastore"
+	"github.com/direktiv/direktiv/pkg/database"
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	mediaTypeMergePatch = "application/merge-patch+json"
+	mediaTypeJSONPatch  = "application/json-patch+json"
+
+	maxPatchSize = 1 << 20
+)
+
+// rolePatchDocument is the document patches are applied to, lists are never null so that json
+// patches can append to them.
+type rolePatchDocument struct {
+	Name        string               `json:"name"`
+	Description string               `json:"description"`
+	OidcGroups  []string             `json:"oidcGroups"`
+	Users       []string             `json:"users"`
+	Permissions []permissionDocument `json:"permissions"`
+}
+
+type roleOidcGroupRequest struct {
+	Group string `json:"group"`
+}
+
+type roleUserRequest struct {
+	User string `json:"user"`
+}
+
+// patch applies a json merge patch (RFC 7396) or a json patch (RFC 6902) to a role, depending on the
+// content type. The patch is applied to the role as it was read, concurrent changes fail the request.
+func (c *RolesController) patch(w http.ResponseWriter, r *http.Request) {
+	ns := extractContextNamespace(r)
+	roleName := chi.URLParam(r, "roleName")
+
+	db, err := c.db.BeginTx(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer db.Rollback()
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPatchSize))
+	if err != nil {
+		writeReadBodyError(w, r, err)
+		return
+	}
+
+	current, ok := writableRole(w, r, c.eStore.With(db.Conn()), ns.Name, roleName)
+	if !ok {
+		return
+	}
+	if !matchesIfMatch(r, current.Version) {
+		writeError(w, r, &Error{
+			Code:    CodeResourcePreconditionFailed,
+			Message: fmt.Sprintf("role '%s' was changed, its current version is %s", roleName, etag(current.Version)),
+		})
+
+		return
+	}
+
+	doc := &rolePatchDocument{
+		Name:        current.Name,
+		Description: current.Description,
+		OidcGroups:  append([]string{}, current.OidcGroups...),
+		Users:       append([]string{}, current.Users...),
+		Permissions: append([]permissionDocument{}, toPermissionDocuments(current.Permissions)...),
+	}
+	docData, err := json.Marshal(doc)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	var patched []byte
+	switch mediaType {
+	case mediaTypeMergePatch:
+		patched, err = jsonpatch.MergePatch(docData, body)
+	case mediaTypeJSONPatch:
+		var p jsonpatch.Patch
+		p, err = jsonpatch.DecodePatch(body)
+		if err == nil {
+			patched, err = p.Apply(docData)
+		}
+	default:
+		writeError(w, r, &Error{
+			Code:    CodeRequestContentTypeInvalid,
+			Message: fmt.Sprintf("content type should be one of '%s' or '%s'", mediaTypeMergePatch, mediaTypeJSONPatch),
+		})
+
+		return
+	}
+	if err != nil {
+		writeError(w, r, &Error{
+			Code:    CodeRequestDataInvalid,
+			Message: fmt.Sprintf("couldn't apply patch: %s", err),
+		})
+
+		return
+	}
+
+	// Patches can't add fields a role doesn't have.
+	doc = &rolePatchDocument{}
+	dec := json.NewDecoder(bytes.NewReader(patched))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(doc); err != nil {
+		writeError(w, r, &Error{
+			Code:    CodeRequestDataInvalid,
+			Message: fmt.Sprintf("patched role is invalid: %s", err),
+		})
+
+		return
+	}
+
+	c.saveRole(w, r, db, roleName, &eeDStore.Role{
+		Name:        doc.Name,
+		Namespace:   ns.Name,
+		Description: doc.Description,
+		OidcGroups:  doc.OidcGroups,
+		Users:       doc.Users,
+		Permissions: fromPermissionDocuments(doc.Permissions),
+		Version:     current.Version,
+	})
+}
+
+func (c *RolesController) addOidcGroup(w http.ResponseWriter, r *http.Request) {
+	req := roleOidcGroupRequest{}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	c.modifyRole(w, r, func(role *eeDStore.Role) *Error {
+		if !slices.Contains(role.OidcGroups, req.Group) {
+			role.OidcGroups = append(role.OidcGroups, req.Group)
+		}
+
+		return nil
+	})
+}
+
+func (c *RolesController) removeOidcGroup(w http.ResponseWriter, r *http.Request) {
+	// Groups can contain slashes, they are passed escaped.
+	group, err := url.PathUnescape(chi.URLParam(r, "group"))
+	if err != nil {
+		writeError(w, r, &Error{
+			Code:    CodeRequestDataInvalid,
+			Message: "group is not escaped correctly",
+		})
+
+		return
+	}
+
+	c.modifyRole(w, r, func(role *eeDStore.Role) *Error {
+		i := slices.Index(role.OidcGroups, group)
+		if i < 0 {
+			return &Error{
+				Code:    CodeResourceNotFound,
+				Message: fmt.Sprintf("role '%s' has no oidc group '%s'", role.Name, group),
+			}
+		}
+		role.OidcGroups = slices.Delete(role.OidcGroups, i, i+1)
+
+		return nil
+	})
+}
+
+func (c *RolesController) addUser(w http.ResponseWriter, r *http.Request) {
+	req := roleUserRequest{}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	c.modifyRole(w, r, func(role *eeDStore.Role) *Error {
+		if !slices.Contains(role.Users, req.User) {
+			role.Users = append(role.Users, req.User)
+		}
+
+		return nil
+	})
+}
+
+func (c *RolesController) removeUser(w http.ResponseWriter, r *http.Request) {
+	// Subjects can contain slashes, they are passed escaped.
+	user, err := url.PathUnescape(chi.URLParam(r, "user"))
+	if err != nil {
+		writeError(w, r, &Error{
+			Code:    CodeRequestDataInvalid,
+			Message: "user is not escaped correctly",
+		})
+
+		return
+	}
+
+	c.modifyRole(w, r, func(role *eeDStore.Role) *Error {
+		i := slices.Index(role.Users, user)
+		if i < 0 {
+			return &Error{
+				Code:    CodeResourceNotFound,
+				Message: fmt.Sprintf("role '%s' has no user '%s'", role.Name, user),
+			}
+		}
+		role.Users = slices.Delete(role.Users, i, i+1)
+
+		return nil
+	})
+}
+
+func (c *RolesController) addPermission(w http.ResponseWriter, r *http.Request) {
+	req := permissionDocument{}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	c.modifyRole(w, r, func(role *eeDStore.Role) *Error {
+		if permissionIndex(role.Permissions, req.Topic, req.Method) < 0 {
+			role.Permissions = append(role.Permissions, &eeDStore.Permission{Topic: req.Topic, Method: req.Method})
+		}
+
+		return nil
+	})
+}
+
+func (c *RolesController) removePermission(w http.ResponseWriter, r *http.Request) {
+	topic, method := chi.URLParam(r, "topic"), chi.URLParam(r, "method")
+
+	c.modifyRole(w, r, func(role *eeDStore.Role) *Error {
+		i := permissionIndex(role.Permissions, topic, method)
+		if i < 0 {
+			return &Error{
+				Code:    CodeResourceNotFound,
+				Message: fmt.Sprintf("role '%s' has no permission '%s:%s'", role.Name, topic, method),
+			}
+		}
+		role.Permissions = slices.Delete(role.Permissions, i, i+1)
+
+		return nil
+	})
+}
+
+func permissionIndex(permissions eeDStore.Permissions, topic, method string) int {
+	return slices.IndexFunc(permissions, func(p *eeDStore.Permission) bool {
+		return p.Topic == topic && p.Method == method
+	})
+}
+
+// modifyRole applies a single change to the current role, the change is based on the version that
+// was read so it never overwrites a concurrent change.
+func (c *RolesController) modifyRole(w http.ResponseWriter, r *http.Request, modify func(role *eeDStore.Role) *Error) {
+	ns := extractContextNamespace(r)
+	roleName := chi.URLParam(r, "roleName")
+
+	db, err := c.db.BeginTx(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer db.Rollback()
+
+	role, ok := writableRole(w, r, c.eStore.With(db.Conn()), ns.Name, roleName)
+	if !ok {
+		return
+	}
+	if apiErr := modify(role); apiErr != nil {
+		writeError(w, r, apiErr)
+		return
+	}
+
+	c.saveRole(w, r, db, roleName, role)
+}
+
+// saveRole validates and stores a changed role and commits the transaction, the update is
+// conditional on the version of the role.
+func (c *RolesController) saveRole(w http.ResponseWriter, r *http.Request, db *database.DB, roleName string, role *eeDStore.Role) {
+	req := &roleRequest{
+		Name:        role.Name,
+		Description: role.Description,
+		OidcGroups:  role.OidcGroups,
+		Users:       role.Users,
+		Permissions: role.Permissions,
+	}
+	if !checkFields(w, r, req.validate(roleName)) {
+		return
+	}
+	// Roles can't grant more than the caller holds, as the caller could bind them to its own groups.
+	if !checkGrants(w, r, CallerFromContext(r.Context()).disallowedGrants("permissions", role.Namespace, role.Permissions)) {
+		return
+	}
+
+	role.UpdatedBy = CallerFromContext(r.Context()).Name
+	updated, err := c.eStore.With(db.Conn()).Roles().Update(r.Context(), role.Namespace, roleName, role)
+	if err != nil {
+		writeDataStoreError(w, r, err)
+		return
+	}
+
+	err = db.Commit(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(updated.Version))
+	writeJSON(w, convertRole(updated))
+}
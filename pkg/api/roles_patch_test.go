@@ -0,0 +1,187 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	eeDStore "github.com/direktiv/direktiv/direktiv-ee/pkg/datastore"
+)
+
+func Test_RolesController_patch(t *testing.T) {
+	rt := newRolesTest(t)
+	rt.create(&eeDStore.Role{Name: "devs", Description: "developers", OidcGroups: eeDStore.OidcGroups{"g1"},
+		Users: eeDStore.RoleUsers{"alice"}, Permissions: eeDStore.Permissions{{"", "secrets", "read"}}})
+	rt.create(&eeDStore.Role{Name: "managed", ManagedBy: "/.direktiv/roles/managed.yaml"})
+
+	patch := func(name, contentType, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		w := rt.do(http.MethodPatch, "/"+name, contentType, body)
+		if w.Code == http.StatusOK && w.Header().Get("ETag") == "" {
+			t.Errorf("patch() has no ETag header")
+		}
+
+		return w
+	}
+	role := func() *eeDStore.Role {
+		t.Helper()
+		role, err := rt.get("devs")
+		if err != nil {
+			t.Fatalf("unexpected Roles().Get() error = %v", err)
+		}
+
+		return role
+	}
+
+	// Merge patches keep what they leave out and replace lists as a whole.
+	if w := patch("devs", mediaTypeMergePatch, `{"description": "backend developers", "oidcGroups": ["g2"]}`); w.Code != http.StatusOK {
+		t.Fatalf("patch() status = %d: %s", w.Code, w.Body)
+	}
+	if got := role(); got.Description != "backend developers" || !slices.Equal(got.OidcGroups, eeDStore.OidcGroups{"g2"}) ||
+		!slices.Equal(got.Users, eeDStore.RoleUsers{"alice"}) || len(got.Permissions) != 1 {
+		t.Errorf("role = %+v after a merge patch, want description and groups replaced", got)
+	}
+	if w := patch("devs", mediaTypeMergePatch, `{"description": null}`); w.Code != http.StatusOK {
+		t.Fatalf("patch() status = %d: %s", w.Code, w.Body)
+	}
+	if got := role(); got.Description != "" {
+		t.Errorf("role description = %q after null, want it cleared", got.Description)
+	}
+
+	// Json patches change single entries.
+	if w := patch("devs", mediaTypeJSONPatch, `[{"op": "add", "path": "/users/-", "value": "bob"}]`); w.Code != http.StatusOK {
+		t.Fatalf("patch() status = %d: %s", w.Code, w.Body)
+	}
+	if got := role(); !slices.Equal(got.Users, eeDStore.RoleUsers{"alice", "bob"}) {
+		t.Errorf("role users = %v after a json patch, want bob appended", got.Users)
+	}
+
+	tests := []struct {
+		name        string
+		role        string
+		contentType string
+		body        string
+		want        ErrorCode
+	}{
+		{"unknown field", "devs", mediaTypeMergePatch, `{"owner": "alice"}`, CodeRequestDataInvalid},
+		{"failing json patch", "devs", mediaTypeJSONPatch, `[{"op": "remove", "path": "/users/5"}]`, CodeRequestDataInvalid},
+		{"content type", "devs", "application/json", `{"description": "x"}`, CodeRequestContentTypeInvalid},
+		{"unknown role", "nobody", mediaTypeMergePatch, `{"description": "x"}`, CodeResourceNotFound},
+		{"managed role", "managed", mediaTypeMergePatch, `{"description": "x"}`, CodeResourceReadOnly},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := patch(tt.role, tt.contentType, tt.body)
+			if code := decodeAPIError(t, w).Error.Code; code != tt.want {
+				t.Errorf("patch() error code = %s, want %s", code, tt.want)
+			}
+		})
+	}
+
+	// Patches are based on the version the client read.
+	r := httptest.NewRequest(http.MethodPatch, "/namespaces/"+rt.ns+"/roles/devs", strings.NewReader(`{"description": "x"}`))
+	r.Header.Set("Content-Type", mediaTypeMergePatch)
+	r.Header.Set("If-Match", etag(role().Version-1))
+	w := httptest.NewRecorder()
+	rt.handler.ServeHTTP(w, r)
+	if code := decodeAPIError(t, w).Error.Code; code != CodeResourcePreconditionFailed {
+		t.Errorf("patch() error code = %s for a stale version, want %s", code, CodeResourcePreconditionFailed)
+	}
+
+	// Patches can't grant more than the caller holds.
+	rt.caller = &Caller{Name: "oidc:alice", Permissions: eeDStore.Permissions{
+		{rt.ns, "roles", "manage"},
+		{rt.ns, "secrets", "read"},
+	}}
+	w = patch("devs", mediaTypeJSONPatch, `[{"op": "add", "path": "/permissions/-", "value": {"topic": "secrets", "method": "manage"}}]`)
+	if body := decodeAPIError(t, w); body.Error.Code != CodeRequestDataInvalid || body.Error.Validation["permissions[1]"] == "" {
+		t.Errorf("patch() error = %+v, want permissions[1] disallowed", body.Error)
+	}
+	if w := patch("devs", mediaTypeMergePatch, `{"description": "held permissions only"}`); w.Code != http.StatusOK {
+		t.Errorf("patch() status = %d for permissions the caller holds: %s", w.Code, w.Body)
+	}
+}
+
+func Test_RolesController_subResources(t *testing.T) {
+	rt := newRolesTest(t)
+	rt.create(&eeDStore.Role{Name: "devs", Permissions: eeDStore.Permissions{{"", "secrets", "read"}}})
+	rt.create(&eeDStore.Role{Name: "managed", ManagedBy: "/.direktiv/roles/managed.yaml"})
+
+	role := func() *eeDStore.Role {
+		t.Helper()
+		role, err := rt.get("devs")
+		if err != nil {
+			t.Fatalf("unexpected Roles().Get() error = %v", err)
+		}
+
+		return role
+	}
+	status := func(w *httptest.ResponseRecorder) ErrorCode {
+		t.Helper()
+		if w.Code == http.StatusOK {
+			return ""
+		}
+
+		return decodeAPIError(t, w).Error.Code
+	}
+
+	// Adding an entry twice keeps a single one.
+	for range 2 {
+		if code := status(rt.do(http.MethodPost, "/devs/oidc_groups", "application/json", `{"group": "g1"}`)); code != "" {
+			t.Fatalf("addOidcGroup() error code = %s", code)
+		}
+		if code := status(rt.do(http.MethodPost, "/devs/users", "application/json", `{"user": "alice"}`)); code != "" {
+			t.Fatalf("addUser() error code = %s", code)
+		}
+		if code := status(rt.do(http.MethodPost, "/devs/permissions", "application/json", `{"topic": "secrets", "method": "GET"}`)); code != "" {
+			t.Fatalf("addPermission() error code = %s", code)
+		}
+	}
+	got := role()
+	if !slices.Equal(got.OidcGroups, eeDStore.OidcGroups{"g1"}) || !slices.Equal(got.Users, eeDStore.RoleUsers{"alice"}) ||
+		len(got.Permissions) != 2 {
+		t.Fatalf("role = %+v, want one group, one user and two permissions", got)
+	}
+
+	// Removing what the role doesn't have is not found, the second removal included.
+	for i, want := range []ErrorCode{"", CodeResourceNotFound} {
+		if code := status(rt.do(http.MethodDelete, "/devs/oidc_groups/g1", "", "")); code != want {
+			t.Errorf("removeOidcGroup() #%d error code = %s, want %s", i, code, want)
+		}
+		if code := status(rt.do(http.MethodDelete, "/devs/users/alice", "", "")); code != want {
+			t.Errorf("removeUser() #%d error code = %s, want %s", i, code, want)
+		}
+		if code := status(rt.do(http.MethodDelete, "/devs/permissions/secrets/GET", "", "")); code != want {
+			t.Errorf("removePermission() #%d error code = %s, want %s", i, code, want)
+		}
+	}
+	got = role()
+	if len(got.OidcGroups) != 0 || len(got.Users) != 0 || len(got.Permissions) != 1 || got.Permissions[0].Method != "read" {
+		t.Errorf("role = %+v, want only the permission it was created with", got)
+	}
+
+	// Roles declared in the namespace tree are read-only.
+	if code := status(rt.do(http.MethodPost, "/managed/users", "application/json", `{"user": "alice"}`)); code != CodeResourceReadOnly {
+		t.Errorf("addUser() error code = %s for a managed role, want %s", code, CodeResourceReadOnly)
+	}
+	if code := status(rt.do(http.MethodDelete, "/managed/oidc_groups/g1", "", "")); code != CodeResourceReadOnly {
+		t.Errorf("removeOidcGroup() error code = %s for a managed role, want %s", code, CodeResourceReadOnly)
+	}
+
+	// Added permissions are checked against the ones of the caller.
+	rt.caller = &Caller{Name: "oidc:alice", Permissions: eeDStore.Permissions{
+		{rt.ns, "roles", "manage"},
+		{rt.ns, "secrets", "read"},
+	}}
+	if code := status(rt.do(http.MethodPost, "/devs/permissions", "application/json", `{"topic": "variables", "method": "read"}`)); code != CodeRequestDataInvalid {
+		t.Errorf("addPermission() error code = %s for a permission the caller doesn't hold, want %s", code, CodeRequestDataInvalid)
+	}
+	if got := role(); len(got.Permissions) != 1 {
+		t.Errorf("role permissions = %v, want the disallowed one not added", got.Permissions)
+	}
+	if code := status(rt.do(http.MethodPost, "/devs/permissions", "application/json", `{"topic": "secrets", "method": "GET"}`)); code != "" {
+		t.Errorf("addPermission() error code = %s for a permission the caller holds", code)
+	}
+}
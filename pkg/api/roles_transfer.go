@@ -0,0 +1,326 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	eeDStore "github.com/direktiv/direktiv/direktiv-ee/pkg/datastore"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	rolesDocumentVersion = "v1"
+	maxRolesDocumentSize = 4 << 20
+
+	conflictFail      = "fail"
+	conflictSkip      = "skip"
+	conflictOverwrite = "overwrite"
+)
+
+// rolesDocument is the declarative format of role exports and imports.
+type rolesDocument struct {
+	Version string          `json:"version"   yaml:"version"`
+	Roles   []*roleDocument `json:"roles"     yaml:"roles"`
+	// APITokens is informational, tokens are never imported as their secrets can't be exported.
+	APITokens []*apiTokenDocument `json:"apiTokens,omitempty" yaml:"apiTokens,omitempty"`
+}
+
+type roleDocument struct {
+	Name        string               `json:"name"                  yaml:"name"`
+	Description string               `json:"description,omitempty" yaml:"description,omitempty"`
+	OidcGroups  []string             `json:"oidcGroups,omitempty"  yaml:"oidcGroups,omitempty"`
+	Users       []string             `json:"users,omitempty"       yaml:"users,omitempty"`
+	Permissions []permissionDocument `json:"permissions,omitempty" yaml:"permissions,omitempty"`
+}
+
+type permissionDocument struct {
+	Topic  string `json:"topic"  yaml:"topic"`
+	Method string `json:"method" yaml:"method"`
+}
+
+type apiTokenDocument struct {
+	Name           string               `json:"name"                     yaml:"name"`
+	Description    string               `json:"description,omitempty"    yaml:"description,omitempty"`
+	Format         string               `json:"format"                   yaml:"format"`
+	Permissions    []permissionDocument `json:"permissions,omitempty"    yaml:"permissions,omitempty"`
+	Roles          []string             `json:"roles,omitempty"          yaml:"roles,omitempty"`
+	ServiceAccount string               `json:"serviceAccount,omitempty" yaml:"serviceAccount,omitempty"`
+	ExpiredAt      *time.Time           `json:"expiredAt,omitempty"      yaml:"expiredAt,omitempty"`
+}
+
+// rolesImportResult lists the imported roles by what happened to them.
+type rolesImportResult struct {
+	DryRun  bool     `json:"dryRun"`
+	Created []string `json:"created"`
+	Updated []string `json:"updated"`
+	Skipped []string `json:"skipped"`
+}
+
+func toPermissionDocuments(permissions eeDStore.Permissions) []permissionDocument {
+	var res []permissionDocument
+	for _, p := range permissions {
+		res = append(res, permissionDocument{Topic: p.Topic, Method: p.Method})
+	}
+
+	return res
+}
+
+func fromPermissionDocuments(permissions []permissionDocument) eeDStore.Permissions {
+	var res eeDStore.Permissions
+	for _, p := range permissions {
+		res = append(res, &eeDStore.Permission{Topic: p.Topic, Method: p.Method})
+	}
+
+	return res
+}
+
+// export writes all roles of the namespace and the metadata of its api tokens as one document,
+// in yaml unless the format query parameter is json.
+func (c *RolesController) export(w http.ResponseWriter, r *http.Request) {
+	ns := extractContextNamespace(r)
+
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "yaml" && format != "json" {
+		writeError(w, r, &Error{
+			Code:    CodeRequestDataInvalid,
+			Message: "request data has invalid fields",
+			Validation: map[string]string{
+				"format": "format should be one of 'yaml' or 'json'",
+			},
+		})
+
+		return
+	}
+
+	db, err := c.db.BeginTx(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer db.Rollback()
+
+	store := c.eStore.With(db.Conn())
+	roles, _, err := store.Roles().List(r.Context(), ns.Name, eeDStore.RolesFilter{}, eeDStore.ListOptions{SortBy: eeDStore.SortByName})
+	if err != nil {
+		writeDataStoreError(w, r, err)
+		return
+	}
+	tokens, _, err := store.APITokens().List(r.Context(), ns.Name, eeDStore.APITokensFilter{}, eeDStore.ListOptions{SortBy: eeDStore.SortByName})
+	if err != nil {
+		writeDataStoreError(w, r, err)
+		return
+	}
+
+	doc := &rolesDocument{Version: rolesDocumentVersion, Roles: []*roleDocument{}}
+	for _, role := range roles {
+		doc.Roles = append(doc.Roles, &roleDocument{
+			Name:        role.Name,
+			Description: role.Description,
+			OidcGroups:  role.OidcGroups,
+			Users:       role.Users,
+			Permissions: toPermissionDocuments(role.Permissions),
+		})
+	}
+	for _, t := range tokens {
+		doc.APITokens = append(doc.APITokens, &apiTokenDocument{
+			Name:           t.Name,
+			Description:    t.Description,
+			Format:         t.Format,
+			Permissions:    toPermissionDocuments(t.Permissions),
+			Roles:          t.Roles,
+			ServiceAccount: t.ServiceAccount,
+			ExpiredAt:      t.ExpiredAt,
+		})
+	}
+
+	var (
+		data        []byte
+		contentType = "application/yaml"
+	)
+	if format == "json" {
+		contentType = "application/json"
+		data, err = json.MarshalIndent(doc, "", "  ")
+	} else {
+		data, err = yaml.Marshal(doc)
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+}
+
+// importRoles creates the roles of a yaml or json document in a single transaction, existing roles
+// are handled by the conflict query parameter. With dryRun nothing is committed.
+func (c *RolesController) importRoles(w http.ResponseWriter, r *http.Request) {
+	ns := extractContextNamespace(r)
+
+	dryRun := r.URL.Query().Get("dryRun") == "true"
+	conflict := r.URL.Query().Get("conflict")
+	if conflict == "" {
+		conflict = conflictFail
+	}
+	if conflict != conflictFail && conflict != conflictSkip && conflict != conflictOverwrite {
+		writeError(w, r, &Error{
+			Code:    CodeRequestDataInvalid,
+			Message: "request data has invalid fields",
+			Validation: map[string]string{
+				"conflict": "conflict should be one of 'fail', 'skip' or 'overwrite'",
+			},
+		})
+
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRolesDocumentSize))
+	if err != nil {
+		writeReadBodyError(w, r, err)
+		return
+	}
+	// Json documents are valid yaml as well.
+	doc := &rolesDocument{}
+	if err := yaml.Unmarshal(body, doc); err != nil {
+		writeError(w, r, &Error{
+			Code:    CodeRequestDataInvalid,
+			Message: fmt.Sprintf("couldn't parse roles document: %s", err),
+		})
+
+		return
+	}
+	if doc.Version != "" && doc.Version != rolesDocumentVersion {
+		writeError(w, r, &Error{
+			Code:    CodeRequestDataInvalid,
+			Message: "request data has invalid fields",
+			Validation: map[string]string{
+				"version": fmt.Sprintf("unsupported version '%s', want '%s'", doc.Version, rolesDocumentVersion),
+			},
+		})
+
+		return
+	}
+
+	db, err := c.db.BeginTx(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer db.Rollback()
+
+	store := c.eStore.With(db.Conn())
+	caller := CallerFromContext(r.Context())
+	res := rolesImportResult{
+		DryRun:  dryRun,
+		Created: []string{},
+		Updated: []string{},
+		Skipped: []string{},
+	}
+	seen := map[string]bool{}
+
+	for i, rd := range doc.Roles {
+		field := fmt.Sprintf("roles[%d]", i)
+		if seen[rd.Name] {
+			writeError(w, r, &Error{
+				Code:       CodeRequestDataInvalid,
+				Message:    "request data has invalid fields",
+				Validation: map[string]string{field + ".name": fmt.Sprintf("duplicate role '%s'", rd.Name)},
+			})
+
+			return
+		}
+		seen[rd.Name] = true
+
+		role := &eeDStore.Role{
+			Name:        rd.Name,
+			Namespace:   ns.Name,
+			Description: rd.Description,
+			OidcGroups:  rd.OidcGroups,
+			Users:       rd.Users,
+			Permissions: fromPermissionDocuments(rd.Permissions),
+			UpdatedBy:   caller.Name,
+		}
+		if !checkGrants(w, r, caller.disallowedGrants(field+".permissions", ns.Name, role.Permissions)) {
+			return
+		}
+
+		current, err := store.Roles().Get(r.Context(), ns.Name, rd.Name)
+		exists := err == nil
+		if err != nil && !errors.Is(err, eeDStore.ErrNotFound) {
+			writeInternalError(w, r, err)
+			return
+		}
+
+		// Existing roles keep their names, new ones have to follow the name rules.
+		currentName := ""
+		if exists {
+			currentName = current.Name
+		}
+		docErrs := fieldErrors{}
+		docErrs.name("name", rd.Name, currentName)
+		docErrs.length("description", rd.Description, maxDescriptionLength)
+		if !checkFields(w, r, docErrs.prefixed(field)) {
+			return
+		}
+
+		switch {
+		case exists && conflict == conflictFail:
+			writeError(w, r, &Error{
+				Code:    CodeResourceAlreadyExists,
+				Message: fmt.Sprintf("role '%s' already exists", rd.Name),
+			})
+
+			return
+		case exists && conflict == conflictSkip:
+			res.Skipped = append(res.Skipped, rd.Name)
+
+			continue
+		case exists && current.ManagedBy != "":
+			writeError(w, r, &Error{
+				Code:    CodeResourceReadOnly,
+				Message: fmt.Sprintf("role '%s' is managed by '%s' in the namespace tree", rd.Name, current.ManagedBy),
+			})
+
+			return
+		case exists:
+			_, err = store.Roles().Update(r.Context(), ns.Name, rd.Name, role)
+			res.Updated = append(res.Updated, rd.Name)
+		default:
+			_, err = store.Roles().Create(r.Context(), role)
+			res.Created = append(res.Created, rd.Name)
+		}
+		var vErrs eeDStore.InvalidArgumentError
+		if errors.As(err, &vErrs) {
+			validation := map[string]string{}
+			for k, v := range vErrs {
+				validation[field+"."+k] = v
+			}
+			writeError(w, r, &Error{
+				Code:       CodeRequestDataInvalid,
+				Message:    "request data has invalid fields",
+				Validation: validation,
+			})
+
+			return
+		}
+		if err != nil {
+			writeDataStoreError(w, r, err)
+			return
+		}
+	}
+
+	if !dryRun {
+		err = db.Commit(r.Context())
+		if err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+	}
+
+	writeJSON(w, res)
+}
@@ -0,0 +1,221 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	eeDStore "github.com/direktiv/direktiv/direktiv-ee/pkg/datastore"
+	"github.com/direktiv/direktiv/direktiv-ee/pkg/datastore/datasql"
+	"github.com/direktiv/direktiv/pkg/database"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// rolesTest serves the roles routes of a fresh namespace on a test database.
+type rolesTest struct {
+	t       *testing.T
+	db      *database.DB
+	ns      string
+	handler http.Handler
+	// caller makes the requests, it is an admin unless a test changes it.
+	caller *Caller
+}
+
+func newRolesTest(t *testing.T) *rolesTest {
+	t.Helper()
+
+	db, ns, err := database.NewTestDBWithNamespace(t, uuid.NewString())
+	if err != nil {
+		t.Fatalf("unexpected NewTestDBWithNamespace() error = %v", err)
+	}
+	if res := db.Conn().Exec(datasql.Schema); res.Error != nil {
+		t.Fatalf("unexpected exec db_schema error = %v", res.Error)
+	}
+
+	rt := &rolesTest{t: t, db: db, ns: ns.Name, caller: &Caller{Name: "api_key", Admin: true}}
+	router := chi.NewRouter()
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r = r.WithContext(context.WithValue(r.Context(), ctxKeyNamespace, ns))
+			next.ServeHTTP(w, withCaller(r, rt.caller))
+		})
+	})
+	router.Route("/namespaces/{namespace}/roles", NewRolesController(db, datasql.New()).MountRouter)
+	rt.handler = router
+
+	return rt
+}
+
+// do sends a request to path below the roles of the namespace.
+func (rt *rolesTest) do(method, path, contentType, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, "/namespaces/"+rt.ns+"/roles"+path, strings.NewReader(body))
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	w := httptest.NewRecorder()
+	rt.handler.ServeHTTP(w, r)
+
+	return w
+}
+
+func (rt *rolesTest) create(role *eeDStore.Role) {
+	rt.t.Helper()
+	role.Namespace = rt.ns
+	role.UpdatedBy = "test"
+	if _, err := datasql.New().With(rt.db.Conn()).Roles().Create(context.Background(), role); err != nil {
+		rt.t.Fatalf("unexpected Roles().Create() error = %v", err)
+	}
+}
+
+func (rt *rolesTest) get(name string) (*eeDStore.Role, error) {
+	return datasql.New().With(rt.db.Conn()).Roles().Get(context.Background(), rt.ns, name)
+}
+
+// apiError is the body of an error response.
+type apiError struct {
+	Error struct {
+		Code       ErrorCode         `json:"code"`
+		Validation map[string]string `json:"validation"`
+	} `json:"error"`
+}
+
+func decodeAPIError(t *testing.T, w *httptest.ResponseRecorder) apiError {
+	t.Helper()
+	var body apiError
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("response %q is no error: %v", w.Body, err)
+	}
+
+	return body
+}
+
+func Test_RolesController_importRoles(t *testing.T) {
+	rt := newRolesTest(t)
+	rt.create(&eeDStore.Role{Name: "existing", Description: "before",
+		Permissions: eeDStore.Permissions{{"", "secrets", "read"}}})
+	rt.create(&eeDStore.Role{Name: "managed", ManagedBy: "/.direktiv/roles/managed.yaml"})
+
+	importRoles := func(query, doc string) (*httptest.ResponseRecorder, rolesImportResult) {
+		t.Helper()
+		w := rt.do(http.MethodPost, "/-/import"+query, "application/yaml", doc)
+		var body struct {
+			Data rolesImportResult `json:"data"`
+		}
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("importRoles() returned invalid json: %v", err)
+			}
+		}
+
+		return w, body.Data
+	}
+
+	// Dry runs report what would happen without committing it.
+	w, res := importRoles("?dryRun=true", "version: v1\nroles:\n  - name: new\n    permissions:\n      - {topic: secrets, method: read}\n")
+	if w.Code != http.StatusOK || !res.DryRun || !slices.Equal(res.Created, []string{"new"}) {
+		t.Fatalf("importRoles() dry run = %d %+v, want new created", w.Code, res)
+	}
+	if _, err := rt.get("new"); !errors.Is(err, eeDStore.ErrNotFound) {
+		t.Errorf("Roles().Get() error = %v after a dry run, wantErr %v", err, eeDStore.ErrNotFound)
+	}
+
+	// Existing roles fail the import by default.
+	w, _ = importRoles("", "roles:\n  - name: new\n  - name: existing\n    description: after\n")
+	if code := decodeAPIError(t, w).Error.Code; code != CodeResourceAlreadyExists {
+		t.Errorf("importRoles() error code = %s, want %s", code, CodeResourceAlreadyExists)
+	}
+	if _, err := rt.get("new"); !errors.Is(err, eeDStore.ErrNotFound) {
+		t.Errorf("Roles().Get() error = %v after a failed import, wantErr %v", err, eeDStore.ErrNotFound)
+	}
+
+	w, res = importRoles("?conflict=skip", "roles:\n  - name: new\n  - name: existing\n    description: after\n")
+	if w.Code != http.StatusOK || !slices.Equal(res.Created, []string{"new"}) || !slices.Equal(res.Skipped, []string{"existing"}) {
+		t.Fatalf("importRoles() skip = %d %+v, want new created and existing skipped", w.Code, res)
+	}
+	if role, err := rt.get("existing"); err != nil || role.Description != "before" {
+		t.Errorf("Roles().Get() = %v, %v, want the skipped role unchanged", role, err)
+	}
+
+	w, res = importRoles("?conflict=overwrite", "roles:\n  - name: existing\n    description: after\n")
+	if w.Code != http.StatusOK || !slices.Equal(res.Updated, []string{"existing"}) {
+		t.Fatalf("importRoles() overwrite = %d %+v, want existing updated", w.Code, res)
+	}
+	if role, err := rt.get("existing"); err != nil || role.Description != "after" || len(role.Permissions) != 0 {
+		t.Errorf("Roles().Get() = %v, %v, want the role replaced by the document", role, err)
+	}
+
+	// Roles declared in the namespace tree are never overwritten.
+	w, _ = importRoles("?conflict=overwrite", "roles:\n  - name: managed\n")
+	if code := decodeAPIError(t, w).Error.Code; code != CodeResourceReadOnly {
+		t.Errorf("importRoles() error code = %s for a managed role, want %s", code, CodeResourceReadOnly)
+	}
+
+	w, _ = importRoles("?conflict=skip", "roles:\n  - name: twice\n  - name: twice\n")
+	if body := decodeAPIError(t, w); body.Error.Code != CodeRequestDataInvalid || body.Error.Validation["roles[1].name"] == "" {
+		t.Errorf("importRoles() error = %+v for duplicate names, want roles[1].name invalid", body.Error)
+	}
+	if _, err := rt.get("twice"); !errors.Is(err, eeDStore.ErrNotFound) {
+		t.Errorf("Roles().Get() error = %v after a failed import, wantErr %v", err, eeDStore.ErrNotFound)
+	}
+
+	// Imported roles can't grant more than the caller holds.
+	rt.caller = &Caller{Name: "oidc:alice", Permissions: eeDStore.Permissions{
+		{rt.ns, "roles", "manage"},
+		{rt.ns, "secrets", "read"},
+	}}
+	w, _ = importRoles("", "roles:\n  - name: readers\n    permissions:\n      - {topic: secrets, method: read}\n"+
+		"  - name: writers\n    permissions:\n      - {topic: secrets, method: manage}\n")
+	if body := decodeAPIError(t, w); body.Error.Code != CodeRequestDataInvalid || body.Error.Validation["roles[1].permissions[0]"] == "" {
+		t.Errorf("importRoles() error = %+v, want roles[1].permissions[0] disallowed", body.Error)
+	}
+	if _, err := rt.get("readers"); !errors.Is(err, eeDStore.ErrNotFound) {
+		t.Errorf("Roles().Get() error = %v after a failed import, wantErr %v", err, eeDStore.ErrNotFound)
+	}
+}
+
+func Test_RolesController_export(t *testing.T) {
+	rt := newRolesTest(t)
+	rt.create(&eeDStore.Role{Name: "writers", OidcGroups: eeDStore.OidcGroups{"devs"},
+		Permissions: eeDStore.Permissions{{"", "secrets", "manage"}}})
+	rt.create(&eeDStore.Role{Name: "readers", Users: eeDStore.RoleUsers{"alice"},
+		Permissions: eeDStore.Permissions{{"", "secrets", "read"}}})
+
+	w := rt.do(http.MethodGet, "/-/export?format=json", "", "")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("export() = %d %s, want json", w.Code, w.Header().Get("Content-Type"))
+	}
+	doc := &rolesDocument{}
+	if err := json.Unmarshal(w.Body.Bytes(), doc); err != nil {
+		t.Fatalf("export() returned invalid json: %v", err)
+	}
+	if doc.Version != rolesDocumentVersion || len(doc.Roles) != 2 {
+		t.Fatalf("export() = %+v, want both roles", doc)
+	}
+	if doc.Roles[0].Name != "readers" || !slices.Equal(doc.Roles[0].Users, []string{"alice"}) {
+		t.Errorf("export() roles[0] = %+v, want readers", doc.Roles[0])
+	}
+	if doc.Roles[1].Name != "writers" || !slices.Equal(doc.Roles[1].OidcGroups, []string{"devs"}) ||
+		!slices.Equal(doc.Roles[1].Permissions, []permissionDocument{{Topic: "secrets", Method: "manage"}}) {
+		t.Errorf("export() roles[1] = %+v, want writers", doc.Roles[1])
+	}
+
+	// Exports are yaml by default and import as they are.
+	w = rt.do(http.MethodGet, "/-/export", "", "")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/yaml" {
+		t.Fatalf("export() = %d %s, want yaml", w.Code, w.Header().Get("Content-Type"))
+	}
+	if w := rt.do(http.MethodPost, "/-/import?conflict=overwrite&dryRun=true", "application/yaml", w.Body.String()); w.Code != http.StatusOK {
+		t.Errorf("importRoles() status = %d for an export: %s", w.Code, w.Body)
+	}
+
+	w = rt.do(http.MethodGet, "/-/export?format=xml", "", "")
+	if body := decodeAPIError(t, w); body.Error.Code != CodeRequestDataInvalid || body.Error.Validation["format"] == "" {
+		t.Errorf("export() error = %+v, want format invalid", body.Error)
+	}
+}
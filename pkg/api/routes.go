@@ -0,0 +1,32 @@
+package api
+
+import "github.com/go-chi/chi/v5"
+
+// Controllers are the handlers of the enterprise endpoints.
+type Controllers struct {
+	APITokens           *APITokensController
+	APITokenPolicies    *APITokenPoliciesController
+	Roles               *RolesController
+	RoleAssignments     *RoleAssignmentsController
+	RBACSync            *RBACSyncController
+	ServiceAccounts     *ServiceAccountsController
+	Quotas              *QuotasController
+	CertificateBindings *CertificateBindingsController
+}
+
+// Routes returns the enterprise endpoints keyed by their path below /api/v2, they are registered as
+// extensions.AdditionalAPIRoutes. Every route has to be described in openapi.json.
+func (c *Controllers) Routes() map[string]func(r chi.Router) {
+	return map[string]func(r chi.Router){
+		"/namespaces/{namespace}/api_tokens":           c.APITokens.MountRouter,
+		"/namespaces/{namespace}/api_token_policy":     c.APITokenPolicies.MountRouter,
+		"/namespaces/{namespace}/roles":                c.Roles.MountRouter,
+		"/namespaces/{namespace}/role_assignments":     c.RoleAssignments.MountRouter,
+		"/namespaces/{namespace}/rbac_sync":            c.RBACSync.MountRouter,
+		"/namespaces/{namespace}/service_accounts":     c.ServiceAccounts.MountRouter,
+		"/namespaces/{namespace}/quota":                c.Quotas.MountRouter,
+		"/namespaces/{namespace}/certificate_bindings": c.CertificateBindings.MountRouter,
+		jwksPath:    c.APITokens.MountJWKSRouter,
+		openAPIPath: mountOpenAPIRouter,
+	}
+}
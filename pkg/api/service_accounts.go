@@ -0,0 +1,271 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	eeDStore "github.com/direktiv/direktiv/direktiv-ee/pkg/datastore"
+	"github.com/direktiv/direktiv/pkg/database"
+	"github.com/go-chi/chi/v5"
+)
+
+type ServiceAccountsController struct {
+	db     *database.DB
+	eStore eeDStore.Store
+}
+
+func NewServiceAccountsController(db *database.DB, eStore eeDStore.Store) *ServiceAccountsController {
+	return &ServiceAccountsController{
+		db:     db,
+		eStore: eStore,
+	}
+}
+
+type serviceAccountRequest struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Roles       eeDStore.RoleRefs `json:"roles"`
+}
+
+// validate checks every field of the request, current is the name of the service account that is
+// updated.
+func (req *serviceAccountRequest) validate(current string) fieldErrors {
+	vErrs := fieldErrors{}
+	vErrs.name("name", req.Name, current)
+	vErrs.length("description", req.Description, maxDescriptionLength)
+	vErrs.check("roles", req.Roles.Validate())
+
+	return vErrs
+}
+
+func (c *ServiceAccountsController) MountRouter(r chi.Router) {
+	r.Get("/{serviceAccountName}", c.get)
+	r.Delete("/{serviceAccountName}", c.delete)
+	r.Put("/{serviceAccountName}", c.update)
+	r.Get("/{serviceAccountName}/api_tokens", c.listAPITokens)
+
+	r.Get("/", c.list)
+	r.Post("/", c.create)
+}
+
+func (c *ServiceAccountsController) get(w http.ResponseWriter, r *http.Request) {
+	ns := extractContextNamespace(r)
+	name := chi.URLParam(r, "serviceAccountName")
+
+	db, err := c.db.BeginTx(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer db.Rollback()
+
+	// Fetch one
+	serviceAccount, err := c.eStore.With(db.Conn()).ServiceAccounts().Get(r.Context(), ns.Name, name)
+	if err != nil {
+		writeDataStoreError(w, r, err)
+		return
+	}
+
+	writeJSON(w, convertServiceAccount(serviceAccount))
+}
+
+func (c *ServiceAccountsController) delete(w http.ResponseWriter, r *http.Request) {
+	ns := extractContextNamespace(r)
+	name := chi.URLParam(r, "serviceAccountName")
+
+	db, err := c.db.BeginTx(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer db.Rollback()
+
+	err = c.eStore.With(db.Conn()).ServiceAccounts().Delete(r.Context(), ns.Name, name)
+	if err != nil {
+		writeDataStoreError(w, r, err)
+		return
+	}
+
+	err = db.Commit(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeOk(w)
+}
+
+func (c *ServiceAccountsController) create(w http.ResponseWriter, r *http.Request) {
+	ns := extractContextNamespace(r)
+
+	db, err := c.db.BeginTx(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer db.Rollback()
+
+	// Parse request.
+	req := serviceAccountRequest{}
+	if !decodeJSON(w, r, &req) || !checkFields(w, r, req.validate("")) {
+		return
+	}
+
+	// Tokens of the account act with its roles, so only roles the caller holds can be assigned.
+	store := c.eStore.With(db.Conn())
+	vErrs, err := tokenDisallowedGrants(r.Context(), CallerFromContext(r.Context()), store, ns.Name, nil, req.Roles, "")
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if !checkGrants(w, r, vErrs) {
+		return
+	}
+
+	// Create service account.
+	serviceAccount, err := store.ServiceAccounts().Create(r.Context(), &eeDStore.ServiceAccount{
+		Name:        req.Name,
+		Namespace:   ns.Name,
+		Description: req.Description,
+		Roles:       req.Roles,
+	})
+	if err != nil {
+		writeDataStoreError(w, r, err)
+
+		return
+	}
+
+	err = db.Commit(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeJSON(w, convertServiceAccount(serviceAccount))
+}
+
+func (c *ServiceAccountsController) update(w http.ResponseWriter, r *http.Request) {
+	ns := extractContextNamespace(r)
+	name := chi.URLParam(r, "serviceAccountName")
+
+	db, err := c.db.BeginTx(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer db.Rollback()
+
+	// Parse request.
+	req := serviceAccountRequest{}
+	if !decodeJSON(w, r, &req) || !checkFields(w, r, req.validate(name)) {
+		return
+	}
+
+	store := c.eStore.With(db.Conn())
+	vErrs, err := tokenDisallowedGrants(r.Context(), CallerFromContext(r.Context()), store, ns.Name, nil, req.Roles, "")
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if !checkGrants(w, r, vErrs) {
+		return
+	}
+
+	// Update service account.
+	serviceAccount, err := store.ServiceAccounts().Update(r.Context(), ns.Name, name, &eeDStore.ServiceAccount{
+		Name:        req.Name,
+		Description: req.Description,
+		Roles:       req.Roles,
+	})
+	if err != nil {
+		writeDataStoreError(w, r, err)
+
+		return
+	}
+
+	err = db.Commit(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeJSON(w, convertServiceAccount(serviceAccount))
+}
+
+func (c *ServiceAccountsController) list(w http.ResponseWriter, r *http.Request) {
+	ns := extractContextNamespace(r)
+
+	db, err := c.db.BeginTx(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer db.Rollback()
+
+	list, err := c.eStore.With(db.Conn()).ServiceAccounts().List(r.Context(), ns.Name)
+	if err != nil {
+		writeDataStoreError(w, r, err)
+		return
+	}
+
+	res := make([]any, len(list))
+	for i := range list {
+		res[i] = convertServiceAccount(list[i])
+	}
+
+	writeJSON(w, res)
+}
+
+func (c *ServiceAccountsController) listAPITokens(w http.ResponseWriter, r *http.Request) {
+	ns := extractContextNamespace(r)
+	name := chi.URLParam(r, "serviceAccountName")
+
+	db, err := c.db.BeginTx(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer db.Rollback()
+
+	// Make sure the service account exists so that unknown accounts result in not found.
+	_, err = c.eStore.With(db.Conn()).ServiceAccounts().Get(r.Context(), ns.Name, name)
+	if err != nil {
+		writeDataStoreError(w, r, err)
+		return
+	}
+
+	list, err := c.eStore.With(db.Conn()).APITokens().ListByServiceAccount(r.Context(), ns.Name, name)
+	if err != nil {
+		writeDataStoreError(w, r, err)
+		return
+	}
+
+	res := make([]any, len(list))
+	for i := range list {
+		res[i] = convertAPIToken(list[i])
+	}
+
+	writeJSON(w, res)
+}
+
+func convertServiceAccount(v *eeDStore.ServiceAccount) any {
+	type serviceAccountForAPI struct {
+		Name        string   `json:"name"`
+		Description string   `json:"description"`
+		Roles       []string `json:"roles"`
+
+		CreatedAt time.Time `json:"createdAt"`
+		UpdatedAt time.Time `json:"updatedAt"`
+	}
+
+	res := &serviceAccountForAPI{
+		Name:        v.Name,
+		Description: v.Description,
+		Roles:       v.Roles,
+
+		CreatedAt: v.CreatedAt,
+		UpdatedAt: v.UpdatedAt,
+	}
+
+	return res
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// snapshot is an in memory copy of data in the datastore that is fetched at most once per refresh
+// interval. The fetch runs outside the read lock: only one caller fetches at a time and the others
+// keep reading the previous copy meanwhile, just the very first fetch is waited for.
+type snapshot[T any] struct {
+	refresh time.Duration
+	fetch   func(ctx context.Context) (T, error)
+
+	// fetching is held by the caller that fetches.
+	fetching  sync.Mutex
+	mu        sync.RWMutex
+	value     T
+	fetchedAt time.Time
+}
+
+func newSnapshot[T any](refresh time.Duration, fetch func(ctx context.Context) (T, error)) *snapshot[T] {
+	return &snapshot[T]{
+		refresh: refresh,
+		fetch:   fetch,
+	}
+}
+
+// get returns the current copy, fetching a new one when it is older than the refresh interval.
+func (s *snapshot[T]) get(ctx context.Context) (T, error) {
+	value, fetchedAt := s.peek()
+	if !fetchedAt.IsZero() && time.Since(fetchedAt) <= s.refresh {
+		return value, nil
+	}
+	if fetchedAt.IsZero() {
+		s.fetching.Lock()
+	} else if !s.fetching.TryLock() {
+		return value, nil
+	}
+	defer s.fetching.Unlock()
+
+	// Someone else may have fetched while we waited.
+	value, fetchedAt = s.peek()
+	if !fetchedAt.IsZero() && time.Since(fetchedAt) <= s.refresh {
+		return value, nil
+	}
+	value, err := s.fetch(ctx)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	s.mu.Lock()
+	s.value, s.fetchedAt = value, time.Now()
+	s.mu.Unlock()
+
+	return value, nil
+}
+
+// peek returns the current copy without fetching, fetchedAt is zero before the first fetch.
+func (s *snapshot[T]) peek() (T, time.Time) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.value, s.fetchedAt
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func Test_snapshot(t *testing.T) {
+	ctx := context.Background()
+	fetches := 0
+	release := make(chan struct{})
+	s := newSnapshot(time.Hour, func(ctx context.Context) (int, error) {
+		fetches++
+		if fetches > 1 {
+			<-release
+		}
+
+		return fetches, nil
+	})
+
+	if got, err := s.get(ctx); err != nil || got != 1 {
+		t.Fatalf("get() = %d, %v, want 1", got, err)
+	}
+	if got, _ := s.get(ctx); got != 1 || fetches != 1 {
+		t.Errorf("get() = %d after %d fetches, want the cached 1", got, fetches)
+	}
+
+	// A slow refresh doesn't block readers, they get the previous copy.
+	s.mu.Lock()
+	s.fetchedAt = time.Now().Add(-time.Hour * 2)
+	s.mu.Unlock()
+	done := make(chan int)
+	go func() {
+		got, _ := s.get(ctx)
+		done <- got
+	}()
+	for {
+		if !s.fetching.TryLock() {
+			break
+		}
+		s.fetching.Unlock()
+		time.Sleep(time.Millisecond)
+	}
+	if got, err := s.get(ctx); err != nil || got != 1 {
+		t.Errorf("get() = %d, %v during a refresh, want the previous 1", got, err)
+	}
+	close(release)
+	if got := <-done; got != 2 {
+		t.Errorf("get() = %d after the refresh, want 2", got)
+	}
+	if got, _ := s.get(ctx); got != 2 {
+		t.Errorf("get() = %d, want the refreshed 2", got)
+	}
+}
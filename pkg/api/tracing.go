@@ -0,0 +1,48 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/direktiv/direktiv/direktiv-ee/pkg/api")
+
+// Span attributes of the middleware steps.
+const (
+	attrNamespace      = "direktiv.namespace"
+	attrTopic          = "direktiv.topic"
+	attrDecision       = "direktiv.authz.decision"
+	attrAuthResult     = "direktiv.auth.result"
+	attrAPITokenFormat = "direktiv.api_token.format"
+	attrQuotaKind      = "direktiv.quota.kind"
+)
+
+// startStep starts the span of a middleware step. The span ends when the step calls the returned
+// handler, which passes the request on to next, or when the step ends it after writing an error.
+// Handlers down the chain don't become children of the step.
+func startStep(r *http.Request, name string, next http.Handler) (*http.Request, trace.Span, http.Handler) {
+	ctx := r.Context()
+	// The first step continues the trace of the client, unless a server span was started already.
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		ctx = otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(r.Header))
+	}
+	parent := trace.SpanFromContext(ctx)
+
+	ctx, span := tracer.Start(ctx, "auth."+name)
+	stepNext := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		span.End()
+		next.ServeHTTP(w, r.WithContext(trace.ContextWithSpan(r.Context(), parent)))
+	})
+
+	return r.WithContext(ctx), span, stepNext
+}
+
+// setSpanResult records the result of an authentication step on the span of ctx.
+func setSpanResult(ctx context.Context, attrs ...attribute.KeyValue) {
+	trace.SpanFromContext(ctx).SetAttributes(attrs...)
+}
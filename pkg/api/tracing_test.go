@@ -0,0 +1,112 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	spanRecorderOnce sync.Once
+	spanRecorder     *tracetest.SpanRecorder
+)
+
+// recordSpans installs an in-memory span recorder as the global tracer provider. The package tracer
+// only switches to the first provider that is installed, so all tests share one recorder and tell
+// their spans apart by trace id.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	spanRecorderOnce.Do(func() {
+		spanRecorder = tracetest.NewSpanRecorder()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spanRecorder)))
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+	})
+
+	return spanRecorder
+}
+
+func spanAttributes(span sdktrace.ReadOnlySpan) map[attribute.Key]string {
+	attrs := map[attribute.Key]string{}
+	for _, kv := range span.Attributes() {
+		attrs[kv.Key] = kv.Value.Emit()
+	}
+
+	return attrs
+}
+
+func Test_startStep(t *testing.T) {
+	recorder := recordSpans(t)
+	const (
+		traceID  = "4bf92f3577b34da6a3ce929d0e0e4736"
+		parentID = "00f067aa0ba902b7"
+	)
+
+	var (
+		got        *Caller
+		nextParent trace.SpanContext
+	)
+	handler := http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		got = CallerFromContext(r.Context())
+		nextParent = trace.SpanContextFromContext(r.Context())
+	})
+	checkAPIKey := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r, span, step := startStep(r, "CheckAPIKey", handler)
+		defer span.End()
+		(&Middlewares{}).recordAuthzDecision(r.Context(), "ns", "roles", outcomeAllowed)
+		// Values the step adds to the request are passed on, only the span is reset.
+		step.ServeHTTP(w, withCaller(r, &Caller{Name: "api_key", Admin: true}))
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/api/v2/namespaces/ns/roles", nil)
+	r.Header.Set("Traceparent", "00-"+traceID+"-"+parentID+"-01")
+	r, span, step := startStep(r, "CheckAPIToken", checkAPIKey)
+	setSpanResult(r.Context(), attribute.String(attrAuthResult, resultOK))
+	step.ServeHTTP(httptest.NewRecorder(), r)
+	span.End()
+
+	if got == nil || got.Name != "api_key" {
+		t.Errorf("next got caller %+v, want api_key", got)
+	}
+	if nextParent.TraceID().String() != traceID || nextParent.SpanID().String() != parentID {
+		t.Errorf("next got span %v, want the span of the client", nextParent.SpanID())
+	}
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, s := range recorder.Ended() {
+		if s.SpanContext().TraceID().String() == traceID {
+			spans[s.Name()] = s
+		}
+	}
+	if len(spans) != 2 {
+		t.Fatalf("recorded %d spans of the trace, want 2", len(spans))
+	}
+	// Steps are siblings under the span of the client, not nested in each other.
+	for _, name := range []string{"auth.CheckAPIToken", "auth.CheckAPIKey"} {
+		s, ok := spans[name]
+		if !ok {
+			t.Fatalf("no span %s recorded, got %v", name, spans)
+		}
+		if s.Parent().SpanID().String() != parentID || !s.Parent().IsRemote() {
+			t.Errorf("span %s has parent %v, want %s of the client", name, s.Parent().SpanID(), parentID)
+		}
+	}
+
+	if attrs := spanAttributes(spans["auth.CheckAPIToken"]); attrs[attrAuthResult] != resultOK {
+		t.Errorf("auth.CheckAPIToken attributes = %v, want result %s", attrs, resultOK)
+	}
+	attrs := spanAttributes(spans["auth.CheckAPIKey"])
+	want := map[attribute.Key]string{attrNamespace: "ns", attrTopic: "roles", attrDecision: outcomeAllowed}
+	for k, v := range want {
+		if attrs[k] != v {
+			t.Errorf("auth.CheckAPIKey attribute %s = %q, want %q", k, attrs[k], v)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	eeDStore "github.com/direktiv/direktiv/direktiv-ee/pkg/datastore"
+)
+
+// usageRecorder aggregates api token usage in memory and writes it in batches, so that
+// authenticating a request doesn't cost a database write.
+type usageRecorder struct {
+	mu      sync.Mutex
+	pending map[[2]string]*eeDStore.APITokenUsage
+	write   func(ctx context.Context, usage []*eeDStore.APITokenUsage) error
+}
+
+func newUsageRecorder(write func(ctx context.Context, usage []*eeDStore.APITokenUsage) error) *usageRecorder {
+	return &usageRecorder{
+		pending: map[[2]string]*eeDStore.APITokenUsage{},
+		write:   write,
+	}
+}
+
+func (u *usageRecorder) record(namespace, name, ip, userAgent string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	key := [2]string{namespace, name}
+	usage, ok := u.pending[key]
+	if !ok {
+		usage = &eeDStore.APITokenUsage{
+			Namespace: namespace,
+			Name:      name,
+		}
+		u.pending[key] = usage
+	}
+	usage.LastUsedAt = time.Now()
+	usage.LastUsedIP = ip
+	usage.LastUsedUserAgent = userAgent
+	usage.Requests++
+}
+
+// flush writes the pending usage, usage that fails to be written is dropped.
+func (u *usageRecorder) flush(ctx context.Context) {
+	u.mu.Lock()
+	if len(u.pending) == 0 {
+		u.mu.Unlock()
+		return
+	}
+	list := make([]*eeDStore.APITokenUsage, 0, len(u.pending))
+	for _, usage := range u.pending {
+		list = append(list, usage)
+	}
+	u.pending = map[[2]string]*eeDStore.APITokenUsage{}
+	u.mu.Unlock()
+
+	if err := u.write(ctx, list); err != nil {
+		slog.Error("writing api tokens usage", "err", err, "count", len(list))
+	}
+}
+
+func (u *usageRecorder) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			u.flush(context.WithoutCancel(ctx))
+			return
+		case <-ticker.C:
+			u.flush(ctx)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package api
+
+import (
+	"context"
+	"testing"
+
+	eeDStore "github.com/direktiv/direktiv/direktiv-ee/pkg/datastore"
+)
+
+func Test_usageRecorder(t *testing.T) {
+	var written []*eeDStore.APITokenUsage
+	u := newUsageRecorder(func(ctx context.Context, usage []*eeDStore.APITokenUsage) error {
+		written = append(written, usage...)
+
+		return nil
+	})
+
+	u.record("ns1", "t1", "10.0.0.1", "curl")
+	u.record("ns1", "t1", "10.0.0.2", "wget")
+	u.record("ns2", "t1", "10.0.0.3", "curl")
+	u.flush(context.Background())
+
+	if len(written) != 2 {
+		t.Fatalf("flush() wrote %v entries, want %v", len(written), 2)
+	}
+	for _, usage := range written {
+		if usage.Namespace == "ns1" {
+			if usage.Requests != 2 {
+				t.Errorf("flush() wrote %v requests, want %v", usage.Requests, 2)
+			}
+			if usage.LastUsedIP != "10.0.0.2" || usage.LastUsedUserAgent != "wget" {
+				t.Errorf("flush() wrote %v, want last ip and user agent", usage)
+			}
+		}
+	}
+
+	// Nothing is pending after a flush.
+	written = nil
+	u.flush(context.Background())
+	if len(written) != 0 {
+		t.Errorf("flush() wrote %v entries, want %v", len(written), 0)
+	}
+}
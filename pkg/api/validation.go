@@ -0,0 +1,166 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+const (
+	// maxRequestBodySize limits json request bodies, role documents have their own limit.
+	maxRequestBodySize = 1 << 20
+
+	maxNameLength          = 64
+	maxDescriptionLength   = 1024
+	maxJustificationLength = 1024
+)
+
+// namePattern is the format of role, token and service account names, it is the same as for
+// namespace names.
+var namePattern = regexp.MustCompile(`^(([a-z][a-z0-9_\-\.]*[a-z0-9])|([a-z]))$`)
+
+// decodeJSON decodes the body of r into v, bodies with unknown fields, trailing data or more than
+// maxRequestBodySize bytes are rejected. It writes the error and returns false when decoding fails.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodySize))
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(v)
+	if err == nil {
+		// The body has to end after the value, trailing whitespace is fine.
+		switch extra := dec.Decode(&json.RawMessage{}); {
+		case extra == nil:
+			err = errors.New("unexpected data after json value")
+		case !errors.Is(extra, io.EOF):
+			err = extra
+		}
+	}
+	if err == nil {
+		return true
+	}
+
+	var (
+		sizeErr *http.MaxBytesError
+		typeErr *json.UnmarshalTypeError
+	)
+	switch {
+	case errors.As(err, &sizeErr):
+		writeReadBodyError(w, r, err)
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// encoding/json has no error type for unknown fields.
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		writeError(w, r, &Error{
+			Code:       CodeRequestDataInvalid,
+			Message:    "request data has invalid fields",
+			Validation: map[string]string{field: "unknown field"},
+		})
+	case errors.As(err, &typeErr):
+		writeError(w, r, &Error{
+			Code:       CodeRequestBodyBadJSONSchema,
+			Message:    "request payload has bad json schema",
+			Validation: map[string]string{typeErr.Field: fmt.Sprintf("should be %s", jsonTypeName(typeErr))},
+		})
+	default:
+		writeError(w, r, &Error{
+			Code:    CodeRequestBodyNotJSON,
+			Message: "couldn't parse request payload in json format",
+		})
+	}
+
+	return false
+}
+
+// writeReadBodyError writes the error of reading a request body that is limited by
+// http.MaxBytesReader.
+func writeReadBodyError(w http.ResponseWriter, r *http.Request, err error) {
+	var sizeErr *http.MaxBytesError
+	if errors.As(err, &sizeErr) {
+		writeError(w, r, &Error{
+			Code:    CodeRequestBodyTooLarge,
+			Message: fmt.Sprintf("request payload is larger than %d bytes", sizeErr.Limit),
+		})
+
+		return
+	}
+	writeError(w, r, &Error{
+		Code:    CodeRequestDataInvalid,
+		Message: fmt.Sprintf("couldn't read request payload: %s", err),
+	})
+}
+
+func jsonTypeName(err *json.UnmarshalTypeError) string {
+	switch err.Type.Kind() {
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Struct, reflect.Map, reflect.Pointer:
+		return "an object"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.String:
+		return "a string"
+	default:
+		return "a number"
+	}
+}
+
+// fieldErrors collects what is wrong with the fields of a request, it is keyed by the json field
+// name so that every invalid field is reported at once.
+type fieldErrors map[string]string
+
+// name checks a name field, current is the name the resource already has. Names from before the
+// format was enforced can be kept, only new names have to match namePattern.
+func (e fieldErrors) name(field, name, current string) {
+	switch {
+	case name == "":
+		e[field] = "is required"
+	case name == current:
+	case len(name) > maxNameLength:
+		e[field] = fmt.Sprintf("should be at most %d characters", maxNameLength)
+	case !namePattern.MatchString(name):
+		e[field] = "should contain lowercase letters, digits, '_', '-' and '.', start with a letter and end with a letter or digit"
+	}
+}
+
+// length checks that a free text field is at most max characters.
+func (e fieldErrors) length(field, value string, max int) {
+	if len([]rune(value)) > max {
+		e[field] = fmt.Sprintf("should be at most %d characters", max)
+	}
+}
+
+// check records err, if any, for field.
+func (e fieldErrors) check(field string, err error) {
+	if err != nil {
+		e[field] = err.Error()
+	}
+}
+
+// prefixed returns the errors with their fields nested below prefix, as for the entries of a list.
+func (e fieldErrors) prefixed(prefix string) fieldErrors {
+	res := fieldErrors{}
+	for k, v := range e {
+		res[prefix+"."+k] = v
+	}
+
+	return res
+}
+
+// checkFields writes a request_data_invalid error listing every invalid field, it returns false
+// when there are any.
+func checkFields(w http.ResponseWriter, r *http.Request, vErrs fieldErrors) bool {
+	if len(vErrs) == 0 {
+		return true
+	}
+	writeError(w, r, &Error{
+		Code:       CodeRequestDataInvalid,
+		Message:    "request data has invalid fields",
+		Validation: vErrs,
+	})
+
+	return false
+}
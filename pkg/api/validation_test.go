@@ -0,0 +1,113 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	eeDStore "github.com/direktiv/direktiv/direktiv-ee/pkg/datastore"
+)
+
+func Test_decodeJSON(t *testing.T) {
+	tests := []struct {
+		name           string
+		body           string
+		wantCode       ErrorCode
+		wantValidation string
+	}{
+		{"valid", `{"name": "ci", "roles": ["readers"]}` + "\n", "", ""},
+		{"unknown field", `{"name": "ci", "role": "readers"}`, CodeRequestDataInvalid, "role"},
+		{"wrong type", `{"name": 1}`, CodeRequestBodyBadJSONSchema, "name"},
+		{"not json", `{"name": `, CodeRequestBodyNotJSON, ""},
+		{"trailing value", `{"name": "ci"} {}`, CodeRequestBodyNotJSON, ""},
+		{"trailing garbage", `{"name": "ci"}}`, CodeRequestBodyNotJSON, ""},
+		{"too large", `{"name": "` + strings.Repeat("a", maxRequestBodySize) + `"}`, CodeRequestBodyTooLarge, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "/", strings.NewReader(tt.body))
+			req := serviceAccountRequest{}
+			ok := decodeJSON(w, r, &req)
+			if ok != (tt.wantCode == "") {
+				t.Fatalf("decodeJSON() = %v, want %v", ok, tt.wantCode == "")
+			}
+			if ok {
+				if req.Name != "ci" || len(req.Roles) != 1 {
+					t.Errorf("decodeJSON() decoded %+v", req)
+				}
+
+				return
+			}
+
+			var res struct {
+				Error *Error `json:"error"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+				t.Fatalf("decoding response error = %v", err)
+			}
+			if res.Error.Code != tt.wantCode {
+				t.Errorf("decodeJSON() code = %v, want %v", res.Error.Code, tt.wantCode)
+			}
+			if tt.wantValidation != "" && res.Error.Validation[tt.wantValidation] == "" {
+				t.Errorf("decodeJSON() validation = %v, want %s", res.Error.Validation, tt.wantValidation)
+			}
+			if tt.wantCode == CodeRequestBodyTooLarge && w.Code != http.StatusRequestEntityTooLarge {
+				t.Errorf("decodeJSON() status = %v", w.Code)
+			}
+		})
+	}
+}
+
+func Test_fieldErrors_name(t *testing.T) {
+	tests := []struct {
+		name    string
+		current string
+		valid   bool
+	}{
+		{"a", "", true},
+		{"ci-deploy.v2_x", "", true},
+		{"", "", false},
+		{"Readers", "", false},
+		{"1readers", "", false},
+		{"readers-", "", false},
+		{"read ers", "", false},
+		{strings.Repeat("a", maxNameLength), "", true},
+		{strings.Repeat("a", maxNameLength+1), "", false},
+		// Names from before the rules were enforced are kept on updates, renames are checked.
+		{"Readers", "Readers", true},
+		{"Writers", "Readers", false},
+	}
+	for _, tt := range tests {
+		vErrs := fieldErrors{}
+		vErrs.name("name", tt.name, tt.current)
+		if got := vErrs["name"] == ""; got != tt.valid {
+			t.Errorf("name(%q, current %q) valid = %v, want %v: %v", tt.name, tt.current, got, tt.valid, vErrs)
+		}
+	}
+}
+
+func Test_roleRequest_validate(t *testing.T) {
+	req := &roleRequest{
+		Name:        "Readers",
+		Description: strings.Repeat("d", maxDescriptionLength+1),
+		OidcGroups:  eeDStore.OidcGroups{""},
+		Users:       eeDStore.RoleUsers{""},
+		Permissions: eeDStore.Permissions{{Topic: "unknown", Method: "read"}},
+	}
+
+	// Every invalid field is reported under its own key.
+	vErrs := req.validate("")
+	for _, field := range []string{"name", "description", "oidcGroups", "users", "permissions"} {
+		if vErrs[field] == "" {
+			t.Errorf("validate() = %v, want an error for %s", vErrs, field)
+		}
+	}
+
+	req = &roleRequest{Name: "readers", OidcGroups: eeDStore.OidcGroups{"g1"}}
+	if vErrs := req.validate(""); len(vErrs) != 0 {
+		t.Errorf("validate() = %v, want none", vErrs)
+	}
+}
@@ -0,0 +1,167 @@
+// Package apitoken provides the credential formats that API tokens can be issued in
+// besides the opaque uuid format.
+package apitoken
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+)
+
+// Issuer is the value of the iss claim of every token signed by a Signer.
+const Issuer = "direktiv"
+
+// Permission is the compact form of a permission inside the token claims.
+type Permission struct {
+	Topic  string `json:"topic"`
+	Method string `json:"method"`
+}
+
+// Claims are the claims of a signed API token. The registered jti claim is
+// the uuid that identifies the token in the revocation list.
+type Claims struct {
+	jwt.Claims
+
+	Namespace      string       `json:"ns"`
+	Permissions    []Permission `json:"perms,omitempty"`
+	Roles          []string     `json:"roles,omitempty"`
+	ServiceAccount string       `json:"sa,omitempty"`
+}
+
+// Signer issues and verifies signed API tokens with a single private key.
+type Signer struct {
+	signer    jose.Signer
+	publicKey jose.JSONWebKey
+	algorithm jose.SignatureAlgorithm
+}
+
+// NewSigner creates a signer from a PEM encoded RSA, ECDSA or Ed25519 private key.
+func NewSigner(pemKey []byte) (*Signer, error) {
+	block, _ := pem.Decode(pemKey)
+	if block == nil {
+		return nil, errors.New("failed to decode private key pem")
+	}
+
+	privateKey, err := parsePrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		algorithm jose.SignatureAlgorithm
+		publicKey crypto.PublicKey
+	)
+	switch k := privateKey.(type) {
+	case *rsa.PrivateKey:
+		algorithm, publicKey = jose.RS256, k.Public()
+	case *ecdsa.PrivateKey:
+		switch k.Curve {
+		case elliptic.P256():
+			algorithm = jose.ES256
+		case elliptic.P384():
+			algorithm = jose.ES384
+		case elliptic.P521():
+			algorithm = jose.ES512
+		default:
+			return nil, fmt.Errorf("unsupported ecdsa curve: %s", k.Curve.Params().Name)
+		}
+		publicKey = k.Public()
+	case ed25519.PrivateKey:
+		algorithm, publicKey = jose.EdDSA, k.Public()
+	default:
+		return nil, fmt.Errorf("unsupported private key type: %T", privateKey)
+	}
+
+	jwk := jose.JSONWebKey{
+		Key:       publicKey,
+		Algorithm: string(algorithm),
+		Use:       "sig",
+	}
+	thumbprint, err := jwk.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return nil, fmt.Errorf("computing key thumbprint: %w", err)
+	}
+	jwk.KeyID = base64.RawURLEncoding.EncodeToString(thumbprint)
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: algorithm, Key: privateKey},
+		(&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", jwk.KeyID))
+	if err != nil {
+		return nil, fmt.Errorf("creating signer: %w", err)
+	}
+
+	return &Signer{
+		signer:    signer,
+		publicKey: jwk,
+		algorithm: algorithm,
+	}, nil
+}
+
+func parsePrivateKey(der []byte) (any, error) {
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+
+	return nil, errors.New("failed to parse private key, want pkcs8, pkcs1 or ec key")
+}
+
+// Sign serializes the claims into a signed compact JWT. The issuer claim is always set to Issuer.
+func (s *Signer) Sign(claims *Claims) (string, error) {
+	claims.Issuer = Issuer
+
+	return jwt.Signed(s.signer).Claims(claims).Serialize()
+}
+
+// Verify checks the signature, issuer and time bound claims of a token and returns its claims.
+func (s *Signer) Verify(token string) (*Claims, error) {
+	parsed, err := jwt.ParseSigned(token, []jose.SignatureAlgorithm{s.algorithm})
+	if err != nil {
+		return nil, fmt.Errorf("parsing token: %w", err)
+	}
+
+	claims := &Claims{}
+	if err := parsed.Claims(s.publicKey.Key, claims); err != nil {
+		return nil, fmt.Errorf("verifying token signature: %w", err)
+	}
+	err = claims.ValidateWithLeeway(jwt.Expected{
+		Issuer: Issuer,
+		Time:   time.Now(),
+	}, time.Minute)
+	if err != nil {
+		return nil, fmt.Errorf("validating token claims: %w", err)
+	}
+	if claims.ID == "" {
+		return nil, errors.New("missing jti claim")
+	}
+
+	return claims, nil
+}
+
+// JWKS returns the key set other services can use to verify tokens offline.
+func (s *Signer) JWKS() jose.JSONWebKeySet {
+	return jose.JSONWebKeySet{
+		Keys: []jose.JSONWebKey{s.publicKey},
+	}
+}
+
+// IsJWT reports whether a token looks like a compact serialized JWT rather than a uuid.
+func IsJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
@@ -0,0 +1,84 @@
+package apitoken_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/direktiv/direktiv/direktiv-ee/pkg/apitoken"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSigner(t *testing.T) *apitoken.Signer {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	signer, err := apitoken.NewSigner(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	require.NoError(t, err)
+
+	return signer
+}
+
+func TestSignerSignAndVerify(t *testing.T) {
+	signer := newTestSigner(t)
+
+	jti := uuid.NewString()
+	token, err := signer.Sign(&apitoken.Claims{
+		Claims: jwt.Claims{
+			ID:     jti,
+			Expiry: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+		Namespace: "ns1",
+		Permissions: []apitoken.Permission{
+			{Topic: "secrets", Method: "read"},
+		},
+		Roles: []string{"r1"},
+	})
+	require.NoError(t, err)
+	require.True(t, apitoken.IsJWT(token))
+
+	claims, err := signer.Verify(token)
+	require.NoError(t, err)
+	require.Equal(t, jti, claims.ID)
+	require.Equal(t, "ns1", claims.Namespace)
+	require.Equal(t, apitoken.Issuer, claims.Issuer)
+	require.Equal(t, []string{"r1"}, claims.Roles)
+	require.Equal(t, "secrets", claims.Permissions[0].Topic)
+
+	// Tokens of another key are rejected.
+	_, err = newTestSigner(t).Verify(token)
+	require.Error(t, err)
+
+	// Expired tokens are rejected.
+	expired, err := signer.Sign(&apitoken.Claims{
+		Claims: jwt.Claims{
+			ID:     uuid.NewString(),
+			Expiry: jwt.NewNumericDate(time.Now().Add(-time.Hour)),
+		},
+		Namespace: "ns1",
+	})
+	require.NoError(t, err)
+	_, err = signer.Verify(expired)
+	require.Error(t, err)
+
+	// The published key set contains the signing key.
+	jwks := signer.JWKS()
+	require.Len(t, jwks.Keys, 1)
+	require.NotEmpty(t, jwks.Keys[0].KeyID)
+	require.True(t, jwks.Keys[0].IsPublic())
+}
+
+func TestIsJWT(t *testing.T) {
+	require.False(t, apitoken.IsJWT(uuid.NewString()))
+	require.True(t, apitoken.IsJWT("a.b.c"))
+}
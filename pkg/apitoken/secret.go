@@ -0,0 +1,82 @@
+package apitoken
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// SecretPrefix marks opaque api token secrets so that secret scanners can detect leaked tokens.
+const SecretPrefix = "dkv_"
+
+const (
+	lookupIDBytes = 8
+	keyBytes      = 32
+	saltBytes     = 16
+)
+
+// NewSecret generates an opaque api token secret in the format dkv_<lookup id>_<key>. The lookup id
+// is public and used to find the stored token, the 256-bit key is only stored as a salted digest.
+func NewSecret() (secret string, lookupID string, key string, err error) {
+	lookupID, err = randomHex(lookupIDBytes)
+	if err != nil {
+		return "", "", "", err
+	}
+	key, err = randomHex(keyBytes)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	return SecretPrefix + lookupID + "_" + key, lookupID, key, nil
+}
+
+// ParseSecret splits an opaque secret into its lookup id and key, ok is false when the
+// secret is not in the dkv_ format.
+func ParseSecret(secret string) (lookupID string, key string, ok bool) {
+	rest, found := strings.CutPrefix(secret, SecretPrefix)
+	if !found {
+		return "", "", false
+	}
+	lookupID, key, found = strings.Cut(rest, "_")
+	if !found || len(lookupID) != lookupIDBytes*2 || len(key) != keyBytes*2 {
+		return "", "", false
+	}
+	if _, err := hex.DecodeString(lookupID + key); err != nil {
+		return "", "", false
+	}
+
+	return lookupID, key, true
+}
+
+// HashKey returns a random salt and the salted SHA-256 digest of a secret key.
+func HashKey(key string) (salt string, digest string, err error) {
+	salt, err = randomHex(saltBytes)
+	if err != nil {
+		return "", "", err
+	}
+
+	return salt, digestKey(salt, key), nil
+}
+
+// VerifyKey reports whether the key matches a digest created by HashKey, in constant time.
+func VerifyKey(key, salt, digest string) bool {
+	return subtle.ConstantTimeCompare([]byte(digestKey(salt, key)), []byte(digest)) == 1
+}
+
+func digestKey(salt, key string) string {
+	sum := sha256.Sum256([]byte(salt + key))
+
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("reading random bytes: %w", err)
+	}
+
+	return hex.EncodeToString(b), nil
+}
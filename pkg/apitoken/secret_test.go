@@ -0,0 +1,56 @@
+package apitoken_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/direktiv/direktiv/direktiv-ee/pkg/apitoken"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecretGenerateAndVerify(t *testing.T) {
+	secret, lookupID, key, err := apitoken.NewSecret()
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(secret, apitoken.SecretPrefix))
+
+	gotLookupID, gotKey, ok := apitoken.ParseSecret(secret)
+	require.True(t, ok)
+	require.Equal(t, lookupID, gotLookupID)
+	require.Equal(t, key, gotKey)
+
+	salt, digest, err := apitoken.HashKey(key)
+	require.NoError(t, err)
+	require.NotContains(t, digest, key)
+	require.True(t, apitoken.VerifyKey(key, salt, digest))
+	require.False(t, apitoken.VerifyKey(key+"0", salt, digest))
+
+	// The same key hashes to different digests because of the random salt.
+	_, otherDigest, err := apitoken.HashKey(key)
+	require.NoError(t, err)
+	require.NotEqual(t, digest, otherDigest)
+}
+
+func TestParseSecret(t *testing.T) {
+	secret, _, _, err := apitoken.NewSecret()
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		secret string
+		wantOk bool
+	}{
+		{"valid", secret, true},
+		{"legacy uuid", uuid.NewString(), false},
+		{"missing prefix", strings.TrimPrefix(secret, apitoken.SecretPrefix), false},
+		{"truncated", secret[:len(secret)-1], false},
+		{"not hex", secret[:len(secret)-1] + "z", false},
+		{"empty", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, ok := apitoken.ParseSecret(tt.secret)
+			require.Equal(t, tt.wantOk, ok)
+		})
+	}
+}
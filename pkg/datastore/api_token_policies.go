@@ -0,0 +1,103 @@
+package datastore
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// APITokenPolicy limits the api tokens that can be created in a namespace, namespaces without a stored
+// policy use DefaultAPITokenPolicy.
+type APITokenPolicy struct {
+	Namespace string
+	// MaxLifetime is the longest lifetime a token can have, zero means unlimited.
+	MaxLifetime time.Duration
+	// DefaultLifetime is used when a token is created without a duration, zero means a duration is required.
+	DefaultLifetime time.Duration
+	// PermissionsCeiling holds the only permissions tokens can be granted, nil means unrestricted.
+	PermissionsCeiling Permissions
+	AllowNonExpiring   bool
+	// ManagedBy is the path of the file in the namespace tree that declares the policy, it is empty
+	// for policies managed through the api.
+	ManagedBy string
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// DefaultAPITokenPolicy keeps the behaviour from before policies existed, tokens need an explicit
+// duration of any length.
+func DefaultAPITokenPolicy(namespace string) *APITokenPolicy {
+	return &APITokenPolicy{
+		Namespace: namespace,
+	}
+}
+
+func (p *APITokenPolicy) Validate() error {
+	vErrs := InvalidArgumentError{}
+	if p.MaxLifetime < 0 {
+		vErrs["maxLifetime"] = "must not be negative"
+	}
+	if p.DefaultLifetime < 0 {
+		vErrs["defaultLifetime"] = "must not be negative"
+	}
+	if p.MaxLifetime > 0 && p.DefaultLifetime > p.MaxLifetime {
+		vErrs["defaultLifetime"] = "must not exceed maxLifetime"
+	}
+	if err := p.PermissionsCeiling.Validate(); err != nil {
+		vErrs["permissionsCeiling"] = err.Error()
+	}
+	if len(vErrs) > 0 {
+		return vErrs
+	}
+
+	return nil
+}
+
+// Expiry returns the expiry of a token with the given lifetime, a zero lifetime means the default
+// lifetime of the policy. It returns nil for tokens that never expire.
+func (p *APITokenPolicy) Expiry(now time.Time, lifetime time.Duration, neverExpires bool) (*time.Time, error) {
+	if neverExpires {
+		if !p.AllowNonExpiring {
+			return nil, InvalidArgumentError{"neverExpires": "non-expiring tokens are not allowed by the namespace token policy"}
+		}
+
+		return nil, nil
+	}
+	if lifetime == 0 {
+		lifetime = p.DefaultLifetime
+	}
+	if lifetime <= 0 {
+		return nil, InvalidArgumentError{"duration": "is required"}
+	}
+	if p.MaxLifetime > 0 && lifetime > p.MaxLifetime {
+		return nil, InvalidArgumentError{
+			"duration": fmt.Sprintf("exceeds the maximum lifetime of the namespace token policy: %s", p.MaxLifetime),
+		}
+	}
+	expiredAt := now.Add(lifetime)
+
+	return &expiredAt, nil
+}
+
+// CheckPermissions returns an error when any of the permissions is not covered by the ceiling.
+func (p *APITokenPolicy) CheckPermissions(permissions Permissions) error {
+	if p.PermissionsCeiling == nil {
+		return nil
+	}
+	for _, perm := range permissions {
+		if !p.PermissionsCeiling.Covers(perm) {
+			return InvalidArgumentError{
+				"permissions": fmt.Sprintf("permission '%s:%s' exceeds the namespace token policy", perm.Topic, perm.Method),
+			}
+		}
+	}
+
+	return nil
+}
+
+type APITokenPoliciesStore interface {
+	Get(ctx context.Context, namespace string) (*APITokenPolicy, error)
+	Set(ctx context.Context, policy *APITokenPolicy) (*APITokenPolicy, error)
+	Delete(ctx context.Context, namespace string) error
+}
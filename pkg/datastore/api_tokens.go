@@ -8,27 +8,95 @@ import (
 	"github.com/google/uuid"
 )
 
+const (
+	// APITokenFormatSecret tokens are opaque dkv_ secrets that are looked up by their LookupID,
+	// only the salted Digest of the secret key is stored.
+	APITokenFormatSecret = "secret"
+	// APITokenFormatUUID tokens are legacy opaque uuid secrets that are looked up by their hash.
+	// They keep working until they expire but can't be created anymore.
+	APITokenFormatUUID = "uuid"
+	// APITokenFormatJWT tokens are signed JWTs that can be verified offline, their Hash is the jti claim.
+	APITokenFormatJWT = "jwt"
+)
+
 type APIToken struct {
-	Name        string
-	Namespace   string
-	Description string
-	Hash        uuid.UUID
-	Permissions Permissions
-	ExpiredAt   time.Time
-	IsExpired   bool
+	Name           string
+	Namespace      string
+	Description    string
+	Format         string
+	Hash           uuid.UUID
+	LookupID       string
+	Salt           string
+	Digest         string
+	Permissions    Permissions
+	Roles          RoleRefs
+	ServiceAccount string
+	// ExpiredAt is nil for tokens that never expire.
+	ExpiredAt *time.Time
+	IsExpired bool
+
+	LastUsedAt        *time.Time
+	LastUsedIP        string
+	LastUsedUserAgent string
+	// RequestCount counts all requests of the token, RecentRequestCount those of the last
+	// APITokenRecentDays days.
+	RequestCount       int64
+	RecentRequestCount int64
 
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
 
+// APITokensFilter narrows down the result of APITokensStore.List, zero fields are ignored.
+type APITokensFilter struct {
+	// UnusedSince matches tokens that were not used since the given time, including never used tokens.
+	UnusedSince time.Time
+	// Topic matches tokens with an inline permission on the given topic.
+	Topic      string
+	NamePrefix string
+	// Expired matches expired tokens when true and valid tokens when false.
+	Expired *bool
+}
+
+// APITokenRecentDays is the window of APIToken.RecentRequestCount, requests are counted per day.
+const APITokenRecentDays = 30
+
+// APITokenUsage is the aggregated usage of a token since the last time it was recorded.
+type APITokenUsage struct {
+	Namespace         string
+	Name              string
+	LastUsedAt        time.Time
+	LastUsedIP        string
+	LastUsedUserAgent string
+	Requests          int64
+}
+
 type APITokensStore interface {
-	Create(ctx context.Context, apiToken *APIToken, lifeSeconds int) (*APIToken, error)
+	Create(ctx context.Context, apiToken *APIToken) (*APIToken, error)
+	// Update changes the description, permissions, roles and expiry of a token, signed jwt tokens
+	// can't be updated as their claims are part of the secret.
+	Update(ctx context.Context, namespace, name string, apiToken *APIToken) (*APIToken, error)
 	Delete(ctx context.Context, namespace, name string) error
 	Get(ctx context.Context, namespace, name string) (*APIToken, error)
 	GetByHash(ctx context.Context, hash uuid.UUID) (*APIToken, error)
-	List(ctx context.Context, namespace string) ([]*APIToken, error)
+	GetByLookupID(ctx context.Context, lookupID string) (*APIToken, error)
+	// List returns a page of tokens and the cursor of the next page, which is empty on the last page.
+	List(ctx context.Context, namespace string, filter APITokensFilter, opts ListOptions) ([]*APIToken, string, error)
+	ListByServiceAccount(ctx context.Context, namespace, serviceAccount string) ([]*APIToken, error)
+	// ListRevoked returns the jti of every deleted jwt token that is not expired yet.
+	ListRevoked(ctx context.Context) ([]uuid.UUID, error)
+	// RecordUsage adds the given usage to the usage columns of the tokens, unknown tokens are skipped.
+	RecordUsage(ctx context.Context, usage []*APITokenUsage) error
+	// PurgeExpired deletes tokens that expired before the given time, revocations that are expired and
+	// request counts older than APITokenRecentDays, when archive is set the purged tokens are moved to
+	// the archive instead. It returns the number of purged tokens.
+	PurgeExpired(ctx context.Context, expiredBefore time.Time, archive bool) (int64, error)
+	// ListExpiring returns tokens that expire before the given time and were not notified about yet.
+	ListExpiring(ctx context.Context, expireBefore time.Time) ([]*APIToken, error)
+	SetExpiryNotified(ctx context.Context, namespace, name string) error
 }
 
+// HashTokenID derives the stored hash of a legacy uuid token.
 func HashTokenID(input uuid.UUID) uuid.UUID {
 	sum := sha256.Sum256(input[:])
 	output := make([]byte, 0, 16)
@@ -0,0 +1,26 @@
+package datastore
+
+import (
+	"context"
+	"time"
+)
+
+// AuthFailure counts the failed authentications of a key, usually a client ip. Keys with too many
+// failures are locked out until LockedUntil.
+type AuthFailure struct {
+	Key         string
+	Failures    int
+	LockedUntil *time.Time
+
+	LastFailureAt time.Time
+}
+
+type AuthFailuresStore interface {
+	Get(ctx context.Context, key string) (*AuthFailure, error)
+	// Record counts a failed authentication of key, failures older than resetBefore are forgotten
+	// first.
+	Record(ctx context.Context, key string, resetBefore time.Time) (*AuthFailure, error)
+	Lock(ctx context.Context, key string, until time.Time) error
+	// Purge deletes the keys that failed last before the given time and aren't locked anymore.
+	Purge(ctx context.Context, before time.Time) (int64, error)
+}
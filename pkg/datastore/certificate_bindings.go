@@ -0,0 +1,92 @@
+package datastore
+
+import (
+	"context"
+	"encoding/hex"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// CertificateBinding maps verified client certificates to an identity inside a namespace. A
+// certificate matches when every field of the binding that is set matches it, the binding then
+// grants its roles or acts as its service account.
+type CertificateBinding struct {
+	Name        string
+	Namespace   string
+	Description string
+	// Subject is the distinguished name of the certificate subject in RFC 2253 form, e.g.
+	// "CN=deploy,O=Acme".
+	Subject string
+	// URI is a URI subject alternative name, usually a SPIFFE ID.
+	URI string
+	// Fingerprint is the hex encoded SHA-256 digest of the DER certificate.
+	Fingerprint string
+
+	Roles          RoleRefs
+	ServiceAccount string
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// NormalizeFingerprint returns a fingerprint in lower case and without the colons that tools like
+// openssl print.
+func NormalizeFingerprint(fingerprint string) string {
+	return strings.ToLower(strings.ReplaceAll(fingerprint, ":", ""))
+}
+
+// Validate checks the matchers and grants of the binding, the fingerprint has to be normalized.
+func (b *CertificateBinding) Validate() error {
+	vErrs := InvalidArgumentError{}
+	if b.Subject == "" && b.URI == "" && b.Fingerprint == "" {
+		vErrs["subject"] = "one of subject, uri or fingerprint is required"
+	}
+	if b.URI != "" {
+		if u, err := url.Parse(b.URI); err != nil || u.Scheme == "" {
+			vErrs["uri"] = "should be an absolute uri"
+		}
+	}
+	if b.Fingerprint != "" {
+		if d, err := hex.DecodeString(b.Fingerprint); err != nil || len(d) != 32 {
+			vErrs["fingerprint"] = "should be a hex encoded sha-256 digest"
+		}
+	}
+	if err := b.Roles.Validate(); err != nil {
+		vErrs["roles"] = err.Error()
+	}
+	if len(b.Roles) == 0 && b.ServiceAccount == "" {
+		vErrs["roles"] = "either roles or serviceAccount is required"
+	}
+	if len(vErrs) > 0 {
+		return vErrs
+	}
+
+	return nil
+}
+
+// Matches reports whether a certificate with the given subject, uri subject alternative names and
+// fingerprint is bound.
+func (b *CertificateBinding) Matches(subject string, uris []string, fingerprint string) bool {
+	if b.Subject != "" && b.Subject != subject {
+		return false
+	}
+	if b.URI != "" && !slices.Contains(uris, b.URI) {
+		return false
+	}
+	if b.Fingerprint != "" && b.Fingerprint != NormalizeFingerprint(fingerprint) {
+		return false
+	}
+
+	return b.Subject != "" || b.URI != "" || b.Fingerprint != ""
+}
+
+type CertificateBindingsStore interface {
+	Create(ctx context.Context, binding *CertificateBinding) (*CertificateBinding, error)
+	Get(ctx context.Context, namespace, name string) (*CertificateBinding, error)
+	Delete(ctx context.Context, namespace, name string) error
+	List(ctx context.Context, namespace string) ([]*CertificateBinding, error)
+	// ListAll returns the bindings of all namespaces.
+	ListAll(ctx context.Context) ([]*CertificateBinding, error)
+}
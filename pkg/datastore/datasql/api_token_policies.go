@@ -0,0 +1,112 @@
+package datasql
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/direktiv/direktiv/direktiv-ee/pkg/datastore"
+	"gorm.io/gorm"
+)
+
+type apiTokenPoliciesStore struct {
+	db *gorm.DB
+}
+
+// apiTokenPolicyRow stores the lifetimes in seconds.
+type apiTokenPolicyRow struct {
+	Namespace              string
+	MaxLifetimeSeconds     int64
+	DefaultLifetimeSeconds int64
+	PermissionsCeiling     datastore.Permissions
+	AllowNonExpiring       bool
+	ManagedBy              string
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (s *apiTokenPoliciesStore) Get(ctx context.Context, namespace string) (_ *datastore.APITokenPolicy, err error) {
+	ctx, span := startSpan(ctx, "APITokenPolicies.Get", namespaceAttr(namespace))
+	defer func() { endSpan(span, err) }()
+
+	scan := &apiTokenPolicyRow{}
+	res := s.db.WithContext(ctx).Raw(`
+							SELECT namespace, max_lifetime_seconds, default_lifetime_seconds, permissions_ceiling,
+								allow_non_expiring, managed_by, created_at, updated_at
+							FROM ee_api_token_policies
+							WHERE namespace=?`,
+		namespace).
+		First(scan)
+	if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+		return nil, datastore.ErrNotFound
+	}
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	return &datastore.APITokenPolicy{
+		Namespace:          scan.Namespace,
+		MaxLifetime:        time.Duration(scan.MaxLifetimeSeconds) * time.Second,
+		DefaultLifetime:    time.Duration(scan.DefaultLifetimeSeconds) * time.Second,
+		PermissionsCeiling: scan.PermissionsCeiling,
+		AllowNonExpiring:   scan.AllowNonExpiring,
+		ManagedBy:          scan.ManagedBy,
+		CreatedAt:          scan.CreatedAt,
+		UpdatedAt:          scan.UpdatedAt,
+	}, nil
+}
+
+func (s *apiTokenPoliciesStore) Set(ctx context.Context, policy *datastore.APITokenPolicy) (_ *datastore.APITokenPolicy, err error) {
+	ctx, span := startSpan(ctx, "APITokenPolicies.Set")
+	defer func() { endSpan(span, err) }()
+
+	if policy == nil {
+		return nil, datastore.InvalidArgumentError{"policy": "is nil"}
+	}
+	if policy.Namespace == "" {
+		return nil, datastore.InvalidArgumentError{"namespace": "is required"}
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+	for i := range policy.PermissionsCeiling {
+		policy.PermissionsCeiling[i].Namespace = policy.Namespace
+	}
+
+	res := s.db.WithContext(ctx).Exec(`
+							INSERT INTO ee_api_token_policies(namespace, max_lifetime_seconds, default_lifetime_seconds,
+								permissions_ceiling, allow_non_expiring, managed_by)
+							VALUES(?, ?, ?, ?, ?, ?)
+							ON CONFLICT (namespace) DO UPDATE SET
+								max_lifetime_seconds=EXCLUDED.max_lifetime_seconds,
+								default_lifetime_seconds=EXCLUDED.default_lifetime_seconds,
+								permissions_ceiling=EXCLUDED.permissions_ceiling,
+								allow_non_expiring=EXCLUDED.allow_non_expiring,
+								managed_by=EXCLUDED.managed_by,
+								updated_at=CURRENT_TIMESTAMP`,
+		policy.Namespace, int64(policy.MaxLifetime.Seconds()), int64(policy.DefaultLifetime.Seconds()),
+		policy.PermissionsCeiling, policy.AllowNonExpiring, policy.ManagedBy)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	return s.Get(ctx, policy.Namespace)
+}
+
+func (s *apiTokenPoliciesStore) Delete(ctx context.Context, namespace string) (err error) {
+	ctx, span := startSpan(ctx, "APITokenPolicies.Delete", namespaceAttr(namespace))
+	defer func() { endSpan(span, err) }()
+
+	res := s.db.WithContext(ctx).Exec(`DELETE FROM ee_api_token_policies WHERE namespace=?`, namespace)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected != 1 {
+		return datastore.ErrNotFound
+	}
+
+	return nil
+}
+
+var _ datastore.APITokenPoliciesStore = &apiTokenPoliciesStore{}
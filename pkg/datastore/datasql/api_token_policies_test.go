@@ -0,0 +1,103 @@
+package datasql_test
+
+import (
+	"context"
+	"errors"
+	"github.com/direktiv/direktiv/direktiv-ee/pkg/datastore"
+	"github.com/direktiv/direktiv/direktiv-ee/pkg/datastore/datasql"
+	"github.com/direktiv/direktiv/pkg/database"
+	"github.com/google/uuid"
+	"testing"
+	"time"
+)
+
+func Test_APITokenPolicies(t *testing.T) {
+	ctx := context.Background()
+
+	db, ns, err := database.NewTestDBWithNamespace(t, uuid.NewString())
+	if err != nil {
+		t.Fatalf("unepxected NewTestDBWithNamespace() error = %v", err)
+	}
+	if res := db.Conn().Exec(datasql.Schema); res.Error != nil {
+		t.Fatalf("unepxected exec db_schema error = %v", res.Error)
+	}
+
+	_, err = datasql.New().With(db.Conn()).APITokenPolicies().Get(ctx, ns.Name)
+	if !errors.Is(err, datastore.ErrNotFound) {
+		t.Errorf("APITokenPolicies().Get() error = %v, wantErr %v", err, datastore.ErrNotFound)
+	}
+
+	_, err = datasql.New().With(db.Conn()).APITokenPolicies().Set(ctx, &datastore.APITokenPolicy{
+		Namespace:       ns.Name,
+		MaxLifetime:     time.Hour,
+		DefaultLifetime: time.Hour * 2,
+	})
+	var vErrs datastore.InvalidArgumentError
+	if !errors.As(err, &vErrs) || vErrs["defaultLifetime"] == "" {
+		t.Fatalf("APITokenPolicies().Set() error = %v, want defaultLifetime validation error", err)
+	}
+
+	policy, err := datasql.New().With(db.Conn()).APITokenPolicies().Set(ctx, &datastore.APITokenPolicy{
+		Namespace:       ns.Name,
+		MaxLifetime:     time.Hour * 24,
+		DefaultLifetime: time.Hour,
+		PermissionsCeiling: datastore.Permissions{
+			{"", "secrets", "read"},
+			{"", "variables", "manage"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("APITokenPolicies().Set() error = %v", err)
+	}
+	if policy.MaxLifetime != time.Hour*24 || policy.DefaultLifetime != time.Hour {
+		t.Errorf("APITokenPolicies().Set() returned %v, want the stored lifetimes", policy)
+	}
+
+	now := time.Now()
+	expiredAt, err := policy.Expiry(now, 0, false)
+	if err != nil || !expiredAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("Expiry() = %v, %v, want the default lifetime", expiredAt, err)
+	}
+	if _, err := policy.Expiry(now, time.Hour*48, false); err == nil {
+		t.Errorf("Expiry() error = nil, want error for exceeding the max lifetime")
+	}
+	if _, err := policy.Expiry(now, 0, true); err == nil {
+		t.Errorf("Expiry() error = nil, want error for non-expiring tokens")
+	}
+	if err := policy.CheckPermissions(datastore.Permissions{{"", "secrets", "GET"}, {"", "variables", "POST"}}); err != nil {
+		t.Errorf("CheckPermissions() error = %v", err)
+	}
+	if err := policy.CheckPermissions(datastore.Permissions{{"", "secrets", "manage"}}); err == nil {
+		t.Errorf("CheckPermissions() error = nil, want error for exceeding the ceiling")
+	}
+
+	// Tokens without expiry.
+	token, err := datasql.New().With(db.Conn()).APITokens().Create(ctx, &datastore.APIToken{
+		Name:      "never",
+		Namespace: ns.Name,
+		Format:    datastore.APITokenFormatUUID,
+		Hash:      uuid.New(),
+	})
+	if err != nil {
+		t.Fatalf("APITokens().Create() error = %v", err)
+	}
+	if token.ExpiredAt != nil || token.IsExpired {
+		t.Errorf("APITokens().Create() returned %v, want a token without expiry", token)
+	}
+
+	token, err = datasql.New().With(db.Conn()).APITokens().Update(ctx, ns.Name, "never", &datastore.APIToken{
+		Description: "updated",
+		ExpiredAt:   expiresIn(60),
+	})
+	if err != nil {
+		t.Fatalf("APITokens().Update() error = %v", err)
+	}
+	if token.Description != "updated" || token.ExpiredAt == nil {
+		t.Errorf("APITokens().Update() returned %v, want updated description and expiry", token)
+	}
+
+	err = datasql.New().With(db.Conn()).APITokenPolicies().Delete(ctx, ns.Name)
+	if err != nil {
+		t.Fatalf("APITokenPolicies().Delete() error = %v", err)
+	}
+}
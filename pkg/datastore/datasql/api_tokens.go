@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/direktiv/direktiv/direktiv-ee/pkg/datastore"
 	"github.com/google/uuid"
@@ -15,8 +16,26 @@ type apiTokensStore struct {
 	db *gorm.DB
 }
 
+// apiTokensSelect selects tokens with their requests of the last days, its first argument is
+// datastore.APITokenRecentDays.
+const apiTokensSelect = `
+							SELECT name, namespace, description, format, hash,
+							COALESCE(lookup_id, '') AS lookup_id, COALESCE(salt, '') AS salt, COALESCE(digest, '') AS digest,
+							permissions, roles, COALESCE(service_account, '') AS service_account,
+							expired_at, created_at, updated_at,
+							COALESCE(expired_at <= NOW(), false) AS is_expired,
+							last_used_at, COALESCE(last_used_ip, '') AS last_used_ip,
+							COALESCE(last_used_user_agent, '') AS last_used_user_agent, request_count,
+							(SELECT COALESCE(SUM(r.requests), 0) FROM ee_api_token_requests r
+								WHERE r.namespace=ee_api_tokens.namespace AND r.name=ee_api_tokens.name
+								AND r.day > CURRENT_DATE - ?::int) AS recent_request_count
+							FROM ee_api_tokens`
+
 //nolint:goconst
-func (s *apiTokensStore) Create(ctx context.Context, apiToken *datastore.APIToken, lifeSeconds int) (*datastore.APIToken, error) {
+func (s *apiTokensStore) Create(ctx context.Context, apiToken *datastore.APIToken) (_ *datastore.APIToken, err error) {
+	ctx, span := startSpan(ctx, "APITokens.Create")
+	defer func() { endSpan(span, err) }()
+
 	vErrs := datastore.InvalidArgumentError{}
 	if apiToken == nil {
 		vErrs["apiToken"] = "is nil"
@@ -29,28 +48,68 @@ func (s *apiTokensStore) Create(ctx context.Context, apiToken *datastore.APIToke
 	if apiToken.Namespace == "" {
 		vErrs["namespace"] = "is required"
 	}
-	if apiToken.Hash == uuid.Nil {
-		vErrs["hash"] = "is required"
+	if apiToken.Format == "" {
+		apiToken.Format = datastore.APITokenFormatSecret
 	}
-	err := apiToken.Permissions.Validate()
+	switch apiToken.Format {
+	case datastore.APITokenFormatSecret:
+		if apiToken.LookupID == "" {
+			vErrs["lookupID"] = "is required"
+		}
+		if apiToken.Salt == "" || apiToken.Digest == "" {
+			vErrs["digest"] = "is required"
+		}
+	case datastore.APITokenFormatUUID, datastore.APITokenFormatJWT:
+		if apiToken.Hash == uuid.Nil {
+			vErrs["hash"] = "is required"
+		}
+	default:
+		vErrs["format"] = fmt.Sprintf("invalid format: '%s'", apiToken.Format)
+	}
+	err = apiToken.Permissions.Validate()
 	if err != nil {
 		vErrs["permissions"] = err.Error()
 	}
+	err = apiToken.Roles.Validate()
+	if err != nil {
+		vErrs["roles"] = err.Error()
+	}
 	if len(vErrs) > 0 {
 		return nil, vErrs
 	}
+	missing, err := missingRoles(ctx, s.db, apiToken.Namespace, apiToken.Roles)
+	if err != nil {
+		return nil, err
+	}
+	if len(missing) > 0 {
+		return nil, datastore.InvalidArgumentError{
+			"roles": fmt.Sprintf("roles don't exist: '%s'", strings.Join(missing, "', '")),
+		}
+	}
 	for i := range apiToken.Permissions {
 		apiToken.Permissions[i].Namespace = apiToken.Namespace
 	}
-	query := fmt.Sprintf(`
-							INSERT INTO ee_api_tokens(name, namespace, description, hash, permissions, expired_at) VALUES(?, ?, ?, ?, ?, NOW() + INTERVAL '%d SECOND');
-							`, lifeSeconds)
-
-	res := s.db.WithContext(ctx).Exec(query, apiToken.Name, apiToken.Namespace, apiToken.Description, apiToken.Hash, apiToken.Permissions)
+	// Tokens in the secret format have no hash.
+	var hash any
+	if apiToken.Hash != uuid.Nil {
+		hash = apiToken.Hash
+	}
+	res := s.db.WithContext(ctx).Exec(`
+							INSERT INTO ee_api_tokens(name, namespace, description, format, hash, lookup_id, salt, digest,
+								permissions, roles, service_account, expired_at)
+							VALUES(?, ?, ?, ?, ?, NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), ?, ?, NULLIF(?, ''), ?);
+							`, apiToken.Name, apiToken.Namespace, apiToken.Description, apiToken.Format,
+		hash, apiToken.LookupID, apiToken.Salt, apiToken.Digest,
+		apiToken.Permissions, apiToken.Roles, apiToken.ServiceAccount, apiToken.ExpiredAt)
 
 	if res.Error != nil && strings.Contains(res.Error.Error(), "duplicate key") {
 		return nil, datastore.ErrDuplication
 	}
+	if res.Error != nil && strings.Contains(res.Error.Error(), "fk_ee_service_accounts_ee_api_tokens") {
+		return nil, datastore.InvalidArgumentError{
+			"serviceAccount": fmt.Sprintf("service account '%s' doesn't exist", apiToken.ServiceAccount),
+		}
+	}
 	if res.Error != nil {
 		return nil, res.Error
 	}
@@ -61,7 +120,66 @@ func (s *apiTokensStore) Create(ctx context.Context, apiToken *datastore.APIToke
 	return s.Get(ctx, apiToken.Namespace, apiToken.Name)
 }
 
-func (s *apiTokensStore) Delete(ctx context.Context, namespace, name string) error {
+func (s *apiTokensStore) Update(ctx context.Context, namespace, name string, apiToken *datastore.APIToken) (_ *datastore.APIToken, err error) {
+	ctx, span := startSpan(ctx, "APITokens.Update", namespaceAttr(namespace))
+	defer func() { endSpan(span, err) }()
+
+	vErrs := datastore.InvalidArgumentError{}
+	if apiToken == nil {
+		vErrs["apiToken"] = "is nil"
+
+		return nil, vErrs
+	}
+	err = apiToken.Permissions.Validate()
+	if err != nil {
+		vErrs["permissions"] = err.Error()
+	}
+	err = apiToken.Roles.Validate()
+	if err != nil {
+		vErrs["roles"] = err.Error()
+	}
+	if len(vErrs) > 0 {
+		return nil, vErrs
+	}
+	current, err := s.Get(ctx, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	if current.Format == datastore.APITokenFormatJWT {
+		return nil, datastore.InvalidArgumentError{"format": "signed jwt tokens can't be updated"}
+	}
+	missing, err := missingRoles(ctx, s.db, namespace, apiToken.Roles)
+	if err != nil {
+		return nil, err
+	}
+	if len(missing) > 0 {
+		return nil, datastore.InvalidArgumentError{
+			"roles": fmt.Sprintf("roles don't exist: '%s'", strings.Join(missing, "', '")),
+		}
+	}
+	for i := range apiToken.Permissions {
+		apiToken.Permissions[i].Namespace = namespace
+	}
+
+	res := s.db.WithContext(ctx).Exec(`
+							UPDATE ee_api_tokens SET description=?, permissions=?, roles=?, expired_at=?, expiry_notified_at=NULL,
+								updated_at=CURRENT_TIMESTAMP
+							WHERE namespace=? AND name=?`,
+		apiToken.Description, apiToken.Permissions, apiToken.Roles, apiToken.ExpiredAt, namespace, name)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected != 1 {
+		return nil, datastore.ErrNotFound
+	}
+
+	return s.Get(ctx, namespace, name)
+}
+
+func (s *apiTokensStore) Delete(ctx context.Context, namespace, name string) (err error) {
+	ctx, span := startSpan(ctx, "APITokens.Delete", namespaceAttr(namespace))
+	defer func() { endSpan(span, err) }()
+
 	res := s.db.WithContext(ctx).Exec(`DELETE FROM ee_api_tokens WHERE  name=? AND namespace=?`, name, namespace)
 	if res.Error != nil {
 		return res.Error
@@ -73,14 +191,14 @@ func (s *apiTokensStore) Delete(ctx context.Context, namespace, name string) err
 	return nil
 }
 
-func (s *apiTokensStore) Get(ctx context.Context, namespace, name string) (*datastore.APIToken, error) {
+func (s *apiTokensStore) Get(ctx context.Context, namespace, name string) (_ *datastore.APIToken, err error) {
+	ctx, span := startSpan(ctx, "APITokens.Get", namespaceAttr(namespace))
+	defer func() { endSpan(span, err) }()
+
 	scan := &datastore.APIToken{}
-	res := s.db.WithContext(ctx).Raw(`
-							SELECT name, namespace, description, hash, permissions, expired_at, created_at, updated_at,
-							(expired_at <= NOW()) AS is_expired
-							FROM ee_api_tokens 
+	res := s.db.WithContext(ctx).Raw(apiTokensSelect+`
 							WHERE name=? AND namespace=?`,
-		name, namespace).
+		datastore.APITokenRecentDays, name, namespace).
 		First(scan)
 	if errors.Is(res.Error, gorm.ErrRecordNotFound) {
 		return nil, datastore.ErrNotFound
@@ -92,14 +210,14 @@ func (s *apiTokensStore) Get(ctx context.Context, namespace, name string) (*data
 	return scan, nil
 }
 
-func (s *apiTokensStore) GetByHash(ctx context.Context, hash uuid.UUID) (*datastore.APIToken, error) {
+func (s *apiTokensStore) GetByHash(ctx context.Context, hash uuid.UUID) (_ *datastore.APIToken, err error) {
+	ctx, span := startSpan(ctx, "APITokens.GetByHash")
+	defer func() { endSpan(span, err) }()
+
 	scan := &datastore.APIToken{}
-	res := s.db.WithContext(ctx).Raw(`
-							SELECT name, namespace, description, hash, permissions, expired_at, created_at, updated_at,
-							(expired_at <= NOW()) AS is_expired
-							FROM ee_api_tokens 
-							WHERE hash=?`,
-		hash).
+	res := s.db.WithContext(ctx).Raw(apiTokensSelect+`
+							WHERE hash=? AND format='uuid'`,
+		datastore.APITokenRecentDays, hash).
 		First(scan)
 	if errors.Is(res.Error, gorm.ErrRecordNotFound) {
 		return nil, datastore.ErrNotFound
@@ -111,14 +229,189 @@ func (s *apiTokensStore) GetByHash(ctx context.Context, hash uuid.UUID) (*datast
 	return scan, nil
 }
 
-func (s *apiTokensStore) List(ctx context.Context, namespace string) ([]*datastore.APIToken, error) {
+func (s *apiTokensStore) GetByLookupID(ctx context.Context, lookupID string) (_ *datastore.APIToken, err error) {
+	ctx, span := startSpan(ctx, "APITokens.GetByLookupID")
+	defer func() { endSpan(span, err) }()
+
+	scan := &datastore.APIToken{}
+	res := s.db.WithContext(ctx).Raw(apiTokensSelect+`
+							WHERE lookup_id=? AND format='secret'`,
+		datastore.APITokenRecentDays, lookupID).
+		First(scan)
+	if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+		return nil, datastore.ErrNotFound
+	}
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	return scan, nil
+}
+
+func (s *apiTokensStore) List(ctx context.Context, namespace string, filter datastore.APITokensFilter,
+	opts datastore.ListOptions,
+) (_ []*datastore.APIToken, _ string, err error) {
+	ctx, span := startSpan(ctx, "APITokens.List", namespaceAttr(namespace))
+	defer func() { endSpan(span, err) }()
+
+	query := apiTokensSelect + `
+							WHERE namespace=?`
+	args := []any{datastore.APITokenRecentDays, namespace}
+	if !filter.UnusedSince.IsZero() {
+		query += ` AND (last_used_at IS NULL OR last_used_at < ?)`
+		args = append(args, filter.UnusedSince)
+	}
+	if filter.Topic != "" {
+		query += ` AND NULLIF(permissions, '')::jsonb @> ?::jsonb`
+		args = append(args, topicFilter(filter.Topic))
+	}
+	if filter.NamePrefix != "" {
+		query += ` AND starts_with(name, ?)`
+		args = append(args, filter.NamePrefix)
+	}
+	if filter.Expired != nil {
+		query += ` AND COALESCE(expired_at <= NOW(), false) = ?`
+		args = append(args, *filter.Expired)
+	}
+	query, args, err = pageQuery(query, args, opts, map[string]sortColumn{
+		datastore.SortByCreated: sortByCreated,
+		datastore.SortByExpiry:  sortByExpiry,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	var list []*datastore.APIToken
+	res := s.db.WithContext(ctx).Raw(query, args...).Find(&list)
+	if res.Error != nil {
+		return nil, "", res.Error
+	}
+	list, next := nextPage(list, opts,
+		func(v *datastore.APIToken) string {
+			if opts.SortBy == datastore.SortByExpiry {
+				if v.ExpiredAt == nil {
+					return "infinity"
+				}
+
+				return v.ExpiredAt.Format(time.RFC3339Nano)
+			}
+
+			return v.CreatedAt.Format(time.RFC3339Nano)
+		},
+		func(v *datastore.APIToken) string { return v.Name })
+
+	return list, next, nil
+}
+
+func (s *apiTokensStore) ListByServiceAccount(ctx context.Context, namespace, serviceAccount string) (_ []*datastore.APIToken, err error) {
+	ctx, span := startSpan(ctx, "APITokens.ListByServiceAccount", namespaceAttr(namespace))
+	defer func() { endSpan(span, err) }()
+
 	var list []*datastore.APIToken
+	res := s.db.WithContext(ctx).Raw(apiTokensSelect+`
+							WHERE namespace=? AND service_account=?
+							ORDER BY created_at ASC`, datastore.APITokenRecentDays, namespace, serviceAccount).
+		Find(&list)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	return list, nil
+}
+
+func (s *apiTokensStore) ListRevoked(ctx context.Context) (_ []uuid.UUID, err error) {
+	ctx, span := startSpan(ctx, "APITokens.ListRevoked")
+	defer func() { endSpan(span, err) }()
+
+	var list []uuid.UUID
 	res := s.db.WithContext(ctx).Raw(`
-							SELECT name, namespace, description, hash, permissions, expired_at, created_at, updated_at,
-							(expired_at <= NOW()) AS is_expired
-							FROM ee_api_tokens
-							WHERE namespace=? 
-							ORDER BY created_at ASC`, namespace).
+							SELECT jti
+							FROM ee_api_token_revocations
+							WHERE expired_at > NOW()`).
+		Scan(&list)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	return list, nil
+}
+
+func (s *apiTokensStore) RecordUsage(ctx context.Context, usage []*datastore.APITokenUsage) (err error) {
+	ctx, span := startSpan(ctx, "APITokens.RecordUsage")
+	defer func() { endSpan(span, err) }()
+
+	for _, u := range usage {
+		// The client of an older batch, e.g. of another replica, doesn't replace the last one.
+		res := s.db.WithContext(ctx).Exec(`
+							UPDATE ee_api_tokens SET
+							last_used_ip=CASE WHEN last_used_at IS NULL OR last_used_at < ? THEN ? ELSE last_used_ip END,
+							last_used_user_agent=CASE WHEN last_used_at IS NULL OR last_used_at < ? THEN ?
+								ELSE last_used_user_agent END,
+							last_used_at=GREATEST(last_used_at, ?),
+							request_count=request_count+?
+							WHERE namespace=? AND name=?`,
+			u.LastUsedAt, u.LastUsedIP, u.LastUsedAt, u.LastUsedUserAgent, u.LastUsedAt, u.Requests, u.Namespace, u.Name)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			continue
+		}
+		res = s.db.WithContext(ctx).Exec(`
+							INSERT INTO ee_api_token_requests(namespace, name, day, requests) VALUES(?, ?, ?::date, ?)
+							ON CONFLICT (namespace, name, day) DO UPDATE SET
+								requests = ee_api_token_requests.requests + EXCLUDED.requests`,
+			u.Namespace, u.Name, u.LastUsedAt.UTC().Format(time.DateOnly), u.Requests)
+		if res.Error != nil {
+			return res.Error
+		}
+	}
+
+	return nil
+}
+
+func (s *apiTokensStore) PurgeExpired(ctx context.Context, expiredBefore time.Time, archive bool) (_ int64, err error) {
+	ctx, span := startSpan(ctx, "APITokens.PurgeExpired")
+	defer func() { endSpan(span, err) }()
+
+	query := `DELETE FROM ee_api_tokens WHERE expired_at < ?`
+	if archive {
+		query = `WITH purged AS (
+								DELETE FROM ee_api_tokens WHERE expired_at < ?
+								RETURNING name, namespace, description, format, permissions, roles, service_account,
+									last_used_at, request_count, expired_at, created_at
+							)
+							INSERT INTO ee_api_tokens_archive(name, namespace, description, format, permissions, roles,
+								service_account, last_used_at, request_count, expired_at, created_at)
+							SELECT * FROM purged`
+	}
+	res := s.db.WithContext(ctx).Exec(query, expiredBefore)
+	if res.Error != nil {
+		return 0, res.Error
+	}
+	purged := res.RowsAffected
+
+	res = s.db.WithContext(ctx).Exec(`DELETE FROM ee_api_token_revocations WHERE expired_at <= NOW()`)
+	if res.Error != nil {
+		return 0, res.Error
+	}
+	res = s.db.WithContext(ctx).Exec(`DELETE FROM ee_api_token_requests WHERE day <= CURRENT_DATE - ?::int`,
+		datastore.APITokenRecentDays)
+	if res.Error != nil {
+		return 0, res.Error
+	}
+
+	return purged, nil
+}
+
+func (s *apiTokensStore) ListExpiring(ctx context.Context, expireBefore time.Time) (_ []*datastore.APIToken, err error) {
+	ctx, span := startSpan(ctx, "APITokens.ListExpiring")
+	defer func() { endSpan(span, err) }()
+
+	var list []*datastore.APIToken
+	res := s.db.WithContext(ctx).Raw(apiTokensSelect+`
+							WHERE expired_at > NOW() AND expired_at <= ? AND expiry_notified_at IS NULL
+							ORDER BY expired_at ASC`, datastore.APITokenRecentDays, expireBefore).
 		Find(&list)
 	if res.Error != nil {
 		return nil, res.Error
@@ -127,4 +420,20 @@ func (s *apiTokensStore) List(ctx context.Context, namespace string) ([]*datasto
 	return list, nil
 }
 
+func (s *apiTokensStore) SetExpiryNotified(ctx context.Context, namespace, name string) (err error) {
+	ctx, span := startSpan(ctx, "APITokens.SetExpiryNotified", namespaceAttr(namespace))
+	defer func() { endSpan(span, err) }()
+
+	res := s.db.WithContext(ctx).Exec(`UPDATE ee_api_tokens SET expiry_notified_at=NOW() WHERE namespace=? AND name=?`,
+		namespace, name)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return datastore.ErrNotFound
+	}
+
+	return nil
+}
+
 var _ datastore.APITokensStore = &apiTokensStore{}
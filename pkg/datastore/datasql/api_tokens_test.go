@@ -8,8 +8,15 @@ import (
 	"github.com/direktiv/direktiv/pkg/database"
 	"github.com/google/uuid"
 	"testing"
+	"time"
 )
 
+func expiresIn(seconds int) *time.Time {
+	t := time.Now().Add(time.Duration(seconds) * time.Second)
+
+	return &t
+}
+
 const (
 	textSomething     = "something"
 	textSomethingElse = "something_else"
@@ -44,12 +51,14 @@ func Test_APITokens(t *testing.T) {
 		Name:        textSomething,
 		Description: textSomethingElse,
 		Namespace:   ns.Name,
+		Format:      datastore.APITokenFormatUUID,
 		Hash:        uuid1,
 		Permissions: datastore.Permissions{
 			{"", "secrets", "GET"},
 			{"", "variables", "GET"},
 		},
-	}, 0)
+		ExpiredAt: expiresIn(0),
+	})
 	if err != nil {
 		t.Fatalf("APITokens().Create() error = %v", err)
 	}
@@ -72,7 +81,7 @@ func Test_APITokens(t *testing.T) {
 		t.Errorf("APITokens().Create() returned %v, want %v", p1.Permissions, "secrets")
 	}
 
-	l, err := datasql.New().With(db.Conn()).APITokens().List(ctx, ns.Name)
+	l, _, err := datasql.New().With(db.Conn()).APITokens().List(ctx, ns.Name, datastore.APITokensFilter{}, datastore.ListOptions{})
 	if err != nil {
 		t.Fatalf("APITokens().List() error = %v", err)
 	}
@@ -83,3 +92,274 @@ func Test_APITokens(t *testing.T) {
 		t.Errorf("APITokens().List() returned %v, want %v", l[0].Name, textSomething)
 	}
 }
+
+func Test_APITokensUsage(t *testing.T) {
+	ctx := context.Background()
+
+	db, ns, err := database.NewTestDBWithNamespace(t, uuid.NewString())
+	if err != nil {
+		t.Fatalf("unepxected NewTestDBWithNamespace() error = %v", err)
+	}
+	if res := db.Conn().Exec(datasql.Schema); res.Error != nil {
+		t.Fatalf("unepxected exec db_schema error = %v", res.Error)
+	}
+
+	for _, name := range []string{"used", "unused"} {
+		_, err = datasql.New().With(db.Conn()).APITokens().Create(ctx, &datastore.APIToken{
+			Name:      name,
+			Namespace: ns.Name,
+			Format:    datastore.APITokenFormatUUID,
+			Hash:      uuid.New(),
+			ExpiredAt: expiresIn(60),
+		})
+		if err != nil {
+			t.Fatalf("APITokens().Create() error = %v", err)
+		}
+	}
+
+	usedAt := time.Now().UTC().Truncate(time.Second)
+	err = datasql.New().With(db.Conn()).APITokens().RecordUsage(ctx, []*datastore.APITokenUsage{
+		{Namespace: ns.Name, Name: "used", LastUsedAt: usedAt, LastUsedIP: "10.0.0.1", LastUsedUserAgent: "curl", Requests: 3},
+		{Namespace: ns.Name, Name: "missing", LastUsedAt: usedAt, Requests: 1},
+	})
+	if err != nil {
+		t.Fatalf("APITokens().RecordUsage() error = %v", err)
+	}
+	// An older batch doesn't move last_used_at back nor replace the client, requests older than
+	// APITokenRecentDays only count towards the total.
+	err = datasql.New().With(db.Conn()).APITokens().RecordUsage(ctx, []*datastore.APITokenUsage{
+		{Namespace: ns.Name, Name: "used", LastUsedAt: usedAt.Add(-time.Hour), LastUsedIP: "10.0.0.2", LastUsedUserAgent: "wget", Requests: 2},
+		{Namespace: ns.Name, Name: "used", LastUsedAt: usedAt.AddDate(0, 0, -40), LastUsedIP: "10.0.0.3", Requests: 4},
+	})
+	if err != nil {
+		t.Fatalf("APITokens().RecordUsage() error = %v", err)
+	}
+
+	got, err := datasql.New().With(db.Conn()).APITokens().Get(ctx, ns.Name, "used")
+	if err != nil {
+		t.Fatalf("APITokens().Get() error = %v", err)
+	}
+	if got.RequestCount != 9 {
+		t.Errorf("APITokens().Get() returned %v requests, want %v", got.RequestCount, 9)
+	}
+	if got.RecentRequestCount != 5 {
+		t.Errorf("APITokens().Get() returned %v recent requests, want %v", got.RecentRequestCount, 5)
+	}
+	if got.LastUsedAt == nil || !got.LastUsedAt.Equal(usedAt) {
+		t.Errorf("APITokens().Get() returned last used at %v, want %v", got.LastUsedAt, usedAt)
+	}
+	if got.LastUsedIP != "10.0.0.1" || got.LastUsedUserAgent != "curl" {
+		t.Errorf("APITokens().Get() returned last used client %v %v, want %v %v",
+			got.LastUsedIP, got.LastUsedUserAgent, "10.0.0.1", "curl")
+	}
+
+	l, _, err := datasql.New().With(db.Conn()).APITokens().List(ctx, ns.Name, datastore.APITokensFilter{
+		UnusedSince: usedAt.Add(-time.Minute),
+	}, datastore.ListOptions{})
+	if err != nil {
+		t.Fatalf("APITokens().List() error = %v", err)
+	}
+	if len(l) != 1 || l[0].Name != "unused" {
+		t.Errorf("APITokens().List() returned %v, want only the unused token", l)
+	}
+}
+
+func Test_APITokensRevocation(t *testing.T) {
+	ctx := context.Background()
+
+	db, ns, err := database.NewTestDBWithNamespace(t, uuid.NewString())
+	if err != nil {
+		t.Fatalf("unepxected NewTestDBWithNamespace() error = %v", err)
+	}
+	if res := db.Conn().Exec(datasql.Schema); res.Error != nil {
+		t.Fatalf("unepxected exec db_schema error = %v", res.Error)
+	}
+
+	jti := uuid.New()
+	for _, token := range []*datastore.APIToken{
+		{Name: "uuid_token", Namespace: ns.Name, Hash: uuid.New(), Format: datastore.APITokenFormatUUID},
+		{Name: "jwt_token", Namespace: ns.Name, Hash: jti, Format: datastore.APITokenFormatJWT},
+	} {
+		token.ExpiredAt = expiresIn(60)
+		_, err = datasql.New().With(db.Conn()).APITokens().Create(ctx, token)
+		if err != nil {
+			t.Fatalf("APITokens().Create() error = %v", err)
+		}
+	}
+
+	// Signed tokens must never be found by hash lookups.
+	_, err = datasql.New().With(db.Conn()).APITokens().GetByHash(ctx, jti)
+	if !errors.Is(err, datastore.ErrNotFound) {
+		t.Errorf("APITokens().GetByHash() error = %v, wantErr %v", err, datastore.ErrNotFound)
+	}
+
+	for _, name := range []string{"uuid_token", "jwt_token"} {
+		err = datasql.New().With(db.Conn()).APITokens().Delete(ctx, ns.Name, name)
+		if err != nil {
+			t.Fatalf("APITokens().Delete() error = %v", err)
+		}
+	}
+
+	revoked, err := datasql.New().With(db.Conn()).APITokens().ListRevoked(ctx)
+	if err != nil {
+		t.Fatalf("APITokens().ListRevoked() error = %v", err)
+	}
+	if len(revoked) != 1 || revoked[0] != jti {
+		t.Errorf("APITokens().ListRevoked() returned %v, want %v", revoked, []uuid.UUID{jti})
+	}
+}
+
+func Test_APITokensSecretFormat(t *testing.T) {
+	ctx := context.Background()
+
+	db, ns, err := database.NewTestDBWithNamespace(t, uuid.NewString())
+	if err != nil {
+		t.Fatalf("unepxected NewTestDBWithNamespace() error = %v", err)
+	}
+	if res := db.Conn().Exec(datasql.Schema); res.Error != nil {
+		t.Fatalf("unepxected exec db_schema error = %v", res.Error)
+	}
+
+	_, err = datasql.New().With(db.Conn()).APITokens().Create(ctx, &datastore.APIToken{
+		Name:      textSomething,
+		Namespace: ns.Name,
+		ExpiredAt: expiresIn(60),
+	})
+	var vErrs datastore.InvalidArgumentError
+	if !errors.As(err, &vErrs) || vErrs["lookupID"] == "" {
+		t.Fatalf("APITokens().Create() error = %v, want lookupID validation error", err)
+	}
+
+	p1, err := datasql.New().With(db.Conn()).APITokens().Create(ctx, &datastore.APIToken{
+		Name:      textSomething,
+		Namespace: ns.Name,
+		LookupID:  "0011223344556677",
+		Salt:      "salt",
+		Digest:    "digest",
+		ExpiredAt: expiresIn(60),
+	})
+	if err != nil {
+		t.Fatalf("APITokens().Create() error = %v", err)
+	}
+	if p1.Format != datastore.APITokenFormatSecret {
+		t.Errorf("APITokens().Create() returned %v, want %v", p1.Format, datastore.APITokenFormatSecret)
+	}
+	if p1.Hash != uuid.Nil {
+		t.Errorf("APITokens().Create() returned %v, want %v", p1.Hash, uuid.Nil)
+	}
+
+	p1, err = datasql.New().With(db.Conn()).APITokens().GetByLookupID(ctx, "0011223344556677")
+	if err != nil {
+		t.Fatalf("APITokens().GetByLookupID() error = %v", err)
+	}
+	if p1.Name != textSomething || p1.Salt != "salt" || p1.Digest != "digest" {
+		t.Errorf("APITokens().GetByLookupID() returned %v", p1)
+	}
+}
+
+func Test_APITokensPurgeExpired(t *testing.T) {
+	ctx := context.Background()
+
+	db, ns, err := database.NewTestDBWithNamespace(t, uuid.NewString())
+	if err != nil {
+		t.Fatalf("unepxected NewTestDBWithNamespace() error = %v", err)
+	}
+	if res := db.Conn().Exec(datasql.Schema); res.Error != nil {
+		t.Fatalf("unepxected exec db_schema error = %v", res.Error)
+	}
+
+	for name, lifeSeconds := range map[string]int{"expired": -3600, "archived": -7200, "valid": 3600} {
+		_, err = datasql.New().With(db.Conn()).APITokens().Create(ctx, &datastore.APIToken{
+			Name:      name,
+			Namespace: ns.Name,
+			Format:    datastore.APITokenFormatUUID,
+			Hash:      uuid.New(),
+			ExpiredAt: expiresIn(lifeSeconds),
+		})
+		if err != nil {
+			t.Fatalf("APITokens().Create() error = %v", err)
+		}
+	}
+
+	purged, err := datasql.New().With(db.Conn()).APITokens().PurgeExpired(ctx, time.Now().Add(-time.Minute*90), true)
+	if err != nil {
+		t.Fatalf("APITokens().PurgeExpired() error = %v", err)
+	}
+	if purged != 1 {
+		t.Errorf("APITokens().PurgeExpired() returned %v, want %v", purged, 1)
+	}
+	var archived int64
+	db.Conn().Raw(`SELECT COUNT(*) FROM ee_api_tokens_archive WHERE namespace=? AND name='archived'`, ns.Name).Scan(&archived)
+	if archived != 1 {
+		t.Errorf("archived tokens = %v, want %v", archived, 1)
+	}
+
+	purged, err = datasql.New().With(db.Conn()).APITokens().PurgeExpired(ctx, time.Now(), false)
+	if err != nil {
+		t.Fatalf("APITokens().PurgeExpired() error = %v", err)
+	}
+	if purged != 1 {
+		t.Errorf("APITokens().PurgeExpired() returned %v, want %v", purged, 1)
+	}
+	l, _, err := datasql.New().With(db.Conn()).APITokens().List(ctx, ns.Name, datastore.APITokensFilter{}, datastore.ListOptions{})
+	if err != nil {
+		t.Fatalf("APITokens().List() error = %v", err)
+	}
+	if len(l) != 1 || l[0].Name != "valid" {
+		t.Errorf("APITokens().List() returned %v, want only the valid token", l)
+	}
+}
+
+func Test_APITokensExpiring(t *testing.T) {
+	ctx := context.Background()
+
+	db, ns, err := database.NewTestDBWithNamespace(t, uuid.NewString())
+	if err != nil {
+		t.Fatalf("unepxected NewTestDBWithNamespace() error = %v", err)
+	}
+	if res := db.Conn().Exec(datasql.Schema); res.Error != nil {
+		t.Fatalf("unepxected exec db_schema error = %v", res.Error)
+	}
+
+	for name, lifeSeconds := range map[string]int{"expired": -60, "soon": 3600, "later": 3600 * 24 * 30} {
+		_, err = datasql.New().With(db.Conn()).APITokens().Create(ctx, &datastore.APIToken{
+			Name:      name,
+			Namespace: ns.Name,
+			Format:    datastore.APITokenFormatUUID,
+			Hash:      uuid.New(),
+			ExpiredAt: expiresIn(lifeSeconds),
+		})
+		if err != nil {
+			t.Fatalf("APITokens().Create() error = %v", err)
+		}
+	}
+
+	l, err := datasql.New().With(db.Conn()).APITokens().ListExpiring(ctx, time.Now().Add(time.Hour*24))
+	if err != nil {
+		t.Fatalf("APITokens().ListExpiring() error = %v", err)
+	}
+	if len(l) != 1 || l[0].Name != "soon" {
+		t.Fatalf("APITokens().ListExpiring() returned %v, want only the soon token", l)
+	}
+
+	err = datasql.New().With(db.Conn()).APITokens().SetExpiryNotified(ctx, ns.Name, "soon")
+	if err != nil {
+		t.Fatalf("APITokens().SetExpiryNotified() error = %v", err)
+	}
+	l, err = datasql.New().With(db.Conn()).APITokens().ListExpiring(ctx, time.Now().Add(time.Hour*24))
+	if err != nil {
+		t.Fatalf("APITokens().ListExpiring() error = %v", err)
+	}
+	if len(l) != 0 {
+		t.Errorf("APITokens().ListExpiring() returned %v, want none after notifying", l)
+	}
+
+	notified, err := datasql.New().With(db.Conn()).SetExpiryNotified(ctx, "license:"+ns.Name)
+	if err != nil || !notified {
+		t.Fatalf("SetExpiryNotified() = %v, %v, want true", notified, err)
+	}
+	notified, err = datasql.New().With(db.Conn()).SetExpiryNotified(ctx, "license:"+ns.Name)
+	if err != nil || notified {
+		t.Errorf("SetExpiryNotified() = %v, %v, want false for a second time", notified, err)
+	}
+}
@@ -0,0 +1,90 @@
+package datasql
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/direktiv/direktiv/direktiv-ee/pkg/datastore"
+	"gorm.io/gorm"
+)
+
+type authFailuresStore struct {
+	db *gorm.DB
+}
+
+func (s *authFailuresStore) Get(ctx context.Context, key string) (_ *datastore.AuthFailure, err error) {
+	ctx, span := startSpan(ctx, "AuthFailures.Get")
+	defer func() { endSpan(span, err) }()
+
+	scan := &datastore.AuthFailure{}
+	res := s.db.WithContext(ctx).Raw(`
+							SELECT key, failures, locked_until, last_failure_at
+							FROM ee_auth_failures
+							WHERE key=?`,
+		key).
+		First(scan)
+	if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+		return nil, datastore.ErrNotFound
+	}
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	return scan, nil
+}
+
+func (s *authFailuresStore) Record(ctx context.Context, key string, resetBefore time.Time) (_ *datastore.AuthFailure, err error) {
+	ctx, span := startSpan(ctx, "AuthFailures.Record")
+	defer func() { endSpan(span, err) }()
+
+	if key == "" {
+		return nil, datastore.InvalidArgumentError{"key": "is required"}
+	}
+
+	// Concurrent failures of the same key are counted atomically by the upsert.
+	scan := &datastore.AuthFailure{}
+	res := s.db.WithContext(ctx).Raw(`
+							INSERT INTO ee_auth_failures(key, failures, last_failure_at) VALUES(?, 1, CURRENT_TIMESTAMP)
+							ON CONFLICT (key) DO UPDATE SET
+								failures = CASE WHEN ee_auth_failures.last_failure_at < ? THEN 1
+									ELSE ee_auth_failures.failures + 1 END,
+								last_failure_at = CURRENT_TIMESTAMP
+							RETURNING key, failures, locked_until, last_failure_at`,
+		key, resetBefore).
+		Scan(scan)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	return scan, nil
+}
+
+func (s *authFailuresStore) Lock(ctx context.Context, key string, until time.Time) (err error) {
+	ctx, span := startSpan(ctx, "AuthFailures.Lock")
+	defer func() { endSpan(span, err) }()
+
+	res := s.db.WithContext(ctx).Exec(`UPDATE ee_auth_failures SET locked_until=? WHERE key=?`, until, key)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected != 1 {
+		return datastore.ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *authFailuresStore) Purge(ctx context.Context, before time.Time) (_ int64, err error) {
+	ctx, span := startSpan(ctx, "AuthFailures.Purge")
+	defer func() { endSpan(span, err) }()
+
+	res := s.db.WithContext(ctx).Exec(`DELETE FROM ee_auth_failures
+							WHERE last_failure_at < ? AND (locked_until IS NULL OR locked_until < CURRENT_TIMESTAMP)`,
+		before)
+	if res.Error != nil {
+		return 0, res.Error
+	}
+
+	return res.RowsAffected, nil
+}
@@ -0,0 +1,69 @@
+package datasql_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/direktiv/direktiv/direktiv-ee/pkg/datastore"
+	"github.com/direktiv/direktiv/direktiv-ee/pkg/datastore/datasql"
+	"github.com/direktiv/direktiv/pkg/database"
+	"github.com/google/uuid"
+)
+
+func Test_AuthFailures(t *testing.T) {
+	ctx := context.Background()
+
+	db, _, err := database.NewTestDBWithNamespace(t, uuid.NewString())
+	if err != nil {
+		t.Fatalf("unepxected NewTestDBWithNamespace() error = %v", err)
+	}
+	if res := db.Conn().Exec(datasql.Schema); res.Error != nil {
+		t.Fatalf("unepxected exec db_schema error = %v", res.Error)
+	}
+	store := datasql.New().With(db.Conn()).AuthFailures()
+
+	_, err = store.Get(ctx, "ip:10.0.0.1")
+	if !errors.Is(err, datastore.ErrNotFound) {
+		t.Errorf("AuthFailures().Get() error = %v, wantErr %v", err, datastore.ErrNotFound)
+	}
+
+	for i := 1; i <= 3; i++ {
+		f, err := store.Record(ctx, "ip:10.0.0.1", time.Now().Add(-time.Hour))
+		if err != nil {
+			t.Fatalf("AuthFailures().Record() error = %v", err)
+		}
+		if f.Failures != i {
+			t.Errorf("AuthFailures().Record() failures = %d, want %d", f.Failures, i)
+		}
+	}
+	// Failures before resetBefore are forgotten.
+	f, err := store.Record(ctx, "ip:10.0.0.1", time.Now().Add(time.Hour))
+	if err != nil || f.Failures != 1 {
+		t.Errorf("AuthFailures().Record() = %v, %v, want a single failure", f, err)
+	}
+
+	until := time.Now().Add(time.Minute)
+	if err := store.Lock(ctx, "ip:10.0.0.1", until); err != nil {
+		t.Fatalf("AuthFailures().Lock() error = %v", err)
+	}
+	if err := store.Lock(ctx, "ip:10.0.0.2", until); !errors.Is(err, datastore.ErrNotFound) {
+		t.Errorf("AuthFailures().Lock() error = %v, wantErr %v", err, datastore.ErrNotFound)
+	}
+	f, err = store.Get(ctx, "ip:10.0.0.1")
+	if err != nil || f.LockedUntil == nil || f.LockedUntil.Sub(until).Abs() > time.Millisecond {
+		t.Errorf("AuthFailures().Get() = %v, %v, want locked until %v", f, err, until)
+	}
+
+	// Locked keys are kept by purges.
+	if n, err := store.Purge(ctx, time.Now().Add(time.Hour)); err != nil || n != 0 {
+		t.Errorf("AuthFailures().Purge() = %d, %v, want 0", n, err)
+	}
+	if _, err := store.Record(ctx, "token:abc", time.Now()); err != nil {
+		t.Fatalf("AuthFailures().Record() error = %v", err)
+	}
+	if n, err := store.Purge(ctx, time.Now().Add(time.Hour)); err != nil || n != 1 {
+		t.Errorf("AuthFailures().Purge() = %d, %v, want 1", n, err)
+	}
+}
@@ -0,0 +1,140 @@
+package datasql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/direktiv/direktiv/direktiv-ee/pkg/datastore"
+	"gorm.io/gorm"
+)
+
+type certificateBindingsStore struct {
+	db *gorm.DB
+}
+
+const certificateBindingsSelect = `
+							SELECT name, namespace, description, subject, uri, fingerprint, roles,
+							COALESCE(service_account, '') AS service_account, created_at, updated_at
+							FROM ee_certificate_bindings`
+
+func (s *certificateBindingsStore) Create(ctx context.Context, binding *datastore.CertificateBinding) (_ *datastore.CertificateBinding, err error) {
+	ctx, span := startSpan(ctx, "CertificateBindings.Create")
+	defer func() { endSpan(span, err) }()
+
+	if binding == nil {
+		return nil, datastore.InvalidArgumentError{"binding": "is nil"}
+	}
+	vErrs := datastore.InvalidArgumentError{}
+	if binding.Namespace == "" {
+		vErrs["namespace"] = "is required"
+	}
+	if binding.Name == "" {
+		vErrs["name"] = "is required"
+	}
+	var invalid datastore.InvalidArgumentError
+	if errors.As(binding.Validate(), &invalid) {
+		for field, msg := range invalid {
+			vErrs[field] = msg
+		}
+	}
+	if len(vErrs) > 0 {
+		return nil, vErrs
+	}
+	missing, err := missingRoles(ctx, s.db, binding.Namespace, binding.Roles)
+	if err != nil {
+		return nil, err
+	}
+	if len(missing) > 0 {
+		return nil, datastore.InvalidArgumentError{
+			"roles": fmt.Sprintf("roles don't exist: '%s'", strings.Join(missing, "', '")),
+		}
+	}
+
+	res := s.db.WithContext(ctx).Exec(`
+							INSERT INTO ee_certificate_bindings(name, namespace, description, subject, uri, fingerprint,
+								roles, service_account)
+							VALUES(?, ?, ?, ?, ?, ?, ?, NULLIF(?, ''));
+							`, binding.Name, binding.Namespace, binding.Description, binding.Subject, binding.URI,
+		binding.Fingerprint, binding.Roles, binding.ServiceAccount)
+
+	if res.Error != nil && strings.Contains(res.Error.Error(), "duplicate key") {
+		return nil, datastore.ErrDuplication
+	}
+	if res.Error != nil && strings.Contains(res.Error.Error(), "fk_ee_service_accounts_ee_certificate_bindings") {
+		return nil, datastore.InvalidArgumentError{
+			"serviceAccount": fmt.Sprintf("service account '%s' doesn't exist", binding.ServiceAccount),
+		}
+	}
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected != 1 {
+		return nil, fmt.Errorf("unexpected ee_certificate_bindings insert count, got: %d, want: %d", res.RowsAffected, 1)
+	}
+
+	return s.Get(ctx, binding.Namespace, binding.Name)
+}
+
+func (s *certificateBindingsStore) Get(ctx context.Context, namespace, name string) (_ *datastore.CertificateBinding, err error) {
+	ctx, span := startSpan(ctx, "CertificateBindings.Get", namespaceAttr(namespace))
+	defer func() { endSpan(span, err) }()
+
+	scan := &datastore.CertificateBinding{}
+	res := s.db.WithContext(ctx).Raw(certificateBindingsSelect+` WHERE name=? AND namespace=?`, name, namespace).
+		First(scan)
+	if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+		return nil, datastore.ErrNotFound
+	}
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	return scan, nil
+}
+
+func (s *certificateBindingsStore) Delete(ctx context.Context, namespace, name string) (err error) {
+	ctx, span := startSpan(ctx, "CertificateBindings.Delete", namespaceAttr(namespace))
+	defer func() { endSpan(span, err) }()
+
+	res := s.db.WithContext(ctx).Exec(`DELETE FROM ee_certificate_bindings WHERE name=? AND namespace=?`, name, namespace)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected != 1 {
+		return datastore.ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *certificateBindingsStore) List(ctx context.Context, namespace string) (_ []*datastore.CertificateBinding, err error) {
+	ctx, span := startSpan(ctx, "CertificateBindings.List", namespaceAttr(namespace))
+	defer func() { endSpan(span, err) }()
+
+	var list []*datastore.CertificateBinding
+	res := s.db.WithContext(ctx).Raw(certificateBindingsSelect+` WHERE namespace=? ORDER BY created_at ASC`, namespace).
+		Find(&list)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	return list, nil
+}
+
+func (s *certificateBindingsStore) ListAll(ctx context.Context) (_ []*datastore.CertificateBinding, err error) {
+	ctx, span := startSpan(ctx, "CertificateBindings.ListAll")
+	defer func() { endSpan(span, err) }()
+
+	var list []*datastore.CertificateBinding
+	res := s.db.WithContext(ctx).Raw(certificateBindingsSelect + ` ORDER BY namespace ASC, created_at ASC`).
+		Find(&list)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	return list, nil
+}
+
+var _ datastore.CertificateBindingsStore = &certificateBindingsStore{}
@@ -0,0 +1,127 @@
+package datasql_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/direktiv/direktiv/direktiv-ee/pkg/datastore"
+	"github.com/direktiv/direktiv/direktiv-ee/pkg/datastore/datasql"
+	"github.com/direktiv/direktiv/pkg/database"
+	"github.com/google/uuid"
+)
+
+func Test_CertificateBindings(t *testing.T) {
+	ctx := context.Background()
+
+	db, ns, err := database.NewTestDBWithNamespace(t, uuid.NewString())
+	if err != nil {
+		t.Fatalf("unepxected NewTestDBWithNamespace() error = %v", err)
+	}
+	if res := db.Conn().Exec(datasql.Schema); res.Error != nil {
+		t.Fatalf("unepxected exec db_schema error = %v", res.Error)
+	}
+	store := datasql.New().With(db.Conn()).CertificateBindings()
+
+	_, err = store.Get(ctx, ns.Name, "deploy")
+	if !errors.Is(err, datastore.ErrNotFound) {
+		t.Errorf("CertificateBindings().Get() error = %v, wantErr %v", err, datastore.ErrNotFound)
+	}
+
+	_, err = store.Create(ctx, &datastore.CertificateBinding{
+		Name:      "deploy",
+		Namespace: ns.Name,
+		Roles:     datastore.RoleRefs{"r1"},
+	})
+	var vErrs datastore.InvalidArgumentError
+	if !errors.As(err, &vErrs) || vErrs["subject"] == "" {
+		t.Fatalf("CertificateBindings().Create() error = %v, want subject validation error", err)
+	}
+	_, err = store.Create(ctx, &datastore.CertificateBinding{
+		Name:           "deploy",
+		Namespace:      ns.Name,
+		URI:            "spiffe://example.org/deploy",
+		ServiceAccount: "missing",
+	})
+	if !errors.As(err, &vErrs) || vErrs["serviceAccount"] == "" {
+		t.Fatalf("CertificateBindings().Create() error = %v, want serviceAccount validation error", err)
+	}
+
+	_, err = datasql.New().With(db.Conn()).Roles().Create(ctx, &datastore.Role{
+		Name:      "r1",
+		Namespace: ns.Name,
+		Permissions: datastore.Permissions{
+			{"", "secrets", "GET"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Roles().Create() error = %v", err)
+	}
+	_, err = datasql.New().With(db.Conn()).ServiceAccounts().Create(ctx, &datastore.ServiceAccount{
+		Name:      "ci",
+		Namespace: ns.Name,
+		Roles:     datastore.RoleRefs{"r1"},
+	})
+	if err != nil {
+		t.Fatalf("ServiceAccounts().Create() error = %v", err)
+	}
+
+	fingerprint := strings.Repeat("ab", 32)
+	b1, err := store.Create(ctx, &datastore.CertificateBinding{
+		Name:        "deploy",
+		Namespace:   ns.Name,
+		Subject:     "CN=deploy,O=Acme",
+		Fingerprint: fingerprint,
+		Roles:       datastore.RoleRefs{"r1"},
+	})
+	if err != nil {
+		t.Fatalf("CertificateBindings().Create() error = %v", err)
+	}
+	if b1.Subject != "CN=deploy,O=Acme" || b1.Fingerprint != fingerprint || b1.Roles.String() != `["r1"]` {
+		t.Errorf("CertificateBindings().Create() = %+v", b1)
+	}
+	_, err = store.Create(ctx, b1)
+	if !errors.Is(err, datastore.ErrDuplication) {
+		t.Errorf("CertificateBindings().Create() error = %v, wantErr %v", err, datastore.ErrDuplication)
+	}
+	b2, err := store.Create(ctx, &datastore.CertificateBinding{
+		Name:           "ci",
+		Namespace:      ns.Name,
+		URI:            "spiffe://example.org/ci",
+		ServiceAccount: "ci",
+	})
+	if err != nil {
+		t.Fatalf("CertificateBindings().Create() error = %v", err)
+	}
+	if b2.ServiceAccount != "ci" || len(b2.Roles) != 0 {
+		t.Errorf("CertificateBindings().Create() = %+v", b2)
+	}
+
+	list, err := store.ListAll(ctx)
+	if err != nil {
+		t.Fatalf("CertificateBindings().ListAll() error = %v", err)
+	}
+	if len(list) != 2 {
+		t.Errorf("CertificateBindings().ListAll() returned %d entries, want 2", len(list))
+	}
+
+	// Deleting the service account removes its bindings.
+	if err := datasql.New().With(db.Conn()).ServiceAccounts().Delete(ctx, ns.Name, "ci"); err != nil {
+		t.Fatalf("ServiceAccounts().Delete() error = %v", err)
+	}
+	list, err = store.List(ctx, ns.Name)
+	if err != nil {
+		t.Fatalf("CertificateBindings().List() error = %v", err)
+	}
+	if len(list) != 1 || list[0].Name != "deploy" {
+		t.Errorf("CertificateBindings().List() = %v, want only deploy", list)
+	}
+
+	if err := store.Delete(ctx, ns.Name, "deploy"); err != nil {
+		t.Fatalf("CertificateBindings().Delete() error = %v", err)
+	}
+	if err := store.Delete(ctx, ns.Name, "deploy"); !errors.Is(err, datastore.ErrNotFound) {
+		t.Errorf("CertificateBindings().Delete() error = %v, wantErr %v", err, datastore.ErrNotFound)
+	}
+}
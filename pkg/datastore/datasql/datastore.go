@@ -1,6 +1,7 @@
 package datasql
 
 import (
+	"context"
 	_ "embed"
 	"fmt"
 
@@ -38,6 +39,55 @@ func (s *storeInner) APITokens() datastore.APITokensStore {
 	return &apiTokensStore{db: s.db}
 }
 
+func (s *storeInner) APITokenPolicies() datastore.APITokenPoliciesStore {
+	return &apiTokenPoliciesStore{db: s.db}
+}
+
 func (s *storeInner) Roles() datastore.RolesStore {
 	return &rolesStore{db: s.db}
 }
+
+func (s *storeInner) RoleAssignments() datastore.RoleAssignmentsStore {
+	return &roleAssignmentsStore{db: s.db}
+}
+
+func (s *storeInner) ServiceAccounts() datastore.ServiceAccountsStore {
+	return &serviceAccountsStore{db: s.db}
+}
+
+func (s *storeInner) AuthFailures() datastore.AuthFailuresStore {
+	return &authFailuresStore{db: s.db}
+}
+
+func (s *storeInner) Quotas() datastore.QuotasStore {
+	return &quotasStore{db: s.db}
+}
+
+func (s *storeInner) CertificateBindings() datastore.CertificateBindingsStore {
+	return &certificateBindingsStore{db: s.db}
+}
+
+func (s *storeInner) TryLock(ctx context.Context, key int64) (_ bool, err error) {
+	ctx, span := startSpan(ctx, "Store.TryLock")
+	defer func() { endSpan(span, err) }()
+
+	var locked bool
+	res := s.db.WithContext(ctx).Raw(`SELECT pg_try_advisory_xact_lock(?)`, key).Scan(&locked)
+	if res.Error != nil {
+		return false, res.Error
+	}
+
+	return locked, nil
+}
+
+func (s *storeInner) SetExpiryNotified(ctx context.Context, subject string) (_ bool, err error) {
+	ctx, span := startSpan(ctx, "Store.SetExpiryNotified")
+	defer func() { endSpan(span, err) }()
+
+	res := s.db.WithContext(ctx).Exec(`INSERT INTO ee_expiry_notifications(subject) VALUES(?) ON CONFLICT DO NOTHING`, subject)
+	if res.Error != nil {
+		return false, res.Error
+	}
+
+	return res.RowsAffected == 1, nil
+}
@@ -11,6 +11,18 @@ CREATE TABLE IF NOT EXISTS "ee_roles" (
     FOREIGN KEY ("namespace") REFERENCES "namespaces"("name") ON DELETE CASCADE ON UPDATE CASCADE
 );
 
+CREATE TABLE IF NOT EXISTS "ee_service_accounts" (
+    "name" text NOT NULL,
+    "namespace" text NOT NULL,
+    "description" text NOT NULL,
+    "roles" text NOT NULL,
+    "created_at" timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    "updated_at" timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY ("name", "namespace"),
+    CONSTRAINT "fk_namespaces_ee_service_accounts"
+    FOREIGN KEY ("namespace") REFERENCES "namespaces"("name") ON DELETE CASCADE ON UPDATE CASCADE
+);
+
 CREATE TABLE IF NOT EXISTS "ee_api_tokens" (
     "name" text NOT NULL,
     "namespace" text NOT NULL,
@@ -25,3 +37,224 @@ CREATE TABLE IF NOT EXISTS "ee_api_tokens" (
     CONSTRAINT "fk_namespaces_ee_api_tokens"
     FOREIGN KEY ("namespace") REFERENCES "namespaces"("name") ON DELETE CASCADE ON UPDATE CASCADE
 );
+
+-- API tokens can be credentials of a service account, deleting the account revokes all of them.
+ALTER TABLE "ee_api_tokens" ADD COLUMN IF NOT EXISTS "service_account" text;
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'fk_ee_service_accounts_ee_api_tokens') THEN
+        ALTER TABLE "ee_api_tokens" ADD CONSTRAINT "fk_ee_service_accounts_ee_api_tokens"
+        FOREIGN KEY ("service_account", "namespace") REFERENCES "ee_service_accounts"("name", "namespace") ON DELETE CASCADE ON UPDATE CASCADE;
+    END IF;
+END $$;
+
+-- Tokens can be issued as signed JWTs, for those the hash column holds the jti claim.
+ALTER TABLE "ee_api_tokens" ADD COLUMN IF NOT EXISTS "format" text NOT NULL DEFAULT 'uuid';
+ALTER TABLE "ee_api_tokens" ADD COLUMN IF NOT EXISTS "roles" text NOT NULL DEFAULT 'null';
+
+-- Signed tokens are verified offline, so every deleted jwt token is kept here until it expires.
+CREATE TABLE IF NOT EXISTS "ee_api_token_revocations" (
+    "jti" uuid NOT NULL,
+    "namespace" text NOT NULL,
+    "expired_at" timestamptz NOT NULL,
+    "created_at" timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY ("jti")
+);
+
+CREATE OR REPLACE FUNCTION ee_revoke_api_token() RETURNS trigger AS $$
+BEGIN
+    IF OLD.format = 'jwt' AND COALESCE(OLD.expired_at, 'infinity') > NOW() THEN
+        INSERT INTO ee_api_token_revocations(jti, namespace, expired_at)
+        VALUES (OLD.hash, OLD.namespace, COALESCE(OLD.expired_at, 'infinity'))
+        ON CONFLICT DO NOTHING;
+    END IF;
+    RETURN OLD;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS "ee_revoke_api_token" ON "ee_api_tokens";
+CREATE TRIGGER "ee_revoke_api_token" AFTER DELETE ON "ee_api_tokens"
+FOR EACH ROW EXECUTE FUNCTION ee_revoke_api_token();
+
+-- Tokens in the secret format are found by their public lookup id and verified against a salted digest,
+-- the hash column is only used by legacy uuid tokens and jwt tokens.
+ALTER TABLE "ee_api_tokens" ALTER COLUMN "hash" DROP NOT NULL;
+ALTER TABLE "ee_api_tokens" ADD COLUMN IF NOT EXISTS "lookup_id" text;
+ALTER TABLE "ee_api_tokens" ADD COLUMN IF NOT EXISTS "salt" text;
+ALTER TABLE "ee_api_tokens" ADD COLUMN IF NOT EXISTS "digest" text;
+CREATE UNIQUE INDEX IF NOT EXISTS "ee_api_tokens_lookup_id" ON "ee_api_tokens" ("lookup_id");
+
+-- Usage of tokens is written in batches, see APITokensStore.RecordUsage.
+ALTER TABLE "ee_api_tokens" ADD COLUMN IF NOT EXISTS "last_used_at" timestamptz;
+ALTER TABLE "ee_api_tokens" ADD COLUMN IF NOT EXISTS "last_used_ip" text;
+ALTER TABLE "ee_api_tokens" ADD COLUMN IF NOT EXISTS "last_used_user_agent" text;
+ALTER TABLE "ee_api_tokens" ADD COLUMN IF NOT EXISTS "request_count" bigint NOT NULL DEFAULT 0;
+
+-- Tokens purged by the janitor in archive mode are kept here for auditing, without their credentials.
+CREATE TABLE IF NOT EXISTS "ee_api_tokens_archive" (
+    "name" text NOT NULL,
+    "namespace" text NOT NULL,
+    "description" text NOT NULL,
+    "format" text NOT NULL,
+    "permissions" text NOT NULL,
+    "roles" text NOT NULL,
+    "service_account" text,
+    "last_used_at" timestamptz,
+    "request_count" bigint NOT NULL,
+    "expired_at" timestamptz NOT NULL,
+    "created_at" timestamptz NOT NULL,
+    "archived_at" timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS "ee_api_tokens_expired_at" ON "ee_api_tokens" ("expired_at");
+
+-- Expiry notifications are sent once, for tokens the time is kept on the token and for everything
+-- else, like the license, by subject.
+ALTER TABLE "ee_api_tokens" ADD COLUMN IF NOT EXISTS "expiry_notified_at" timestamptz;
+CREATE TABLE IF NOT EXISTS "ee_expiry_notifications" (
+    "subject" text NOT NULL,
+    "created_at" timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY ("subject")
+);
+
+-- Tokens without expiry are only allowed by a namespace token policy, see ee_api_token_policies.
+ALTER TABLE "ee_api_tokens" ALTER COLUMN "expired_at" DROP NOT NULL;
+CREATE TABLE IF NOT EXISTS "ee_api_token_policies" (
+    "namespace" text NOT NULL,
+    "max_lifetime_seconds" bigint NOT NULL,
+    "default_lifetime_seconds" bigint NOT NULL,
+    "permissions_ceiling" text NOT NULL,
+    "allow_non_expiring" boolean NOT NULL,
+    "created_at" timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    "updated_at" timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY ("namespace"),
+    CONSTRAINT "fk_namespaces_ee_api_token_policies"
+    FOREIGN KEY ("namespace") REFERENCES "namespaces"("name") ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+-- Roles and token policies declared in the namespace tree are managed by their file and read-only
+-- in the api, managed_by holds the path of the file and is empty for everything else.
+ALTER TABLE "ee_roles" ADD COLUMN IF NOT EXISTS "managed_by" text NOT NULL DEFAULT '';
+ALTER TABLE "ee_api_token_policies" ADD COLUMN IF NOT EXISTS "managed_by" text NOT NULL DEFAULT '';
+
+-- Role updates can be conditional on the version, see RolesStore.Update.
+ALTER TABLE "ee_roles" ADD COLUMN IF NOT EXISTS "version" bigint NOT NULL DEFAULT 1;
+
+-- Every change of a role is kept as a revision, numbered by the version of the role. Roles from
+-- before revisions existed start with their current definition.
+ALTER TABLE "ee_roles" ADD COLUMN IF NOT EXISTS "updated_by" text NOT NULL DEFAULT '';
+CREATE TABLE IF NOT EXISTS "ee_role_revisions" (
+    "namespace" text NOT NULL,
+    "role_name" text NOT NULL,
+    "revision" bigint NOT NULL,
+    "description" text NOT NULL,
+    "oidc_groups" text NOT NULL,
+    "permissions" text NOT NULL,
+    "author" text NOT NULL,
+    "created_at" timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY ("namespace", "role_name", "revision"),
+    CONSTRAINT "fk_namespaces_ee_role_revisions"
+    FOREIGN KEY ("namespace") REFERENCES "namespaces"("name") ON DELETE CASCADE ON UPDATE CASCADE
+);
+INSERT INTO "ee_role_revisions"("namespace", "role_name", "revision", "description", "oidc_groups", "permissions", "author", "created_at")
+    SELECT "namespace", "name", "version", "description", "oidc_groups", "permissions", "updated_by", "updated_at" FROM "ee_roles"
+    ON CONFLICT DO NOTHING;
+
+-- Time-bound assignments of a role to an oidc group or user, see datastore.RoleAssignment. Expired
+-- assignments are kept for auditing.
+CREATE TABLE IF NOT EXISTS "ee_role_assignments" (
+    "id" uuid NOT NULL,
+    "namespace" text NOT NULL,
+    "role_name" text NOT NULL,
+    "assignee_type" text NOT NULL,
+    "assignee" text NOT NULL,
+    "justification" text NOT NULL,
+    "starts_at" timestamptz NOT NULL,
+    "ends_at" timestamptz NOT NULL,
+    "status" text NOT NULL,
+    "requested_by" text NOT NULL,
+    "decided_by" text NOT NULL DEFAULT '',
+    "decided_at" timestamptz,
+    "created_at" timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_namespaces_ee_role_assignments"
+    FOREIGN KEY ("namespace") REFERENCES "namespaces"("name") ON DELETE CASCADE ON UPDATE CASCADE
+);
+CREATE INDEX IF NOT EXISTS "ee_role_assignments_ends_at" ON "ee_role_assignments" ("ends_at");
+
+-- Roles can be bound to individual oidc users, see datastore.RoleUsers.
+ALTER TABLE "ee_roles" ADD COLUMN IF NOT EXISTS "users" text NOT NULL DEFAULT '';
+ALTER TABLE "ee_role_revisions" ADD COLUMN IF NOT EXISTS "users" text NOT NULL DEFAULT '';
+
+-- Failed authentications per client ip, see datastore.AuthFailure. Only used
+-- when the auth limiter of the api is backed by postgres.
+CREATE TABLE IF NOT EXISTS "ee_auth_failures" (
+    "key" text NOT NULL,
+    "failures" integer NOT NULL,
+    "locked_until" timestamptz,
+    "last_failure_at" timestamptz NOT NULL,
+    PRIMARY KEY ("key")
+);
+CREATE INDEX IF NOT EXISTS "ee_auth_failures_last_failure_at" ON "ee_auth_failures" ("last_failure_at");
+
+-- Requests per minute allowed in a namespace, see datastore.Quota. Zero means unlimited.
+CREATE TABLE IF NOT EXISTS "ee_quotas" (
+    "namespace" text NOT NULL,
+    "api_calls" integer NOT NULL,
+    "instance_starts" integer NOT NULL,
+    "event_broadcasts" integer NOT NULL,
+    "api_token_api_calls" integer NOT NULL,
+    "api_token_instance_starts" integer NOT NULL,
+    "api_token_event_broadcasts" integer NOT NULL,
+    "created_at" timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    "updated_at" timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY ("namespace"),
+    CONSTRAINT "fk_namespaces_ee_quotas"
+    FOREIGN KEY ("namespace") REFERENCES "namespaces"("name") ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+-- Requests per namespace, api token and minute, api_token is empty for the whole namespace. Only used
+-- when the quota counter of the api is backed by postgres. Usage outlives its namespace for billing
+-- and is purged after the retention instead.
+CREATE TABLE IF NOT EXISTS "ee_quota_usage" (
+    "namespace" text NOT NULL,
+    "api_token" text NOT NULL,
+    "kind" text NOT NULL,
+    "minute" timestamptz NOT NULL,
+    "requests" bigint NOT NULL,
+    "rejected" bigint NOT NULL DEFAULT 0,
+    PRIMARY KEY ("namespace", "api_token", "kind", "minute")
+);
+CREATE INDEX IF NOT EXISTS "ee_quota_usage_minute" ON "ee_quota_usage" ("minute");
+
+-- Client certificates bound to roles or a service account of a namespace, see
+-- datastore.CertificateBinding. Empty subject, uri and fingerprint columns are not matched.
+CREATE TABLE IF NOT EXISTS "ee_certificate_bindings" (
+    "name" text NOT NULL,
+    "namespace" text NOT NULL,
+    "description" text NOT NULL,
+    "subject" text NOT NULL,
+    "uri" text NOT NULL,
+    "fingerprint" text NOT NULL,
+    "roles" text NOT NULL,
+    "service_account" text,
+    "created_at" timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    "updated_at" timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY ("name", "namespace"),
+    CONSTRAINT "fk_namespaces_ee_certificate_bindings"
+    FOREIGN KEY ("namespace") REFERENCES "namespaces"("name") ON DELETE CASCADE ON UPDATE CASCADE,
+    CONSTRAINT "fk_ee_service_accounts_ee_certificate_bindings"
+    FOREIGN KEY ("service_account", "namespace") REFERENCES "ee_service_accounts"("name", "namespace") ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+-- Requests of tokens per day, they make up APIToken.RecentRequestCount and are purged by the janitor
+-- after datastore.APITokenRecentDays.
+CREATE TABLE IF NOT EXISTS "ee_api_token_requests" (
+    "name" text NOT NULL,
+    "namespace" text NOT NULL,
+    "day" date NOT NULL,
+    "requests" bigint NOT NULL,
+    PRIMARY KEY ("namespace", "name", "day"),
+    CONSTRAINT "fk_ee_api_tokens_ee_api_token_requests"
+    FOREIGN KEY ("name", "namespace") REFERENCES "ee_api_tokens"("name", "namespace") ON DELETE CASCADE ON UPDATE CASCADE
+);
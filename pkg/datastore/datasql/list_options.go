@@ -0,0 +1,95 @@
+package datasql
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/direktiv/direktiv/direktiv-ee/pkg/datastore"
+)
+
+// sortColumn is the sql expression and type of a sort key.
+type sortColumn struct {
+	expr string
+	typ  string
+}
+
+var (
+	sortByCreated = sortColumn{expr: "created_at", typ: "timestamptz"}
+	sortByExpiry  = sortColumn{expr: "COALESCE(expired_at, 'infinity')", typ: "timestamptz"}
+)
+
+// pageQuery appends the cursor condition, ordering and limit of opts to a query that ends with a
+// where clause. Sorting always breaks ties by name, so that cursors are stable.
+func pageQuery(query string, args []any, opts datastore.ListOptions, columns map[string]sortColumn,
+) (string, []any, error) {
+	if opts.SortBy == "" {
+		opts.SortBy = datastore.SortByCreated
+	}
+	if opts.Limit < 0 {
+		return "", nil, datastore.InvalidArgumentError{"limit": "must not be negative"}
+	}
+	column, ok := columns[opts.SortBy]
+	if opts.SortBy != datastore.SortByName && !ok {
+		return "", nil, datastore.InvalidArgumentError{"sort": fmt.Sprintf("invalid sort: '%s'", opts.SortBy)}
+	}
+	cursor, err := datastore.DecodeCursor(opts)
+	if err != nil {
+		return "", nil, err
+	}
+
+	op, order := ">", "ASC"
+	if opts.Desc {
+		op, order = "<", "DESC"
+	}
+	if opts.SortBy == datastore.SortByName {
+		if cursor != nil {
+			query += fmt.Sprintf(` AND name %s ?`, op)
+			args = append(args, cursor.Name)
+		}
+		query += fmt.Sprintf(` ORDER BY name %s`, order)
+	} else {
+		if cursor != nil {
+			query += fmt.Sprintf(` AND (%s, name) %s (?::%s, ?)`, column.expr, op, column.typ)
+			args = append(args, cursor.Value, cursor.Name)
+		}
+		query += fmt.Sprintf(` ORDER BY %s %s, name %s`, column.expr, order, order)
+	}
+	// One more row tells if there is a next page.
+	if opts.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, opts.Limit+1)
+	}
+
+	return query, args, nil
+}
+
+// nextPage trims the extra row fetched by pageQuery and returns the cursor of the next page, which
+// is empty on the last page.
+func nextPage[T any](list []T, opts datastore.ListOptions, sortValue func(T) string, name func(T) string) ([]T, string) {
+	if opts.Limit == 0 || len(list) <= opts.Limit {
+		return list, ""
+	}
+	list = list[:opts.Limit]
+	last := list[len(list)-1]
+	if opts.SortBy == "" {
+		opts.SortBy = datastore.SortByCreated
+	}
+	cursor := &datastore.Cursor{
+		SortBy: opts.SortBy,
+		Desc:   opts.Desc,
+		Name:   name(last),
+	}
+	if opts.SortBy != datastore.SortByName {
+		cursor.Value = sortValue(last)
+	}
+
+	return list, cursor.Encode()
+}
+
+// topicFilter is the jsonb value that a permissions column contains when it has any permission
+// on the given topic.
+func topicFilter(topic string) string {
+	b, _ := json.Marshal([]map[string]string{{"Topic": topic}})
+
+	return string(b)
+}
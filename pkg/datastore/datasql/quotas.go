@@ -0,0 +1,187 @@
+package datasql
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/direktiv/direktiv/direktiv-ee/pkg/datastore"
+	"gorm.io/gorm"
+)
+
+type quotasStore struct {
+	db *gorm.DB
+}
+
+// quotaRow stores the limits in flat columns.
+type quotaRow struct {
+	Namespace               string
+	APICalls                int
+	InstanceStarts          int
+	EventBroadcasts         int
+	APITokenAPICalls        int
+	APITokenInstanceStarts  int
+	APITokenEventBroadcasts int
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (s *quotasStore) Get(ctx context.Context, namespace string) (_ *datastore.Quota, err error) {
+	ctx, span := startSpan(ctx, "Quotas.Get", namespaceAttr(namespace))
+	defer func() { endSpan(span, err) }()
+
+	scan := &quotaRow{}
+	res := s.db.WithContext(ctx).Raw(`
+							SELECT namespace, api_calls, instance_starts, event_broadcasts, api_token_api_calls,
+								api_token_instance_starts, api_token_event_broadcasts, created_at, updated_at
+							FROM ee_quotas
+							WHERE namespace=?`,
+		namespace).
+		First(scan)
+	if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+		return nil, datastore.ErrNotFound
+	}
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	return &datastore.Quota{
+		Namespace: scan.Namespace,
+		Limits: datastore.QuotaLimits{
+			APICalls:        scan.APICalls,
+			InstanceStarts:  scan.InstanceStarts,
+			EventBroadcasts: scan.EventBroadcasts,
+		},
+		APITokenLimits: datastore.QuotaLimits{
+			APICalls:        scan.APITokenAPICalls,
+			InstanceStarts:  scan.APITokenInstanceStarts,
+			EventBroadcasts: scan.APITokenEventBroadcasts,
+		},
+		CreatedAt: scan.CreatedAt,
+		UpdatedAt: scan.UpdatedAt,
+	}, nil
+}
+
+func (s *quotasStore) Set(ctx context.Context, quota *datastore.Quota) (_ *datastore.Quota, err error) {
+	ctx, span := startSpan(ctx, "Quotas.Set")
+	defer func() { endSpan(span, err) }()
+
+	if quota == nil {
+		return nil, datastore.InvalidArgumentError{"quota": "is nil"}
+	}
+	if quota.Namespace == "" {
+		return nil, datastore.InvalidArgumentError{"namespace": "is required"}
+	}
+	if err := quota.Validate(); err != nil {
+		return nil, err
+	}
+
+	res := s.db.WithContext(ctx).Exec(`
+							INSERT INTO ee_quotas(namespace, api_calls, instance_starts, event_broadcasts,
+								api_token_api_calls, api_token_instance_starts, api_token_event_broadcasts)
+							VALUES(?, ?, ?, ?, ?, ?, ?)
+							ON CONFLICT (namespace) DO UPDATE SET
+								api_calls=EXCLUDED.api_calls,
+								instance_starts=EXCLUDED.instance_starts,
+								event_broadcasts=EXCLUDED.event_broadcasts,
+								api_token_api_calls=EXCLUDED.api_token_api_calls,
+								api_token_instance_starts=EXCLUDED.api_token_instance_starts,
+								api_token_event_broadcasts=EXCLUDED.api_token_event_broadcasts,
+								updated_at=CURRENT_TIMESTAMP`,
+		quota.Namespace, quota.Limits.APICalls, quota.Limits.InstanceStarts, quota.Limits.EventBroadcasts,
+		quota.APITokenLimits.APICalls, quota.APITokenLimits.InstanceStarts, quota.APITokenLimits.EventBroadcasts)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	return s.Get(ctx, quota.Namespace)
+}
+
+func (s *quotasStore) Delete(ctx context.Context, namespace string) (err error) {
+	ctx, span := startSpan(ctx, "Quotas.Delete", namespaceAttr(namespace))
+	defer func() { endSpan(span, err) }()
+
+	res := s.db.WithContext(ctx).Exec(`DELETE FROM ee_quotas WHERE namespace=?`, namespace)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected != 1 {
+		return datastore.ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *quotasStore) CountUsage(ctx context.Context, namespace, apiToken, kind string, minute time.Time) (_ int64, err error) {
+	ctx, span := startSpan(ctx, "Quotas.CountUsage", namespaceAttr(namespace))
+	defer func() { endSpan(span, err) }()
+
+	// Concurrent requests of the same minute are counted atomically by the upsert.
+	var requests int64
+	res := s.db.WithContext(ctx).Raw(`
+							INSERT INTO ee_quota_usage(namespace, api_token, kind, minute, requests) VALUES(?, ?, ?, ?, 1)
+							ON CONFLICT (namespace, api_token, kind, minute) DO UPDATE SET
+								requests = ee_quota_usage.requests + 1
+							RETURNING requests`,
+		namespace, apiToken, kind, minute).
+		Scan(&requests)
+	if res.Error != nil {
+		return 0, res.Error
+	}
+
+	return requests, nil
+}
+
+func (s *quotasStore) RejectUsage(ctx context.Context, namespace, apiToken, kind string, minute time.Time) (err error) {
+	ctx, span := startSpan(ctx, "Quotas.RejectUsage", namespaceAttr(namespace))
+	defer func() { endSpan(span, err) }()
+
+	res := s.db.WithContext(ctx).Exec(`
+							UPDATE ee_quota_usage SET rejected = rejected + 1
+							WHERE namespace=? AND api_token=? AND kind=? AND minute=?`,
+		namespace, apiToken, kind, minute)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected != 1 {
+		return datastore.ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *quotasStore) ListUsage(ctx context.Context, namespace string, from, to time.Time) (_ []*datastore.QuotaUsage, err error) {
+	ctx, span := startSpan(ctx, "Quotas.ListUsage", namespaceAttr(namespace))
+	defer func() { endSpan(span, err) }()
+
+	var list []*datastore.QuotaUsage
+	res := s.db.WithContext(ctx).Raw(`
+							SELECT namespace, api_token, kind, date_trunc('hour', minute) AS "start",
+								SUM(requests) AS requests, SUM(rejected) AS rejected
+							FROM ee_quota_usage
+							WHERE namespace=? AND minute >= ? AND minute < ?
+							GROUP BY namespace, api_token, kind, "start"
+							ORDER BY "start" ASC, api_token ASC, kind ASC`,
+		namespace, from, to).
+		Find(&list)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	return list, nil
+}
+
+func (s *quotasStore) PurgeUsage(ctx context.Context, before time.Time) (_ int64, err error) {
+	ctx, span := startSpan(ctx, "Quotas.PurgeUsage")
+	defer func() { endSpan(span, err) }()
+
+	res := s.db.WithContext(ctx).Exec(`DELETE FROM ee_quota_usage WHERE minute < ?`, before)
+	if res.Error != nil {
+		return 0, res.Error
+	}
+
+	return res.RowsAffected, nil
+}
+
+var _ datastore.QuotasStore = &quotasStore{}
@@ -0,0 +1,117 @@
+package datasql_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/direktiv/direktiv/direktiv-ee/pkg/datastore"
+	"github.com/direktiv/direktiv/direktiv-ee/pkg/datastore/datasql"
+	"github.com/direktiv/direktiv/pkg/database"
+	"github.com/google/uuid"
+)
+
+func Test_Quotas(t *testing.T) {
+	ctx := context.Background()
+
+	db, ns, err := database.NewTestDBWithNamespace(t, uuid.NewString())
+	if err != nil {
+		t.Fatalf("unepxected NewTestDBWithNamespace() error = %v", err)
+	}
+	if res := db.Conn().Exec(datasql.Schema); res.Error != nil {
+		t.Fatalf("unepxected exec db_schema error = %v", res.Error)
+	}
+	store := datasql.New().With(db.Conn()).Quotas()
+
+	_, err = store.Get(ctx, ns.Name)
+	if !errors.Is(err, datastore.ErrNotFound) {
+		t.Errorf("Quotas().Get() error = %v, wantErr %v", err, datastore.ErrNotFound)
+	}
+
+	_, err = store.Set(ctx, &datastore.Quota{
+		Namespace:      ns.Name,
+		APITokenLimits: datastore.QuotaLimits{InstanceStarts: -1},
+	})
+	var vErrs datastore.InvalidArgumentError
+	if !errors.As(err, &vErrs) || vErrs["apiTokenLimits.instanceStartsPerMinute"] == "" {
+		t.Fatalf("Quotas().Set() error = %v, want instanceStartsPerMinute validation error", err)
+	}
+
+	want := datastore.Quota{
+		Namespace:      ns.Name,
+		Limits:         datastore.QuotaLimits{APICalls: 600, InstanceStarts: 60},
+		APITokenLimits: datastore.QuotaLimits{APICalls: 100, EventBroadcasts: 10},
+	}
+	quota, err := store.Set(ctx, &want)
+	if err != nil {
+		t.Fatalf("Quotas().Set() error = %v", err)
+	}
+	if quota.Limits != want.Limits || quota.APITokenLimits != want.APITokenLimits {
+		t.Errorf("Quotas().Set() = %+v, want %+v", quota, want)
+	}
+
+	if err := store.Delete(ctx, ns.Name); err != nil {
+		t.Fatalf("Quotas().Delete() error = %v", err)
+	}
+	if err := store.Delete(ctx, ns.Name); !errors.Is(err, datastore.ErrNotFound) {
+		t.Errorf("Quotas().Delete() error = %v, wantErr %v", err, datastore.ErrNotFound)
+	}
+}
+
+func Test_QuotaUsage(t *testing.T) {
+	ctx := context.Background()
+
+	db, ns, err := database.NewTestDBWithNamespace(t, uuid.NewString())
+	if err != nil {
+		t.Fatalf("unepxected NewTestDBWithNamespace() error = %v", err)
+	}
+	if res := db.Conn().Exec(datasql.Schema); res.Error != nil {
+		t.Fatalf("unepxected exec db_schema error = %v", res.Error)
+	}
+	store := datasql.New().With(db.Conn()).Quotas()
+
+	hour := time.Now().UTC().Truncate(time.Hour)
+	for i := int64(1); i <= 3; i++ {
+		got, err := store.CountUsage(ctx, ns.Name, "", datastore.QuotaAPICalls, hour)
+		if err != nil {
+			t.Fatalf("Quotas().CountUsage() error = %v", err)
+		}
+		if got != i {
+			t.Errorf("Quotas().CountUsage() = %d, want %d", got, i)
+		}
+	}
+	if _, err := store.CountUsage(ctx, ns.Name, "", datastore.QuotaAPICalls, hour.Add(time.Minute)); err != nil {
+		t.Fatalf("Quotas().CountUsage() error = %v", err)
+	}
+	if _, err := store.CountUsage(ctx, ns.Name, "ci", datastore.QuotaAPICalls, hour); err != nil {
+		t.Fatalf("Quotas().CountUsage() error = %v", err)
+	}
+	if err := store.RejectUsage(ctx, ns.Name, "", datastore.QuotaAPICalls, hour); err != nil {
+		t.Fatalf("Quotas().RejectUsage() error = %v", err)
+	}
+	err = store.RejectUsage(ctx, ns.Name, "", datastore.QuotaInstanceStarts, hour)
+	if !errors.Is(err, datastore.ErrNotFound) {
+		t.Errorf("Quotas().RejectUsage() error = %v, wantErr %v", err, datastore.ErrNotFound)
+	}
+
+	// Minutes are summed up by hour.
+	list, err := store.ListUsage(ctx, ns.Name, hour, hour.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Quotas().ListUsage() error = %v", err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("Quotas().ListUsage() returned %d entries, want 2", len(list))
+	}
+	if list[0].APIToken != "" || list[0].Requests != 4 || list[0].Rejected != 1 || !list[0].Start.Equal(hour) {
+		t.Errorf("Quotas().ListUsage()[0] = %+v", list[0])
+	}
+	if list[1].APIToken != "ci" || list[1].Requests != 1 {
+		t.Errorf("Quotas().ListUsage()[1] = %+v", list[1])
+	}
+
+	n, err := store.PurgeUsage(ctx, hour.Add(time.Minute))
+	if err != nil || n != 2 {
+		t.Errorf("Quotas().PurgeUsage() = %d, %v, want 2", n, err)
+	}
+}
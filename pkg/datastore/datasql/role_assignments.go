@@ -0,0 +1,198 @@
+package datasql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/direktiv/direktiv/direktiv-ee/pkg/datastore"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type roleAssignmentsStore struct {
+	db *gorm.DB
+}
+
+const roleAssignmentsSelect = `
+							SELECT id, namespace, role_name, assignee_type, assignee, justification, starts_at, ends_at, status,
+							       requested_by, decided_by, decided_at, created_at
+							FROM ee_role_assignments`
+
+//nolint:goconst
+func (s *roleAssignmentsStore) Create(ctx context.Context, assignment *datastore.RoleAssignment) (_ *datastore.RoleAssignment, err error) {
+	ctx, span := startSpan(ctx, "RoleAssignments.Create")
+	defer func() { endSpan(span, err) }()
+
+	vErrs := datastore.InvalidArgumentError{}
+	if assignment == nil {
+		vErrs["assignment"] = "is nil"
+
+		return nil, vErrs
+	}
+	if assignment.Namespace == "" {
+		vErrs["namespace"] = "is required"
+	}
+	if assignment.RoleName == "" {
+		vErrs["role"] = "is required"
+	}
+	switch assignment.AssigneeType {
+	case datastore.AssigneeGroup, datastore.AssigneeUser:
+		if assignment.Assignee == "" {
+			vErrs[assignment.AssigneeType] = "is required"
+		}
+	default:
+		vErrs["assigneeType"] = fmt.Sprintf("invalid assignee type: '%s'", assignment.AssigneeType)
+	}
+	if assignment.Justification == "" {
+		vErrs["justification"] = "is required"
+	}
+	if assignment.EndsAt.IsZero() {
+		vErrs["endsAt"] = "is required"
+	} else if !assignment.EndsAt.After(assignment.StartsAt) {
+		vErrs["endsAt"] = "should be after startsAt"
+	}
+	switch assignment.Status {
+	case datastore.AssignmentPending, datastore.AssignmentApproved:
+	default:
+		vErrs["status"] = fmt.Sprintf("invalid status: '%s'", assignment.Status)
+	}
+	if len(vErrs) > 0 {
+		return nil, vErrs
+	}
+	missing, err := missingRoles(ctx, s.db, assignment.Namespace, datastore.RoleRefs{assignment.RoleName})
+	if err != nil {
+		return nil, err
+	}
+	if len(missing) > 0 {
+		return nil, datastore.InvalidArgumentError{
+			"role": fmt.Sprintf("role doesn't exist: '%s'", assignment.RoleName),
+		}
+	}
+
+	id := uuid.New()
+	// Assignments created approved are decided by their requester.
+	var decidedBy string
+	var decidedAt *time.Time
+	if assignment.Status == datastore.AssignmentApproved {
+		now := time.Now()
+		decidedBy, decidedAt = assignment.RequestedBy, &now
+	}
+	res := s.db.WithContext(ctx).Exec(`
+							INSERT INTO ee_role_assignments(id, namespace, role_name, assignee_type, assignee, justification, starts_at, ends_at, status, requested_by, decided_by, decided_at)
+							VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
+							`, id, assignment.Namespace, assignment.RoleName, assignment.AssigneeType, assignment.Assignee, assignment.Justification,
+		assignment.StartsAt, assignment.EndsAt, assignment.Status, assignment.RequestedBy, decidedBy, decidedAt)
+	if res.Error != nil && strings.Contains(res.Error.Error(), "duplicate key") {
+		return nil, datastore.ErrDuplication
+	}
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected != 1 {
+		return nil, fmt.Errorf("unexpected ee_role_assignments insert count, got: %d, want: %d", res.RowsAffected, 1)
+	}
+
+	return s.Get(ctx, assignment.Namespace, id)
+}
+
+func (s *roleAssignmentsStore) Get(ctx context.Context, namespace string, id uuid.UUID) (_ *datastore.RoleAssignment, err error) {
+	ctx, span := startSpan(ctx, "RoleAssignments.Get", namespaceAttr(namespace))
+	defer func() { endSpan(span, err) }()
+
+	scan := &datastore.RoleAssignment{}
+	res := s.db.WithContext(ctx).Raw(roleAssignmentsSelect+`
+							WHERE namespace=? AND id=?`,
+		namespace, id).
+		First(scan)
+	if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+		return nil, datastore.ErrNotFound
+	}
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	return scan, nil
+}
+
+func (s *roleAssignmentsStore) Delete(ctx context.Context, namespace string, id uuid.UUID) (err error) {
+	ctx, span := startSpan(ctx, "RoleAssignments.Delete", namespaceAttr(namespace))
+	defer func() { endSpan(span, err) }()
+
+	res := s.db.WithContext(ctx).Exec(`DELETE FROM ee_role_assignments WHERE namespace=? AND id=?`, namespace, id)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected != 1 {
+		return datastore.ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *roleAssignmentsStore) List(ctx context.Context, namespace string) (_ []*datastore.RoleAssignment, err error) {
+	ctx, span := startSpan(ctx, "RoleAssignments.List", namespaceAttr(namespace))
+	defer func() { endSpan(span, err) }()
+
+	var list []*datastore.RoleAssignment
+	res := s.db.WithContext(ctx).Raw(roleAssignmentsSelect+`
+							WHERE namespace=?
+							ORDER BY created_at DESC`,
+		namespace).
+		Find(&list)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	return list, nil
+}
+
+func (s *roleAssignmentsStore) ListActive(ctx context.Context, at time.Time) (_ []*datastore.RoleAssignment, err error) {
+	ctx, span := startSpan(ctx, "RoleAssignments.ListActive")
+	defer func() { endSpan(span, err) }()
+
+	var list []*datastore.RoleAssignment
+	res := s.db.WithContext(ctx).Raw(roleAssignmentsSelect+`
+							WHERE status=? AND starts_at <= ? AND ends_at > ?`,
+		datastore.AssignmentApproved, at, at).
+		Find(&list)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	return list, nil
+}
+
+func (s *roleAssignmentsStore) Decide(ctx context.Context, namespace string, id uuid.UUID, status, decidedBy string,
+) (_ *datastore.RoleAssignment, err error) {
+	ctx, span := startSpan(ctx, "RoleAssignments.Decide", namespaceAttr(namespace))
+	defer func() { endSpan(span, err) }()
+
+	if status != datastore.AssignmentApproved && status != datastore.AssignmentRejected {
+		return nil, datastore.InvalidArgumentError{
+			"status": fmt.Sprintf("invalid status: '%s'", status),
+		}
+	}
+
+	res := s.db.WithContext(ctx).Exec(`
+							UPDATE ee_role_assignments SET status=?, decided_by=?, decided_at=CURRENT_TIMESTAMP
+							WHERE namespace=? AND id=? AND status=?`,
+		status, decidedBy, namespace, id, datastore.AssignmentPending)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected != 1 {
+		// Tell an already decided assignment apart from a missing one.
+		if _, err := s.Get(ctx, namespace, id); err != nil {
+			return nil, err
+		}
+
+		return nil, datastore.ErrConflict
+	}
+
+	return s.Get(ctx, namespace, id)
+}
+
+var _ datastore.RoleAssignmentsStore = &roleAssignmentsStore{}
@@ -0,0 +1,132 @@
+package datasql_test
+
+import (
+	"context"
+	"errors"
+	"github.com/direktiv/direktiv/direktiv-ee/pkg/datastore"
+	"github.com/direktiv/direktiv/direktiv-ee/pkg/datastore/datasql"
+	"github.com/direktiv/direktiv/pkg/database"
+	"github.com/google/uuid"
+	"testing"
+	"time"
+)
+
+func Test_RoleAssignments(t *testing.T) {
+	ctx := context.Background()
+
+	db, ns, err := database.NewTestDBWithNamespace(t, uuid.NewString())
+	if err != nil {
+		t.Fatalf("unepxected NewTestDBWithNamespace() error = %v", err)
+	}
+	if res := db.Conn().Exec(datasql.Schema); res.Error != nil {
+		t.Fatalf("unepxected exec db_schema error = %v", res.Error)
+	}
+	store := datasql.New().With(db.Conn())
+
+	now := time.Now()
+	assignment := func(role, group string, startsAt time.Time, status string) *datastore.RoleAssignment {
+		return &datastore.RoleAssignment{
+			Namespace:     ns.Name,
+			RoleName:      role,
+			AssigneeType:  datastore.AssigneeGroup,
+			Assignee:      group,
+			Justification: "incident",
+			StartsAt:      startsAt,
+			EndsAt:        startsAt.Add(time.Hour),
+			Status:        status,
+			RequestedBy:   "alice",
+		}
+	}
+
+	_, err = store.RoleAssignments().Create(ctx, assignment("missing", "g1", now, datastore.AssignmentApproved))
+	var vErrs datastore.InvalidArgumentError
+	if !errors.As(err, &vErrs) || vErrs["role"] == "" {
+		t.Fatalf("RoleAssignments().Create() error = %v, want role validation error", err)
+	}
+
+	_, err = store.Roles().Create(ctx, &datastore.Role{Name: "r1", Namespace: ns.Name})
+	if err != nil {
+		t.Fatalf("Roles().Create() error = %v", err)
+	}
+
+	invalid := assignment("r1", "g1", now, datastore.AssignmentApproved)
+	invalid.EndsAt = now.Add(-time.Hour)
+	_, err = store.RoleAssignments().Create(ctx, invalid)
+	if !errors.As(err, &vErrs) || vErrs["endsAt"] == "" {
+		t.Fatalf("RoleAssignments().Create() error = %v, want endsAt validation error", err)
+	}
+
+	approved, err := store.RoleAssignments().Create(ctx, assignment("r1", "g1", now.Add(-time.Minute), datastore.AssignmentApproved))
+	if err != nil {
+		t.Fatalf("RoleAssignments().Create() error = %v", err)
+	}
+	if approved.DecidedBy != "alice" || approved.DecidedAt == nil {
+		t.Errorf("RoleAssignments().Create() returned %+v, want decided by alice", approved)
+	}
+	pending, err := store.RoleAssignments().Create(ctx, assignment("r1", "g2", now.Add(-time.Minute), datastore.AssignmentPending))
+	if err != nil {
+		t.Fatalf("RoleAssignments().Create() error = %v", err)
+	}
+	_, err = store.RoleAssignments().Create(ctx, assignment("r1", "g3", now.Add(time.Hour), datastore.AssignmentApproved))
+	if err != nil {
+		t.Fatalf("RoleAssignments().Create() error = %v", err)
+	}
+
+	// Pending and future assignments are not active.
+	active, err := store.RoleAssignments().ListActive(ctx, now)
+	if err != nil {
+		t.Fatalf("RoleAssignments().ListActive() error = %v", err)
+	}
+	if len(active) != 1 || active[0].ID != approved.ID {
+		t.Errorf("RoleAssignments().ListActive() returned %v, want %v", active, approved.ID)
+	}
+
+	decided, err := store.RoleAssignments().Decide(ctx, ns.Name, pending.ID, datastore.AssignmentApproved, "bob")
+	if err != nil {
+		t.Fatalf("RoleAssignments().Decide() error = %v", err)
+	}
+	if decided.Status != datastore.AssignmentApproved || decided.DecidedBy != "bob" {
+		t.Errorf("RoleAssignments().Decide() returned %+v, want approved by bob", decided)
+	}
+	_, err = store.RoleAssignments().Decide(ctx, ns.Name, pending.ID, datastore.AssignmentRejected, "bob")
+	if !errors.Is(err, datastore.ErrConflict) {
+		t.Errorf("RoleAssignments().Decide() error = %v, wantErr %v", err, datastore.ErrConflict)
+	}
+	_, err = store.RoleAssignments().Decide(ctx, ns.Name, uuid.New(), datastore.AssignmentRejected, "bob")
+	if !errors.Is(err, datastore.ErrNotFound) {
+		t.Errorf("RoleAssignments().Decide() error = %v, wantErr %v", err, datastore.ErrNotFound)
+	}
+
+	// Assignments expire with their time window.
+	active, err = store.RoleAssignments().ListActive(ctx, now.Add(2*time.Hour))
+	if err != nil {
+		t.Fatalf("RoleAssignments().ListActive() error = %v", err)
+	}
+	if len(active) != 0 {
+		t.Errorf("RoleAssignments().ListActive() returned %v, want none", active)
+	}
+
+	// Assignments follow their role.
+	_, err = store.Roles().Update(ctx, ns.Name, "r1", &datastore.Role{Name: "r2"})
+	if err != nil {
+		t.Fatalf("Roles().Update() error = %v", err)
+	}
+	got, err := store.RoleAssignments().Get(ctx, ns.Name, approved.ID)
+	if err != nil {
+		t.Fatalf("RoleAssignments().Get() error = %v", err)
+	}
+	if got.RoleName != "r2" {
+		t.Errorf("RoleAssignments().Get() returned %v, want %v", got.RoleName, "r2")
+	}
+	err = store.Roles().Delete(ctx, ns.Name, "r2")
+	if err != nil {
+		t.Fatalf("Roles().Delete() error = %v", err)
+	}
+	list, err := store.RoleAssignments().List(ctx, ns.Name)
+	if err != nil {
+		t.Fatalf("RoleAssignments().List() error = %v", err)
+	}
+	if len(list) != 0 {
+		t.Errorf("RoleAssignments().List() returned %v, want none", list)
+	}
+}
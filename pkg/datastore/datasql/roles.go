@@ -4,7 +4,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/direktiv/direktiv/direktiv-ee/pkg/datastore"
 	"gorm.io/gorm"
@@ -14,7 +16,10 @@ type rolesStore struct {
 	db *gorm.DB
 }
 
-func (s *rolesStore) Update(ctx context.Context, namespace, name string, role *datastore.Role) (*datastore.Role, error) {
+func (s *rolesStore) Update(ctx context.Context, namespace, name string, role *datastore.Role) (_ *datastore.Role, err error) {
+	ctx, span := startSpan(ctx, "Roles.Update", namespaceAttr(namespace))
+	defer func() { endSpan(span, err) }()
+
 	vErrs := datastore.InvalidArgumentError{}
 	if namespace == "" {
 		//nolint:goconst
@@ -32,7 +37,7 @@ func (s *rolesStore) Update(ctx context.Context, namespace, name string, role *d
 	if role.Name == "" {
 		vErrs["name"] = "is required"
 	}
-	err := role.Permissions.Validate()
+	err = role.Permissions.Validate()
 	if err != nil {
 		vErrs["permissions"] = err.Error()
 	}
@@ -40,26 +45,99 @@ func (s *rolesStore) Update(ctx context.Context, namespace, name string, role *d
 	if err != nil {
 		vErrs["oidcGroups"] = err.Error()
 	}
+	err = role.Users.Validate()
+	if err != nil {
+		vErrs["users"] = err.Error()
+	}
 	if len(vErrs) > 0 {
 		return nil, vErrs
 	}
 
+	if role.Name != name {
+		refs, err := s.references(ctx, namespace, name)
+		if err != nil {
+			return nil, err
+		}
+		// Signed tokens carry the role names in their claims, so references can't be renamed along.
+		if len(refs) > 0 {
+			return nil, datastore.InvalidArgumentError{
+				"name": "role can't be renamed while it is referenced by " + strings.Join(refs, ", "),
+			}
+		}
+	}
+
 	for i := range role.Permissions {
 		role.Permissions[i].Namespace = role.Namespace
 	}
-	res := s.db.WithContext(ctx).Exec(`UPDATE ee_roles SET name=?, description=?, oidc_groups=?, permissions=?, updated_at=CURRENT_TIMESTAMP WHERE namespace=? and name=?`,
-		role.Name, role.Description, role.OidcGroups, role.Permissions, namespace, name)
+	query := `UPDATE ee_roles SET name=?, description=?, oidc_groups=?, users=?, permissions=?, managed_by=?, updated_by=?, version=version+1, updated_at=CURRENT_TIMESTAMP WHERE namespace=? and name=?`
+	args := []any{role.Name, role.Description, role.OidcGroups, role.Users, role.Permissions, role.ManagedBy, role.UpdatedBy, namespace, name}
+	if role.Version > 0 {
+		query += ` AND version=?`
+		args = append(args, role.Version)
+	}
+	res := s.db.WithContext(ctx).Exec(query, args...)
+	if res.Error != nil && strings.Contains(res.Error.Error(), "duplicate key") {
+		return nil, datastore.ErrDuplication
+	}
 	if res.Error != nil {
 		return nil, res.Error
 	}
 	if res.RowsAffected != 1 {
+		// Tell a stale version apart from a missing role.
+		if _, err := s.Get(ctx, namespace, name); err == nil && role.Version > 0 {
+			return nil, datastore.ErrConflict
+		}
+
 		return nil, datastore.ErrNotFound
 	}
+	if role.Name != name {
+		for _, table := range []string{"ee_role_revisions", "ee_role_assignments"} {
+			res = s.db.WithContext(ctx).Exec(`UPDATE `+table+` SET role_name=? WHERE namespace=? AND role_name=?`,
+				role.Name, namespace, name)
+			if res.Error != nil {
+				return nil, res.Error
+			}
+		}
+	}
+	if err := s.recordRevision(ctx, namespace, role.Name); err != nil {
+		return nil, err
+	}
 
 	return s.Get(ctx, namespace, role.Name)
 }
 
-func (s *rolesStore) Create(ctx context.Context, role *datastore.Role) (*datastore.Role, error) {
+// references returns the api tokens, service accounts and certificate bindings that reference the
+// role by its name.
+func (s *rolesStore) references(ctx context.Context, namespace, name string) ([]string, error) {
+	var rows []struct {
+		Kind string
+		Name string
+	}
+	res := s.db.WithContext(ctx).Raw(`
+					SELECT 'api token' AS kind, name FROM ee_api_tokens
+						WHERE namespace=? AND roles::jsonb @> jsonb_build_array(?::text)
+					UNION ALL SELECT 'service account', name FROM ee_service_accounts
+						WHERE namespace=? AND roles::jsonb @> jsonb_build_array(?::text)
+					UNION ALL SELECT 'certificate binding', name FROM ee_certificate_bindings
+						WHERE namespace=? AND roles::jsonb @> jsonb_build_array(?::text)
+					ORDER BY kind, name`,
+		namespace, name, namespace, name, namespace, name).Scan(&rows)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	refs := make([]string, len(rows))
+	for i, row := range rows {
+		refs[i] = fmt.Sprintf("%s '%s'", row.Kind, row.Name)
+	}
+
+	return refs, nil
+}
+
+func (s *rolesStore) Create(ctx context.Context, role *datastore.Role) (_ *datastore.Role, err error) {
+	ctx, span := startSpan(ctx, "Roles.Create")
+	defer func() { endSpan(span, err) }()
+
 	vErrs := datastore.InvalidArgumentError{}
 	if role == nil {
 		vErrs["role"] = "is nil"
@@ -72,7 +150,7 @@ func (s *rolesStore) Create(ctx context.Context, role *datastore.Role) (*datasto
 	if role.Name == "" {
 		vErrs["name"] = "is required"
 	}
-	err := role.Permissions.Validate()
+	err = role.Permissions.Validate()
 	if err != nil {
 		vErrs["permissions"] = err.Error()
 	}
@@ -80,6 +158,10 @@ func (s *rolesStore) Create(ctx context.Context, role *datastore.Role) (*datasto
 	if err != nil {
 		vErrs["oidcGroups"] = err.Error()
 	}
+	err = role.Users.Validate()
+	if err != nil {
+		vErrs["users"] = err.Error()
+	}
 	if len(vErrs) > 0 {
 		return nil, vErrs
 	}
@@ -88,8 +170,8 @@ func (s *rolesStore) Create(ctx context.Context, role *datastore.Role) (*datasto
 	}
 
 	res := s.db.WithContext(ctx).Exec(`
-							INSERT INTO ee_roles(name, namespace, description, oidc_groups, permissions) VALUES(?, ?, ?, ?, ?);
-							`, role.Name, role.Namespace, role.Description, role.OidcGroups, role.Permissions)
+							INSERT INTO ee_roles(name, namespace, description, oidc_groups, users, permissions, managed_by, updated_by) VALUES(?, ?, ?, ?, ?, ?, ?, ?);
+							`, role.Name, role.Namespace, role.Description, role.OidcGroups, role.Users, role.Permissions, role.ManagedBy, role.UpdatedBy)
 
 	if res.Error != nil && strings.Contains(res.Error.Error(), "duplicate key") {
 		return nil, datastore.ErrDuplication
@@ -100,11 +182,17 @@ func (s *rolesStore) Create(ctx context.Context, role *datastore.Role) (*datasto
 	if res.RowsAffected != 1 {
 		return nil, fmt.Errorf("unexpected ee_roles insert count, got: %d, want: %d", res.RowsAffected, 1)
 	}
+	if err := s.recordRevision(ctx, role.Namespace, role.Name); err != nil {
+		return nil, err
+	}
 
 	return s.Get(ctx, role.Namespace, role.Name)
 }
 
-func (s *rolesStore) Delete(ctx context.Context, namespace, name string) error {
+func (s *rolesStore) Delete(ctx context.Context, namespace, name string) (err error) {
+	ctx, span := startSpan(ctx, "Roles.Delete", namespaceAttr(namespace))
+	defer func() { endSpan(span, err) }()
+
 	res := s.db.WithContext(ctx).Exec(`DELETE FROM ee_roles WHERE  name=? AND namespace=?`, name, namespace)
 	if res.Error != nil {
 		return res.Error
@@ -112,14 +200,23 @@ func (s *rolesStore) Delete(ctx context.Context, namespace, name string) error {
 	if res.RowsAffected != 1 {
 		return datastore.ErrNotFound
 	}
+	for _, table := range []string{"ee_role_revisions", "ee_role_assignments"} {
+		res = s.db.WithContext(ctx).Exec(`DELETE FROM `+table+` WHERE namespace=? AND role_name=?`, namespace, name)
+		if res.Error != nil {
+			return res.Error
+		}
+	}
 
 	return nil
 }
 
-func (s *rolesStore) Get(ctx context.Context, namespace, name string) (*datastore.Role, error) {
+func (s *rolesStore) Get(ctx context.Context, namespace, name string) (_ *datastore.Role, err error) {
+	ctx, span := startSpan(ctx, "Roles.Get", namespaceAttr(namespace))
+	defer func() { endSpan(span, err) }()
+
 	scan := &datastore.Role{}
 	res := s.db.WithContext(ctx).Raw(`
-							SELECT name, namespace, description, oidc_groups, permissions, created_at, updated_at 
+							SELECT name, namespace, description, oidc_groups, users, permissions, managed_by, version, updated_by, created_at, updated_at 
 							FROM ee_roles 
 							WHERE name=? AND namespace=?`,
 		name, namespace).
@@ -134,14 +231,61 @@ func (s *rolesStore) Get(ctx context.Context, namespace, name string) (*datastor
 	return scan, nil
 }
 
-func (s *rolesStore) List(ctx context.Context, namespace string) ([]*datastore.Role, error) {
+func (s *rolesStore) List(ctx context.Context, namespace string, filter datastore.RolesFilter, opts datastore.ListOptions,
+) (_ []*datastore.Role, _ string, err error) {
+	ctx, span := startSpan(ctx, "Roles.List", namespaceAttr(namespace))
+	defer func() { endSpan(span, err) }()
+
+	query := `
+							SELECT name, namespace, description, oidc_groups, users, permissions, managed_by, version, updated_by, created_at, updated_at 
+							FROM ee_roles
+							WHERE namespace=?`
+	args := []any{namespace}
+	if filter.OidcGroup != "" {
+		query += ` AND NULLIF(oidc_groups, '')::jsonb @> ?::jsonb`
+		args = append(args, datastore.OidcGroups{filter.OidcGroup}.String())
+	}
+	if filter.User != "" {
+		query += ` AND NULLIF(users, '')::jsonb @> ?::jsonb`
+		args = append(args, datastore.RoleUsers{filter.User}.String())
+	}
+	if filter.Topic != "" {
+		query += ` AND NULLIF(permissions, '')::jsonb @> ?::jsonb`
+		args = append(args, topicFilter(filter.Topic))
+	}
+	if filter.NamePrefix != "" {
+		query += ` AND starts_with(name, ?)`
+		args = append(args, filter.NamePrefix)
+	}
+	query, args, err = pageQuery(query, args, opts, map[string]sortColumn{
+		datastore.SortByCreated: sortByCreated,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	var list []*datastore.Role
+	res := s.db.WithContext(ctx).Raw(query, args...).Find(&list)
+	if res.Error != nil {
+		return nil, "", res.Error
+	}
+	list, next := nextPage(list, opts,
+		func(v *datastore.Role) string { return v.CreatedAt.Format(time.RFC3339Nano) },
+		func(v *datastore.Role) string { return v.Name })
+
+	return list, next, nil
+}
+
+func (s *rolesStore) ListAll(ctx context.Context) (_ []*datastore.Role, err error) {
+	ctx, span := startSpan(ctx, "Roles.ListAll")
+	defer func() { endSpan(span, err) }()
+
 	var list []*datastore.Role
 
 	res := s.db.WithContext(ctx).Raw(`
-							SELECT name, namespace, description, oidc_groups, permissions, created_at, updated_at 
+							SELECT name, namespace, description, oidc_groups, users, permissions, managed_by, version, updated_by, created_at, updated_at 
 							FROM ee_roles
-							WHERE namespace=? 
-							ORDER BY created_at ASC`, namespace).
+							ORDER BY created_at ASC`).
 		Find(&list)
 	if res.Error != nil {
 		return nil, res.Error
@@ -150,13 +294,35 @@ func (s *rolesStore) List(ctx context.Context, namespace string) ([]*datastore.R
 	return list, nil
 }
 
-func (s *rolesStore) ListAll(ctx context.Context) ([]*datastore.Role, error) {
-	var list []*datastore.Role
+// recordRevision stores the current definition of a role as the revision of its version.
+func (s *rolesStore) recordRevision(ctx context.Context, namespace, name string) error {
+	res := s.db.WithContext(ctx).Exec(`
+							INSERT INTO ee_role_revisions(namespace, role_name, revision, description, oidc_groups, users, permissions, author)
+							SELECT namespace, name, version, description, oidc_groups, users, permissions, updated_by
+							FROM ee_roles
+							WHERE namespace=? AND name=?`,
+		namespace, name)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected != 1 {
+		return fmt.Errorf("unexpected ee_role_revisions insert count, got: %d, want: %d", res.RowsAffected, 1)
+	}
+
+	return nil
+}
+
+func (s *rolesStore) ListRevisions(ctx context.Context, namespace, name string) (_ []*datastore.RoleRevision, err error) {
+	ctx, span := startSpan(ctx, "Roles.ListRevisions", namespaceAttr(namespace))
+	defer func() { endSpan(span, err) }()
 
+	var list []*datastore.RoleRevision
 	res := s.db.WithContext(ctx).Raw(`
-							SELECT name, namespace, description, oidc_groups, permissions, created_at, updated_at 
-							FROM ee_roles
-							ORDER BY created_at ASC`).
+							SELECT namespace, role_name, revision, description, oidc_groups, users, permissions, author, created_at
+							FROM ee_role_revisions
+							WHERE namespace=? AND role_name=?
+							ORDER BY revision DESC`,
+		namespace, name).
 		Find(&list)
 	if res.Error != nil {
 		return nil, res.Error
@@ -165,4 +331,49 @@ func (s *rolesStore) ListAll(ctx context.Context) ([]*datastore.Role, error) {
 	return list, nil
 }
 
+func (s *rolesStore) GetRevision(ctx context.Context, namespace, name string, revision int64) (_ *datastore.RoleRevision, err error) {
+	ctx, span := startSpan(ctx, "Roles.GetRevision", namespaceAttr(namespace))
+	defer func() { endSpan(span, err) }()
+
+	scan := &datastore.RoleRevision{}
+	res := s.db.WithContext(ctx).Raw(`
+							SELECT namespace, role_name, revision, description, oidc_groups, users, permissions, author, created_at
+							FROM ee_role_revisions
+							WHERE namespace=? AND role_name=? AND revision=?`,
+		namespace, name, revision).
+		First(scan)
+	if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+		return nil, datastore.ErrNotFound
+	}
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	return scan, nil
+}
+
+// missingRoles returns the referenced roles that don't exist in the given namespace.
+func missingRoles(ctx context.Context, db *gorm.DB, namespace string, roles datastore.RoleRefs) ([]string, error) {
+	if len(roles) == 0 {
+		return nil, nil
+	}
+
+	var existing []string
+	res := db.WithContext(ctx).Raw(`SELECT name FROM ee_roles WHERE namespace=? AND name IN ?`,
+		namespace, []string(roles)).
+		Scan(&existing)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	var missing []string
+	for _, name := range roles {
+		if !slices.Contains(existing, name) {
+			missing = append(missing, name)
+		}
+	}
+
+	return missing, nil
+}
+
 var _ datastore.RolesStore = &rolesStore{}
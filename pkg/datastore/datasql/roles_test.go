@@ -7,6 +7,7 @@ import (
 	"github.com/direktiv/direktiv/direktiv-ee/pkg/datastore/datasql"
 	"github.com/direktiv/direktiv/pkg/database"
 	"github.com/google/uuid"
+	"strings"
 	"testing"
 )
 
@@ -66,7 +67,7 @@ func Test_Roles(t *testing.T) {
 		t.Errorf("Roles().Create() returned %v, want %v", p1.Permissions, "secrets")
 	}
 
-	l, err := datasql.New().With(db.Conn()).Roles().List(ctx, ns.Name)
+	l, _, err := datasql.New().With(db.Conn()).Roles().List(ctx, ns.Name, datastore.RolesFilter{}, datastore.ListOptions{})
 	if err != nil {
 		t.Fatalf("Roles().List() error = %v", err)
 	}
@@ -102,3 +103,252 @@ func Test_Roles(t *testing.T) {
 		t.Errorf("Roles().Update() returned %v, want %v", p1.Permissions, "GET")
 	}
 }
+
+func Test_RolesListPages(t *testing.T) {
+	ctx := context.Background()
+
+	db, ns, err := database.NewTestDBWithNamespace(t, uuid.NewString())
+	if err != nil {
+		t.Fatalf("unepxected NewTestDBWithNamespace() error = %v", err)
+	}
+	if res := db.Conn().Exec(datasql.Schema); res.Error != nil {
+		t.Fatalf("unepxected exec db_schema error = %v", res.Error)
+	}
+
+	for _, name := range []string{"r3", "r1", "r2", "other"} {
+		_, err = datasql.New().With(db.Conn()).Roles().Create(ctx, &datastore.Role{
+			Name:       name,
+			Namespace:  ns.Name,
+			OidcGroups: datastore.OidcGroups{"g_" + name},
+			Users:      datastore.RoleUsers{name + "@example.com"},
+			Permissions: datastore.Permissions{
+				{"", "secrets", "read"},
+			},
+		})
+		if err != nil {
+			t.Fatalf("Roles().Create() error = %v", err)
+		}
+	}
+
+	var names []string
+	opts := datastore.ListOptions{Limit: 2, SortBy: datastore.SortByName, Desc: true}
+	for i := 0; i < 3; i++ {
+		l, next, err := datasql.New().With(db.Conn()).Roles().List(ctx, ns.Name, datastore.RolesFilter{}, opts)
+		if err != nil {
+			t.Fatalf("Roles().List() error = %v", err)
+		}
+		for _, role := range l {
+			names = append(names, role.Name)
+		}
+		if next == "" {
+			break
+		}
+		opts.Cursor = next
+	}
+	if strings.Join(names, ",") != "r3,r2,r1,other" {
+		t.Errorf("Roles().List() returned %v, want %v", names, "r3,r2,r1,other")
+	}
+
+	l, next, err := datasql.New().With(db.Conn()).Roles().List(ctx, ns.Name, datastore.RolesFilter{NamePrefix: "r"},
+		datastore.ListOptions{Limit: 2})
+	if err != nil {
+		t.Fatalf("Roles().List() error = %v", err)
+	}
+	if len(l) != 2 || l[0].Name != "r3" || next == "" {
+		t.Errorf("Roles().List() returned %v, %v, want the first two r roles by creation", l, next)
+	}
+	l, _, err = datasql.New().With(db.Conn()).Roles().List(ctx, ns.Name, datastore.RolesFilter{NamePrefix: "r"},
+		datastore.ListOptions{Limit: 2, Cursor: next})
+	if err != nil {
+		t.Fatalf("Roles().List() error = %v", err)
+	}
+	if len(l) != 1 || l[0].Name != "r2" {
+		t.Errorf("Roles().List() returned %v, want the last r role", l)
+	}
+
+	l, _, err = datasql.New().With(db.Conn()).Roles().List(ctx, ns.Name, datastore.RolesFilter{OidcGroup: "g_r1", Topic: "secrets"},
+		datastore.ListOptions{})
+	if err != nil {
+		t.Fatalf("Roles().List() error = %v", err)
+	}
+	if len(l) != 1 || l[0].Name != "r1" {
+		t.Errorf("Roles().List() returned %v, want only r1", l)
+	}
+
+	l, _, err = datasql.New().With(db.Conn()).Roles().List(ctx, ns.Name, datastore.RolesFilter{User: "r2@example.com"},
+		datastore.ListOptions{})
+	if err != nil {
+		t.Fatalf("Roles().List() error = %v", err)
+	}
+	if len(l) != 1 || l[0].Name != "r2" || l[0].Users.String() != `["r2@example.com"]` {
+		t.Errorf("Roles().List() returned %v, want only r2", l)
+	}
+
+	// Cursors are only valid with the sorting they were created with.
+	_, _, err = datasql.New().With(db.Conn()).Roles().List(ctx, ns.Name, datastore.RolesFilter{},
+		datastore.ListOptions{Limit: 2, Cursor: next, SortBy: datastore.SortByName})
+	var vErrs datastore.InvalidArgumentError
+	if !errors.As(err, &vErrs) || vErrs["cursor"] == "" {
+		t.Errorf("Roles().List() error = %v, want cursor validation error", err)
+	}
+}
+
+func Test_RolesUpdateVersion(t *testing.T) {
+	ctx := context.Background()
+
+	db, ns, err := database.NewTestDBWithNamespace(t, uuid.NewString())
+	if err != nil {
+		t.Fatalf("unepxected NewTestDBWithNamespace() error = %v", err)
+	}
+	if res := db.Conn().Exec(datasql.Schema); res.Error != nil {
+		t.Fatalf("unepxected exec db_schema error = %v", res.Error)
+	}
+	store := datasql.New().With(db.Conn())
+
+	role, err := store.Roles().Create(ctx, &datastore.Role{Name: textSomething, Namespace: ns.Name})
+	if err != nil {
+		t.Fatalf("Roles().Create() error = %v", err)
+	}
+	if role.Version != 1 {
+		t.Errorf("Roles().Create() returned version %v, want %v", role.Version, 1)
+	}
+
+	updated, err := store.Roles().Update(ctx, ns.Name, textSomething, &datastore.Role{
+		Name:        textSomething,
+		Description: "first",
+		Version:     role.Version,
+	})
+	if err != nil {
+		t.Fatalf("Roles().Update() error = %v", err)
+	}
+	if updated.Version != 2 {
+		t.Errorf("Roles().Update() returned version %v, want %v", updated.Version, 2)
+	}
+
+	// The stale version loses.
+	_, err = store.Roles().Update(ctx, ns.Name, textSomething, &datastore.Role{
+		Name:        textSomething,
+		Description: "second",
+		Version:     role.Version,
+	})
+	if !errors.Is(err, datastore.ErrConflict) {
+		t.Errorf("Roles().Update() error = %v, wantErr %v", err, datastore.ErrConflict)
+	}
+
+	_, err = store.Roles().Update(ctx, ns.Name, textSomethingElse, &datastore.Role{
+		Name:    textSomethingElse,
+		Version: 1,
+	})
+	if !errors.Is(err, datastore.ErrNotFound) {
+		t.Errorf("Roles().Update() error = %v, wantErr %v", err, datastore.ErrNotFound)
+	}
+
+	// Updates without version always apply.
+	updated, err = store.Roles().Update(ctx, ns.Name, textSomething, &datastore.Role{
+		Name:        textSomething,
+		Description: "third",
+	})
+	if err != nil {
+		t.Fatalf("Roles().Update() error = %v", err)
+	}
+	if updated.Version != 3 || updated.Description != "third" {
+		t.Errorf("Roles().Update() returned %v, want version 3 and description third", updated)
+	}
+}
+
+func Test_RolesRevisions(t *testing.T) {
+	ctx := context.Background()
+
+	db, ns, err := database.NewTestDBWithNamespace(t, uuid.NewString())
+	if err != nil {
+		t.Fatalf("unepxected NewTestDBWithNamespace() error = %v", err)
+	}
+	if res := db.Conn().Exec(datasql.Schema); res.Error != nil {
+		t.Fatalf("unepxected exec db_schema error = %v", res.Error)
+	}
+	store := datasql.New().With(db.Conn())
+
+	_, err = store.Roles().Create(ctx, &datastore.Role{Name: textSomething, Namespace: ns.Name, Description: "first", UpdatedBy: "alice"})
+	if err != nil {
+		t.Fatalf("Roles().Create() error = %v", err)
+	}
+	// Revisions follow renames.
+	_, err = store.Roles().Update(ctx, ns.Name, textSomething, &datastore.Role{Name: textSomethingElse, Description: "second", UpdatedBy: "bob"})
+	if err != nil {
+		t.Fatalf("Roles().Update() error = %v", err)
+	}
+
+	list, err := store.Roles().ListRevisions(ctx, ns.Name, textSomethingElse)
+	if err != nil {
+		t.Fatalf("Roles().ListRevisions() error = %v", err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("Roles().ListRevisions() returned %v, want %v", len(list), 2)
+	}
+	if list[0].Revision != 2 || list[0].Description != "second" || list[0].Author != "bob" {
+		t.Errorf("Roles().ListRevisions() returned %+v, want revision 2 by bob", list[0])
+	}
+
+	rev, err := store.Roles().GetRevision(ctx, ns.Name, textSomethingElse, 1)
+	if err != nil {
+		t.Fatalf("Roles().GetRevision() error = %v", err)
+	}
+	if rev.Description != "first" || rev.Author != "alice" {
+		t.Errorf("Roles().GetRevision() returned %+v, want revision 1 by alice", rev)
+	}
+
+	err = store.Roles().Delete(ctx, ns.Name, textSomethingElse)
+	if err != nil {
+		t.Fatalf("Roles().Delete() error = %v", err)
+	}
+	_, err = store.Roles().GetRevision(ctx, ns.Name, textSomethingElse, 1)
+	if !errors.Is(err, datastore.ErrNotFound) {
+		t.Errorf("Roles().GetRevision() error = %v, wantErr %v", err, datastore.ErrNotFound)
+	}
+}
+
+func Test_RolesRenameReferenced(t *testing.T) {
+	ctx := context.Background()
+
+	db, ns, err := database.NewTestDBWithNamespace(t, uuid.NewString())
+	if err != nil {
+		t.Fatalf("unepxected NewTestDBWithNamespace() error = %v", err)
+	}
+	if res := db.Conn().Exec(datasql.Schema); res.Error != nil {
+		t.Fatalf("unepxected exec db_schema error = %v", res.Error)
+	}
+	store := datasql.New().With(db.Conn())
+
+	_, err = store.Roles().Create(ctx, &datastore.Role{Name: textSomething, Namespace: ns.Name})
+	if err != nil {
+		t.Fatalf("Roles().Create() error = %v", err)
+	}
+	_, err = store.ServiceAccounts().Create(ctx, &datastore.ServiceAccount{
+		Name:      "ci",
+		Namespace: ns.Name,
+		Roles:     datastore.RoleRefs{textSomething},
+	})
+	if err != nil {
+		t.Fatalf("ServiceAccounts().Create() error = %v", err)
+	}
+
+	// References would go stale, so renames are refused while there are any.
+	_, err = store.Roles().Update(ctx, ns.Name, textSomething, &datastore.Role{Name: textSomethingElse})
+	var vErrs datastore.InvalidArgumentError
+	if !errors.As(err, &vErrs) || !strings.Contains(vErrs["name"], "service account 'ci'") {
+		t.Fatalf("Roles().Update() error = %v, want name validation error naming the service account", err)
+	}
+	_, err = store.Roles().Update(ctx, ns.Name, textSomething, &datastore.Role{Name: textSomething, Description: "kept"})
+	if err != nil {
+		t.Fatalf("Roles().Update() error = %v without a rename", err)
+	}
+
+	err = store.ServiceAccounts().Delete(ctx, ns.Name, "ci")
+	if err != nil {
+		t.Fatalf("ServiceAccounts().Delete() error = %v", err)
+	}
+	_, err = store.Roles().Update(ctx, ns.Name, textSomething, &datastore.Role{Name: textSomethingElse})
+	if err != nil {
+		t.Errorf("Roles().Update() error = %v after the references are gone", err)
+	}
+}
@@ -0,0 +1,175 @@
+package datasql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/direktiv/direktiv/direktiv-ee/pkg/datastore"
+	"gorm.io/gorm"
+)
+
+type serviceAccountsStore struct {
+	db *gorm.DB
+}
+
+//nolint:goconst
+func (s *serviceAccountsStore) Create(ctx context.Context, serviceAccount *datastore.ServiceAccount) (_ *datastore.ServiceAccount, err error) {
+	ctx, span := startSpan(ctx, "ServiceAccounts.Create")
+	defer func() { endSpan(span, err) }()
+
+	vErrs := datastore.InvalidArgumentError{}
+	if serviceAccount == nil {
+		vErrs["serviceAccount"] = "is nil"
+
+		return nil, vErrs
+	}
+	if serviceAccount.Namespace == "" {
+		vErrs["namespace"] = "is required"
+	}
+	if serviceAccount.Name == "" {
+		vErrs["name"] = "is required"
+	}
+	err = serviceAccount.Roles.Validate()
+	if err != nil {
+		vErrs["roles"] = err.Error()
+	}
+	if len(vErrs) > 0 {
+		return nil, vErrs
+	}
+	missing, err := missingRoles(ctx, s.db, serviceAccount.Namespace, serviceAccount.Roles)
+	if err != nil {
+		return nil, err
+	}
+	if len(missing) > 0 {
+		return nil, datastore.InvalidArgumentError{
+			"roles": fmt.Sprintf("roles don't exist: '%s'", strings.Join(missing, "', '")),
+		}
+	}
+
+	res := s.db.WithContext(ctx).Exec(`
+							INSERT INTO ee_service_accounts(name, namespace, description, roles) VALUES(?, ?, ?, ?);
+							`, serviceAccount.Name, serviceAccount.Namespace, serviceAccount.Description, serviceAccount.Roles)
+
+	if res.Error != nil && strings.Contains(res.Error.Error(), "duplicate key") {
+		return nil, datastore.ErrDuplication
+	}
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected != 1 {
+		return nil, fmt.Errorf("unexpected ee_service_accounts insert count, got: %d, want: %d", res.RowsAffected, 1)
+	}
+
+	return s.Get(ctx, serviceAccount.Namespace, serviceAccount.Name)
+}
+
+//nolint:goconst
+func (s *serviceAccountsStore) Update(ctx context.Context, namespace, name string, serviceAccount *datastore.ServiceAccount) (_ *datastore.ServiceAccount, err error) {
+	ctx, span := startSpan(ctx, "ServiceAccounts.Update", namespaceAttr(namespace))
+	defer func() { endSpan(span, err) }()
+
+	vErrs := datastore.InvalidArgumentError{}
+	if namespace == "" {
+		vErrs["namespace"] = "is required"
+	}
+	if name == "" {
+		vErrs["name"] = "is required"
+	}
+	if serviceAccount == nil {
+		vErrs["serviceAccount"] = "is nil"
+
+		return nil, vErrs
+	}
+	if serviceAccount.Name == "" {
+		vErrs["name"] = "is required"
+	}
+	err = serviceAccount.Roles.Validate()
+	if err != nil {
+		vErrs["roles"] = err.Error()
+	}
+	if len(vErrs) > 0 {
+		return nil, vErrs
+	}
+	missing, err := missingRoles(ctx, s.db, namespace, serviceAccount.Roles)
+	if err != nil {
+		return nil, err
+	}
+	if len(missing) > 0 {
+		return nil, datastore.InvalidArgumentError{
+			"roles": fmt.Sprintf("roles don't exist: '%s'", strings.Join(missing, "', '")),
+		}
+	}
+
+	res := s.db.WithContext(ctx).Exec(`UPDATE ee_service_accounts SET name=?, description=?, roles=?, updated_at=CURRENT_TIMESTAMP WHERE namespace=? and name=?`,
+		serviceAccount.Name, serviceAccount.Description, serviceAccount.Roles, namespace, name)
+	if res.Error != nil && strings.Contains(res.Error.Error(), "duplicate key") {
+		return nil, datastore.ErrDuplication
+	}
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected != 1 {
+		return nil, datastore.ErrNotFound
+	}
+
+	return s.Get(ctx, namespace, serviceAccount.Name)
+}
+
+func (s *serviceAccountsStore) Delete(ctx context.Context, namespace, name string) (err error) {
+	ctx, span := startSpan(ctx, "ServiceAccounts.Delete", namespaceAttr(namespace))
+	defer func() { endSpan(span, err) }()
+
+	res := s.db.WithContext(ctx).Exec(`DELETE FROM ee_service_accounts WHERE  name=? AND namespace=?`, name, namespace)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected != 1 {
+		return datastore.ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *serviceAccountsStore) Get(ctx context.Context, namespace, name string) (_ *datastore.ServiceAccount, err error) {
+	ctx, span := startSpan(ctx, "ServiceAccounts.Get", namespaceAttr(namespace))
+	defer func() { endSpan(span, err) }()
+
+	scan := &datastore.ServiceAccount{}
+	res := s.db.WithContext(ctx).Raw(`
+							SELECT name, namespace, description, roles, created_at, updated_at
+							FROM ee_service_accounts
+							WHERE name=? AND namespace=?`,
+		name, namespace).
+		First(scan)
+	if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+		return nil, datastore.ErrNotFound
+	}
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	return scan, nil
+}
+
+func (s *serviceAccountsStore) List(ctx context.Context, namespace string) (_ []*datastore.ServiceAccount, err error) {
+	ctx, span := startSpan(ctx, "ServiceAccounts.List", namespaceAttr(namespace))
+	defer func() { endSpan(span, err) }()
+
+	var list []*datastore.ServiceAccount
+
+	res := s.db.WithContext(ctx).Raw(`
+							SELECT name, namespace, description, roles, created_at, updated_at
+							FROM ee_service_accounts
+							WHERE namespace=?
+							ORDER BY created_at ASC`, namespace).
+		Find(&list)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	return list, nil
+}
+
+var _ datastore.ServiceAccountsStore = &serviceAccountsStore{}
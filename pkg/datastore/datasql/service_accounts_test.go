@@ -0,0 +1,109 @@
+package datasql_test
+
+import (
+	"context"
+	"errors"
+	"github.com/direktiv/direktiv/direktiv-ee/pkg/datastore"
+	"github.com/direktiv/direktiv/direktiv-ee/pkg/datastore/datasql"
+	"github.com/direktiv/direktiv/pkg/database"
+	"github.com/google/uuid"
+	"testing"
+)
+
+func Test_ServiceAccounts(t *testing.T) {
+	ctx := context.Background()
+
+	db, ns, err := database.NewTestDBWithNamespace(t, uuid.NewString())
+	if err != nil {
+		t.Fatalf("unepxected NewTestDBWithNamespace() error = %v", err)
+	}
+	if res := db.Conn().Exec(datasql.Schema); res.Error != nil {
+		t.Fatalf("unepxected exec db_schema error = %v", res.Error)
+	}
+
+	res, err := datasql.New().With(db.Conn()).ServiceAccounts().Get(ctx, textSomething, textSomethingElse)
+	if res != nil {
+		t.Errorf("ServiceAccounts().Get() returned %v, want nil", res)
+	}
+	if !errors.Is(err, datastore.ErrNotFound) {
+		t.Errorf("ServiceAccounts().Get() error = %v, wantErr %v", err, datastore.ErrNotFound)
+	}
+
+	_, err = datasql.New().With(db.Conn()).ServiceAccounts().Create(ctx, &datastore.ServiceAccount{
+		Name:      textSomething,
+		Namespace: ns.Name,
+		Roles:     datastore.RoleRefs{"missing"},
+	})
+	var vErrs datastore.InvalidArgumentError
+	if !errors.As(err, &vErrs) || vErrs["roles"] == "" {
+		t.Fatalf("ServiceAccounts().Create() error = %v, want roles validation error", err)
+	}
+
+	_, err = datasql.New().With(db.Conn()).Roles().Create(ctx, &datastore.Role{
+		Name:      "r1",
+		Namespace: ns.Name,
+		Permissions: datastore.Permissions{
+			{"", "secrets", "GET"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Roles().Create() error = %v", err)
+	}
+
+	p1, err := datasql.New().With(db.Conn()).ServiceAccounts().Create(ctx, &datastore.ServiceAccount{
+		Name:        textSomething,
+		Description: textSomethingElse,
+		Namespace:   ns.Name,
+		Roles:       datastore.RoleRefs{"r1"},
+	})
+	if err != nil {
+		t.Fatalf("ServiceAccounts().Create() error = %v", err)
+	}
+	if p1.Name != textSomething {
+		t.Errorf("ServiceAccounts().Create() returned %v, want %v", p1.Name, textSomething)
+	}
+	if p1.Roles.String() != "[\"r1\"]" {
+		t.Errorf("ServiceAccounts().Create() returned %v, want %v", p1.Roles.String(), "[\"r1\"]")
+	}
+
+	_, err = datasql.New().With(db.Conn()).APITokens().Create(ctx, &datastore.APIToken{
+		Name:           "t1",
+		Namespace:      ns.Name,
+		Format:         datastore.APITokenFormatUUID,
+		Hash:           uuid.New(),
+		ServiceAccount: textSomething,
+		ExpiredAt:      expiresIn(60),
+	})
+	if err != nil {
+		t.Fatalf("APITokens().Create() error = %v", err)
+	}
+
+	l, err := datasql.New().With(db.Conn()).APITokens().ListByServiceAccount(ctx, ns.Name, textSomething)
+	if err != nil {
+		t.Fatalf("APITokens().ListByServiceAccount() error = %v", err)
+	}
+	if len(l) != 1 || l[0].ServiceAccount != textSomething {
+		t.Errorf("APITokens().ListByServiceAccount() returned %v, want one token", l)
+	}
+
+	p1, err = datasql.New().With(db.Conn()).ServiceAccounts().Update(ctx, ns.Name, textSomething, &datastore.ServiceAccount{
+		Name:        textSomething,
+		Description: textSomething,
+	})
+	if err != nil {
+		t.Fatalf("ServiceAccounts().Update() error = %v", err)
+	}
+	if p1.Description != textSomething {
+		t.Errorf("ServiceAccounts().Update() returned %v, want %v", p1.Description, textSomething)
+	}
+
+	// Deleting the service account revokes all of its tokens.
+	err = datasql.New().With(db.Conn()).ServiceAccounts().Delete(ctx, ns.Name, textSomething)
+	if err != nil {
+		t.Fatalf("ServiceAccounts().Delete() error = %v", err)
+	}
+	_, err = datasql.New().With(db.Conn()).APITokens().Get(ctx, ns.Name, "t1")
+	if !errors.Is(err, datastore.ErrNotFound) {
+		t.Errorf("APITokens().Get() error = %v, wantErr %v", err, datastore.ErrNotFound)
+	}
+}
@@ -0,0 +1,40 @@
+package datasql
+
+import (
+	"context"
+	"errors"
+
+	"github.com/direktiv/direktiv/direktiv-ee/pkg/datastore"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/direktiv/direktiv/direktiv-ee/pkg/datastore/datasql")
+
+// startSpan starts the span of a store method, op is named like "Roles.Get".
+func startSpan(ctx context.Context, op string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	attrs = append(attrs, attribute.String("db.system", "postgresql"))
+
+	return tracer.Start(ctx, "datasql."+op, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+}
+
+// endSpan ends span and records err. Missing resources, validation errors and conflicts are
+// results of the method rather than failures, so they don't mark the span as failed.
+func endSpan(span trace.Span, err error) {
+	var vErrs datastore.InvalidArgumentError
+	switch {
+	case err == nil:
+	case errors.Is(err, datastore.ErrNotFound), errors.Is(err, datastore.ErrDuplication),
+		errors.Is(err, datastore.ErrConflict), errors.As(err, &vErrs):
+	default:
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func namespaceAttr(namespace string) attribute.KeyValue {
+	return attribute.String("direktiv.namespace", namespace)
+}
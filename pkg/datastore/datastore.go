@@ -1,6 +1,7 @@
 package datastore
 
 import (
+	"context"
 	"errors"
 	"fmt"
 )
@@ -11,7 +12,20 @@ type Store interface {
 
 type StoreInner interface {
 	APITokens() APITokensStore
+	APITokenPolicies() APITokenPoliciesStore
 	Roles() RolesStore
+	RoleAssignments() RoleAssignmentsStore
+	ServiceAccounts() ServiceAccountsStore
+	AuthFailures() AuthFailuresStore
+	Quotas() QuotasStore
+	CertificateBindings() CertificateBindingsStore
+
+	// TryLock acquires the advisory lock of the given key for the current transaction, it returns false
+	// when the lock is held by someone else.
+	TryLock(ctx context.Context, key int64) (bool, error)
+	// SetExpiryNotified records that an expiry notification was sent for the subject, it returns false
+	// when one was already sent.
+	SetExpiryNotified(ctx context.Context, subject string) (bool, error)
 }
 
 var (
@@ -22,6 +36,10 @@ var (
 	// ErrDuplication is a common error type that should be returned by any store implementation
 	// when tying to violate unique constraints.
 	ErrDuplication = errors.New("duplicate key")
+
+	// ErrConflict is returned by updates that expect a version of an entry that was changed in the
+	// meantime.
+	ErrConflict = errors.New("version conflict")
 )
 
 type InvalidArgumentError map[string]string
@@ -0,0 +1,60 @@
+package datastore
+
+import (
+	"encoding/base64"
+	"encoding/json"
+)
+
+const (
+	SortByCreated = "created"
+	SortByName    = "name"
+	// SortByExpiry sorts tokens without expiry last.
+	SortByExpiry = "expiry"
+)
+
+// ListOptions control the paging and sorting of list calls, the zero value lists everything sorted
+// by creation.
+type ListOptions struct {
+	// Limit is the maximum number of entries of a page, zero means no limit.
+	Limit int
+	// Cursor continues a listing after the page it was returned with, it is only valid with the
+	// same sorting.
+	Cursor string
+	SortBy string
+	Desc   bool
+}
+
+// Cursor points after the last entry of a page, entries are identified by their sort value and
+// their name, which is unique within a namespace.
+type Cursor struct {
+	SortBy string `json:"s"`
+	Desc   bool   `json:"d,omitempty"`
+	Value  string `json:"v,omitempty"`
+	Name   string `json:"n"`
+}
+
+func (c *Cursor) Encode() string {
+	b, _ := json.Marshal(c)
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeCursor decodes the cursor of the given list options, it returns nil when there is none.
+func DecodeCursor(opts ListOptions) (*Cursor, error) {
+	if opts.Cursor == "" {
+		return nil, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(opts.Cursor)
+	if err != nil {
+		return nil, InvalidArgumentError{"cursor": "is invalid"}
+	}
+	c := &Cursor{}
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, InvalidArgumentError{"cursor": "is invalid"}
+	}
+	if c.SortBy != opts.SortBy || c.Desc != opts.Desc {
+		return nil, InvalidArgumentError{"cursor": "doesn't match the sorting"}
+	}
+
+	return c, nil
+}
@@ -32,6 +32,12 @@ var allowedTopics = []string{
 	"events",
 	"roles",
 	"api_tokens",
+	"service_accounts",
+	"api_token_policy",
+	"rbac_sync",
+	"role_assignments",
+	"quota",
+	"certificate_bindings",
 }
 
 type Permission struct {
@@ -60,6 +66,33 @@ func (perms Permissions) Validate() error {
 	return nil
 }
 
+// IsTopic reports whether permissions can grant access to topic.
+func IsTopic(topic string) bool {
+	return slices.Contains(allowedTopics, topic)
+}
+
+// Covers reports whether perm is granted by any of perms, manage grants every method and read
+// is the same as GET.
+func (perms Permissions) Covers(perm *Permission) bool {
+	normalize := func(method string) string {
+		if method == "read" {
+			return "GET"
+		}
+
+		return method
+	}
+	for _, p := range perms {
+		if p.Topic != perm.Topic {
+			continue
+		}
+		if p.Method == "manage" || normalize(p.Method) == normalize(perm.Method) {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (perms Permissions) Value() (driver.Value, error) {
 	return json.Marshal(perms)
 }
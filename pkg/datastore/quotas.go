@@ -0,0 +1,101 @@
+package datastore
+
+import (
+	"context"
+	"time"
+)
+
+// Kinds of requests quotas limit. Every request to a namespace is an api call, instance starts and
+// event broadcasts are counted once more under their own kind.
+const (
+	QuotaAPICalls        = "api_calls"
+	QuotaInstanceStarts  = "instance_starts"
+	QuotaEventBroadcasts = "event_broadcasts"
+)
+
+// QuotaLimits are the requests allowed per minute by kind, zero means unlimited.
+type QuotaLimits struct {
+	APICalls        int
+	InstanceStarts  int
+	EventBroadcasts int
+}
+
+// Of returns the limit of the given kind.
+func (l QuotaLimits) Of(kind string) int {
+	switch kind {
+	case QuotaAPICalls:
+		return l.APICalls
+	case QuotaInstanceStarts:
+		return l.InstanceStarts
+	case QuotaEventBroadcasts:
+		return l.EventBroadcasts
+	}
+
+	return 0
+}
+
+func (l QuotaLimits) validate(vErrs InvalidArgumentError, field string) {
+	if l.APICalls < 0 {
+		vErrs[field+".apiCallsPerMinute"] = "must not be negative"
+	}
+	if l.InstanceStarts < 0 {
+		vErrs[field+".instanceStartsPerMinute"] = "must not be negative"
+	}
+	if l.EventBroadcasts < 0 {
+		vErrs[field+".eventBroadcastsPerMinute"] = "must not be negative"
+	}
+}
+
+// Quota caps the requests of a namespace per minute, namespaces without a stored quota are
+// unlimited.
+type Quota struct {
+	Namespace string
+	// Limits apply to all requests to the namespace together.
+	Limits QuotaLimits
+	// APITokenLimits apply to the requests of every api token of the namespace on its own.
+	APITokenLimits QuotaLimits
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (q *Quota) Validate() error {
+	vErrs := InvalidArgumentError{}
+	q.Limits.validate(vErrs, "limits")
+	q.APITokenLimits.validate(vErrs, "apiTokenLimits")
+	if len(vErrs) > 0 {
+		return vErrs
+	}
+
+	return nil
+}
+
+// QuotaUsage counts the requests of a kind to a namespace in the period that begins at Start.
+// APIToken is the name of the api token that sent them, it is empty for the usage of the whole
+// namespace, which includes the usage of its tokens.
+type QuotaUsage struct {
+	Namespace string
+	APIToken  string
+	Kind      string
+	Start     time.Time
+	// Requests includes the rejected requests.
+	Requests int64
+	Rejected int64
+}
+
+type QuotasStore interface {
+	Get(ctx context.Context, namespace string) (*Quota, error)
+	Set(ctx context.Context, quota *Quota) (*Quota, error)
+	Delete(ctx context.Context, namespace string) error
+
+	// CountUsage counts a request in the minute that begins at minute and returns the requests of
+	// the minute so far.
+	CountUsage(ctx context.Context, namespace, apiToken, kind string, minute time.Time) (int64, error)
+	// RejectUsage counts a request that was counted before as rejected.
+	RejectUsage(ctx context.Context, namespace, apiToken, kind string, minute time.Time) error
+	// ListUsage returns the usage of a namespace summed up by hour, from is inclusive and to
+	// exclusive.
+	ListUsage(ctx context.Context, namespace string, from, to time.Time) ([]*QuotaUsage, error)
+	// PurgeUsage deletes the usage of the minutes before the given time.
+	PurgeUsage(ctx context.Context, before time.Time) (int64, error)
+}
@@ -0,0 +1,60 @@
+package datastore
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// AssigneeGroup assignments bind a role to an oidc group.
+	AssigneeGroup = "group"
+	// AssigneeUser assignments bind a role to a single oidc user, identified by the subject claim.
+	AssigneeUser = "user"
+)
+
+const (
+	AssignmentPending  = "pending"
+	AssignmentApproved = "approved"
+	AssignmentRejected = "rejected"
+)
+
+// RoleAssignment binds a role to an oidc group or user for a limited time. Only approved
+// assignments grant the permissions of the role, and only between StartsAt and EndsAt.
+type RoleAssignment struct {
+	ID            uuid.UUID
+	Namespace     string
+	RoleName      string
+	AssigneeType  string
+	Assignee      string
+	Justification string
+	StartsAt      time.Time
+	EndsAt        time.Time
+	Status        string
+	// RequestedBy and DecidedBy identify callers like Role.UpdatedBy does, DecidedBy is empty
+	// while the assignment is pending.
+	RequestedBy string
+	DecidedBy   string
+	DecidedAt   *time.Time
+
+	CreatedAt time.Time
+}
+
+// IsActive reports whether the assignment grants its role at the given time.
+func (a *RoleAssignment) IsActive(at time.Time) bool {
+	return a.Status == AssignmentApproved && !at.Before(a.StartsAt) && at.Before(a.EndsAt)
+}
+
+type RoleAssignmentsStore interface {
+	Create(ctx context.Context, assignment *RoleAssignment) (*RoleAssignment, error)
+	Get(ctx context.Context, namespace string, id uuid.UUID) (*RoleAssignment, error)
+	Delete(ctx context.Context, namespace string, id uuid.UUID) error
+	// List returns the assignments of a namespace, newest first.
+	List(ctx context.Context, namespace string) ([]*RoleAssignment, error)
+	// ListActive returns the assignments of all namespaces that grant their role at the given time.
+	ListActive(ctx context.Context, at time.Time) ([]*RoleAssignment, error)
+	// Decide approves or rejects a pending assignment, it fails with ErrConflict when the assignment
+	// was already decided.
+	Decide(ctx context.Context, namespace string, id uuid.UUID, status, decidedBy string) (*RoleAssignment, error)
+}
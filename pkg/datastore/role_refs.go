@@ -0,0 +1,55 @@
+package datastore
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"slices"
+)
+
+// RoleRefs is a list of role names that belong to the same namespace.
+//
+//nolint:recvcheck
+type RoleRefs []string
+
+func (r RoleRefs) Validate() error {
+	if len(r) == 0 {
+		return nil
+	}
+
+	for i, name := range r {
+		if len(name) == 0 {
+			return fmt.Errorf("empty role name at index: %d", i)
+		}
+		if slices.Contains(r[:i], name) {
+			return fmt.Errorf("duplicate role name: '%s'", name)
+		}
+	}
+
+	return nil
+}
+
+func (r RoleRefs) Value() (driver.Value, error) {
+	return json.Marshal(r)
+}
+
+func (r RoleRefs) String() string {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return ""
+	}
+
+	return string(b)
+}
+
+func (r *RoleRefs) Scan(value interface{}) error {
+	b, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("type assertion to string failed: got %T", value)
+	}
+	if b == "" {
+		return nil
+	}
+
+	return json.Unmarshal([]byte(b), r)
+}
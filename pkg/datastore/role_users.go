@@ -0,0 +1,59 @@
+package datastore
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// RoleUsers binds a role to individual oidc users, every entry matches either the subject or the
+// email claim of a token.
+//
+//nolint:recvcheck
+type RoleUsers []string
+
+func (u RoleUsers) Validate() error {
+	for _, i := range u {
+		if len(i) == 0 {
+			return fmt.Errorf("empty user string: '%s'", i)
+		}
+	}
+
+	return nil
+}
+
+// Matches reports whether any entry is the given subject or email, empty claims never match.
+func (u RoleUsers) Matches(subject, email string) bool {
+	for _, i := range u {
+		if (subject != "" && i == subject) || (email != "" && i == email) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (u RoleUsers) Value() (driver.Value, error) {
+	return json.Marshal(u)
+}
+
+func (u RoleUsers) String() string {
+	b, err := json.Marshal(u)
+	if err != nil {
+		return ""
+	}
+
+	return string(b)
+}
+
+func (u *RoleUsers) Scan(value interface{}) error {
+	b, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("type assertion to string failed: got %T", value)
+	}
+	if b == "" {
+		return nil
+	}
+
+	return json.Unmarshal([]byte(b), u)
+}
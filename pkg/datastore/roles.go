@@ -10,17 +10,60 @@ type Role struct {
 	Namespace   string
 	Description string
 	OidcGroups  OidcGroups
+	// Users binds the role to individual oidc users in addition to OidcGroups.
+	Users       RoleUsers
 	Permissions Permissions
+	// ManagedBy is the path of the file in the namespace tree that declares the role, it is empty
+	// for roles managed through the api.
+	ManagedBy string
+	// Version is incremented by every update, a non-zero version passed to RolesStore.Update makes
+	// the update fail with ErrConflict when the role was changed since.
+	Version int64
+	// UpdatedBy identifies who made the last change, it is recorded as the author of the revision.
+	UpdatedBy string
 
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
 
+// RoleRevision is the definition of a role after one of its changes, revisions are numbered by
+// the version of the role. Revisions move along when a role is renamed and are removed with it.
+type RoleRevision struct {
+	Namespace   string
+	RoleName    string
+	Revision    int64
+	Description string
+	OidcGroups  OidcGroups
+	Users       RoleUsers
+	Permissions Permissions
+	Author      string
+
+	CreatedAt time.Time
+}
+
+// RolesFilter narrows down the result of RolesStore.List, zero fields are ignored.
+type RolesFilter struct {
+	// OidcGroup matches roles bound to the given group.
+	OidcGroup string
+	// User matches roles bound to the given user.
+	User string
+	// Topic matches roles with any permission on the given topic.
+	Topic      string
+	NamePrefix string
+}
+
 type RolesStore interface {
 	Create(ctx context.Context, role *Role) (*Role, error)
 	Delete(ctx context.Context, namespace, name string) error
 	Get(ctx context.Context, namespace, name string) (*Role, error)
+	// Update changes the role, renames fail with InvalidArgumentError while api tokens, service accounts
+	// or certificate bindings reference the role by its name.
 	Update(ctx context.Context, namespace, name string, role *Role) (*Role, error)
-	List(ctx context.Context, namespace string) ([]*Role, error)
+	// List returns a page of roles and the cursor of the next page, which is empty on the last page.
+	List(ctx context.Context, namespace string, filter RolesFilter, opts ListOptions) ([]*Role, string, error)
 	ListAll(ctx context.Context) ([]*Role, error)
+
+	// ListRevisions returns the revisions of a role, newest first.
+	ListRevisions(ctx context.Context, namespace, name string) ([]*RoleRevision, error)
+	GetRevision(ctx context.Context, namespace, name string, revision int64) (*RoleRevision, error)
 }
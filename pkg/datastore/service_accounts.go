@@ -0,0 +1,27 @@
+package datastore
+
+import (
+	"context"
+	"time"
+)
+
+// ServiceAccount is a non-human identity inside a namespace. Permissions are
+// granted to the account through its roles, and API tokens issued for the account
+// act as its credentials.
+type ServiceAccount struct {
+	Name        string
+	Namespace   string
+	Description string
+	Roles       RoleRefs
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+type ServiceAccountsStore interface {
+	Create(ctx context.Context, serviceAccount *ServiceAccount) (*ServiceAccount, error)
+	Delete(ctx context.Context, namespace, name string) error
+	Get(ctx context.Context, namespace, name string) (*ServiceAccount, error)
+	Update(ctx context.Context, namespace, name string, serviceAccount *ServiceAccount) (*ServiceAccount, error)
+	List(ctx context.Context, namespace string) ([]*ServiceAccount, error)
+}
@@ -0,0 +1,81 @@
+// Package events sends events into the namespaces of this direktiv instance.
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Broadcast posts the payload to the events broadcast endpoint of the given namespace.
+func Broadcast(ctx context.Context, namespace string, header http.Header, payload []byte) error {
+	url := fmt.Sprintf("http://localhost:%s/api/v2/namespaces/%s/events/broadcast",
+		os.Getenv("DIREKTIV_API_PORT"), namespace)
+
+	client := http.Client{}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	if header != nil {
+		req.Header = header
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	// add api key if required
+	if os.Getenv("DIREKTIV_API_KEY") != "" {
+		req.Header.Set("Direktiv-Api-Key", os.Getenv("DIREKTIV_API_KEY"))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("broadcasting event to namespace '%s': unexpected status code %d", namespace, resp.StatusCode)
+	}
+
+	return nil
+}
+
+// CloudEvent is a structured mode cloud event with a json payload.
+type CloudEvent struct {
+	SpecVersion     string    `json:"specversion"`
+	ID              string    `json:"id"`
+	Source          string    `json:"source"`
+	Type            string    `json:"type"`
+	Time            time.Time `json:"time"`
+	DataContentType string    `json:"datacontenttype"`
+	Data            any       `json:"data"`
+}
+
+func NewCloudEvent(eventType, source string, data any) *CloudEvent {
+	return &CloudEvent{
+		SpecVersion:     "1.0",
+		ID:              uuid.NewString(),
+		Source:          source,
+		Type:            eventType,
+		Time:            time.Now().UTC(),
+		DataContentType: "application/json",
+		Data:            data,
+	}
+}
+
+// Send broadcasts the event to the given namespace.
+func Send(ctx context.Context, namespace string, event *CloudEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("marshalling event: %w", err)
+	}
+
+	return Broadcast(ctx, namespace, nil, payload)
+}
@@ -0,0 +1,53 @@
+package events_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/direktiv/direktiv/direktiv-ee/pkg/events"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSend(t *testing.T) {
+	var (
+		gotPath   string
+		gotAPIKey string
+		gotEvent  events.CloudEvent
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotAPIKey = r.Header.Get("Direktiv-Api-Key")
+		_ = json.NewDecoder(r.Body).Decode(&gotEvent)
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	t.Setenv("DIREKTIV_API_PORT", u.Port())
+	t.Setenv("DIREKTIV_API_KEY", "password")
+
+	event := events.NewCloudEvent("io.direktiv.test", "/test", map[string]string{"foo": "bar"})
+	require.NoError(t, events.Send(context.Background(), "ns1", event))
+	require.Equal(t, "/api/v2/namespaces/ns1/events/broadcast", gotPath)
+	require.Equal(t, "password", gotAPIKey)
+	require.Equal(t, "io.direktiv.test", gotEvent.Type)
+	require.Equal(t, event.ID, gotEvent.ID)
+}
+
+func TestSendFailed(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	t.Setenv("DIREKTIV_API_PORT", u.Port())
+
+	err = events.Send(context.Background(), "ns1", events.NewCloudEvent("io.direktiv.test", "/test", nil))
+	require.Error(t, err)
+}
@@ -0,0 +1,420 @@
+package gitops
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"slices"
+	"sort"
+	"strings"
+
+	isoDuration "github.com/ChannelMeter/iso8601duration"
+	eeDStore "github.com/direktiv/direktiv/direktiv-ee/pkg/datastore"
+	"github.com/direktiv/direktiv/pkg/database"
+	"github.com/direktiv/direktiv/pkg/filestore"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// RolesDirectory holds one role declaration per yaml file.
+	RolesDirectory = "/.direktiv/roles"
+	// APITokenPolicyFile declares the api token policy of the namespace.
+	APITokenPolicyFile = "/.direktiv/api_token_policy.yaml"
+
+	// rbacSyncLock is combined with a hash of the namespace, so that namespaces sync independently.
+	rbacSyncLock int64 = 0x64_6b_76_03
+)
+
+const (
+	KindRole           = "role"
+	KindAPITokenPolicy = "api_token_policy"
+
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+	// ActionAdopt turns a role created through the api into a managed one.
+	ActionAdopt = "adopt"
+)
+
+// SyncAuthor is the author of role revisions made by syncs.
+const SyncAuthor = "rbac_sync"
+
+// ErrSyncInProgress is returned when another replica is syncing the namespace.
+var ErrSyncInProgress = errors.New("rbac sync in progress")
+
+// DeclarationError is an invalid declaration in the file at Path.
+type DeclarationError struct {
+	Path string
+	Err  error
+}
+
+func (e *DeclarationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Err)
+}
+
+func (e *DeclarationError) Unwrap() error {
+	return e.Err
+}
+
+func declarationError(path, format string, args ...any) error {
+	return &DeclarationError{Path: path, Err: fmt.Errorf(format, args...)}
+}
+
+// Change is a difference between the declarations in the namespace tree and the datastore.
+type Change struct {
+	Kind   string
+	Name   string
+	Action string
+	// Path is the declaring file, for deletions the file that used to declare the object.
+	Path string
+}
+
+type roleFile struct {
+	Name        string   `yaml:"name"`
+	Description string   `yaml:"description"`
+	OidcGroups  []string `yaml:"oidcGroups"`
+	Users       []string `yaml:"users"`
+	Permissions []struct {
+		Topic  string `yaml:"topic"`
+		Method string `yaml:"method"`
+	} `yaml:"permissions"`
+}
+
+type apiTokenPolicyFile struct {
+	MaxLifetime        string `yaml:"maxLifetime"`
+	DefaultLifetime    string `yaml:"defaultLifetime"`
+	PermissionsCeiling []struct {
+		Topic  string `yaml:"topic"`
+		Method string `yaml:"method"`
+	} `yaml:"permissionsCeiling"`
+	AllowNonExpiring bool `yaml:"allowNonExpiring"`
+}
+
+// Declarations are the rbac objects declared in the tree of a namespace.
+type Declarations struct {
+	Roles []*eeDStore.Role
+	// APITokenPolicy is nil when the namespace doesn't declare one.
+	APITokenPolicy *eeDStore.APITokenPolicy
+}
+
+// ParseRole parses the role declared in the file at path.
+func ParseRole(namespace, path string, data []byte) (*eeDStore.Role, error) {
+	f := &roleFile{}
+	if err := yaml.Unmarshal(data, f); err != nil {
+		return nil, &DeclarationError{Path: path, Err: err}
+	}
+	if f.Name == "" {
+		return nil, declarationError(path, "name is required")
+	}
+	role := &eeDStore.Role{
+		Name:        f.Name,
+		Namespace:   namespace,
+		Description: f.Description,
+		OidcGroups:  f.OidcGroups,
+		Users:       f.Users,
+		ManagedBy:   path,
+		UpdatedBy:   SyncAuthor,
+	}
+	for _, p := range f.Permissions {
+		role.Permissions = append(role.Permissions, &eeDStore.Permission{Namespace: namespace, Topic: p.Topic, Method: p.Method})
+	}
+	if err := role.Permissions.Validate(); err != nil {
+		return nil, declarationError(path, "permissions: %w", err)
+	}
+	if err := role.OidcGroups.Validate(); err != nil {
+		return nil, declarationError(path, "oidcGroups: %w", err)
+	}
+	if err := role.Users.Validate(); err != nil {
+		return nil, declarationError(path, "users: %w", err)
+	}
+
+	return role, nil
+}
+
+// ParseAPITokenPolicy parses the api token policy declared in the file at path.
+func ParseAPITokenPolicy(namespace, path string, data []byte) (*eeDStore.APITokenPolicy, error) {
+	f := &apiTokenPolicyFile{}
+	if err := yaml.Unmarshal(data, f); err != nil {
+		return nil, &DeclarationError{Path: path, Err: err}
+	}
+	policy := &eeDStore.APITokenPolicy{
+		Namespace:        namespace,
+		AllowNonExpiring: f.AllowNonExpiring,
+		ManagedBy:        path,
+	}
+	if f.MaxLifetime != "" {
+		d, err := isoDuration.FromString(f.MaxLifetime)
+		if err != nil {
+			return nil, declarationError(path, "maxLifetime: invalid iso8601 duration format")
+		}
+		policy.MaxLifetime = d.ToDuration()
+	}
+	if f.DefaultLifetime != "" {
+		d, err := isoDuration.FromString(f.DefaultLifetime)
+		if err != nil {
+			return nil, declarationError(path, "defaultLifetime: invalid iso8601 duration format")
+		}
+		policy.DefaultLifetime = d.ToDuration()
+	}
+	for _, p := range f.PermissionsCeiling {
+		policy.PermissionsCeiling = append(policy.PermissionsCeiling,
+			&eeDStore.Permission{Namespace: namespace, Topic: p.Topic, Method: p.Method})
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, &DeclarationError{Path: path, Err: err}
+	}
+
+	return policy, nil
+}
+
+// Load reads the declarations from the tree of the namespace, a namespace without declarations
+// returns empty ones.
+func Load(ctx context.Context, db *database.DB, namespace string) (*Declarations, error) {
+	decl := &Declarations{}
+	root := db.FileStore().ForNamespace(namespace)
+
+	files, err := root.ReadDirectory(ctx, RolesDirectory)
+	if err != nil && !errors.Is(err, filestore.ErrNotFound) {
+		return nil, err
+	}
+	seen := map[string]string{}
+	for _, f := range files {
+		if f.Typ == filestore.FileTypeDirectory ||
+			(!strings.HasSuffix(f.Path, ".yaml") && !strings.HasSuffix(f.Path, ".yml")) {
+			continue
+		}
+		data, err := db.FileStore().ForFile(f).GetData(ctx)
+		if err != nil {
+			return nil, err
+		}
+		role, err := ParseRole(namespace, f.Path, data)
+		if err != nil {
+			return nil, err
+		}
+		if other, ok := seen[role.Name]; ok {
+			return nil, declarationError(f.Path, "role '%s' is already declared in %s", role.Name, other)
+		}
+		seen[role.Name] = f.Path
+		decl.Roles = append(decl.Roles, role)
+	}
+
+	f, err := root.GetFile(ctx, APITokenPolicyFile)
+	if err != nil && !errors.Is(err, filestore.ErrNotFound) {
+		return nil, err
+	}
+	if err == nil {
+		data, err := db.FileStore().ForFile(f).GetData(ctx)
+		if err != nil {
+			return nil, err
+		}
+		decl.APITokenPolicy, err = ParseAPITokenPolicy(namespace, f.Path, data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return decl, nil
+}
+
+// Plan returns the changes that make the stored roles and policy match the declarations, policy is
+// nil when the namespace has no stored policy. Roles created through the api are only touched when
+// a file declares a role with the same name.
+func Plan(decl *Declarations, roles []*eeDStore.Role, policy *eeDStore.APITokenPolicy) []Change {
+	var changes []Change
+
+	stored := map[string]*eeDStore.Role{}
+	for _, role := range roles {
+		stored[role.Name] = role
+	}
+	declared := map[string]bool{}
+	for _, role := range decl.Roles {
+		declared[role.Name] = true
+		current, ok := stored[role.Name]
+		switch {
+		case !ok:
+			changes = append(changes, Change{Kind: KindRole, Name: role.Name, Action: ActionCreate, Path: role.ManagedBy})
+		case current.ManagedBy == "":
+			changes = append(changes, Change{Kind: KindRole, Name: role.Name, Action: ActionAdopt, Path: role.ManagedBy})
+		case !equalRoles(current, role):
+			changes = append(changes, Change{Kind: KindRole, Name: role.Name, Action: ActionUpdate, Path: role.ManagedBy})
+		}
+	}
+	for _, role := range roles {
+		if role.ManagedBy != "" && !declared[role.Name] {
+			changes = append(changes, Change{Kind: KindRole, Name: role.Name, Action: ActionDelete, Path: role.ManagedBy})
+		}
+	}
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].Name < changes[j].Name })
+
+	switch {
+	case decl.APITokenPolicy != nil && policy == nil:
+		changes = append(changes, Change{Kind: KindAPITokenPolicy, Action: ActionCreate, Path: decl.APITokenPolicy.ManagedBy})
+	case decl.APITokenPolicy != nil && policy.ManagedBy == "":
+		changes = append(changes, Change{Kind: KindAPITokenPolicy, Action: ActionAdopt, Path: decl.APITokenPolicy.ManagedBy})
+	case decl.APITokenPolicy != nil && !equalPolicies(policy, decl.APITokenPolicy):
+		changes = append(changes, Change{Kind: KindAPITokenPolicy, Action: ActionUpdate, Path: decl.APITokenPolicy.ManagedBy})
+	case decl.APITokenPolicy == nil && policy != nil && policy.ManagedBy != "":
+		changes = append(changes, Change{Kind: KindAPITokenPolicy, Action: ActionDelete, Path: policy.ManagedBy})
+	}
+
+	return changes
+}
+
+func equalRoles(a, b *eeDStore.Role) bool {
+	return a.Description == b.Description &&
+		a.ManagedBy == b.ManagedBy &&
+		slices.Equal(a.OidcGroups, b.OidcGroups) &&
+		slices.Equal(a.Users, b.Users) &&
+		equalPermissions(a.Permissions, b.Permissions)
+}
+
+func equalPolicies(a, b *eeDStore.APITokenPolicy) bool {
+	return a.MaxLifetime == b.MaxLifetime &&
+		a.DefaultLifetime == b.DefaultLifetime &&
+		a.AllowNonExpiring == b.AllowNonExpiring &&
+		a.ManagedBy == b.ManagedBy &&
+		(a.PermissionsCeiling == nil) == (b.PermissionsCeiling == nil) &&
+		equalPermissions(a.PermissionsCeiling, b.PermissionsCeiling)
+}
+
+func equalPermissions(a, b eeDStore.Permissions) bool {
+	return slices.EqualFunc(a, b, func(x, y *eeDStore.Permission) bool {
+		return x.Topic == y.Topic && x.Method == y.Method
+	})
+}
+
+// RBACSyncer reconciles the roles and the api token policy of namespaces with their declarations.
+type RBACSyncer struct {
+	db     *database.DB
+	eStore eeDStore.Store
+}
+
+func NewRBACSyncer(db *database.DB, eStore eeDStore.Store) *RBACSyncer {
+	return &RBACSyncer{
+		db:     db,
+		eStore: eStore,
+	}
+}
+
+// Drift returns the changes the next sync of the namespace would apply, files edited since the last
+// sync and out of band changes to the datastore both show up as drift.
+func (s *RBACSyncer) Drift(ctx context.Context, namespace string) ([]Change, error) {
+	db, err := s.db.BeginTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Rollback()
+
+	_, changes, err := s.plan(ctx, db, namespace)
+
+	return changes, err
+}
+
+// SyncCheck vets the declarations and the planned changes before a sync applies them, an error
+// aborts the sync.
+type SyncCheck func(decl *Declarations, changes []Change) error
+
+// Sync applies the declarations of the namespace in a single transaction and returns the applied
+// changes. Invalid declarations fail the whole sync and leave the datastore untouched, so does an
+// error of check. A nil check applies whatever the namespace tree declares.
+func (s *RBACSyncer) Sync(ctx context.Context, namespace string, check SyncCheck) ([]Change, error) {
+	db, err := s.db.BeginTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Rollback()
+
+	store := s.eStore.With(db.Conn())
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(namespace))
+	locked, err := store.TryLock(ctx, rbacSyncLock<<32|int64(h.Sum32()))
+	if err != nil {
+		return nil, err
+	}
+	if !locked {
+		return nil, ErrSyncInProgress
+	}
+
+	decl, changes, err := s.plan(ctx, db, namespace)
+	if err != nil {
+		return nil, err
+	}
+	if check != nil {
+		if err := check(decl, changes); err != nil {
+			return nil, err
+		}
+	}
+	declared := map[string]*eeDStore.Role{}
+	for _, role := range decl.Roles {
+		declared[role.Name] = role
+	}
+
+	for _, c := range changes {
+		switch {
+		case c.Kind == KindRole && c.Action == ActionCreate:
+			_, err = store.Roles().Create(ctx, declared[c.Name])
+		case c.Kind == KindRole && c.Action == ActionDelete:
+			err = store.Roles().Delete(ctx, namespace, c.Name)
+		case c.Kind == KindRole:
+			_, err = store.Roles().Update(ctx, namespace, c.Name, declared[c.Name])
+		case c.Action == ActionDelete:
+			err = store.APITokenPolicies().Delete(ctx, namespace)
+		default:
+			_, err = store.APITokenPolicies().Set(ctx, decl.APITokenPolicy)
+		}
+		var vErrs eeDStore.InvalidArgumentError
+		if errors.As(err, &vErrs) {
+			return nil, &DeclarationError{Path: c.Path, Err: err}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := db.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	return changes, nil
+}
+
+func (s *RBACSyncer) plan(ctx context.Context, db *database.DB, namespace string) (*Declarations, []Change, error) {
+	decl, err := Load(ctx, db, namespace)
+	if err != nil {
+		return nil, nil, err
+	}
+	store := s.eStore.With(db.Conn())
+	roles, _, err := store.Roles().List(ctx, namespace, eeDStore.RolesFilter{}, eeDStore.ListOptions{SortBy: eeDStore.SortByName})
+	if err != nil {
+		return nil, nil, err
+	}
+	policy, err := store.APITokenPolicies().Get(ctx, namespace)
+	if errors.Is(err, eeDStore.ErrNotFound) {
+		policy, err = nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return decl, Plan(decl, roles, policy), nil
+}
+
+// Run syncs a namespace and logs the outcome, it is meant to be called on mirror syncs. Mirror syncs
+// trust the repository: whoever can push to it can declare any role, there is no caller whose grants
+// could be checked.
+func (s *RBACSyncer) Run(ctx context.Context, namespace string) {
+	changes, err := s.Sync(ctx, namespace, nil)
+	if errors.Is(err, ErrSyncInProgress) {
+		return
+	}
+	if err != nil {
+		slog.Error("syncing rbac declarations", "namespace", namespace, "err", err)
+		return
+	}
+	for _, c := range changes {
+		slog.Info("synced rbac declaration", "namespace", namespace, "kind", c.Kind, "name", c.Name,
+			"action", c.Action, "path", c.Path)
+	}
+}
@@ -0,0 +1,101 @@
+package gitops_test
+
+import (
+	"testing"
+	"time"
+
+	eeDStore "github.com/direktiv/direktiv/direktiv-ee/pkg/datastore"
+	"github.com/direktiv/direktiv/direktiv-ee/pkg/gitops"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRole(t *testing.T) {
+	role, err := gitops.ParseRole("ns", "/.direktiv/roles/readers.yaml", []byte(`
+name: readers
+description: reads secrets
+oidcGroups:
+  - g1
+users:
+  - alice@example.com
+permissions:
+  - topic: secrets
+    method: read
+`))
+	require.NoError(t, err)
+	require.Equal(t, "readers", role.Name)
+	require.Equal(t, "ns", role.Namespace)
+	require.Equal(t, "reads secrets", role.Description)
+	require.Equal(t, eeDStore.OidcGroups{"g1"}, role.OidcGroups)
+	require.Equal(t, eeDStore.RoleUsers{"alice@example.com"}, role.Users)
+	require.Equal(t, "/.direktiv/roles/readers.yaml", role.ManagedBy)
+	require.Len(t, role.Permissions, 1)
+	require.Equal(t, "secrets", role.Permissions[0].Topic)
+
+	_, err = gitops.ParseRole("ns", "/.direktiv/roles/unnamed.yaml", []byte(`description: no name`))
+	require.ErrorContains(t, err, "name is required")
+
+	_, err = gitops.ParseRole("ns", "/.direktiv/roles/bad.yaml", []byte(`
+name: bad
+permissions:
+  - topic: secrets
+    method: write
+`))
+	require.ErrorContains(t, err, "/.direktiv/roles/bad.yaml")
+}
+
+func TestParseAPITokenPolicy(t *testing.T) {
+	policy, err := gitops.ParseAPITokenPolicy("ns", gitops.APITokenPolicyFile, []byte(`
+maxLifetime: P30D
+defaultLifetime: PT12H
+allowNonExpiring: true
+`))
+	require.NoError(t, err)
+	require.Equal(t, time.Hour*24*30, policy.MaxLifetime)
+	require.Equal(t, time.Hour*12, policy.DefaultLifetime)
+	require.True(t, policy.AllowNonExpiring)
+	require.Nil(t, policy.PermissionsCeiling)
+	require.Equal(t, gitops.APITokenPolicyFile, policy.ManagedBy)
+
+	_, err = gitops.ParseAPITokenPolicy("ns", gitops.APITokenPolicyFile, []byte(`maxLifetime: 30 days`))
+	require.ErrorContains(t, err, "maxLifetime")
+}
+
+func TestPlan(t *testing.T) {
+	role := func(name, description, managedBy string) *eeDStore.Role {
+		return &eeDStore.Role{Name: name, Namespace: "ns", Description: description, ManagedBy: managedBy}
+	}
+
+	decl := &gitops.Declarations{
+		Roles: []*eeDStore.Role{
+			role("new", "", "/.direktiv/roles/new.yaml"),
+			role("same", "x", "/.direktiv/roles/same.yaml"),
+			role("changed", "new", "/.direktiv/roles/changed.yaml"),
+			role("adopted", "", "/.direktiv/roles/adopted.yaml"),
+		},
+		APITokenPolicy: &eeDStore.APITokenPolicy{Namespace: "ns", MaxLifetime: time.Hour, ManagedBy: gitops.APITokenPolicyFile},
+	}
+	stored := []*eeDStore.Role{
+		role("same", "x", "/.direktiv/roles/same.yaml"),
+		role("changed", "old", "/.direktiv/roles/changed.yaml"),
+		role("adopted", "", ""),
+		role("removed", "", "/.direktiv/roles/removed.yaml"),
+		role("api", "", ""),
+	}
+
+	changes := gitops.Plan(decl, stored, nil)
+	require.Equal(t, []gitops.Change{
+		{Kind: gitops.KindRole, Name: "adopted", Action: gitops.ActionAdopt, Path: "/.direktiv/roles/adopted.yaml"},
+		{Kind: gitops.KindRole, Name: "changed", Action: gitops.ActionUpdate, Path: "/.direktiv/roles/changed.yaml"},
+		{Kind: gitops.KindRole, Name: "new", Action: gitops.ActionCreate, Path: "/.direktiv/roles/new.yaml"},
+		{Kind: gitops.KindRole, Name: "removed", Action: gitops.ActionDelete, Path: "/.direktiv/roles/removed.yaml"},
+		{Kind: gitops.KindAPITokenPolicy, Action: gitops.ActionCreate, Path: gitops.APITokenPolicyFile},
+	}, changes)
+
+	// A managed policy that is no longer declared is removed, an api managed one is kept.
+	managed := &eeDStore.APITokenPolicy{Namespace: "ns", ManagedBy: gitops.APITokenPolicyFile}
+	changes = gitops.Plan(&gitops.Declarations{}, nil, managed)
+	require.Equal(t, []gitops.Change{
+		{Kind: gitops.KindAPITokenPolicy, Action: gitops.ActionDelete, Path: gitops.APITokenPolicyFile},
+	}, changes)
+	require.Empty(t, gitops.Plan(&gitops.Declarations{}, nil, &eeDStore.APITokenPolicy{Namespace: "ns"}))
+}
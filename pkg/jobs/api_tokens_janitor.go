@@ -0,0 +1,93 @@
+package jobs
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	eeDStore "github.com/direktiv/direktiv/direktiv-ee/pkg/datastore"
+	"github.com/direktiv/direktiv/pkg/database"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// apiTokensJanitorLock is the advisory lock key that makes sure only one replica purges at a time.
+const apiTokensJanitorLock int64 = 0x64_6b_76_01
+
+var apiTokensPurged = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "direktiv_api_tokens_purged_total",
+	Help: "Number of expired api tokens purged by the janitor.",
+}, []string{"mode"})
+
+// APITokensJanitor purges api tokens that are expired longer than the retention.
+type APITokensJanitor struct {
+	db     *database.DB
+	eStore eeDStore.Store
+
+	// Retention is how long expired tokens are kept before being purged.
+	Retention time.Duration
+	// Archive moves purged tokens to the archive table instead of deleting them.
+	Archive bool
+}
+
+func NewAPITokensJanitor(db *database.DB, eStore eeDStore.Store, retention time.Duration, archive bool) *APITokensJanitor {
+	return &APITokensJanitor{
+		db:        db,
+		eStore:    eStore,
+		Retention: retention,
+		Archive:   archive,
+	}
+}
+
+// Run purges expired tokens every interval until ctx is done.
+func (j *APITokensJanitor) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purged, err := j.Purge(ctx)
+			if err != nil {
+				slog.Error("purging expired api tokens", "err", err)
+				continue
+			}
+			if purged > 0 {
+				slog.Info("purged expired api tokens", "count", purged, "archive", j.Archive)
+			}
+		}
+	}
+}
+
+// Purge runs a single purge, it does nothing when another replica is purging.
+func (j *APITokensJanitor) Purge(ctx context.Context) (int64, error) {
+	db, err := j.db.BeginTx(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Rollback()
+
+	store := j.eStore.With(db.Conn())
+	locked, err := store.TryLock(ctx, apiTokensJanitorLock)
+	if err != nil || !locked {
+		return 0, err
+	}
+
+	purged, err := store.APITokens().PurgeExpired(ctx, time.Now().Add(-j.Retention), j.Archive)
+	if err != nil {
+		return 0, err
+	}
+	if err := db.Commit(ctx); err != nil {
+		return 0, err
+	}
+
+	mode := "delete"
+	if j.Archive {
+		mode = "archive"
+	}
+	apiTokensPurged.WithLabelValues(mode).Add(float64(purged))
+
+	return purged, nil
+}
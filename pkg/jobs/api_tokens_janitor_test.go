@@ -0,0 +1,125 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/direktiv/direktiv/direktiv-ee/pkg/datastore"
+	"github.com/direktiv/direktiv/direktiv-ee/pkg/datastore/datasql"
+	"github.com/direktiv/direktiv/pkg/database"
+	"github.com/google/uuid"
+)
+
+// newJanitorTest creates a test database with a token that expired long ago, one that just expired
+// and one that is still valid.
+func newJanitorTest(t *testing.T) (*database.DB, string) {
+	t.Helper()
+	ctx := context.Background()
+
+	db, ns, err := database.NewTestDBWithNamespace(t, uuid.NewString())
+	if err != nil {
+		t.Fatalf("unexpected NewTestDBWithNamespace() error = %v", err)
+	}
+	if res := db.Conn().Exec(datasql.Schema); res.Error != nil {
+		t.Fatalf("unexpected exec db_schema error = %v", res.Error)
+	}
+
+	store := datasql.New().With(db.Conn()).APITokens()
+	for name, expiresIn := range map[string]time.Duration{"old": -time.Hour * 24 * 10, "recent": -time.Hour, "valid": time.Hour} {
+		expiredAt := time.Now().Add(expiresIn)
+		_, err := store.Create(ctx, &datastore.APIToken{
+			Name:        name,
+			Namespace:   ns.Name,
+			Format:      datastore.APITokenFormatUUID,
+			Hash:        uuid.New(),
+			Permissions: datastore.Permissions{{Topic: "secrets", Method: "GET"}},
+			ExpiredAt:   &expiredAt,
+		})
+		if err != nil {
+			t.Fatalf("unexpected APITokens().Create() error = %v", err)
+		}
+	}
+
+	return db, ns.Name
+}
+
+func tokenNames(t *testing.T, db *database.DB, table, namespace string) []string {
+	t.Helper()
+	var names []string
+	res := db.Conn().Raw(`SELECT name FROM `+table+` WHERE namespace = ? ORDER BY name`, namespace).Scan(&names)
+	if res.Error != nil {
+		t.Fatalf("unexpected select %s error = %v", table, res.Error)
+	}
+
+	return names
+}
+
+func Test_APITokensJanitor_Purge(t *testing.T) {
+	tests := []struct {
+		name        string
+		archive     bool
+		wantArchive []string
+	}{
+		{"delete", false, nil},
+		{"archive", true, []string{"old"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, ns := newJanitorTest(t)
+			j := NewAPITokensJanitor(db, datasql.New(), time.Hour*24*7, tt.archive)
+
+			purged, err := j.Purge(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected Purge() error = %v", err)
+			}
+			if purged != 1 {
+				t.Errorf("Purge() = %d, want the old token only", purged)
+			}
+			if got := tokenNames(t, db, "ee_api_tokens", ns); !slices.Equal(got, []string{"recent", "valid"}) {
+				t.Errorf("tokens = %v after Purge(), want the ones within the retention", got)
+			}
+			if got := tokenNames(t, db, "ee_api_tokens_archive", ns); !slices.Equal(got, tt.wantArchive) {
+				t.Errorf("archived tokens = %v, want %v", got, tt.wantArchive)
+			}
+
+			// Purged tokens are gone, a second run has nothing left to do.
+			if purged, err := j.Purge(context.Background()); err != nil || purged != 0 {
+				t.Errorf("Purge() = %d, %v again, want nothing purged", purged, err)
+			}
+		})
+	}
+}
+
+func Test_APITokensJanitor_Run(t *testing.T) {
+	db, ns := newJanitorTest(t)
+	j := NewAPITokensJanitor(db, datasql.New(), 0, false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		j.Run(ctx, time.Millisecond*10)
+		close(done)
+	}()
+
+	_, err := datasql.New().With(db.Conn()).APITokens().Get(context.Background(), ns, "recent")
+	for deadline := time.Now().Add(time.Second * 5); err == nil && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond * 10)
+		_, err = datasql.New().With(db.Conn()).APITokens().Get(context.Background(), ns, "recent")
+	}
+	if !errors.Is(err, datastore.ErrNotFound) {
+		t.Errorf("APITokens().Get() error = %v while Run() is running, wantErr %v", err, datastore.ErrNotFound)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		t.Fatalf("Run() didn't return after ctx was canceled")
+	}
+	if got := tokenNames(t, db, "ee_api_tokens", ns); !slices.Equal(got, []string{"valid"}) {
+		t.Errorf("tokens = %v after Run(), want the valid one only", got)
+	}
+}
@@ -0,0 +1,132 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	eeDStore "github.com/direktiv/direktiv/direktiv-ee/pkg/datastore"
+	"github.com/direktiv/direktiv/direktiv-ee/pkg/events"
+	"github.com/direktiv/direktiv/direktiv-ee/pkg/license"
+	"github.com/direktiv/direktiv/pkg/core"
+	"github.com/direktiv/direktiv/pkg/database"
+)
+
+const (
+	expiryNotifierLock int64 = 0x64_6b_76_02
+
+	EventTypeAPITokenExpiring = "io.direktiv.api_token.expiring"
+	EventTypeLicenseExpiring  = "io.direktiv.license.expiring"
+)
+
+// ExpiryNotifier emits cloud events ahead of the expiry of api tokens and the license, api token
+// events go to the namespace of the token and license events to the system namespace.
+type ExpiryNotifier struct {
+	db     *database.DB
+	eStore eeDStore.Store
+	// license is nil when there is no license to watch.
+	license *license.License
+
+	// Ahead is how long before the expiry the event is sent.
+	Ahead time.Duration
+	send  func(ctx context.Context, namespace string, event *events.CloudEvent) error
+}
+
+func NewExpiryNotifier(db *database.DB, eStore eeDStore.Store, l *license.License, ahead time.Duration) *ExpiryNotifier {
+	return &ExpiryNotifier{
+		db:      db,
+		eStore:  eStore,
+		license: l,
+		Ahead:   ahead,
+		send:    events.Send,
+	}
+}
+
+// Run checks for upcoming expiries every interval until ctx is done.
+func (n *ExpiryNotifier) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := n.Notify(ctx); err != nil {
+				slog.Error("sending expiry notifications", "err", err)
+			}
+		}
+	}
+}
+
+// Notify sends the events of a single run, it does nothing when another replica is notifying.
+// Events that fail to be sent are retried on the next run.
+func (n *ExpiryNotifier) Notify(ctx context.Context) error {
+	db, err := n.db.BeginTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Rollback()
+
+	store := n.eStore.With(db.Conn())
+	locked, err := store.TryLock(ctx, expiryNotifierLock)
+	if err != nil || !locked {
+		return err
+	}
+
+	if err := n.notifyLicense(ctx, store); err != nil {
+		return err
+	}
+
+	list, err := store.APITokens().ListExpiring(ctx, time.Now().Add(n.Ahead))
+	if err != nil {
+		return fmt.Errorf("listing expiring api tokens: %w", err)
+	}
+	for _, t := range list {
+		event := events.NewCloudEvent(EventTypeAPITokenExpiring,
+			fmt.Sprintf("/namespaces/%s/api_tokens/%s", t.Namespace, t.Name),
+			map[string]any{
+				"namespace":      t.Namespace,
+				"name":           t.Name,
+				"serviceAccount": t.ServiceAccount,
+				"expiredAt":      t.ExpiredAt,
+			})
+		if err := n.send(ctx, t.Namespace, event); err != nil {
+			slog.Error("sending api token expiry event", "err", err, "namespace", t.Namespace, "name", t.Name)
+			continue
+		}
+		if err := store.APITokens().SetExpiryNotified(ctx, t.Namespace, t.Name); err != nil {
+			return fmt.Errorf("marking api token '%s' notified: %w", t.Name, err)
+		}
+	}
+
+	return db.Commit(ctx)
+}
+
+func (n *ExpiryNotifier) notifyLicense(ctx context.Context, store eeDStore.StoreInner) error {
+	if n.license == nil {
+		return nil
+	}
+	expiresAt, err := time.Parse(time.RFC3339, n.license.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("invalid license expiresAt: %w", err)
+	}
+	if time.Until(expiresAt) > n.Ahead {
+		return nil
+	}
+
+	// A renewed license has a different expiry, so it gets its own notification.
+	notified, err := store.SetExpiryNotified(ctx, "license:"+n.license.ExpiresAt)
+	if err != nil || !notified {
+		return err
+	}
+	event := events.NewCloudEvent(EventTypeLicenseExpiring, "/license", map[string]any{
+		"to":        n.license.To,
+		"type":      n.license.Type,
+		"expiresAt": expiresAt,
+	})
+
+	// The notification mark rolls back with the transaction when sending fails.
+	return n.send(ctx, core.SystemNamespace, event)
+}
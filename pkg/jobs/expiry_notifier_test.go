@@ -0,0 +1,93 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/direktiv/direktiv/direktiv-ee/pkg/datastore"
+	"github.com/direktiv/direktiv/direktiv-ee/pkg/datastore/datasql"
+	"github.com/direktiv/direktiv/direktiv-ee/pkg/events"
+	"github.com/direktiv/direktiv/direktiv-ee/pkg/license"
+	"github.com/direktiv/direktiv/pkg/core"
+	"github.com/direktiv/direktiv/pkg/database"
+	"github.com/google/uuid"
+)
+
+func Test_ExpiryNotifier_Notify(t *testing.T) {
+	ctx := context.Background()
+
+	db, ns, err := database.NewTestDBWithNamespace(t, uuid.NewString())
+	if err != nil {
+		t.Fatalf("unexpected NewTestDBWithNamespace() error = %v", err)
+	}
+	if res := db.Conn().Exec(datasql.Schema); res.Error != nil {
+		t.Fatalf("unexpected exec db_schema error = %v", res.Error)
+	}
+
+	store := datasql.New().With(db.Conn()).APITokens()
+	for name, expiresIn := range map[string]time.Duration{"soon": time.Hour, "later": time.Hour * 48} {
+		expiredAt := time.Now().Add(expiresIn)
+		_, err := store.Create(ctx, &datastore.APIToken{
+			Name:        name,
+			Namespace:   ns.Name,
+			Format:      datastore.APITokenFormatUUID,
+			Hash:        uuid.New(),
+			Permissions: datastore.Permissions{{Topic: "secrets", Method: "GET"}},
+			ExpiredAt:   &expiredAt,
+		})
+		if err != nil {
+			t.Fatalf("unexpected APITokens().Create() error = %v", err)
+		}
+	}
+
+	// Every run gets its own license expiry, notifications of a license are only sent once.
+	l := &license.License{To: "test", Type: "enterprise", ExpiresAt: time.Now().Add(time.Hour).UTC().Format(time.RFC3339)}
+	n := NewExpiryNotifier(db, datasql.New(), l, time.Hour*24)
+	var (
+		sent    map[string]*events.CloudEvent
+		sendErr error
+	)
+	n.send = func(_ context.Context, namespace string, event *events.CloudEvent) error {
+		if sendErr != nil {
+			return sendErr
+		}
+		sent[namespace+" "+event.Type] = event
+
+		return nil
+	}
+
+	// Failed events are sent again on the next run.
+	sent, sendErr = map[string]*events.CloudEvent{}, errors.New("broadcast failed")
+	if err := n.Notify(ctx); err == nil {
+		t.Errorf("Notify() error = nil, want the error of the license event")
+	}
+
+	sent, sendErr = map[string]*events.CloudEvent{}, nil
+	if err := n.Notify(ctx); err != nil {
+		t.Fatalf("unexpected Notify() error = %v", err)
+	}
+	if len(sent) != 2 {
+		t.Fatalf("Notify() sent %v, want the events of the soon token and the license", sent)
+	}
+	event, ok := sent[ns.Name+" "+EventTypeAPITokenExpiring]
+	if !ok {
+		t.Fatalf("Notify() sent no %s event to namespace %s", EventTypeAPITokenExpiring, ns.Name)
+	}
+	if event.Source != "/namespaces/"+ns.Name+"/api_tokens/soon" {
+		t.Errorf("Notify() sent event with source %s, want the soon token", event.Source)
+	}
+	if _, ok := sent[core.SystemNamespace+" "+EventTypeLicenseExpiring]; !ok {
+		t.Errorf("Notify() sent no %s event to the system namespace", EventTypeLicenseExpiring)
+	}
+
+	// Tokens and licenses are notified once.
+	sent = map[string]*events.CloudEvent{}
+	if err := n.Notify(ctx); err != nil {
+		t.Fatalf("unexpected Notify() error = %v", err)
+	}
+	if len(sent) != 0 {
+		t.Errorf("Notify() sent %v again", sent)
+	}
+}
@@ -114,13 +114,23 @@ func Verify(l License, pk []byte) error {
 }
 
 func VerifyJSON(str string, pk string) error {
+	l, err := ParseJSON(str)
+	if err != nil {
+		return err
+	}
+
+	return Verify(l, []byte(pk))
+}
+
+// ParseJSON decodes a license without verifying it.
+func ParseJSON(str string) (License, error) {
 	l := License{}
 	err := json.Unmarshal([]byte(str), &l)
 	if err != nil {
-		return fmt.Errorf("invalid json format: %w", err)
+		return l, fmt.Errorf("invalid json format: %w", err)
 	}
 
-	return Verify(l, []byte(pk))
+	return l, nil
 }
 
 func marshalAndDigest(l *License) ([]byte, error) {
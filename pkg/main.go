@@ -1,13 +1,22 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/direktiv/direktiv/cmd/cli"
 	"github.com/direktiv/direktiv/direktiv-ee/pkg/api"
+	"github.com/direktiv/direktiv/direktiv-ee/pkg/apitoken"
 	"github.com/direktiv/direktiv/direktiv-ee/pkg/datastore/datasql"
+	"github.com/direktiv/direktiv/direktiv-ee/pkg/gitops"
+	"github.com/direktiv/direktiv/direktiv-ee/pkg/jobs"
 	"github.com/direktiv/direktiv/direktiv-ee/pkg/license"
 	_ "github.com/direktiv/direktiv/direktiv-ee/pkg/plugins/inbound"
 	_ "github.com/direktiv/direktiv/direktiv-ee/pkg/plugins/target"
@@ -19,7 +28,6 @@ import (
 	_ "github.com/direktiv/direktiv/pkg/gateway/plugins/outbound"
 	_ "github.com/direktiv/direktiv/pkg/gateway/plugins/target"
 	"github.com/direktiv/direktiv/pkg/pubsub"
-	"github.com/go-chi/chi/v5"
 	"github.com/hashicorp/golang-lru/v2/expirable"
 )
 
@@ -33,22 +41,6 @@ func init() {
 	extensions.AdditionalSchema = datasql.Schema
 
 	extensions.Initialize = func(db *database.DB, bus *pubsub.Bus, config *core.Config) error {
-		apiCtr := api.NewAPITokensController(db, datasql.New())
-		rolesCtr := api.NewRolesController(db, datasql.New())
-		mwCtr := api.NewMiddlewares(
-			db,
-			config,
-			datasql.New(),
-			expirable.NewLRU[string, string](1000, nil, time.Second*30))
-
-		extensions.AdditionalAPIRoutes = map[string]func(r chi.Router){
-			"/namespaces/{namespace}/api_tokens": apiCtr.MountRouter,
-			"/namespaces/{namespace}/roles":      rolesCtr.MountRouter,
-		}
-		extensions.CheckOidcMiddleware = mwCtr.CheckOidc
-		extensions.CheckAPITokenMiddleware = mwCtr.CheckAPIToken
-		extensions.CheckAPIKeyMiddleware = mwCtr.CheckAPIKey
-
 		// Check if license is valid.
 		if os.Getenv("DIREKTIV_LICENSE") == "" {
 			return fmt.Errorf("missing DIREKTIV_LICENSE environment variable")
@@ -56,6 +48,10 @@ func init() {
 		if err := license.VerifyJSON(os.Getenv("DIREKTIV_LICENSE"), license.PublicKey); err != nil {
 			return fmt.Errorf("invalid direktiv license: %w", err)
 		}
+		lic, err := license.ParseJSON(os.Getenv("DIREKTIV_LICENSE"))
+		if err != nil {
+			return fmt.Errorf("invalid direktiv license: %w", err)
+		}
 
 		if os.Getenv("DIREKTIV_OIDC_ISSUER_URL") != "" {
 			if os.Getenv("DIREKTIV_OIDC_ADMIN_GROUP") == "" {
@@ -66,6 +62,197 @@ func init() {
 			}
 		}
 
+		// Signed jwt api tokens are only enabled when a signing key is configured.
+		var signer *apitoken.Signer
+		if os.Getenv("DIREKTIV_API_TOKEN_SIGNING_KEY") != "" {
+			var err error
+			signer, err = apitoken.NewSigner([]byte(os.Getenv("DIREKTIV_API_TOKEN_SIGNING_KEY")))
+			if err != nil {
+				return fmt.Errorf("invalid DIREKTIV_API_TOKEN_SIGNING_KEY: %w", err)
+			}
+		}
+
+		apiCtr := api.NewAPITokensController(db, datasql.New(), signer)
+		apiTokenPoliciesCtr := api.NewAPITokenPoliciesController(db, datasql.New())
+		rolesCtr := api.NewRolesController(db, datasql.New())
+		roleAssignmentsCtr := api.NewRoleAssignmentsController(db, datasql.New())
+		serviceAccountsCtr := api.NewServiceAccountsController(db, datasql.New())
+		mwCtr := api.NewMiddlewares(
+			db,
+			config,
+			datasql.New(),
+			expirable.NewLRU[string, string](1000, nil, time.Second*30),
+			signer)
+
+		// Clients are locked out after DIREKTIV_AUTH_LIMIT_THRESHOLD failed authentications, there is no
+		// limit unless it is set: failures are counted per client ip, which is the ingress for everyone
+		// until DIREKTIV_TRUSTED_PROXIES is set as well. DIREKTIV_AUTH_LIMITER is either "memory"
+		// (default), which counts per replica, or "postgres", which shares the counts between replicas.
+		authLimitPolicy := api.DefaultAuthLimitPolicy
+		authLimitPolicy.Threshold = 0
+		if os.Getenv("DIREKTIV_AUTH_LIMIT_THRESHOLD") != "" {
+			var err error
+			authLimitPolicy.Threshold, err = strconv.Atoi(os.Getenv("DIREKTIV_AUTH_LIMIT_THRESHOLD"))
+			if err != nil || authLimitPolicy.Threshold < 0 {
+				return fmt.Errorf("invalid DIREKTIV_AUTH_LIMIT_THRESHOLD: '%s'", os.Getenv("DIREKTIV_AUTH_LIMIT_THRESHOLD"))
+			}
+		}
+		// X-Forwarded-For is only honored for requests from DIREKTIV_TRUSTED_PROXIES, a comma separated list
+		// of addresses or CIDRs, otherwise clients could pick the ip their failures are counted against.
+		trustedProxies, err := api.ParseTrustedProxies(os.Getenv("DIREKTIV_TRUSTED_PROXIES"))
+		if err != nil {
+			return fmt.Errorf("invalid DIREKTIV_TRUSTED_PROXIES: %w", err)
+		}
+		mwCtr.SetTrustedProxies(trustedProxies)
+		var authLimiter api.AuthLimiter
+		switch os.Getenv("DIREKTIV_AUTH_LIMITER") {
+		case "", "memory":
+			authLimiter = api.NewMemoryAuthLimiter(authLimitPolicy)
+		case "postgres":
+			authLimiter = api.NewDatastoreAuthLimiter(db, datasql.New(), authLimitPolicy)
+		default:
+			return fmt.Errorf("invalid DIREKTIV_AUTH_LIMITER: '%s'", os.Getenv("DIREKTIV_AUTH_LIMITER"))
+		}
+		if authLimitPolicy.Threshold > 0 {
+			mwCtr.SetAuthLimiter(authLimiter)
+		}
+
+		// Quotas are counted per replica by default, DIREKTIV_QUOTA_COUNTER "postgres" shares the counts
+		// between replicas and keeps the usage for DIREKTIV_QUOTA_USAGE_RETENTION, 90 days by default.
+		quotaRetention := time.Hour * 24 * 90
+		if os.Getenv("DIREKTIV_QUOTA_USAGE_RETENTION") != "" {
+			var err error
+			quotaRetention, err = time.ParseDuration(os.Getenv("DIREKTIV_QUOTA_USAGE_RETENTION"))
+			if err != nil || quotaRetention <= 0 {
+				return fmt.Errorf("invalid DIREKTIV_QUOTA_USAGE_RETENTION: '%s'", os.Getenv("DIREKTIV_QUOTA_USAGE_RETENTION"))
+			}
+		}
+		var quotaCounter api.QuotaCounter
+		switch os.Getenv("DIREKTIV_QUOTA_COUNTER") {
+		case "", "memory":
+			quotaCounter = api.NewMemoryQuotaCounter()
+		case "postgres":
+			quotaCounter = api.NewDatastoreQuotaCounter(db, datasql.New(), quotaRetention)
+		default:
+			return fmt.Errorf("invalid DIREKTIV_QUOTA_COUNTER: '%s'", os.Getenv("DIREKTIV_QUOTA_COUNTER"))
+		}
+		mwCtr.SetQuotaCounter(quotaCounter)
+		quotasCtr := api.NewQuotasController(db, datasql.New(), quotaCounter)
+
+		// Client certificates are only accepted when DIREKTIV_CLIENT_CA_FILES lists the CA bundles they are
+		// verified against, separated by commas. Behind a proxy that terminates tls, DIREKTIV_CLIENT_CERT_HEADER
+		// names the header the proxy forwards the certificate in. Anyone who can set that header could pick
+		// any certificate they have seen, so it is only honored on requests from DIREKTIV_TRUSTED_PROXIES and
+		// stripped from all others.
+		certBindingsCtr := api.NewCertificateBindingsController(db, datasql.New())
+		if os.Getenv("DIREKTIV_CLIENT_CA_FILES") != "" {
+			roots, err := api.LoadClientCAs(strings.Split(os.Getenv("DIREKTIV_CLIENT_CA_FILES"), ",")...)
+			if err != nil {
+				return fmt.Errorf("invalid DIREKTIV_CLIENT_CA_FILES: %w", err)
+			}
+			if os.Getenv("DIREKTIV_CLIENT_CERT_HEADER") != "" && len(trustedProxies) == 0 {
+				return fmt.Errorf("DIREKTIV_CLIENT_CERT_HEADER requires DIREKTIV_TRUSTED_PROXIES")
+			}
+			mwCtr.SetClientCertificates(roots, os.Getenv("DIREKTIV_CLIENT_CERT_HEADER"))
+		}
+
+		// Expired api tokens are purged after the retention, DIREKTIV_API_TOKEN_CLEANUP_MODE is either
+		// "delete" (default) or "archive".
+		retention := time.Hour * 24 * 30
+		if os.Getenv("DIREKTIV_API_TOKEN_RETENTION") != "" {
+			var err error
+			retention, err = time.ParseDuration(os.Getenv("DIREKTIV_API_TOKEN_RETENTION"))
+			if err != nil || retention < 0 {
+				return fmt.Errorf("invalid DIREKTIV_API_TOKEN_RETENTION: '%s'", os.Getenv("DIREKTIV_API_TOKEN_RETENTION"))
+			}
+		}
+		var archive bool
+		switch os.Getenv("DIREKTIV_API_TOKEN_CLEANUP_MODE") {
+		case "", "delete":
+		case "archive":
+			archive = true
+		default:
+			return fmt.Errorf("invalid DIREKTIV_API_TOKEN_CLEANUP_MODE: '%s'", os.Getenv("DIREKTIV_API_TOKEN_CLEANUP_MODE"))
+		}
+		janitor := jobs.NewAPITokensJanitor(db, datasql.New(), retention, archive)
+
+		// Roles and the token policy declared in the namespace tree are reconciled on every sync.
+		rbacSyncer := gitops.NewRBACSyncer(db, datasql.New())
+		rbacSyncCtr := api.NewRBACSyncController(rbacSyncer)
+		bus.Subscribe(func(namespace string) {
+			rbacSyncer.Run(context.Background(), namespace)
+		}, pubsub.MirrorSync)
+
+		extensions.AdditionalAPIRoutes = (&api.Controllers{
+			APITokens:           apiCtr,
+			APITokenPolicies:    apiTokenPoliciesCtr,
+			Roles:               rolesCtr,
+			RoleAssignments:     roleAssignmentsCtr,
+			RBACSync:            rbacSyncCtr,
+			ServiceAccounts:     serviceAccountsCtr,
+			Quotas:              quotasCtr,
+			CertificateBindings: certBindingsCtr,
+		}).Routes()
+		extensions.CheckOidcMiddleware = mwCtr.CheckOidc
+		// Client certificates are only checked for requests that didn't authenticate with a token.
+		extensions.CheckAPITokenMiddleware = func(next http.Handler) http.Handler {
+			return mwCtr.CheckAPIToken(mwCtr.CheckClientCert(next))
+		}
+		// Quotas only count requests that passed authentication and authorization.
+		extensions.CheckAPIKeyMiddleware = func(next http.Handler) http.Handler {
+			return mwCtr.CheckAPIKey(mwCtr.CheckQuota(next))
+		}
+
+		// Expiry events are sent DIREKTIV_EXPIRY_NOTIFICATION_DAYS days ahead, 14 by default.
+		notificationDays := 14
+		if os.Getenv("DIREKTIV_EXPIRY_NOTIFICATION_DAYS") != "" {
+			var err error
+			notificationDays, err = strconv.Atoi(os.Getenv("DIREKTIV_EXPIRY_NOTIFICATION_DAYS"))
+			if err != nil || notificationDays < 0 {
+				return fmt.Errorf("invalid DIREKTIV_EXPIRY_NOTIFICATION_DAYS: '%s'", os.Getenv("DIREKTIV_EXPIRY_NOTIFICATION_DAYS"))
+			}
+		}
+		notifier := jobs.NewExpiryNotifier(db, datasql.New(), &lic, time.Hour*24*time.Duration(notificationDays))
+
+		// Background jobs only start once the whole configuration is valid, they stop on shutdown so that
+		// pending usage is flushed.
+		flushed := make(chan struct{})
+		ctx := untilShutdown(flushed)
+		go func() {
+			mwCtr.RunUsageRecorder(ctx, time.Second*10)
+			close(flushed)
+		}()
+		go notifier.Run(ctx, time.Hour)
+		go janitor.Run(ctx, time.Minute*10)
+		go mwCtr.RunAuthLimiter(ctx, time.Minute)
+		go mwCtr.RunQuotaCounter(ctx, time.Minute*10)
+
 		return nil
 	}
 }
+
+// shutdownTimeout bounds how long a shutdown waits for the final flush of the background jobs.
+const shutdownTimeout = time.Second * 10
+
+// untilShutdown returns a context that is canceled on SIGINT or SIGTERM. The signal is held back until
+// done is closed, at most for shutdownTimeout, and then raised again, so that the process terminates
+// the way it would without the extension.
+func untilShutdown(done <-chan struct{}) context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(shutdownTimeout):
+		}
+		signal.Stop(sigs)
+		if p, err := os.FindProcess(os.Getpid()); err == nil {
+			_ = p.Signal(sig)
+		}
+	}()
+
+	return ctx
+}
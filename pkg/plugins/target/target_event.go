@@ -1,13 +1,11 @@
 package target
 
 import (
-	"bytes"
 	"context"
-	"fmt"
 	"io"
 	"net/http"
-	"os"
 
+	"github.com/direktiv/direktiv/direktiv-ee/pkg/events"
 	"github.com/direktiv/direktiv/pkg/core"
 	"github.com/direktiv/direktiv/pkg/gateway"
 )
@@ -34,35 +32,7 @@ func (te *TargetEventPlugin) NewInstance(config core.PluginConfig) (core.Plugin,
 
 func (te *TargetEventPlugin) sendToNamespace(ctx context.Context, namespace string, header http.Header, payload []byte) {
 	// TODO: does this need to log errors somewhere? I think it's probably okay to fail silently.
-
-	// event url
-	url := fmt.Sprintf("http://localhost:%s/api/v2/namespaces/%s/events/broadcast",
-		os.Getenv("DIREKTIV_API_PORT"), namespace)
-
-	client := http.Client{}
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
-	if err != nil {
-		return
-	}
-	req.Header = header
-
-	// req.Header.Set("Content-Type", "application/cloudevents+json")
-	req.Header.Set("Content-Type", "application/json")
-
-	// add api key if required
-	if os.Getenv("DIREKTIV_API_KEY") != "" {
-		req.Header.Set("Direktiv-Token", os.Getenv("DIREKTIV_API_KEY"))
-	}
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode >= http.StatusMultipleChoices {
-		return
-	}
+	_ = events.Broadcast(ctx, namespace, header, payload)
 }
 
 func (te *TargetEventPlugin) Execute(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, *http.Request) {
@@ -36,7 +36,7 @@ describe('test api tokens authorization logic', () => {
 				.post(`/api/v2/namespaces/${ pr }/api_tokens`)
 				.set('Direktiv-Api-Key', 'password')
 				.send({
-					name: tokenTitle,
+					name: tokenTitle.toLowerCase(),
 					description: 'des1',
 					duration: 'PT1M',
 					permissions: [ {
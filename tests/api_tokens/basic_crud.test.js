@@ -17,7 +17,7 @@ describe('Test api_tokens get delete list calls', () => {
 		expect(res.statusCode).toEqual(200)
 		expect(res.body.data).toEqual({
 			apiToken: expectDummyAPIToken('foo1'),
-			secret: expect.stringMatching(regex.uuidRegex),
+			secret: expect.stringMatching(regex.apiTokenSecretRegex),
 		},
 		)
 	})
@@ -80,14 +80,21 @@ describe('Test api_tokens get delete list calls', () => {
 			apiToken: {
 				name: 'foo',
 				description: 'description',
+				format: 'secret',
 				permissions: null,
+				roles: null,
+				serviceAccount: '',
 				prefix: expect.anything(),
 				isExpired: false,
 				expiredAt: expect.stringMatching(regex.timestampRegex),
+				lastUsedAt: null,
+				lastUsedIp: '',
+				lastUsedUserAgent: '',
+				requestCount: 0,
 				createdAt: expect.stringMatching(regex.timestampRegex),
 				updatedAt: expect.stringMatching(regex.timestampRegex),
 			},
-			secret: expect.stringMatching(regex.uuidRegex),
+			secret: expect.stringMatching(regex.apiTokenSecretRegex),
 		},
 		)
 	})
@@ -112,6 +119,7 @@ function expectDummyAPIToken (name) {
 	return {
 		name,
 		description: name + ' description',
+		format: 'secret',
 		prefix: expect.anything(),
 		permissions: [ {
 			topic: 'secrets',
@@ -120,8 +128,14 @@ function expectDummyAPIToken (name) {
 			topic: 'variables',
 			method: 'manage',
 		} ],
+		roles: null,
+		serviceAccount: '',
 		isExpired: false,
 		expiredAt: expect.stringMatching(regex.timestampRegex),
+		lastUsedAt: null,
+		lastUsedIp: '',
+		lastUsedUserAgent: '',
+		requestCount: 0,
 		createdAt: expect.stringMatching(regex.timestampRegex),
 		updatedAt: expect.stringMatching(regex.timestampRegex),
 	}
@@ -0,0 +1,96 @@
+import { beforeAll, describe, expect, it } from '@jest/globals'
+import request from 'supertest'
+
+import config from '../common/config'
+import helpers from '../common/helpers'
+import { POST } from '../common/request'
+
+const namespace = 'escalation'
+
+describe('test api tokens and roles cannot escalate privileges', () => {
+	let secret = ''
+
+	beforeAll(async () => {
+		await helpers.deleteAllNamespaces()
+		const res = await POST('/api/v2/namespaces').send({
+			name: namespace,
+		})
+		expect(res.statusCode).toEqual(200)
+
+		const tokenRes = await POST(`/api/v2/namespaces/${ namespace }/api_tokens`)
+			.send({
+				name: 'manager',
+				duration: 'PT1H',
+				permissions: [ {
+					topic: 'api_tokens',
+					method: 'manage',
+				}, {
+					topic: 'roles',
+					method: 'manage',
+				}, {
+					topic: 'secrets',
+					method: 'read',
+				} ],
+			})
+		expect(tokenRes.statusCode).toEqual(200)
+		secret = tokenRes.body.data.secret
+	})
+
+	it(`should create a token with held permissions`, async () => {
+		const res = await request(config.getDirektivHost())
+			.post(`/api/v2/namespaces/${ namespace }/api_tokens`)
+			.set('Direktiv-Api-Key', 'password')
+			.set('Direktiv-Api-Token', secret)
+			.send({
+				name: 'reader',
+				duration: 'PT1H',
+				permissions: [ {
+					topic: 'secrets',
+					method: 'GET',
+				} ],
+			})
+		expect(res.statusCode).toEqual(200)
+	})
+
+	it(`should not create a token with permissions that are not held`, async () => {
+		const res = await request(config.getDirektivHost())
+			.post(`/api/v2/namespaces/${ namespace }/api_tokens`)
+			.set('Direktiv-Api-Key', 'password')
+			.set('Direktiv-Api-Token', secret)
+			.send({
+				name: 'escalated',
+				duration: 'PT1H',
+				permissions: [ {
+					topic: 'secrets',
+					method: 'read',
+				}, {
+					topic: 'secrets',
+					method: 'manage',
+				} ],
+			})
+		expect(res.statusCode).toEqual(400)
+		expect(res.body.error.code).toEqual('request_data_invalid')
+		expect(res.body.error.validation).toEqual({
+			'permissions[1]': 'caller doesn\'t hold permission \'secrets:manage\'',
+		})
+	})
+
+	it(`should not create a role with permissions that are not held`, async () => {
+		const res = await request(config.getDirektivHost())
+			.post(`/api/v2/namespaces/${ namespace }/roles`)
+			.set('Direktiv-Api-Key', 'password')
+			.set('Direktiv-Api-Token', secret)
+			.send({
+				name: 'escalated',
+				oidcGroups: [],
+				permissions: [ {
+					topic: 'variables',
+					method: 'manage',
+				} ],
+			})
+		expect(res.statusCode).toEqual(400)
+		expect(res.body.error.validation).toEqual({
+			'permissions[0]': 'caller doesn\'t hold permission \'variables:manage\'',
+		})
+	})
+})
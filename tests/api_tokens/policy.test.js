@@ -0,0 +1,135 @@
+import { beforeAll, describe, expect, it } from '@jest/globals'
+import { basename } from 'path'
+
+import helpers from '../common/helpers'
+import regex from '../common/regex'
+import { DELETE, GET, POST, PUT } from '../common/request'
+
+const namespace = basename(__filename)
+
+describe('Test api_token_policy calls', () => {
+	beforeAll(helpers.deleteAllNamespaces)
+	helpers.itShouldCreateNamespace(it, expect, namespace)
+
+	it(`should get the default policy`, async () => {
+		const res = await GET(`/api/v2/namespaces/${ namespace }/api_token_policy`)
+		expect(res.statusCode).toEqual(200)
+		expect(res.body.data).toEqual({
+			maxLifetime: '',
+			defaultLifetime: '',
+			permissionsCeiling: null,
+			allowNonExpiring: false,
+			managedBy: '',
+			readOnly: false,
+		})
+	})
+
+	it(`should not create a non-expiring token with the default policy`, async () => {
+		const res = await POST(`/api/v2/namespaces/${ namespace }/api_tokens`)
+			.send({
+				name: 'never',
+				neverExpires: true,
+			})
+		expect(res.statusCode).toEqual(400)
+		expect(res.body.error.validation.neverExpires).toBeTruthy()
+	})
+
+	it(`should set the policy`, async () => {
+		const res = await PUT(`/api/v2/namespaces/${ namespace }/api_token_policy`)
+			.send({
+				maxLifetime: 'P30D',
+				defaultLifetime: 'P1D',
+				permissionsCeiling: [ {
+					topic: 'secrets',
+					method: 'read',
+				} ],
+				allowNonExpiring: true,
+			})
+		expect(res.statusCode).toEqual(200)
+		expect(res.body.data).toEqual({
+			maxLifetime: 'P30D',
+			defaultLifetime: 'P1D',
+			permissionsCeiling: [ {
+				topic: 'secrets',
+				method: 'read',
+			} ],
+			allowNonExpiring: true,
+			managedBy: '',
+			readOnly: false,
+		})
+	})
+
+	it(`should create a token with the default lifetime`, async () => {
+		const res = await POST(`/api/v2/namespaces/${ namespace }/api_tokens`)
+			.send({
+				name: 'default',
+				permissions: [ {
+					topic: 'secrets',
+					method: 'GET',
+				} ],
+			})
+		expect(res.statusCode).toEqual(200)
+		expect(res.body.data.apiToken.expiredAt).toMatch(new RegExp(regex.timestampRegex))
+	})
+
+	it(`should create a non-expiring token`, async () => {
+		const res = await POST(`/api/v2/namespaces/${ namespace }/api_tokens`)
+			.send({
+				name: 'never',
+				neverExpires: true,
+			})
+		expect(res.statusCode).toEqual(200)
+		expect(res.body.data.apiToken.expiredAt).toBeNull()
+	})
+
+	it(`should not create a token exceeding the max lifetime`, async () => {
+		const res = await POST(`/api/v2/namespaces/${ namespace }/api_tokens`)
+			.send({
+				name: 'long',
+				duration: 'P1Y',
+			})
+		expect(res.statusCode).toEqual(400)
+		expect(res.body.error.validation.duration).toBeTruthy()
+	})
+
+	it(`should not create a token exceeding the permissions ceiling`, async () => {
+		const res = await POST(`/api/v2/namespaces/${ namespace }/api_tokens`)
+			.send({
+				name: 'admin',
+				permissions: [ {
+					topic: 'secrets',
+					method: 'manage',
+				} ],
+			})
+		expect(res.statusCode).toEqual(400)
+		expect(res.body.error.validation.permissions).toBeTruthy()
+	})
+
+	it(`should not update a token exceeding the permissions ceiling`, async () => {
+		const res = await PUT(`/api/v2/namespaces/${ namespace }/api_tokens/default`)
+			.send({
+				permissions: [ {
+					topic: 'variables',
+					method: 'read',
+				} ],
+			})
+		expect(res.statusCode).toEqual(400)
+		expect(res.body.error.validation.permissions).toBeTruthy()
+	})
+
+	it(`should update a token`, async () => {
+		const res = await PUT(`/api/v2/namespaces/${ namespace }/api_tokens/never`)
+			.send({
+				description: 'updated',
+				duration: 'P2D',
+			})
+		expect(res.statusCode).toEqual(200)
+		expect(res.body.data.description).toEqual('updated')
+		expect(res.body.data.expiredAt).toMatch(new RegExp(regex.timestampRegex))
+	})
+
+	it(`should reset the policy`, async () => {
+		const res = await DELETE(`/api/v2/namespaces/${ namespace }/api_token_policy`)
+		expect(res.statusCode).toEqual(200)
+	})
+})
@@ -8,6 +8,7 @@ const nodeExtendedTypeRegex = String.raw`^directory$`
 const hashRegex = String.raw`^[0-9a-f]{64}$`
 const base64Regex = String.raw`^[-A-Za-z0-9+/=]*$`
 const uuidRegex = String.raw`^[0-9a-fA-F]{8}\b-[0-9a-fA-F]{4}\b-[0-9a-fA-F]{4}\b-[0-9a-fA-F]{4}\b-[0-9a-fA-F]{12}$`
+const apiTokenSecretRegex = String.raw`^dkv_[0-9a-f]{16}_[0-9a-f]{64}$`
 
 export default {
 	timestampRegex,
@@ -20,4 +21,5 @@ export default {
 	hashRegex,
 	base64Regex,
 	uuidRegex,
+	apiTokenSecretRegex,
 }
@@ -26,4 +26,10 @@ const PUT = function (path) {
 		.set('Direktiv-Api-Key', 'password')
 }
 
-export { DELETE, GET, POST, PUT }
+const PATCH = function (path) {
+	return request(config.getDirektivHost())
+		.patch(path)
+		.set('Direktiv-Api-Key', 'password')
+}
+
+export { DELETE, GET, PATCH, POST, PUT }
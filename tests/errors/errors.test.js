@@ -0,0 +1,90 @@
+import { beforeAll, describe, expect, it } from '@jest/globals'
+import { basename } from 'path'
+import request from 'supertest'
+
+import config from '../common/config'
+import helpers from '../common/helpers'
+import { GET } from '../common/request'
+import regex from '../common/regex'
+
+const namespace = basename(__filename)
+
+describe('Test error responses', () => {
+	beforeAll(helpers.deleteAllNamespaces)
+	helpers.itShouldCreateNamespace(it, expect, namespace)
+
+	it(`should challenge requests without credentials`, async () => {
+		const res = await request(config.getDirektivHost())
+			.get(`/api/v2/namespaces/${ namespace }/roles`)
+		expect(res.statusCode).toEqual(401)
+		expect(res.headers['www-authenticate']).toEqual('Bearer realm="direktiv"')
+		expect(res.body.error.code).toEqual('access_token_missing')
+		expect(res.body.error.requestId).toMatch(regex.uuidRegex)
+		expect(res.headers['x-request-id']).toEqual(res.body.error.requestId)
+	})
+
+	it(`should challenge requests with invalid credentials`, async () => {
+		let res = await request(config.getDirektivHost())
+			.get(`/api/v2/namespaces/${ namespace }/roles`)
+			.set('Direktiv-Api-Key', 'wrong')
+		expect(res.statusCode).toEqual(401)
+		expect(res.headers['www-authenticate']).toEqual('Bearer realm="direktiv", error="invalid_token"')
+		expect(res.body.error.code).toEqual('access_token_invalid')
+
+		res = await GET(`/api/v2/namespaces/${ namespace }/roles`)
+			.set('Direktiv-Api-Token', 'dkv_unknown_secret')
+		expect(res.statusCode).toEqual(401)
+		expect(res.body.error.code).toEqual('access_token_invalid')
+	})
+
+	it(`should lock out clients after repeated failed authentications`, async () => {
+		// A documentation address keeps the lockout away from the other tests.
+		const clientIP = '203.0.113.48'
+		let res
+		for (let i = 0; i < 10; i++) {
+			res = await request(config.getDirektivHost())
+				.get(`/api/v2/namespaces/${ namespace }/roles`)
+				.set('X-Forwarded-For', clientIP)
+				.set('Direktiv-Api-Key', `wrong-${ i }`)
+			if (res.statusCode !== 401)
+				break
+		}
+		expect(res.statusCode).toEqual(429)
+		expect(res.body.error.code).toEqual('access_rate_limited')
+		expect(Number(res.headers['retry-after'])).toBeGreaterThan(0)
+
+		res = await GET(`/api/v2/namespaces/${ namespace }/roles`)
+			.set('X-Forwarded-For', '203.0.113.49')
+		expect(res.statusCode).toEqual(200)
+	})
+
+	it(`should keep the request id of the client`, async () => {
+		const res = await GET(`/api/v2/namespaces/${ namespace }/roles/unknown`)
+			.set('X-Request-Id', 'my-request')
+		expect(res.statusCode).toEqual(404)
+		expect(res.headers['x-request-id']).toEqual('my-request')
+		expect(res.body.error).toEqual({
+			code: 'resource_not_found',
+			message: 'requested resource is not found',
+			validation: null,
+			requestId: 'my-request',
+		})
+	})
+
+	it(`should write problem details when accepted`, async () => {
+		const res = await GET(`/api/v2/namespaces/${ namespace }/roles/unknown`)
+			.set('Accept', 'application/problem+json')
+			.set('X-Request-Id', 'my-request')
+		expect(res.statusCode).toEqual(404)
+		expect(res.headers['content-type']).toMatch(/^application\/problem\+json/)
+		expect(JSON.parse(res.text)).toEqual({
+			type: 'urn:direktiv:error:resource_not_found',
+			title: 'Resource not found',
+			status: 404,
+			detail: 'requested resource is not found',
+			instance: `/api/v2/namespaces/${ namespace }/roles/unknown`,
+			code: 'resource_not_found',
+			requestId: 'my-request',
+		})
+	})
+})
@@ -0,0 +1,90 @@
+import { beforeAll, describe, expect, it } from '@jest/globals'
+import { basename } from 'path'
+import request from 'supertest'
+
+import config from '../common/config'
+import helpers from '../common/helpers'
+import { GET, POST } from '../common/request'
+
+const namespace = basename(__filename)
+
+describe('Test impersonation', () => {
+	beforeAll(helpers.deleteAllNamespaces)
+	helpers.itShouldCreateNamespace(it, expect, namespace)
+
+	let secret = ''
+
+	it(`should create a role and tokens`, async () => {
+		let res = await POST(`/api/v2/namespaces/${ namespace }/roles`)
+			.send({
+				name: 'readers',
+				oidcGroups: [ 'readers_group' ],
+				permissions: [ {
+					topic: 'secrets',
+					method: 'read',
+				} ],
+			})
+		expect(res.statusCode).toEqual(200)
+
+		res = await POST(`/api/v2/namespaces/${ namespace }/api_tokens`)
+			.send({
+				name: 'manager',
+				duration: 'PT1H',
+				permissions: [ {
+					topic: 'roles',
+					method: 'manage',
+				} ],
+			})
+		expect(res.statusCode).toEqual(200)
+		secret = res.body.data.secret
+	})
+
+	it(`should evaluate permissions of impersonated groups`, async () => {
+		let res = await GET(`/api/v2/namespaces/${ namespace }/secrets`)
+			.set('Impersonate-Groups', 'readers_group')
+		expect(res.statusCode).toEqual(200)
+
+		res = await GET(`/api/v2/namespaces/${ namespace }/roles`)
+			.set('Impersonate-Groups', 'readers_group')
+		expect(res.statusCode).toEqual(403)
+		expect(res.body.error.code).toEqual('access_token_denied')
+	})
+
+	it(`should evaluate permissions of an impersonated token`, async () => {
+		let res = await GET(`/api/v2/namespaces/${ namespace }/roles`)
+			.set('Impersonate-Token', `${ namespace }/manager`)
+		expect(res.statusCode).toEqual(200)
+
+		res = await GET(`/api/v2/namespaces/${ namespace }/secrets`)
+			.set('Impersonate-Token', `${ namespace }/manager`)
+		expect(res.statusCode).toEqual(403)
+
+		res = await GET(`/api/v2/namespaces/${ namespace }/roles`)
+			.set('Impersonate-Token', `${ namespace }/unknown`)
+		expect(res.statusCode).toEqual(400)
+		expect(res.body.error.code).toEqual('request_data_invalid')
+	})
+
+	it(`should name the admin and the impersonated identity in audit records`, async () => {
+		let res = await POST(`/api/v2/namespaces/${ namespace }/roles`)
+			.set('Impersonate-Token', `${ namespace }/manager`)
+			.send({
+				name: 'empty',
+				permissions: [],
+			})
+		expect(res.statusCode).toEqual(200)
+
+		res = await GET(`/api/v2/namespaces/${ namespace }/roles/empty/revisions`)
+		expect(res.body.data[0].author).toEqual(`api_key impersonating api_token:${ namespace }/manager`)
+	})
+
+	it(`should not let non admins impersonate`, async () => {
+		const res = await request(config.getDirektivHost())
+			.get(`/api/v2/namespaces/${ namespace }/roles`)
+			.set('Direktiv-Api-Key', 'password')
+			.set('Direktiv-Api-Token', secret)
+			.set('Impersonate-Groups', 'readers_group')
+		expect(res.statusCode).toEqual(403)
+		expect(res.body.error.code).toEqual('access_denied')
+	})
+})
@@ -0,0 +1,14 @@
+import { describe, expect, it } from '@jest/globals'
+import request from 'supertest'
+
+import config from '../common/config'
+
+describe('Test openapi specification', () => {
+	it(`should serve the specification without credentials`, async () => {
+		const res = await request(config.getDirektivHost())
+			.get(`/api/v2/enterprise/openapi.json`)
+		expect(res.statusCode).toEqual(200)
+		expect(res.body.openapi).toEqual('3.0.3')
+		expect(res.body.paths).toHaveProperty([ '/namespaces/{namespace}/roles' ])
+	})
+})
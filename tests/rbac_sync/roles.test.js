@@ -0,0 +1,113 @@
+import { beforeAll, describe, expect, it } from '@jest/globals'
+import { basename } from 'path'
+
+import helpers from '../common/helpers'
+import { DELETE, GET, POST, PUT } from '../common/request'
+
+const namespace = basename(__filename)
+
+const readersRole = `name: readers
+description: reads secrets
+oidcGroups:
+  - g1
+permissions:
+  - topic: secrets
+    method: read
+`
+
+async function createFile (dir, name, type, data) {
+	const res = await POST(`/api/v2/namespaces/${ namespace }/files${ dir }`)
+		.send({
+			name,
+			type,
+			mimeType: 'application/yaml',
+			data: data ? Buffer.from(data).toString('base64') : undefined,
+		})
+	expect(res.statusCode).toEqual(200)
+}
+
+describe('Test rbac sync calls', () => {
+	beforeAll(helpers.deleteAllNamespaces)
+	helpers.itShouldCreateNamespace(it, expect, namespace)
+
+	it(`should report no drift without declarations`, async () => {
+		const res = await GET(`/api/v2/namespaces/${ namespace }/rbac_sync`)
+		expect(res.statusCode).toEqual(200)
+		expect(res.body.data).toEqual([])
+	})
+
+	it(`should report drift of a declared role`, async () => {
+		await createFile('/', '.direktiv', 'directory')
+		await createFile('/.direktiv', 'roles', 'directory')
+		await createFile('/.direktiv/roles', 'readers.yaml', 'file', readersRole)
+
+		const res = await GET(`/api/v2/namespaces/${ namespace }/rbac_sync`)
+		expect(res.statusCode).toEqual(200)
+		expect(res.body.data).toEqual([ {
+			kind: 'role',
+			name: 'readers',
+			action: 'create',
+			path: '/.direktiv/roles/readers.yaml',
+		} ])
+	})
+
+	it(`should sync the declared role`, async () => {
+		const res = await POST(`/api/v2/namespaces/${ namespace }/rbac_sync`)
+		expect(res.statusCode).toEqual(200)
+		expect(res.body.data).toHaveLength(1)
+
+		const role = await GET(`/api/v2/namespaces/${ namespace }/roles/readers`)
+		expect(role.statusCode).toEqual(200)
+		expect(role.body.data).toMatchObject({
+			name: 'readers',
+			description: 'reads secrets',
+			managedBy: '/.direktiv/roles/readers.yaml',
+			readOnly: true,
+		})
+
+		const drift = await GET(`/api/v2/namespaces/${ namespace }/rbac_sync`)
+		expect(drift.body.data).toEqual([])
+	})
+
+	it(`should not update a managed role`, async () => {
+		const res = await PUT(`/api/v2/namespaces/${ namespace }/roles/readers`)
+			.send({
+				name: 'readers',
+				description: 'changed',
+			})
+		expect(res.statusCode).toEqual(400)
+		expect(res.body.error.code).toEqual('resource_read_only')
+	})
+
+	it(`should not delete a managed role`, async () => {
+		const res = await DELETE(`/api/v2/namespaces/${ namespace }/roles/readers`)
+		expect(res.statusCode).toEqual(400)
+		expect(res.body.error.code).toEqual('resource_read_only')
+	})
+
+	it(`should delete the role when its file is removed`, async () => {
+		const del = await DELETE(`/api/v2/namespaces/${ namespace }/files/.direktiv/roles/readers.yaml`)
+		expect(del.statusCode).toEqual(200)
+
+		const res = await POST(`/api/v2/namespaces/${ namespace }/rbac_sync`)
+		expect(res.statusCode).toEqual(200)
+		expect(res.body.data).toEqual([ {
+			kind: 'role',
+			name: 'readers',
+			action: 'delete',
+			path: '/.direktiv/roles/readers.yaml',
+		} ])
+
+		const role = await GET(`/api/v2/namespaces/${ namespace }/roles/readers`)
+		expect(role.statusCode).toEqual(404)
+	})
+
+	it(`should reject invalid declarations`, async () => {
+		await createFile('/.direktiv/roles', 'bad.yaml', 'file', 'name: bad\npermissions:\n  - topic: nope\n    method: read\n')
+
+		const res = await POST(`/api/v2/namespaces/${ namespace }/rbac_sync`)
+		expect(res.statusCode).toEqual(400)
+		expect(res.body.error.code).toEqual('resource_declaration_invalid')
+		expect(res.body.error.message).toMatch(/bad.yaml/)
+	})
+})
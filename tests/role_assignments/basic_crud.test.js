@@ -0,0 +1,141 @@
+import { beforeAll, describe, expect, it } from '@jest/globals'
+import { basename } from 'path'
+import request from 'supertest'
+
+import config from '../common/config'
+import helpers from '../common/helpers'
+import regex from '../common/regex'
+import { DELETE, GET, POST } from '../common/request'
+
+const namespace = basename(__filename)
+
+const inOneHour = () => new Date(Date.now() + 60 * 60 * 1000).toISOString()
+
+describe('Test role assignments', () => {
+	beforeAll(helpers.deleteAllNamespaces)
+	helpers.itShouldCreateNamespace(it, expect, namespace)
+
+	let requester = ''
+
+	it(`should create a role and a requester token`, async () => {
+		let res = await POST(`/api/v2/namespaces/${ namespace }/roles`)
+			.send({
+				name: 'r1',
+				description: 'r1 description',
+				oidcGroups: [],
+				permissions: [ {
+					topic: 'secrets',
+					method: 'read',
+				} ],
+			})
+		expect(res.statusCode).toEqual(200)
+
+		res = await POST(`/api/v2/namespaces/${ namespace }/api_tokens`)
+			.send({
+				name: 'requester',
+				duration: 'PT1H',
+				permissions: [ {
+					topic: 'role_assignments',
+					method: 'manage',
+				} ],
+			})
+		expect(res.statusCode).toEqual(200)
+		requester = res.body.data.secret
+	})
+
+	let approvedID = ''
+
+	it(`should approve assignments of owners right away`, async () => {
+		const res = await POST(`/api/v2/namespaces/${ namespace }/role_assignments`)
+			.send({
+				role: 'r1',
+				group: 'oncall',
+				justification: 'incident 42',
+				endsAt: inOneHour(),
+			})
+		expect(res.statusCode).toEqual(200)
+		expect(res.body.data).toEqual({
+			id: expect.stringMatching(regex.uuidRegex),
+			role: 'r1',
+			group: 'oncall',
+			justification: 'incident 42',
+			startsAt: expect.stringMatching(regex.timestampRegex),
+			endsAt: expect.stringMatching(regex.timestampRegex),
+			status: 'approved',
+			active: true,
+			requestedBy: 'api_key',
+			decidedBy: 'api_key',
+			decidedAt: expect.stringMatching(regex.timestampRegex),
+			createdAt: expect.stringMatching(regex.timestampRegex),
+		})
+		approvedID = res.body.data.id
+	})
+
+	let pendingID = ''
+
+	it(`should keep requests of non owners pending`, async () => {
+		const res = await request(config.getDirektivHost())
+			.post(`/api/v2/namespaces/${ namespace }/role_assignments`)
+			.set('Direktiv-Api-Key', 'password')
+			.set('Direktiv-Api-Token', requester)
+			.send({
+				role: 'r1',
+				user: 'alice',
+				justification: 'debugging',
+				endsAt: inOneHour(),
+			})
+		expect(res.statusCode).toEqual(200)
+		expect(res.body.data.status).toEqual('pending')
+		expect(res.body.data.active).toEqual(false)
+		expect(res.body.data.user).toEqual('alice')
+		expect(res.body.data.requestedBy).toEqual(`api_token:${ namespace }/requester`)
+		pendingID = res.body.data.id
+	})
+
+	it(`should not let non owners approve`, async () => {
+		const res = await request(config.getDirektivHost())
+			.post(`/api/v2/namespaces/${ namespace }/role_assignments/${ pendingID }/approve`)
+			.set('Direktiv-Api-Key', 'password')
+			.set('Direktiv-Api-Token', requester)
+			.send()
+		expect(res.statusCode).toEqual(403)
+		expect(res.body.error.code).toEqual('access_denied')
+	})
+
+	it(`should approve a pending assignment`, async () => {
+		let res = await POST(`/api/v2/namespaces/${ namespace }/role_assignments/${ pendingID }/approve`)
+			.send()
+		expect(res.statusCode).toEqual(200)
+		expect(res.body.data.status).toEqual('approved')
+		expect(res.body.data.active).toEqual(true)
+
+		res = await POST(`/api/v2/namespaces/${ namespace }/role_assignments/${ pendingID }/reject`)
+			.send()
+		expect(res.statusCode).toEqual(409)
+		expect(res.body.error.code).toEqual('resource_conflict')
+	})
+
+	it(`should not create invalid assignments`, async () => {
+		const res = await POST(`/api/v2/namespaces/${ namespace }/role_assignments`)
+			.send({
+				role: 'unknown',
+				group: 'oncall',
+				endsAt: new Date(Date.now() - 1000).toISOString(),
+			})
+		expect(res.statusCode).toEqual(400)
+		expect(res.body.error.code).toEqual('request_data_invalid')
+		expect(Object.keys(res.body.error.validation).sort()).toEqual([ 'endsAt', 'justification' ])
+	})
+
+	it(`should list and revoke assignments`, async () => {
+		let res = await GET(`/api/v2/namespaces/${ namespace }/role_assignments`)
+		expect(res.statusCode).toEqual(200)
+		expect(res.body.data.map(a => a.id)).toEqual([ pendingID, approvedID ])
+
+		res = await DELETE(`/api/v2/namespaces/${ namespace }/role_assignments/${ approvedID }`)
+		expect(res.statusCode).toEqual(200)
+
+		res = await GET(`/api/v2/namespaces/${ namespace }/role_assignments/${ approvedID }`)
+		expect(res.statusCode).toEqual(404)
+	})
+})
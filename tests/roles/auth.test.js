@@ -34,7 +34,7 @@ describe('test roles authorization logic', () => {
 				.post(`/api/v2/namespaces/${ pr }/roles`)
 				.set('Direktiv-Api-Key', 'password')
 				.send({
-					name: roleTitle,
+					name: roleTitle.toLowerCase(),
 					description: 'des1',
 					oidcGroups: [ roleTitle ],
 					permissions: [ {
@@ -120,6 +120,38 @@ describe('Test roles get delete list calls', () => {
 			updatedAt: expect.stringMatching(regex.timestampRegex),
 		})
 	})
+
+	it(`should reject unknown fields`, async () => {
+		const res = await POST(`/api/v2/namespaces/${ namespace }/roles`)
+			.send({ ...makeDummyRole('foo6'), oidcGroup: 'g1' })
+		expect(res.statusCode).toEqual(400)
+		expect(res.body.error.code).toEqual('request_data_invalid')
+		expect(res.body.error.validation).toEqual({ oidcGroup: 'unknown field' })
+	})
+
+	it(`should report every invalid field`, async () => {
+		const res = await POST(`/api/v2/namespaces/${ namespace }/roles`)
+			.send({
+				name: 'Foo6',
+				description: 'd'.repeat(1025),
+				oidcGroups: [ '' ],
+				permissions: [ {
+					topic: 'unknown',
+					method: 'read',
+				} ],
+			})
+		expect(res.statusCode).toEqual(400)
+		expect(res.body.error.code).toEqual('request_data_invalid')
+		expect(Object.keys(res.body.error.validation).sort()).toEqual(
+			[ 'description', 'name', 'oidcGroups', 'permissions' ])
+	})
+
+	it(`should reject payloads larger than 1 MiB`, async () => {
+		const res = await POST(`/api/v2/namespaces/${ namespace }/roles`)
+			.send({ ...makeDummyRole('foo6'), description: 'd'.repeat(1 << 20) })
+		expect(res.statusCode).toEqual(413)
+		expect(res.body.error.code).toEqual('request_body_too_large')
+	})
 })
 
 function makeDummyRole (name) {
@@ -127,6 +159,7 @@ function makeDummyRole (name) {
 		name,
 		description: name + ' description',
 		oidcGroups: [ name + '_g1', name + '_g2' ],
+		users: [ name + '@example.com' ],
 		permissions: [ {
 			topic: 'secrets',
 			method: 'read',
@@ -142,6 +175,7 @@ function expectDummyRole (name) {
 		name,
 		description: name + ' description',
 		oidcGroups: [ name + '_g1', name + '_g2' ],
+		users: [ name + '@example.com' ],
 		permissions: [ {
 			topic: 'secrets',
 			method: 'read',
@@ -149,6 +183,8 @@ function expectDummyRole (name) {
 			topic: 'variables',
 			method: 'manage',
 		} ],
+		managedBy: '',
+		readOnly: false,
 		createdAt: expect.stringMatching(regex.timestampRegex),
 		updatedAt: expect.stringMatching(regex.timestampRegex),
 	}
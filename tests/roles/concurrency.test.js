@@ -0,0 +1,53 @@
+import { beforeAll, describe, expect, it } from '@jest/globals'
+import { basename } from 'path'
+
+import helpers from '../common/helpers'
+import { GET, POST, PUT } from '../common/request'
+
+const namespace = basename(__filename)
+
+describe('Test roles optimistic concurrency', () => {
+	beforeAll(helpers.deleteAllNamespaces)
+	helpers.itShouldCreateNamespace(it, expect, namespace)
+
+	let tag
+
+	it(`should create a role`, async () => {
+		const res = await POST(`/api/v2/namespaces/${ namespace }/roles`)
+			.send({ name: 'foo1', description: 'first' })
+		expect(res.statusCode).toEqual(200)
+	})
+
+	it(`should return an etag`, async () => {
+		const res = await GET(`/api/v2/namespaces/${ namespace }/roles/foo1`)
+		expect(res.statusCode).toEqual(200)
+		expect(res.headers.etag).toEqual('"1"')
+		tag = res.headers.etag
+	})
+
+	it(`should update with a matching If-Match`, async () => {
+		const res = await PUT(`/api/v2/namespaces/${ namespace }/roles/foo1`)
+			.set('If-Match', tag)
+			.send({ name: 'foo1', description: 'second' })
+		expect(res.statusCode).toEqual(200)
+		expect(res.headers.etag).toEqual('"2"')
+	})
+
+	it(`should reject a stale If-Match`, async () => {
+		const res = await PUT(`/api/v2/namespaces/${ namespace }/roles/foo1`)
+			.set('If-Match', tag)
+			.send({ name: 'foo1', description: 'third' })
+		expect(res.statusCode).toEqual(412)
+		expect(res.body.error.code).toEqual('resource_precondition_failed')
+
+		const role = await GET(`/api/v2/namespaces/${ namespace }/roles/foo1`)
+		expect(role.body.data.description).toEqual('second')
+	})
+
+	it(`should update without If-Match`, async () => {
+		const res = await PUT(`/api/v2/namespaces/${ namespace }/roles/foo1`)
+			.send({ name: 'foo1', description: 'fourth' })
+		expect(res.statusCode).toEqual(200)
+		expect(res.headers.etag).toEqual('"3"')
+	})
+})
@@ -0,0 +1,107 @@
+import { beforeAll, describe, expect, it } from '@jest/globals'
+import { basename } from 'path'
+
+import helpers from '../common/helpers'
+import { GET, POST } from '../common/request'
+
+const namespace = basename(__filename)
+
+const document = `version: v1
+roles:
+  - name: foo1
+    description: foo1 description
+    oidcGroups: [g1]
+    users: [alice@example.com]
+    permissions:
+      - topic: secrets
+        method: read
+  - name: foo2
+    permissions:
+      - topic: variables
+        method: manage
+`
+
+describe('Test roles import and export calls', () => {
+	beforeAll(helpers.deleteAllNamespaces)
+	helpers.itShouldCreateNamespace(it, expect, namespace)
+
+	it(`should dry run an import`, async () => {
+		const res = await POST(`/api/v2/namespaces/${ namespace }/roles/import?dryRun=true`)
+			.set('Content-Type', 'application/yaml')
+			.send(document)
+		expect(res.statusCode).toEqual(200)
+		expect(res.body.data).toEqual({
+			dryRun: true,
+			created: [ 'foo1', 'foo2' ],
+			updated: [],
+			skipped: [],
+		})
+
+		const list = await GET(`/api/v2/namespaces/${ namespace }/roles`)
+		expect(list.body.data).toEqual([])
+	})
+
+	it(`should import roles`, async () => {
+		const res = await POST(`/api/v2/namespaces/${ namespace }/roles/import`)
+			.set('Content-Type', 'application/yaml')
+			.send(document)
+		expect(res.statusCode).toEqual(200)
+		expect(res.body.data.created).toEqual([ 'foo1', 'foo2' ])
+	})
+
+	it(`should fail on existing roles by default`, async () => {
+		const res = await POST(`/api/v2/namespaces/${ namespace }/roles/import`)
+			.set('Content-Type', 'application/yaml')
+			.send(document)
+		expect(res.statusCode).toEqual(400)
+		expect(res.body.error.code).toEqual('resource_already_exists')
+	})
+
+	it(`should skip existing roles`, async () => {
+		const res = await POST(`/api/v2/namespaces/${ namespace }/roles/import?conflict=skip`)
+			.set('Content-Type', 'application/yaml')
+			.send(document)
+		expect(res.statusCode).toEqual(200)
+		expect(res.body.data.skipped).toEqual([ 'foo1', 'foo2' ])
+	})
+
+	it(`should overwrite existing roles`, async () => {
+		const res = await POST(`/api/v2/namespaces/${ namespace }/roles/import?conflict=overwrite`)
+			.set('Content-Type', 'application/yaml')
+			.send(document.replace('foo1 description', 'new description'))
+		expect(res.statusCode).toEqual(200)
+		expect(res.body.data.updated).toEqual([ 'foo1', 'foo2' ])
+
+		const role = await GET(`/api/v2/namespaces/${ namespace }/roles/foo1`)
+		expect(role.body.data.description).toEqual('new description')
+	})
+
+	it(`should reject duplicate roles`, async () => {
+		const res = await POST(`/api/v2/namespaces/${ namespace }/roles/import?conflict=overwrite`)
+			.send({
+				version: 'v1',
+				roles: [ { name: 'foo3' }, { name: 'foo3' } ],
+			})
+		expect(res.statusCode).toEqual(400)
+		expect(res.body.error.validation['roles[1].name']).toBeTruthy()
+	})
+
+	it(`should export roles as json`, async () => {
+		const res = await GET(`/api/v2/namespaces/${ namespace }/roles/export?format=json`)
+		expect(res.statusCode).toEqual(200)
+		expect(res.body.version).toEqual('v1')
+		expect(res.body.roles.map(r => r.name)).toEqual([ 'foo1', 'foo2' ])
+		expect(res.body.roles[0].users).toEqual([ 'alice@example.com' ])
+		expect(res.body.roles[0].permissions).toEqual([ {
+			topic: 'secrets',
+			method: 'read',
+		} ])
+	})
+
+	it(`should export roles as yaml`, async () => {
+		const res = await GET(`/api/v2/namespaces/${ namespace }/roles/export`)
+		expect(res.statusCode).toEqual(200)
+		expect(res.headers['content-type']).toMatch(/application\/yaml/)
+		expect(res.text).toMatch(/name: foo2/)
+	})
+})
@@ -0,0 +1,127 @@
+import { beforeAll, describe, expect, it } from '@jest/globals'
+import { basename } from 'path'
+
+import helpers from '../common/helpers'
+import { DELETE, GET, PATCH, POST } from '../common/request'
+
+const namespace = basename(__filename)
+
+describe('Test roles partial updates', () => {
+	beforeAll(helpers.deleteAllNamespaces)
+	helpers.itShouldCreateNamespace(it, expect, namespace)
+
+	it(`should create a role`, async () => {
+		const res = await POST(`/api/v2/namespaces/${ namespace }/roles`)
+			.send({
+				name: 'foo1',
+				description: 'foo1 description',
+				oidcGroups: [ 'g1' ],
+				permissions: [ {
+					topic: 'secrets',
+					method: 'read',
+				} ],
+			})
+		expect(res.statusCode).toEqual(200)
+	})
+
+	it(`should apply a merge patch`, async () => {
+		const res = await PATCH(`/api/v2/namespaces/${ namespace }/roles/foo1`)
+			.set('Content-Type', 'application/merge-patch+json')
+			.send(JSON.stringify({ description: 'patched' }))
+		expect(res.statusCode).toEqual(200)
+		expect(res.body.data.description).toEqual('patched')
+		expect(res.body.data.oidcGroups).toEqual([ 'g1' ])
+		expect(res.body.data.permissions).toEqual([ {
+			topic: 'secrets',
+			method: 'read',
+		} ])
+	})
+
+	it(`should apply a json patch`, async () => {
+		const res = await PATCH(`/api/v2/namespaces/${ namespace }/roles/foo1`)
+			.set('Content-Type', 'application/json-patch+json')
+			.send(JSON.stringify([
+				{ op: 'add', path: '/oidcGroups/-', value: 'g2' },
+				{ op: 'add', path: '/permissions/-', value: { topic: 'variables', method: 'manage' } },
+			]))
+		expect(res.statusCode).toEqual(200)
+		expect(res.body.data.oidcGroups).toEqual([ 'g1', 'g2' ])
+		expect(res.body.data.permissions).toHaveLength(2)
+	})
+
+	it(`should fail a failing json patch test`, async () => {
+		const res = await PATCH(`/api/v2/namespaces/${ namespace }/roles/foo1`)
+			.set('Content-Type', 'application/json-patch+json')
+			.send(JSON.stringify([
+				{ op: 'test', path: '/description', value: 'other' },
+				{ op: 'replace', path: '/description', value: 'lost' },
+			]))
+		expect(res.statusCode).toEqual(400)
+		expect(res.body.error.code).toEqual('request_data_invalid')
+	})
+
+	it(`should reject other content types`, async () => {
+		const res = await PATCH(`/api/v2/namespaces/${ namespace }/roles/foo1`)
+			.send({ description: 'x' })
+		expect(res.statusCode).toEqual(400)
+		expect(res.body.error.code).toEqual('request_content_type_invalid')
+	})
+
+	it(`should add an oidc group`, async () => {
+		const res = await POST(`/api/v2/namespaces/${ namespace }/roles/foo1/oidc_groups`)
+			.send({ group: '/admins' })
+		expect(res.statusCode).toEqual(200)
+		expect(res.body.data.oidcGroups).toEqual([ 'g1', 'g2', '/admins' ])
+	})
+
+	it(`should remove an oidc group`, async () => {
+		const res = await DELETE(`/api/v2/namespaces/${ namespace }/roles/foo1/oidc_groups/${ encodeURIComponent('/admins') }`)
+		expect(res.statusCode).toEqual(200)
+		expect(res.body.data.oidcGroups).toEqual([ 'g1', 'g2' ])
+	})
+
+	it(`should add a user`, async () => {
+		const res = await POST(`/api/v2/namespaces/${ namespace }/roles/foo1/users`)
+			.send({ user: 'alice@example.com' })
+		expect(res.statusCode).toEqual(200)
+		expect(res.body.data.users).toEqual([ 'alice@example.com' ])
+	})
+
+	it(`should remove a user`, async () => {
+		let res = await DELETE(`/api/v2/namespaces/${ namespace }/roles/foo1/users/${ encodeURIComponent('alice@example.com') }`)
+		expect(res.statusCode).toEqual(200)
+		expect(res.body.data.users).toEqual([])
+
+		res = await DELETE(`/api/v2/namespaces/${ namespace }/roles/foo1/users/bob`)
+		expect(res.statusCode).toEqual(404)
+	})
+
+	it(`should add a permission`, async () => {
+		const res = await POST(`/api/v2/namespaces/${ namespace }/roles/foo1/permissions`)
+			.send({ topic: 'files', method: 'read' })
+		expect(res.statusCode).toEqual(200)
+		expect(res.body.data.permissions).toHaveLength(3)
+	})
+
+	it(`should remove a permission`, async () => {
+		const res = await DELETE(`/api/v2/namespaces/${ namespace }/roles/foo1/permissions/secrets/read`)
+		expect(res.statusCode).toEqual(200)
+		expect(res.body.data.permissions).toEqual([ {
+			topic: 'variables',
+			method: 'manage',
+		}, {
+			topic: 'files',
+			method: 'read',
+		} ])
+	})
+
+	it(`should not remove a missing permission`, async () => {
+		const res = await DELETE(`/api/v2/namespaces/${ namespace }/roles/foo1/permissions/secrets/read`)
+		expect(res.statusCode).toEqual(404)
+	})
+
+	it(`should keep the role`, async () => {
+		const res = await GET(`/api/v2/namespaces/${ namespace }/roles/foo1`)
+		expect(res.body.data.description).toEqual('patched')
+	})
+})
@@ -0,0 +1,107 @@
+import { beforeAll, describe, expect, it } from '@jest/globals'
+import { basename } from 'path'
+
+import helpers from '../common/helpers'
+import regex from '../common/regex'
+import { GET, POST, PUT } from '../common/request'
+
+const namespace = basename(__filename)
+
+describe('Test roles revisions', () => {
+	beforeAll(helpers.deleteAllNamespaces)
+	helpers.itShouldCreateNamespace(it, expect, namespace)
+
+	it(`should create and update a role`, async () => {
+		let res = await POST(`/api/v2/namespaces/${ namespace }/roles`)
+			.send({
+				name: 'foo1',
+				description: 'first',
+				oidcGroups: [ 'g1' ],
+				permissions: [ {
+					topic: 'secrets',
+					method: 'read',
+				} ],
+			})
+		expect(res.statusCode).toEqual(200)
+
+		res = await PUT(`/api/v2/namespaces/${ namespace }/roles/foo1`)
+			.send({
+				name: 'foo1',
+				description: 'second',
+				oidcGroups: [ 'g2' ],
+				permissions: [ {
+					topic: 'secrets',
+					method: 'read',
+				}, {
+					topic: 'files',
+					method: 'manage',
+				} ],
+			})
+		expect(res.statusCode).toEqual(200)
+	})
+
+	it(`should list revisions`, async () => {
+		const res = await GET(`/api/v2/namespaces/${ namespace }/roles/foo1/revisions`)
+		expect(res.statusCode).toEqual(200)
+		expect(res.body.data).toHaveLength(2)
+		expect(res.body.data[0]).toEqual({
+			revision: 2,
+			description: 'second',
+			oidcGroups: [ 'g2' ],
+			users: null,
+			permissions: [ {
+				topic: 'secrets',
+				method: 'read',
+			}, {
+				topic: 'files',
+				method: 'manage',
+			} ],
+			author: 'api_key',
+			createdAt: expect.stringMatching(regex.timestampRegex),
+		})
+	})
+
+	it(`should diff revisions`, async () => {
+		const res = await GET(`/api/v2/namespaces/${ namespace }/roles/foo1/revisions/diff?from=1`)
+		expect(res.statusCode).toEqual(200)
+		expect(res.body.data).toEqual({
+			from: 1,
+			to: 2,
+			description: {
+				from: 'first',
+				to: 'second',
+			},
+			oidcGroups: {
+				added: [ 'g2' ],
+				removed: [ 'g1' ],
+			},
+			users: {
+				added: [],
+				removed: [],
+			},
+			permissions: {
+				added: [ {
+					topic: 'files',
+					method: 'manage',
+				} ],
+				removed: [],
+			},
+		})
+	})
+
+	it(`should roll back to a revision`, async () => {
+		const res = await POST(`/api/v2/namespaces/${ namespace }/roles/foo1/rollback?revision=1`)
+		expect(res.statusCode).toEqual(200)
+		expect(res.body.data.description).toEqual('first')
+		expect(res.body.data.oidcGroups).toEqual([ 'g1' ])
+		expect(res.headers.etag).toEqual('"3"')
+
+		const revisions = await GET(`/api/v2/namespaces/${ namespace }/roles/foo1/revisions`)
+		expect(revisions.body.data).toHaveLength(3)
+	})
+
+	it(`should not roll back to an unknown revision`, async () => {
+		const res = await POST(`/api/v2/namespaces/${ namespace }/roles/foo1/rollback?revision=9`)
+		expect(res.statusCode).toEqual(404)
+	})
+})
@@ -0,0 +1,144 @@
+import { beforeAll, describe, expect, it } from '@jest/globals'
+import { basename } from 'path'
+import request from 'supertest'
+
+import config from '../common/config'
+import helpers from '../common/helpers'
+import regex from '../common/regex'
+import { DELETE, GET, POST, PUT } from '../common/request'
+
+const namespace = basename(__filename)
+
+describe('Test service_accounts get delete list calls', () => {
+	beforeAll(helpers.deleteAllNamespaces)
+	helpers.itShouldCreateNamespace(it, expect, namespace)
+
+	it(`should create a new role r1`, async () => {
+		const res = await POST(`/api/v2/namespaces/${ namespace }/roles`)
+			.send({
+				name: 'r1',
+				description: 'r1 description',
+				oidcGroups: [],
+				permissions: [ {
+					topic: 'secrets',
+					method: 'read',
+				} ],
+			})
+		expect(res.statusCode).toEqual(200)
+	})
+
+	it(`should not create a service account with unknown roles`, async () => {
+		const res = await POST(`/api/v2/namespaces/${ namespace }/service_accounts`)
+			.send({
+				name: 'bad',
+				description: 'bad description',
+				roles: [ 'unknown' ],
+			})
+		expect(res.statusCode).toEqual(400)
+		expect(res.body.error.code).toEqual('request_data_invalid')
+	})
+
+	it(`should create a new service account sa1`, async () => {
+		const res = await POST(`/api/v2/namespaces/${ namespace }/service_accounts`)
+			.send(makeDummyServiceAccount('sa1'))
+		expect(res.statusCode).toEqual(200)
+		expect(res.body.data).toEqual(expectDummyServiceAccount('sa1'))
+	})
+
+	it(`should get the new service account sa1`, async () => {
+		const res = await GET(`/api/v2/namespaces/${ namespace }/service_accounts/sa1`)
+		expect(res.statusCode).toEqual(200)
+		expect(res.body.data).toEqual(expectDummyServiceAccount('sa1'))
+	})
+
+	let secret = ''
+
+	it(`should create a credential for sa1`, async () => {
+		const res = await POST(`/api/v2/namespaces/${ namespace }/api_tokens`)
+			.send({
+				name: 'sa1_token1',
+				description: 'sa1 token',
+				serviceAccount: 'sa1',
+				permissions: [],
+				duration: 'PT1H',
+			})
+		expect(res.statusCode).toEqual(200)
+		expect(res.body.data.apiToken.serviceAccount).toEqual('sa1')
+		secret = res.body.data.secret
+	})
+
+	it(`should list credentials of sa1`, async () => {
+		const res = await GET(`/api/v2/namespaces/${ namespace }/service_accounts/sa1/api_tokens`)
+		expect(res.statusCode).toEqual(200)
+		expect(res.body.data.length).toEqual(1)
+		expect(res.body.data[0].name).toEqual('sa1_token1')
+	})
+
+	it(`should access secrets with the role of sa1`, async () => {
+		const res = await request(config.getDirektivHost())
+			.get(`/api/v2/namespaces/${ namespace }/secrets`)
+			.set('Direktiv-Api-Key', 'password')
+			.set('Direktiv-Api-Token', secret)
+			.send()
+		expect(res.statusCode).toEqual(200)
+	})
+
+	it(`should update sa1`, async () => {
+		const res = await PUT(`/api/v2/namespaces/${ namespace }/service_accounts/sa1`)
+			.send({
+				name: 'sa1',
+				description: 'sa1 description',
+				roles: [],
+			})
+		expect(res.statusCode).toEqual(200)
+		expect(res.body.data.roles).toEqual([])
+	})
+
+	it(`should reject invalid updates of sa1`, async () => {
+		let res = await PUT(`/api/v2/namespaces/${ namespace }/service_accounts/sa1`)
+			.send({
+				name: 'sa1',
+				roles: 'r1',
+			})
+		expect(res.statusCode).toEqual(400)
+		expect(res.body.error.code).toEqual('request_body_bad_json_schema')
+		expect(res.body.error.validation).toEqual({ roles: 'should be an array' })
+
+		res = await PUT(`/api/v2/namespaces/${ namespace }/service_accounts/sa1`)
+			.send({
+				name: 'sa 1',
+				roles: [],
+			})
+		expect(res.statusCode).toEqual(400)
+		expect(res.body.error.code).toEqual('request_data_invalid')
+		expect(res.body.error.validation.name).toBeDefined()
+	})
+
+	it(`should delete sa1`, async () => {
+		const res = await DELETE(`/api/v2/namespaces/${ namespace }/service_accounts/sa1`)
+		expect(res.statusCode).toEqual(200)
+	})
+
+	it(`should have revoked the credentials of sa1`, async () => {
+		const res = await GET(`/api/v2/namespaces/${ namespace }/api_tokens/sa1_token1`)
+		expect(res.statusCode).toEqual(404)
+	})
+})
+
+function makeDummyServiceAccount (name) {
+	return {
+		name,
+		description: name + ' description',
+		roles: [ 'r1' ],
+	}
+}
+
+function expectDummyServiceAccount (name) {
+	return {
+		name,
+		description: name + ' description',
+		roles: [ 'r1' ],
+		createdAt: expect.stringMatching(regex.timestampRegex),
+		updatedAt: expect.stringMatching(regex.timestampRegex),
+	}
+}